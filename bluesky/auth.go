@@ -2,12 +2,13 @@ package blueskyapi
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strings"
 )
@@ -21,8 +22,8 @@ type DIDDoc struct {
 	} `json:"service"`
 }
 
-func Authenticate(username, password string) (*AuthResponse, *string, error) {
-	_, userPDS, err := GetUserAuthData(username)
+func Authenticate(ctx context.Context, username, password string) (*AuthResponse, *string, error) {
+	_, userPDS, err := GetUserAuthData(ctx, username)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -39,18 +40,14 @@ func Authenticate(username, password string) (*AuthResponse, *string, error) {
 		return nil, nil, err
 	}
 
-	resp, err := SendRequest(nil, http.MethodPost, url, bytes.NewBuffer(reqBody))
+	resp, err := SendRequest(ctx, nil, http.MethodPost, url, bytes.NewBuffer(reqBody))
 	if err != nil {
 		return nil, nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-		fmt.Println("Response Status:", resp.StatusCode)
-		fmt.Println("Response Body:", bodyString)
-		return nil, nil, errors.New(bodyString)
+		return nil, nil, errorFromResponse(resp)
 	}
 
 	var authResp AuthResponse
@@ -61,21 +58,17 @@ func Authenticate(username, password string) (*AuthResponse, *string, error) {
 	return &authResp, userPDS, nil
 }
 
-func RefreshToken(pds string, refreshToken string) (*AuthResponse, error) {
+func RefreshToken(ctx context.Context, pds string, refreshToken string) (*AuthResponse, error) {
 	url := pds + "/xrpc/com.atproto.server.refreshSession"
 
-	resp, err := SendRequest(&refreshToken, http.MethodPost, url, nil)
+	resp, err := SendRequest(ctx, &refreshToken, http.MethodPost, url, nil)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-		fmt.Println("Response Status:", resp.StatusCode)
-		fmt.Println("Response Body:", bodyString)
-		return nil, errors.New(bodyString)
+		return nil, errorFromResponse(resp)
 	}
 
 	var authResp AuthResponse
@@ -89,17 +82,17 @@ func RefreshToken(pds string, refreshToken string) (*AuthResponse, error) {
 // This function is to get: the user DID and the user's PDS this should **ONLY** be used during authentication.
 //
 // @results: userDID, userPDS, error
-func GetUserAuthData(handle string) (*string, *string, error) {
+func GetUserAuthData(ctx context.Context, handle string) (*string, *string, error) {
 	// thank you https://discord.com/channels/1097580399187738645/1097580399187738648/1318477650485973004 (ducky.ws) on https://discord.gg/zYvmrHAr8M for explaining this to me
 
 	// Get the user's DID
-	userDID, err := ResolveDIDFromHandle(handle)
+	userDID, err := ResolveDIDFromHandle(ctx, handle)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	// Get the user's PDS
-	userPDS, err := ResolvePDSFromDID(*userDID)
+	userPDS, err := ResolvePDSFromDID(ctx, *userDID)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -108,17 +101,20 @@ func GetUserAuthData(handle string) (*string, *string, error) {
 	return userDID, userPDS, nil
 }
 
-func ResolveDIDFromHandle(handle string) (*string, error) {
+func ResolveDIDFromHandle(ctx context.Context, handle string) (*string, error) {
 	// Validate our handle
 	if !regexp.MustCompile(`^([a-zA-Z0-9-]+\.)+[a-zA-Z]{2,}$`).MatchString(handle) {
-		return nil, errors.New("invalid handle")
+		return nil, identityError("InvalidHandle", "invalid handle", "com.atproto.identity.resolveHandle")
+	}
+	if configData != nil && configData.HandleResolverURL != "" {
+		return resolveHandleWithServer(ctx, strings.TrimSuffix(configData.HandleResolverURL, "/"), handle)
 	}
 	userDID := ""
 
 	// Get the handle's DID
 
 	// Get DID thru .well-known, since this is what the most common handle PDS, bsky.social uses.
-	wellKnownDIDResp, err := http.Get(fmt.Sprintf("https://%s/.well-known/atproto-did", handle))
+	wellKnownDIDResp, err := getWithTimeout(ctx, fmt.Sprintf("https://%s/.well-known/atproto-did", handle))
 	if err == nil {
 		bodyBytes, _ := io.ReadAll(wellKnownDIDResp.Body)
 		bodyString := string(bodyBytes)
@@ -133,7 +129,9 @@ func ResolveDIDFromHandle(handle string) (*string, error) {
 	}
 	if userDID == "" {
 		// Get DID through _atproto DNS records
-		txts, err := net.LookupTXT(fmt.Sprintf("_atproto.%s", handle))
+		dnsCtx, cancel := context.WithTimeout(ctx, xrpcTimeout())
+		txts, err := net.DefaultResolver.LookupTXT(dnsCtx, fmt.Sprintf("_atproto.%s", handle))
+		cancel()
 		if err == nil {
 			for _, txt := range txts {
 				txt = strings.ReplaceAll(txt, "\n", "")
@@ -146,27 +144,51 @@ func ResolveDIDFromHandle(handle string) (*string, error) {
 	}
 
 	if userDID == "" {
-		return nil, errors.New("user does not exist")
+		return nil, identityError("HandleNotFound", "user does not exist", "com.atproto.identity.resolveHandle")
 	}
 
 	return &userDID, nil
 }
 
-func ResolvePDSFromDID(userDID string) (*string, error) {
+// resolveHandleWithServer asks a server (HANDLE_RESOLVER_URL) to resolve the handle for us.
+func resolveHandleWithServer(ctx context.Context, server string, handle string) (*string, error) {
+	resp, err := SendRequest(ctx, nil, http.MethodGet, server+"/xrpc/com.atproto.identity.resolveHandle?handle="+url.QueryEscape(handle), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		if xrpcErr := errorFromResponse(resp); xrpcErr.StatusCode != http.StatusBadRequest {
+			return nil, xrpcErr
+		}
+		return nil, identityError("HandleNotFound", "user does not exist", "com.atproto.identity.resolveHandle")
+	}
+
+	res := struct {
+		DID string `json:"did"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil || res.DID == "" {
+		return nil, identityError("HandleNotFound", "user does not exist", "com.atproto.identity.resolveHandle")
+	}
+	return &res.DID, nil
+}
+
+func ResolvePDSFromDID(ctx context.Context, userDID string) (*string, error) {
 	// we must do different things depending on the DID type.
 	didDocReqUrl := ""
 	switch strings.Split(userDID, ":")[1] {
 	case "plc":
 		// https://plc.directory/did:plc:<id>
-		didDocReqUrl = fmt.Sprintf("https://plc.directory/%s", userDID)
+		didDocReqUrl = fmt.Sprintf("%s/%s", plcDirectoryURL(), userDID)
 	case "web":
 		didDocReqUrl = fmt.Sprintf("https://%s/.well-known/did.json", strings.Split(userDID, ":")[2])
 	}
 
 	// get the DID doc
-	didDocReq, err := http.Get(didDocReqUrl)
+	didDocReq, err := getWithTimeout(ctx, didDocReqUrl)
 	if err != nil {
-		return nil, errors.New("could not find PDS")
+		return nil, identityError("DidNotFound", "could not find PDS", "com.atproto.identity.resolveDid")
 	}
 	bodyBytes, err := io.ReadAll(didDocReq.Body)
 	if err != nil {
@@ -176,7 +198,7 @@ func ResolvePDSFromDID(userDID string) (*string, error) {
 	err = json.Unmarshal(bodyBytes, &userDIDDoc)
 	didDocReq.Body.Close()
 	if err != nil {
-		return nil, errors.New("could not find PDS")
+		return nil, identityError("DidNotFound", "could not find PDS", "com.atproto.identity.resolveDid")
 	}
 
 	// get the user's PDS
@@ -188,7 +210,7 @@ func ResolvePDSFromDID(userDID string) (*string, error) {
 		}
 	}
 	if userPDS == "" {
-		return nil, errors.New("could not find PDS")
+		return nil, identityError("DidNotFound", "could not find PDS", "com.atproto.identity.resolveDid")
 	}
 
 	return &userPDS, nil
@@ -2,6 +2,7 @@ package blueskyapi
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -15,6 +16,8 @@ import (
 
 	"github.com/Preloading/TwitterAPIBridge/bridge"
 	"github.com/Preloading/TwitterAPIBridge/config"
+	"github.com/Preloading/TwitterAPIBridge/logging"
+	"github.com/Preloading/TwitterAPIBridge/metrics"
 )
 
 type AuthResponse struct {
@@ -85,6 +88,8 @@ type PostReason struct {
 	Type      string    `json:"$type"`
 	By        User      `json:"by"`
 	IndexedAt time.Time `json:"indexedAt"`
+	URI       string    `json:"uri,omitempty"` // the repost record
+	CID       string    `json:"cid,omitempty"`
 }
 
 type Embed struct {
@@ -101,8 +106,10 @@ type MoreImages struct {
 
 // doesn't contain everything, but who cares
 type ExternalImage struct {
-	Uri   string `json:"uri"`
-	Thumb Blob   `json:"thumb"`
+	Uri         string `json:"uri"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Thumb       Blob   `json:"thumb,omitzero"`
 }
 type Image struct {
 	Alt         string      `json:"alt"`
@@ -421,50 +428,87 @@ var (
 
 func InitConfig(config *config.Config) {
 	configData = config
+	// the users in there are from whatever we were pointed at before (ex. the last test harness), with its IDs
+	userCache.Clear()
 }
 
-var userCache = bridge.NewCache(5 * time.Minute) // Cache TTL of 5 minutes
-
-func SendRequest(token *string, method string, url string, body io.Reader) (*http.Response, error) {
-	client := &http.Client{}
-	req, err := http.NewRequest(method, url, body)
-	if err != nil {
-		return nil, err
-	}
-	if token != nil {
-		req.Header.Set("Authorization", "Bearer "+*token)
+// AppViewURL is where requests go when nobody's logged in (APPVIEW_URL).
+func AppViewURL() string {
+	if configData != nil && configData.AppViewURL != "" {
+		return strings.TrimSuffix(configData.AppViewURL, "/")
 	}
-	req.Header.Set("Content-Type", "application/json") // 99% sure all bluesky requests are json.
-	req.Header.Set("User-Agent", "ATwitterBridge/1.0")
+	return "https://public.api.bsky.app"
+}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+func plcDirectoryURL() string {
+	if configData != nil && configData.PLCDirectoryURL != "" {
+		return strings.TrimSuffix(configData.PLCDirectoryURL, "/")
 	}
+	return "https://plc.directory"
+}
+
+var (
+	userCache = bridge.NewCache(5 * time.Minute) // Cache TTL of 5 minutes
+	log       = logging.For("bluesky")
+)
 
-	return resp, nil
+func SendRequest(ctx context.Context, token *string, method string, url string, body io.Reader) (*http.Response, error) {
+	return SendRequestWithContentType(ctx, token, method, url, body, "application/json") // 99% sure all bluesky requests are json.
 }
 
-func SendRequestWithContentType(token *string, method string, url string, body io.Reader, content_type string) (*http.Response, error) {
-	client := &http.Client{}
-	req, err := http.NewRequest(method, url, body)
-	if err != nil {
-		return nil, err
-	}
-	if token != nil {
-		req.Header.Set("Authorization", "Bearer "+*token)
+func SendRequestWithContentType(ctx context.Context, token *string, method string, url string, body io.Reader, content_type string) (*http.Response, error) {
+	// read it all now, so it can be sent again if we need to retry
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = io.ReadAll(body); err != nil {
+			return nil, err
+		}
 	}
-	req.Header.Set("Content-Type", content_type) // 99% sure all bluesky requests are json.
+	return sendXRPC(ctx, token, method, url, payload, content_type)
+}
 
+// doXRPC sends a request, and records how it went in the metrics.
+// Also returns the XRPC error name, if bluesky sent one.
+func doXRPC(client *http.Client, req *http.Request) (*http.Response, string, error) {
+	method := xrpcMethod(req.URL)
+	start := time.Now()
 	resp, err := client.Do(req)
+	metrics.XRPCRequestDuration.Observe(time.Since(start).Seconds(), method)
 	if err != nil {
-		return nil, err
+		metrics.XRPCRequests.Inc(method, "network_error", "")
+		return nil, "", err
+	}
+
+	errorName := ""
+	if resp.StatusCode >= 400 {
+		// Peek at the error, then put the body back so the caller can still read it
+		body, readErr := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodyBytes))
+		resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		if readErr == nil {
+			xrpcError := struct {
+				Error string `json:"error"`
+			}{}
+			if json.Unmarshal(body, &xrpcError) == nil {
+				errorName = xrpcError.Error
+			}
+		}
 	}
+	metrics.XRPCRequests.Inc(method, strconv.Itoa(resp.StatusCode), errorName)
+	return resp, errorName, nil
+}
 
-	return resp, nil
+// xrpcMethod gets the lexicon out of a URL, ex. https://bsky.social/xrpc/app.bsky.actor.getProfile -> app.bsky.actor.getProfile
+func xrpcMethod(u *url.URL) string {
+	_, method, found := strings.Cut(u.Path, "/xrpc/")
+	if !found || method == "" {
+		return "other"
+	}
+	return method
 }
 
-func GetUserInfo(pds string, token string, screen_name string, nocache bool) (*bridge.TwitterUser, error) {
+func GetUserInfo(ctx context.Context, pds string, token string, screen_name string, nocache bool) (*bridge.TwitterUser, error) {
 	if !nocache {
 		if user, found := userCache.Get(screen_name); found {
 			return &user, nil
@@ -473,17 +517,13 @@ func GetUserInfo(pds string, token string, screen_name string, nocache bool) (*b
 
 	url := pds + "/xrpc/app.bsky.actor.getProfile" + "?actor=" + screen_name
 
-	resp, err := SendRequest(&token, http.MethodGet, url, nil)
+	resp, err := SendRequest(ctx, &token, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-		fmt.Println("Response Status:", resp.StatusCode)
-		fmt.Println("Response Body:", bodyString)
-		return nil, errors.New(bodyString) // return response
+		return nil, errorFromResponse(resp)
 	}
 
 	author := User{}
@@ -491,27 +531,23 @@ func GetUserInfo(pds string, token string, screen_name string, nocache bool) (*b
 		return nil, err
 	}
 
-	twitterUser := AuthorTTB(author)
+	twitterUser := AuthorTTB(ctx, author)
 
 	userCache.SetMultiple([]string{author.DID, author.Handle}, *twitterUser)
 
 	return twitterUser, nil
 }
 
-func GetUserInfoRaw(pds string, token string, screen_name string) (*User, error) {
+func GetUserInfoRaw(ctx context.Context, pds string, token string, screen_name string) (*User, error) {
 	url := pds + "/xrpc/app.bsky.actor.getProfile" + "?actor=" + screen_name
 
-	resp, err := SendRequest(&token, http.MethodGet, url, nil)
+	resp, err := SendRequest(ctx, &token, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-		fmt.Println("Response Status:", resp.StatusCode)
-		fmt.Println("Response Body:", bodyString)
-		return nil, errors.New(bodyString) // return response
+		return nil, errorFromResponse(resp)
 	}
 
 	author := User{}
@@ -522,7 +558,7 @@ func GetUserInfoRaw(pds string, token string, screen_name string) (*User, error)
 	return &author, nil
 }
 
-func GetUsersInfo(pds string, token string, items []string, ignoreCache bool) ([]*bridge.TwitterUser, error) {
+func GetUsersInfo(ctx context.Context, pds string, token string, items []string, ignoreCache bool) ([]*bridge.TwitterUser, error) {
 	var results []*bridge.TwitterUser
 	var missing []string
 
@@ -557,16 +593,14 @@ func GetUsersInfo(pds string, token string, items []string, ignoreCache bool) ([
 			defer wg.Done()
 
 			url := pds + "/xrpc/app.bsky.actor.getProfiles" + "?actors=" + strings.Join(c, "&actors=")
-			resp, err := SendRequest(&token, http.MethodGet, url, nil)
+			resp, err := SendRequest(ctx, &token, http.MethodGet, url, nil)
 			if err != nil {
-				fmt.Println(err)
+				log.Error("Error getting profiles", "error", err)
 				return
 			}
 			defer resp.Body.Close()
 			if resp.StatusCode != http.StatusOK {
-				bodyBytes, _ := io.ReadAll(resp.Body)
-				fmt.Println("Response Status:", resp.StatusCode)
-				fmt.Println("Response Body:", string(bodyBytes))
+				errorFromResponse(resp) // logs it, there's nowhere to return it to
 				return
 			}
 
@@ -579,7 +613,7 @@ func GetUsersInfo(pds string, token string, items []string, ignoreCache bool) ([
 
 			mu.Lock()
 			for _, author := range authors.Profiles {
-				userObj := AuthorTTB(author)
+				userObj := AuthorTTB(ctx, author)
 				userCache.SetMultiple([]string{author.DID, author.Handle}, *userObj)
 				results = append(results, userObj)
 			}
@@ -592,7 +626,7 @@ func GetUsersInfo(pds string, token string, items []string, ignoreCache bool) ([
 }
 
 // TODO: Combine this with GetUsersInfo... somehow
-func GetUsersInfoRaw(pds string, token string, items []string, ignoreCache bool) ([]*User, error) {
+func GetUsersInfoRaw(ctx context.Context, pds string, token string, items []string, ignoreCache bool) ([]*User, error) {
 	var results []*User
 	missing := items // hack
 
@@ -611,16 +645,14 @@ func GetUsersInfoRaw(pds string, token string, items []string, ignoreCache bool)
 			defer wg.Done()
 
 			url := pds + "/xrpc/app.bsky.actor.getProfiles" + "?actors=" + strings.Join(c, "&actors=")
-			resp, err := SendRequest(&token, http.MethodGet, url, nil)
+			resp, err := SendRequest(ctx, &token, http.MethodGet, url, nil)
 			if err != nil {
-				fmt.Println(err)
+				log.Error("Error getting profiles", "error", err)
 				return
 			}
 			defer resp.Body.Close()
 			if resp.StatusCode != http.StatusOK {
-				bodyBytes, _ := io.ReadAll(resp.Body)
-				fmt.Println("Response Status:", resp.StatusCode)
-				fmt.Println("Response Body:", string(bodyBytes))
+				errorFromResponse(resp) // logs it, there's nowhere to return it to
 				return
 			}
 
@@ -644,10 +676,10 @@ func GetUsersInfoRaw(pds string, token string, items []string, ignoreCache bool)
 }
 
 // https://docs.bsky.app/docs/api/app-bsky-graph-get-relationships
-func GetRelationships(pds string, token string, source string, others []string) (*RelationshipsRes, error) {
+func GetRelationships(ctx context.Context, pds string, token string, source string, others []string) (*RelationshipsRes, error) {
 	url := pds + "/xrpc/app.bsky.graph.getRelationships" + "?actor=" + url.QueryEscape(source) + "&others=" + url.QueryEscape(strings.Join(others, ","))
 
-	resp, err := SendRequest(&token, http.MethodGet, url, nil)
+	resp, err := SendRequest(ctx, &token, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -659,11 +691,7 @@ func GetRelationships(pds string, token string, source string, others []string)
 	// fmt.Println("Response Body:", bodyString)
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-		fmt.Println("Response Status:", resp.StatusCode)
-		fmt.Println("Response Body:", bodyString)
-		return nil, errors.New(bodyString) // return response
+		return nil, errorFromResponse(resp)
 	}
 
 	feeds := RelationshipsRes{}
@@ -675,8 +703,8 @@ func GetRelationships(pds string, token string, source string, others []string)
 }
 
 // https://web.archive.org/web/20121029153120/https://dev.twitter.com/docs/platform-objects/users
-func AuthorTTB(author User) *bridge.TwitterUser {
-	id := bridge.BlueSkyToTwitterID(author.DID)
+func AuthorTTB(ctx context.Context, author User) *bridge.TwitterUser {
+	id := bridge.BlueSkyToTwitterID(ctx, author.DID)
 	pfp_url := configData.CdnURL + "/cdn/img/?url=" + url.QueryEscape(author.Avatar) + "@jpeg:profile_bigger"
 	banner_url := ""
 	if author.Banner != "" {
@@ -734,13 +762,13 @@ func AuthorTTB(author User) *bridge.TwitterUser {
 }
 
 // https://docs.bsky.app/docs/api/app-bsky-feed-get-feed
-func GetTimeline(pds string, token string, context string, feed string, limit int) (*Timeline, error) {
+func GetTimeline(ctx context.Context, pds string, token string, context string, feed string, limit int) (*Timeline, error) {
 	url := pds + "/xrpc/app.bsky.feed.getTimeline?limit=" + fmt.Sprintf("%d", limit)
 	if context != "" {
 		url = pds + "/xrpc/app.bsky.feed.getTimeline?cursor=" + context + "&limit=" + fmt.Sprintf("%d", limit)
 	}
 
-	resp, err := SendRequest(&token, http.MethodGet, url, nil)
+	resp, err := SendRequest(ctx, &token, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -752,11 +780,7 @@ func GetTimeline(pds string, token string, context string, feed string, limit in
 	// fmt.Println("Response Body:", bodyString)
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-		fmt.Println("Response Status:", resp.StatusCode)
-		fmt.Println("Response Body:", bodyString)
-		return nil, errors.New(bodyString) // return response
+		return nil, errorFromResponse(resp)
 	}
 
 	feeds := Timeline{}
@@ -768,11 +792,11 @@ func GetTimeline(pds string, token string, context string, feed string, limit in
 }
 
 // https://docs.bsky.app/docs/api/app-bsky-feed-get-feed
-func GetHotPosts(pds string, token string, context string, feed string, limit int) (*Timeline, error) {
+func GetHotPosts(ctx context.Context, pds string, token string, context string, feed string, limit int) (*Timeline, error) {
 	url := pds + "/xrpc/app.bsky.feed.getFeed?feed=at://did:plc:z72i7hdynmk6r22z27h6tvur/app.bsky.feed.generator/whats-hot&limit=" + fmt.Sprintf("%d", limit)
 	// Context is removed, since how it gets context is witchcraft.
 
-	resp, err := SendRequest(&token, http.MethodGet, url, nil)
+	resp, err := SendRequest(ctx, &token, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -784,11 +808,7 @@ func GetHotPosts(pds string, token string, context string, feed string, limit in
 	// fmt.Println("Response Body:", bodyString)
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-		fmt.Println("Response Status:", resp.StatusCode)
-		fmt.Println("Response Body:", bodyString)
-		return nil, errors.New(bodyString) // return response
+		return nil, errorFromResponse(resp)
 	}
 
 	feeds := Timeline{}
@@ -800,13 +820,13 @@ func GetHotPosts(pds string, token string, context string, feed string, limit in
 }
 
 // https://docs.bsky.app/docs/api/app-bsky-feed-get-author-feed
-func GetUserTimeline(pds string, token string, context string, actor string, limit int) (*Timeline, error) {
+func GetUserTimeline(ctx context.Context, pds string, token string, context string, actor string, limit int) (*Timeline, error) {
 	apiURL := pds + "/xrpc/app.bsky.feed.getAuthorFeed?actor=" + url.QueryEscape(actor) + "&limit=" + fmt.Sprintf("%d", limit)
 	if context != "" {
 		apiURL = pds + "/xrpc/app.bsky.feed.getAuthorFeed?actor=" + url.QueryEscape(actor) + "&cursor=" + context + "&limit=" + fmt.Sprintf("%d", limit)
 	}
 
-	resp, err := SendRequest(&token, http.MethodGet, apiURL, nil)
+	resp, err := SendRequest(ctx, &token, http.MethodGet, apiURL, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -818,11 +838,7 @@ func GetUserTimeline(pds string, token string, context string, actor string, lim
 	// fmt.Println("Response Body:", bodyString)
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-		fmt.Println("Response Status:", resp.StatusCode)
-		fmt.Println("Response Body:", bodyString)
-		return nil, errors.New(bodyString) // return response
+		return nil, errorFromResponse(resp)
 	}
 
 	feeds := Timeline{}
@@ -833,13 +849,13 @@ func GetUserTimeline(pds string, token string, context string, actor string, lim
 	return &feeds, err
 }
 
-func GetMediaTimeline(pds string, token string, context string, actor string, limit int) (*Timeline, error) {
+func GetMediaTimeline(ctx context.Context, pds string, token string, context string, actor string, limit int) (*Timeline, error) {
 	apiURL := pds + "/xrpc/app.bsky.feed.getAuthorFeed?actor=" + url.QueryEscape(actor) + "&limit=" + fmt.Sprintf("%d", limit) + "&filter=posts_with_media"
 	if context != "" {
 		apiURL = pds + "/xrpc/app.bsky.feed.getAuthorFeed?actor=" + url.QueryEscape(actor) + "&cursor=" + context + "&limit=" + fmt.Sprintf("%d", limit) + "&filter=posts_with_media"
 	}
 
-	resp, err := SendRequest(&token, http.MethodGet, apiURL, nil)
+	resp, err := SendRequest(ctx, &token, http.MethodGet, apiURL, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -851,11 +867,7 @@ func GetMediaTimeline(pds string, token string, context string, actor string, li
 	// fmt.Println("Response Body:", bodyString)
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-		fmt.Println("Response Status:", resp.StatusCode)
-		fmt.Println("Response Body:", bodyString)
-		return nil, errors.New(bodyString) // return response
+		return nil, errorFromResponse(resp)
 	}
 
 	feeds := Timeline{}
@@ -867,13 +879,13 @@ func GetMediaTimeline(pds string, token string, context string, actor string, li
 }
 
 // https://docs.bsky.app/docs/api/app-bsky-graph-get-list
-func GetListTimeline(pds string, token string, context string, listURI string, limit int) (*Timeline, error) {
+func GetListTimeline(ctx context.Context, pds string, token string, context string, listURI string, limit int) (*Timeline, error) {
 	apiURL := pds + "/xrpc/app.bsky.feed.getListFeed?list=" + url.QueryEscape(listURI) + "&limit=" + fmt.Sprintf("%d", limit)
 	if context != "" {
 		apiURL = pds + "/xrpc/app.bsky.feed.getListFeed?list=" + url.QueryEscape(listURI) + "&cursor=" + context + "&limit=" + fmt.Sprintf("%d", limit)
 	}
 
-	resp, err := SendRequest(&token, http.MethodGet, apiURL, nil)
+	resp, err := SendRequest(ctx, &token, http.MethodGet, apiURL, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -885,11 +897,7 @@ func GetListTimeline(pds string, token string, context string, listURI string, l
 	// fmt.Println("Response Body:", bodyString)
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-		fmt.Println("Response Status:", resp.StatusCode)
-		fmt.Println("Response Body:", bodyString)
-		return nil, errors.New(bodyString) // return response
+		return nil, errorFromResponse(resp)
 	}
 
 	feeds := Timeline{}
@@ -900,12 +908,12 @@ func GetListTimeline(pds string, token string, context string, listURI string, l
 	return &feeds, nil
 }
 
-func GetPost(pds string, token string, uri string, depth int, parentHeight int) (*ThreadRoot, error) {
+func GetPost(ctx context.Context, pds string, token string, uri string, depth int, parentHeight int) (*ThreadRoot, error) {
 	// Example URL at://did:plc:dqibjxtqfn6hydazpetzr2w4/app.bsky.feed.post/3lchbospvbc2j
 
 	url := pds + "/xrpc/app.bsky.feed.getPostThread?depth=" + fmt.Sprintf("%d", depth) + "&parentHeight=" + fmt.Sprintf("%d", parentHeight) + "&uri=" + uri
 
-	resp, err := SendRequest(&token, http.MethodGet, url, nil)
+	resp, err := SendRequest(ctx, &token, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -917,11 +925,7 @@ func GetPost(pds string, token string, uri string, depth int, parentHeight int)
 	// fmt.Println("Response Body:", bodyString)
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-		fmt.Println("Response Status:", resp.StatusCode)
-		fmt.Println("Response Body:", bodyString)
-		return nil, errors.New(bodyString) // return response
+		return nil, errorFromResponse(resp)
 	}
 
 	thread := ThreadRoot{}
@@ -933,7 +937,7 @@ func GetPost(pds string, token string, uri string, depth int, parentHeight int)
 }
 
 // https://docs.bsky.app/docs/api/app-bsky-feed-get-posts
-func GetPosts(pds string, token string, items []string) ([]*Post, error) {
+func GetPosts(ctx context.Context, pds string, token string, items []string) ([]*Post, error) {
 	var results []*Post
 
 	// Parallel fetching for chunks of up to 25 at a time
@@ -951,16 +955,14 @@ func GetPosts(pds string, token string, items []string) ([]*Post, error) {
 			defer wg.Done()
 
 			url := pds + "/xrpc/app.bsky.feed.getPosts" + "?uris=" + strings.Join(c, "&uris=")
-			resp, err := SendRequest(&token, http.MethodGet, url, nil)
+			resp, err := SendRequest(ctx, &token, http.MethodGet, url, nil)
 			if err != nil {
-				fmt.Println(err)
+				log.Error("Error getting posts", "error", err)
 				return
 			}
 			defer resp.Body.Close()
 			if resp.StatusCode != http.StatusOK {
-				bodyBytes, _ := io.ReadAll(resp.Body)
-				fmt.Println("Response Status:", resp.StatusCode)
-				fmt.Println("Response Body:", string(bodyBytes))
+				errorFromResponse(resp) // logs it, there's nowhere to return it to
 				return
 			}
 
@@ -984,13 +986,13 @@ func GetPosts(pds string, token string, items []string) ([]*Post, error) {
 }
 
 // https://docs.bsky.app/docs/api/app-bsky-graph-get-followers
-func GetFollowers(pds string, token string, context string, actor string) (*FollowersTimeline, error) {
+func GetFollowers(ctx context.Context, pds string, token string, context string, actor string) (*FollowersTimeline, error) {
 	apiURL := pds + "/xrpc/app.bsky.graph.getFollowers?actor=" + url.QueryEscape(actor)
 	if context != "" {
 		apiURL = pds + "/xrpc/app.bsky.graph.getFollowers?actor=" + url.QueryEscape(actor) + "&cursor=" + context
 	}
 
-	resp, err := SendRequest(&token, http.MethodGet, apiURL, nil)
+	resp, err := SendRequest(ctx, &token, http.MethodGet, apiURL, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -1002,11 +1004,7 @@ func GetFollowers(pds string, token string, context string, actor string) (*Foll
 	// fmt.Println("Response Body:", bodyString)
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-		fmt.Println("Response Status:", resp.StatusCode)
-		fmt.Println("Response Body:", bodyString)
-		return nil, errors.New(bodyString) // return response
+		return nil, errorFromResponse(resp)
 	}
 
 	feeds := FollowersTimeline{}
@@ -1018,13 +1016,13 @@ func GetFollowers(pds string, token string, context string, actor string) (*Foll
 }
 
 // https://docs.bsky.app/docs/api/app-bsky-graph-get-follows
-func GetFollows(pds string, token string, context string, actor string) (*FollowsTimeline, error) {
+func GetFollows(ctx context.Context, pds string, token string, context string, actor string) (*FollowsTimeline, error) {
 	apiURL := pds + "/xrpc/app.bsky.graph.getFollows?actor=" + url.QueryEscape(actor)
 	if context != "" {
 		apiURL = pds + "/xrpc/app.bsky.graph.getFollows?actor=" + url.QueryEscape(actor) + "&cursor=" + context
 	}
 
-	resp, err := SendRequest(&token, http.MethodGet, apiURL, nil)
+	resp, err := SendRequest(ctx, &token, http.MethodGet, apiURL, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -1036,11 +1034,7 @@ func GetFollows(pds string, token string, context string, actor string) (*Follow
 	// fmt.Println("Response Body:", bodyString)
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-		fmt.Println("Response Status:", resp.StatusCode)
-		fmt.Println("Response Body:", bodyString)
-		return nil, errors.New(bodyString) // return response
+		return nil, errorFromResponse(resp)
 	}
 
 	feeds := FollowsTimeline{}
@@ -1052,7 +1046,7 @@ func GetFollows(pds string, token string, context string, actor string) (*Follow
 }
 
 // This handles both normal & replys
-func UpdateStatus(pds string, token string, my_did string, status string, in_reply_to *string, mentions []bridge.FacetParsing, urls []bridge.FacetParsing, tags []bridge.FacetParsing, imageBlob *Blob, imageRes []int) (*ThreadRoot, error) {
+func UpdateStatus(ctx context.Context, pds string, token string, my_did string, status string, in_reply_to *string, mentions []bridge.FacetParsing, urls []bridge.FacetParsing, tags []bridge.FacetParsing, imageBlob *Blob, imageRes []int, linkCard *ExternalImage) (*ThreadRoot, error) {
 	url := pds + "/xrpc/com.atproto.repo.createRecord"
 
 	var replySubject *ReplySubject
@@ -1065,7 +1059,7 @@ func UpdateStatus(pds string, token string, my_did string, status string, in_rep
 	for _, mention := range mentions {
 		handles = append(handles, mention.Item)
 	}
-	mentionedUsers, err := GetUsersInfo(pds, token, handles, false)
+	mentionedUsers, err := GetUsersInfo(ctx, pds, token, handles, false)
 
 	// add mentions to the facets
 	if err == nil {
@@ -1131,7 +1125,7 @@ func UpdateStatus(pds string, token string, my_did string, status string, in_rep
 
 	// Replying
 	if in_reply_to != nil && *in_reply_to != "" {
-		replySubject, err = GetReplyRefs(pds, token, *in_reply_to)
+		replySubject, err = GetReplyRefs(ctx, pds, token, *in_reply_to)
 		if err != nil {
 			return nil, errors.New("failed to fetch reply refs")
 		}
@@ -1153,6 +1147,12 @@ func UpdateStatus(pds string, token string, my_did string, status string, in_rep
 				},
 			},
 		}
+	} else if linkCard != nil {
+		// a post can only have one embed, so the card only goes on if there's no image
+		embeds = Embed{
+			Type:     "app.bsky.embed.external",
+			External: linkCard,
+		}
 	}
 
 	payload := CreateRecordPayload{
@@ -1177,8 +1177,8 @@ func UpdateStatus(pds string, token string, my_did string, status string, in_rep
 	if err != nil {
 		return nil, errors.New("failed to marshal payload")
 	}
-	fmt.Println(string(reqBody))
-	resp, err := SendRequest(&token, http.MethodPost, url, bytes.NewReader(reqBody))
+	log.Debug("Creating post", "record", string(reqBody))
+	resp, err := SendRequest(ctx, &token, http.MethodPost, url, bytes.NewReader(reqBody))
 	if err != nil {
 		return nil, errors.New("failed to post")
 	}
@@ -1186,11 +1186,7 @@ func UpdateStatus(pds string, token string, my_did string, status string, in_rep
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-		fmt.Println("Response Status:", resp.StatusCode)
-		fmt.Println("Response Body:", bodyString)
-		return nil, errors.New(bodyString) // return response
+		return nil, errorFromResponse(resp)
 	}
 
 	postData := CreateRecordResult{}
@@ -1200,7 +1196,7 @@ func UpdateStatus(pds string, token string, my_did string, status string, in_rep
 
 	time.Sleep(100 * time.Millisecond) // Bluesky doesn't update instantly, so we wait a bit before fetching the post
 
-	thread, err := GetPost(pds, token, postData.URI, 0, 1)
+	thread, err := GetPost(ctx, pds, token, postData.URI, 0, 1)
 	if err != nil {
 		return nil, err // posibbly figure out how to add "metadata" to this?
 	}
@@ -1208,7 +1204,7 @@ func UpdateStatus(pds string, token string, my_did string, status string, in_rep
 	return thread, nil
 }
 
-func DeleteRecord(pds string, token string, id string, my_did string, collection string) error {
+func DeleteRecord(ctx context.Context, pds string, token string, id string, my_did string, collection string) error {
 	url := pds + "/xrpc/com.atproto.repo.deleteRecord"
 
 	payload := DeleteRecordPayload{
@@ -1222,36 +1218,36 @@ func DeleteRecord(pds string, token string, id string, my_did string, collection
 		return errors.New("failed to marshal payload")
 	}
 
-	resp, err := SendRequest(&token, http.MethodPost, url, bytes.NewReader(reqBody))
+	resp, err := SendRequest(ctx, &token, http.MethodPost, url, bytes.NewReader(reqBody))
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-		fmt.Println("Response Status:", resp.StatusCode)
-		fmt.Println("Response Body:", bodyString)
-		return errors.New(bodyString) // return response
+		return errorFromResponse(resp)
 	}
 	return nil
 }
 
-func ReTweet(pds string, token string, id string, my_did string) (*ThreadRoot, *string, error) {
+// ReTweet reposts a post, and gives back the post, the repost's URI, and the createdAt in the repost record.
+func ReTweet(ctx context.Context, pds string, token string, id string, my_did string) (*ThreadRoot, *string, *time.Time, error) {
 	url := pds + "/xrpc/com.atproto.repo.createRecord"
 
-	thread, err := GetPost(pds, token, id, 0, 1)
+	thread, err := GetPost(ctx, pds, token, id, 0, 1)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
+	// to the second, same as what's in the record once it's read back
+	createdAt := time.Now().UTC().Truncate(time.Second)
+
 	payload := CreateRecordPayload{
 		Collection: "app.bsky.feed.repost",
 		Repo:       my_did,
 		Record: PostInteractionRecord{
 			Type:      "app.bsky.feed.repost",
-			CreatedAt: time.Now().UTC().Format(time.RFC3339),
+			CreatedAt: createdAt.Format(time.RFC3339),
 			Subject: Subject{
 				URI: thread.Thread.Post.URI,
 				CID: thread.Thread.Post.CID,
@@ -1261,35 +1257,31 @@ func ReTweet(pds string, token string, id string, my_did string) (*ThreadRoot, *
 
 	reqBody, err := json.Marshal(payload)
 	if err != nil {
-		return nil, nil, errors.New("failed to marshal payload")
+		return nil, nil, nil, errors.New("failed to marshal payload")
 	}
 
-	resp, err := SendRequest(&token, http.MethodPost, url, bytes.NewReader(reqBody))
+	resp, err := SendRequest(ctx, &token, http.MethodPost, url, bytes.NewReader(reqBody))
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-		fmt.Println("Response Status:", resp.StatusCode)
-		fmt.Println("Response Body:", bodyString)
-		return nil, nil, errors.New(bodyString) // return response
+		return nil, nil, nil, errorFromResponse(resp)
 	}
 
 	repost := CreateRecordResult{}
 	if err := json.NewDecoder(resp.Body).Decode(&repost); err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
-	return thread, &repost.URI, nil
+	return thread, &repost.URI, &createdAt, nil
 }
 
-func LikePost(pds string, token string, id string, my_did string) (*ThreadRoot, error) {
+func LikePost(ctx context.Context, pds string, token string, id string, my_did string) (*ThreadRoot, error) {
 	url := pds + "/xrpc/com.atproto.repo.createRecord"
 
-	thread, err := GetPost(pds, token, id, 0, 1)
+	thread, err := GetPost(ctx, pds, token, id, 0, 1)
 	if err != nil {
 		return nil, errors.New("failed to fetch post")
 	}
@@ -1312,18 +1304,14 @@ func LikePost(pds string, token string, id string, my_did string) (*ThreadRoot,
 		return nil, errors.New("failed to marshal payload")
 	}
 
-	resp, err := SendRequest(&token, http.MethodPost, url, bytes.NewReader(reqBody))
+	resp, err := SendRequest(ctx, &token, http.MethodPost, url, bytes.NewReader(reqBody))
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-		fmt.Println("Response Status:", resp.StatusCode)
-		fmt.Println("Response Body:", bodyString)
-		return nil, errors.New(bodyString) // return response
+		return nil, errorFromResponse(resp)
 	}
 
 	likeRes := CreateRecordResult{}
@@ -1336,10 +1324,10 @@ func LikePost(pds string, token string, id string, my_did string) (*ThreadRoot,
 	return thread, nil
 }
 
-func UnlikePost(pds string, token string, id string, my_did string) (*ThreadRoot, error) {
+func UnlikePost(ctx context.Context, pds string, token string, id string, my_did string) (*ThreadRoot, error) {
 	url := pds + "/xrpc/com.atproto.repo.deleteRecord"
 
-	thread, err := GetPost(pds, token, id, 0, 1)
+	thread, err := GetPost(ctx, pds, token, id, 0, 1)
 	if err != nil {
 		return nil, errors.New("failed to fetch post")
 	}
@@ -1355,18 +1343,14 @@ func UnlikePost(pds string, token string, id string, my_did string) (*ThreadRoot
 		return nil, errors.New("failed to marshal payload")
 	}
 
-	resp, err := SendRequest(&token, http.MethodPost, url, bytes.NewReader(reqBody))
+	resp, err := SendRequest(ctx, &token, http.MethodPost, url, bytes.NewReader(reqBody))
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-		fmt.Println("Response Status:", resp.StatusCode)
-		fmt.Println("Response Body:", bodyString)
-		return nil, errors.New(bodyString) // return response
+		return nil, errorFromResponse(resp)
 	}
 
 	likeRes := CreateRecordResult{}
@@ -1380,10 +1364,10 @@ func UnlikePost(pds string, token string, id string, my_did string) (*ThreadRoot
 	return thread, nil
 }
 
-func FollowUser(pds string, token string, targetActor string, my_did string) (*User, error) {
+func FollowUser(ctx context.Context, pds string, token string, targetActor string, my_did string) (*User, error) {
 	url := pds + "/xrpc/com.atproto.repo.createRecord"
 
-	targetUser, err := GetUserInfoRaw(pds, token, targetActor)
+	targetUser, err := GetUserInfoRaw(ctx, pds, token, targetActor)
 	if err != nil {
 		return nil, errors.New("failed to fetch post")
 	}
@@ -1407,18 +1391,14 @@ func FollowUser(pds string, token string, targetActor string, my_did string) (*U
 		return nil, errors.New("failed to marshal payload")
 	}
 
-	resp, err := SendRequest(&token, http.MethodPost, url, bytes.NewReader(reqBody))
+	resp, err := SendRequest(ctx, &token, http.MethodPost, url, bytes.NewReader(reqBody))
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-		fmt.Println("Response Status:", resp.StatusCode)
-		fmt.Println("Response Body:", bodyString)
-		return nil, errors.New(bodyString) // return response
+		return nil, errorFromResponse(resp)
 	}
 
 	followRes := CreateRecordResult{}
@@ -1432,10 +1412,10 @@ func FollowUser(pds string, token string, targetActor string, my_did string) (*U
 	return targetUser, nil
 }
 
-func UnfollowUser(pds string, token string, targetActor string, my_did string) (*User, error) {
+func UnfollowUser(ctx context.Context, pds string, token string, targetActor string, my_did string) (*User, error) {
 	url := pds + "/xrpc/com.atproto.repo.deleteRecord"
 
-	targetUser, err := GetUserInfoRaw(pds, token, targetActor)
+	targetUser, err := GetUserInfoRaw(ctx, pds, token, targetActor)
 	if err != nil {
 		return nil, errors.New("failed to fetch post")
 	}
@@ -1455,18 +1435,14 @@ func UnfollowUser(pds string, token string, targetActor string, my_did string) (
 		return nil, errors.New("failed to marshal payload")
 	}
 
-	resp, err := SendRequest(&token, http.MethodPost, url, bytes.NewReader(reqBody))
+	resp, err := SendRequest(ctx, &token, http.MethodPost, url, bytes.NewReader(reqBody))
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-		fmt.Println("Response Status:", resp.StatusCode)
-		fmt.Println("Response Body:", bodyString)
-		return nil, errors.New(bodyString) // return response
+		return nil, errorFromResponse(resp)
 	}
 
 	unfollowRes := CreateRecordResult{}
@@ -1480,10 +1456,10 @@ func UnfollowUser(pds string, token string, targetActor string, my_did string) (
 	return targetUser, nil
 }
 
-func GetPostLikes(pds string, token string, uri string, limit int) (*Likes, error) {
+func GetPostLikes(ctx context.Context, pds string, token string, uri string, limit int) (*Likes, error) {
 	url := fmt.Sprintf(pds+"/xrpc/app.bsky.feed.getLikes?limit=%d&uri=%s", limit, uri)
 
-	resp, err := SendRequest(&token, http.MethodGet, url, nil)
+	resp, err := SendRequest(ctx, &token, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -1495,11 +1471,7 @@ func GetPostLikes(pds string, token string, uri string, limit int) (*Likes, erro
 	// fmt.Println("Response Body:", bodyString)
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-		fmt.Println("Response Status:", resp.StatusCode)
-		fmt.Println("Response Body:", bodyString)
-		return nil, errors.New(bodyString) // return response
+		return nil, errorFromResponse(resp)
 	}
 
 	likes := Likes{}
@@ -1514,13 +1486,13 @@ func GetPostLikes(pds string, token string, uri string, limit int) (*Likes, erro
 // Bluesky for SOME REASON limits viewing the likes to your own user. WHy?
 // What is the point of having an "actor" field if you can only use 1 actor?"ADD THIS TO LOOKUP TABLE"
 // I'm still gonna implement it, we can hope it will be expanded in the future.
-func GetActorLikes(pds string, token string, context string, actor string, limit int) (*Timeline, error) {
+func GetActorLikes(ctx context.Context, pds string, token string, context string, actor string, limit int) (*Timeline, error) {
 	url := fmt.Sprintf(pds+"/xrpc/app.bsky.feed.getActorLikes?limit=%d&actor=%s", limit, actor)
 	if context != "" {
 		url = fmt.Sprintf(pds+"/xrpc/app.bsky.feed.getActorLikes?limit=%d&actor=%s&cursor=%s", limit, actor, context)
 	}
 
-	resp, err := SendRequest(&token, http.MethodGet, url, nil)
+	resp, err := SendRequest(ctx, &token, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -1532,11 +1504,7 @@ func GetActorLikes(pds string, token string, context string, actor string, limit
 	// fmt.Println("Response Body:", bodyString)
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-		fmt.Println("Response Status:", resp.StatusCode)
-		fmt.Println("Response Body:", bodyString)
-		return nil, errors.New(bodyString) // return response
+		return nil, errorFromResponse(resp)
 	}
 
 	likes := Timeline{}
@@ -1547,10 +1515,10 @@ func GetActorLikes(pds string, token string, context string, actor string, limit
 	return &likes, nil
 }
 
-func GetRetweetAuthors(pds string, token string, uri string, limit int) (*RepostedBy, error) {
+func GetRetweetAuthors(ctx context.Context, pds string, token string, uri string, limit int) (*RepostedBy, error) {
 	url := fmt.Sprintf(pds+"/xrpc/app.bsky.feed.getRepostedBy?limit=%d&uri=%s", limit, uri)
 
-	resp, err := SendRequest(&token, http.MethodGet, url, nil)
+	resp, err := SendRequest(ctx, &token, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -1562,11 +1530,7 @@ func GetRetweetAuthors(pds string, token string, uri string, limit int) (*Repost
 	// fmt.Println("Response Body:", bodyString)
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-		fmt.Println("Response Status:", resp.StatusCode)
-		fmt.Println("Response Body:", bodyString)
-		return nil, errors.New("failed to fetch retweet authors")
+		return nil, errorFromResponse(resp)
 	}
 
 	retweetAuthors := RepostedBy{}
@@ -1577,10 +1541,10 @@ func GetRetweetAuthors(pds string, token string, uri string, limit int) (*Repost
 	return &retweetAuthors, nil
 }
 
-func UserSearch(pds string, token string, query string) ([]User, error) {
+func UserSearch(ctx context.Context, pds string, token string, query string) ([]User, error) {
 	url := pds + "/xrpc/app.bsky.actor.searchActors?q=" + url.QueryEscape(query)
 
-	resp, err := SendRequest(&token, http.MethodGet, url, nil)
+	resp, err := SendRequest(ctx, &token, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -1592,11 +1556,7 @@ func UserSearch(pds string, token string, query string) ([]User, error) {
 	// fmt.Println("Response Body:", bodyString)
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-		fmt.Println("Response Status:", resp.StatusCode)
-		fmt.Println("Response Body:", bodyString)
-		return nil, errors.New(bodyString) // return response
+		return nil, errorFromResponse(resp)
 	}
 
 	users := UserSearchResult{}
@@ -1607,10 +1567,10 @@ func UserSearch(pds string, token string, query string) ([]User, error) {
 }
 
 // exactly the same as usersearch but different i guess idk
-func UserSearchAhead(pds string, token string, query string, limit int) ([]User, error) {
+func UserSearchAhead(ctx context.Context, pds string, token string, query string, limit int) ([]User, error) {
 	url := pds + "/xrpc/app.bsky.actor.searchActorsTypeahead?q=" + url.QueryEscape(query) + "&limit=" + fmt.Sprintf("%d", limit)
 
-	resp, err := SendRequest(&token, http.MethodGet, url, nil)
+	resp, err := SendRequest(ctx, &token, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -1622,11 +1582,7 @@ func UserSearchAhead(pds string, token string, query string, limit int) ([]User,
 	// fmt.Println("Response Body:", bodyString)
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-		fmt.Println("Response Status:", resp.StatusCode)
-		fmt.Println("Response Body:", bodyString)
-		return nil, errors.New(bodyString) // return response
+		return nil, errorFromResponse(resp)
 	}
 
 	users := UserSearchResult{}
@@ -1636,7 +1592,7 @@ func UserSearchAhead(pds string, token string, query string, limit int) ([]User,
 	return users.Actors, nil
 }
 
-func PostSearch(pds string, token string, query string, since *time.Time, until *time.Time) ([]Post, error) {
+func PostSearch(ctx context.Context, pds string, token string, query string, since *time.Time, until *time.Time) ([]Post, error) {
 	url := pds + "/xrpc/app.bsky.feed.searchPosts?sort=top&q=" + url.QueryEscape(query)
 	if since != nil {
 		url += "&since=" + since.Format(time.RFC3339)
@@ -1645,7 +1601,7 @@ func PostSearch(pds string, token string, query string, since *time.Time, until
 		url += "&until=" + until.Format(time.RFC3339)
 	}
 
-	resp, err := SendRequest(&token, http.MethodGet, url, nil)
+	resp, err := SendRequest(ctx, &token, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -1657,11 +1613,7 @@ func PostSearch(pds string, token string, query string, since *time.Time, until
 	// fmt.Println("Response Body:", bodyString)
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-		fmt.Println("Response Status:", resp.StatusCode)
-		fmt.Println("Response Body:", bodyString)
-		return nil, errors.New("failed to fetch search results")
+		return nil, errorFromResponse(resp)
 	}
 
 	posts := PostSearchResult{}
@@ -1672,9 +1624,9 @@ func PostSearch(pds string, token string, query string, since *time.Time, until
 }
 
 // thank you https://docs.bsky.app/blog/create-post#replies
-func GetReplyRefs(pds string, token string, parentURI string) (*ReplySubject, error) {
+func GetReplyRefs(ctx context.Context, pds string, token string, parentURI string) (*ReplySubject, error) {
 	// Get the parent post
-	parentThread, err := GetPost(pds, token, parentURI, 0, 1)
+	parentThread, err := GetPost(ctx, pds, token, parentURI, 0, 1)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch parent post: %w", err)
 	}
@@ -1686,7 +1638,7 @@ func GetReplyRefs(pds string, token string, parentURI string) (*ReplySubject, er
 	if parentThread.Thread.Post.Record.Reply != nil {
 		// Get the root post
 		rootURI = parentThread.Thread.Post.Record.Reply.Root.URI
-		rootThread, err := GetPost(pds, token, rootURI, 0, 1)
+		rootThread, err := GetPost(ctx, pds, token, rootURI, 0, 1)
 		if err != nil {
 			return nil, fmt.Errorf("failed to fetch root post: %w", err)
 		}
@@ -1709,16 +1661,16 @@ func GetReplyRefs(pds string, token string, parentURI string) (*ReplySubject, er
 	}, nil
 }
 
-func GetRecordWithUri(pds string, uri string) (*RecordResponse, error) {
+func GetRecordWithUri(ctx context.Context, pds string, uri string) (*RecordResponse, error) {
 	collection, repo, rkey := GetURIComponents(uri)
-	return GetRecord(pds, collection, repo, rkey)
+	return GetRecord(ctx, pds, collection, repo, rkey)
 }
 
-func GetRecord(pds string, collection string, repo string, rkey string) (*RecordResponse, error) {
+func GetRecord(ctx context.Context, pds string, collection string, repo string, rkey string) (*RecordResponse, error) {
 
 	url := pds + "/xrpc/com.atproto.repo.getRecord?collection=" + collection + "&repo=" + repo + "&rkey=" + rkey
 
-	resp, err := SendRequest(nil, http.MethodGet, url, nil)
+	resp, err := SendRequest(ctx, nil, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -1730,11 +1682,7 @@ func GetRecord(pds string, collection string, repo string, rkey string) (*Record
 	// fmt.Println("Response Body:", bodyString)
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-		fmt.Println("Response Status:", resp.StatusCode)
-		fmt.Println("Response Body:", bodyString)
-		return nil, errors.New(bodyString) // return response
+		return nil, errorFromResponse(resp)
 	}
 
 	record := RecordResponse{}
@@ -1745,7 +1693,7 @@ func GetRecord(pds string, collection string, repo string, rkey string) (*Record
 	return &record, nil
 }
 
-func UpdateRecord(pds string, token string, collection string, repo string, rkey string, swapRecord string, newRecord interface{}) error {
+func UpdateRecord(ctx context.Context, pds string, token string, collection string, repo string, rkey string, swapRecord string, newRecord interface{}) error {
 	url := pds + "/xrpc/com.atproto.repo.putRecord"
 
 	payload := UpdateRecordPayload{
@@ -1763,27 +1711,23 @@ func UpdateRecord(pds string, token string, collection string, repo string, rkey
 		return errors.New("failed to marshal payload")
 	}
 
-	resp, err := SendRequest(&token, http.MethodPost, url, bytes.NewReader(reqBody))
+	resp, err := SendRequest(ctx, &token, http.MethodPost, url, bytes.NewReader(reqBody))
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-		fmt.Println("Response Status:", resp.StatusCode)
-		fmt.Println("Response Body:", bodyString)
-		return errors.New("failed to update: " + bodyString)
+		return errorFromResponse(resp)
 	}
 	return nil
 }
 
 // This feature is still in beta, and is likely to break in the future
-func GetTrends(pds string, token string) (*TrendingTopics, error) {
+func GetTrends(ctx context.Context, pds string, token string) (*TrendingTopics, error) {
 	url := pds + "/xrpc/app.bsky.unspecced.getTrendingTopics"
 
-	resp, err := SendRequest(&token, http.MethodGet, url, nil)
+	resp, err := SendRequest(ctx, &token, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -1795,11 +1739,7 @@ func GetTrends(pds string, token string) (*TrendingTopics, error) {
 	// fmt.Println("Response Body:", bodyString)
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-		fmt.Println("Response Status:", resp.StatusCode)
-		fmt.Println("Response Body:", bodyString)
-		return nil, errors.New(bodyString) // return response
+		return nil, errorFromResponse(resp)
 	}
 
 	trends := TrendingTopics{}
@@ -1810,13 +1750,13 @@ func GetTrends(pds string, token string) (*TrendingTopics, error) {
 	return &trends, nil
 }
 
-func GetUsersLists(pds string, token string, actor string, limit int, cursor string) (*Lists, error) {
+func GetUsersLists(ctx context.Context, pds string, token string, actor string, limit int, cursor string) (*Lists, error) {
 	url := fmt.Sprintf(pds+"/xrpc/app.bsky.graph.getLists?limit=%d&actor=%s", limit, actor)
 	if cursor != "" {
 		url = fmt.Sprintf(pds+"/xrpc/app.bsky.graph.getLists?limit=%d&actor=%s&cursor=%s", limit, actor, cursor)
 	}
 
-	resp, err := SendRequest(&token, http.MethodGet, url, nil)
+	resp, err := SendRequest(ctx, &token, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -1828,11 +1768,7 @@ func GetUsersLists(pds string, token string, actor string, limit int, cursor str
 	// fmt.Println("Response Body:", bodyString)
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-		fmt.Println("Response Status:", resp.StatusCode)
-		fmt.Println("Response Body:", bodyString)
-		return nil, errors.New(bodyString) // return response
+		return nil, errorFromResponse(resp)
 	}
 
 	lists := Lists{}
@@ -1843,13 +1779,13 @@ func GetUsersLists(pds string, token string, actor string, limit int, cursor str
 	return &lists, nil
 }
 
-func GetList(pds string, token string, listURI string, limit int, cursor string) (*ListDetailed, error) {
+func GetList(ctx context.Context, pds string, token string, listURI string, limit int, cursor string) (*ListDetailed, error) {
 	url := fmt.Sprintf(pds+"/xrpc/app.bsky.graph.getList?limit=%d&list=%s", limit, listURI)
 	if cursor != "" {
 		url = fmt.Sprintf(pds+"/xrpc/app.bsky.graph.getList?limit=%d&list=%s&cursor=%s", limit, listURI, cursor)
 	}
 
-	resp, err := SendRequest(&token, http.MethodGet, url, nil)
+	resp, err := SendRequest(ctx, &token, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -1861,11 +1797,7 @@ func GetList(pds string, token string, listURI string, limit int, cursor string)
 	// fmt.Println("Response Body:", bodyString)
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-		fmt.Println("Response Status:", resp.StatusCode)
-		fmt.Println("Response Body:", bodyString)
-		return nil, errors.New(bodyString) // return response
+		return nil, errorFromResponse(resp)
 	}
 
 	list := ListDetailed{}
@@ -1876,10 +1808,10 @@ func GetList(pds string, token string, listURI string, limit int, cursor string)
 	return &list, nil
 }
 
-func GetMySuggestedUsers(pds string, token string, limit int) ([]User, error) {
+func GetMySuggestedUsers(ctx context.Context, pds string, token string, limit int) ([]User, error) {
 	url := pds + "/xrpc/app.bsky.actor.getSuggestions?limit=" + fmt.Sprintf("%d", limit)
 
-	resp, err := SendRequest(&token, http.MethodGet, url, nil)
+	resp, err := SendRequest(ctx, &token, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -1891,11 +1823,7 @@ func GetMySuggestedUsers(pds string, token string, limit int) ([]User, error) {
 	// fmt.Println("Response Body:", bodyString)
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-		fmt.Println("Response Status:", resp.StatusCode)
-		fmt.Println("Response Body:", bodyString)
-		return nil, errors.New(bodyString) // return response
+		return nil, errorFromResponse(resp)
 	}
 
 	users := UserSearchResult{}
@@ -1905,10 +1833,10 @@ func GetMySuggestedUsers(pds string, token string, limit int) ([]User, error) {
 	return users.Actors, nil
 }
 
-func GetTopicSuggestedUsers(pds string, token string, limit int, category string) ([]User, error) {
+func GetTopicSuggestedUsers(ctx context.Context, pds string, token string, limit int, category string) ([]User, error) {
 	url := pds + "/xrpc/app.bsky.unspecced.getSuggestedUsers?limit=" + fmt.Sprintf("%d", limit) + "&category=" + category
 
-	resp, err := SendRequest(&token, http.MethodGet, url, nil)
+	resp, err := SendRequest(ctx, &token, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -1920,11 +1848,7 @@ func GetTopicSuggestedUsers(pds string, token string, limit int, category string
 	// fmt.Println("Response Body:", bodyString)
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-		fmt.Println("Response Status:", resp.StatusCode)
-		fmt.Println("Response Body:", bodyString)
-		return nil, errors.New(bodyString) // return response
+		return nil, errorFromResponse(resp)
 	}
 
 	users := UserSearchResult{}
@@ -1934,10 +1858,10 @@ func GetTopicSuggestedUsers(pds string, token string, limit int, category string
 	return users.Actors, nil
 }
 
-func GetOthersSuggestedUsers(pds string, token string, limit int, actor string) ([]User, error) {
+func GetOthersSuggestedUsers(ctx context.Context, pds string, token string, limit int, actor string) ([]User, error) {
 	url := pds + "/xrpc/app.bsky.graph.getSuggestedFollowsByActor?limit=" + fmt.Sprintf("%d", limit) + "&actor=" + actor
 
-	resp, err := SendRequest(&token, http.MethodGet, url, nil)
+	resp, err := SendRequest(ctx, &token, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -1949,11 +1873,7 @@ func GetOthersSuggestedUsers(pds string, token string, limit int, actor string)
 	// fmt.Println("Response Body:", bodyString)
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-		fmt.Println("Response Status:", resp.StatusCode)
-		fmt.Println("Response Body:", bodyString)
-		return nil, errors.New(bodyString) // return response
+		return nil, errorFromResponse(resp)
 	}
 
 	users := OtherActorSuggestions{}
@@ -1963,13 +1883,13 @@ func GetOthersSuggestedUsers(pds string, token string, limit int, actor string)
 	return users.Actors, nil
 }
 
-func GetNotifications(pds string, token string, limit int, context string) (*Notifications, error) {
+func GetNotifications(ctx context.Context, pds string, token string, limit int, context string) (*Notifications, error) {
 	url := pds + "/xrpc/app.bsky.notification.listNotifications?limit=" + fmt.Sprintf("%d", limit)
 	if context != "" {
 		url = pds + "/xrpc/app.bsky.notification.listNotifications?reasons=mention&reasons=reply&reasons=quote&reasons=like&reasons=repost&reasons=follow&cursor=" + context + "&limit=" + fmt.Sprintf("%d", limit)
 	}
 
-	resp, err := SendRequest(&token, http.MethodGet, url, nil)
+	resp, err := SendRequest(ctx, &token, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -1981,11 +1901,7 @@ func GetNotifications(pds string, token string, limit int, context string) (*Not
 	// fmt.Println("Response Body:", bodyString)
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-		fmt.Println("Response Status:", resp.StatusCode)
-		fmt.Println("Response Body:", bodyString)
-		return nil, errors.New(bodyString) // return response
+		return nil, errorFromResponse(resp)
 	}
 
 	notifications := Notifications{}
@@ -1996,10 +1912,10 @@ func GetNotifications(pds string, token string, limit int, context string) (*Not
 	return &notifications, nil
 }
 
-func GetSession(pds string, token string) (*Session, error) {
+func GetSession(ctx context.Context, pds string, token string) (*Session, error) {
 	url := pds + "/xrpc/com.atproto.server.getSession"
 
-	resp, err := SendRequest(&token, http.MethodGet, url, nil)
+	resp, err := SendRequest(ctx, &token, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -2011,11 +1927,7 @@ func GetSession(pds string, token string) (*Session, error) {
 	// fmt.Println("Response Body:", bodyString)
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-		fmt.Println("Response Status:", resp.StatusCode)
-		fmt.Println("Response Body:", bodyString)
-		return nil, errors.New(bodyString) // return response
+		return nil, errorFromResponse(resp)
 	}
 
 	session := Session{}
@@ -2026,13 +1938,13 @@ func GetSession(pds string, token string) (*Session, error) {
 	return &session, nil
 }
 
-func GetMentions(pds string, token string, limit int, context string) (*Notifications, error) {
+func GetMentions(ctx context.Context, pds string, token string, limit int, context string) (*Notifications, error) {
 	url := pds + "/xrpc/app.bsky.notification.listNotifications?reasons=mention&reasons=reply&reasons=quote&limit=" + fmt.Sprintf("%d", limit)
 	if context != "" {
 		url = pds + "/xrpc/app.bsky.notification.listNotifications?reasons=mention&reasons=reply&reasons=quote&cursor=" + context + "&limit=" + fmt.Sprintf("%d", limit)
 	}
 
-	resp, err := SendRequest(&token, http.MethodGet, url, nil)
+	resp, err := SendRequest(ctx, &token, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -2044,11 +1956,7 @@ func GetMentions(pds string, token string, limit int, context string) (*Notifica
 	// fmt.Println("Response Body:", bodyString)
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-		fmt.Println("Response Status:", resp.StatusCode)
-		fmt.Println("Response Body:", bodyString)
-		return nil, errors.New(bodyString) // return response
+		return nil, errorFromResponse(resp)
 	}
 
 	notifications := Notifications{}
@@ -2059,21 +1967,17 @@ func GetMentions(pds string, token string, limit int, context string) (*Notifica
 	return &notifications, nil
 }
 
-func UploadBlob(pds string, token string, data []byte, content_type string) (*Blob, error) {
+func UploadBlob(ctx context.Context, pds string, token string, data []byte, content_type string) (*Blob, error) {
 	url := pds + "/xrpc/com.atproto.repo.uploadBlob"
 
-	resp, err := SendRequestWithContentType(&token, http.MethodPost, url, bytes.NewReader(data), content_type)
+	resp, err := SendRequestWithContentType(ctx, &token, http.MethodPost, url, bytes.NewReader(data), content_type)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyString := string(bodyBytes)
-		fmt.Println("Response Status:", resp.StatusCode)
-		fmt.Println("Response Body:", bodyString)
-		return nil, errors.New(bodyString) // return response
+		return nil, errorFromResponse(resp)
 	}
 
 	blob := struct {
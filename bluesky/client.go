@@ -0,0 +1,431 @@
+package blueskyapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Preloading/TwitterAPIBridge/metrics"
+)
+
+// Everything we send to bluesky goes through one client, so connections to the PDS get reused
+// instead of doing a new TLS handshake for every request.
+var httpClient = func() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = 256
+	transport.MaxIdleConnsPerHost = 64 // most users are on a handful of PDSes
+	transport.IdleConnTimeout = 90 * time.Second
+	return &http.Client{Transport: transport}
+}()
+
+// How long a single request to bluesky can take, if the config doesn't say.
+const defaultXRPCTimeout = 20 * time.Second
+
+func xrpcTimeout() time.Duration {
+	if configData != nil && configData.XRPCTimeoutSeconds > 0 {
+		return time.Duration(configData.XRPCTimeoutSeconds) * time.Second
+	}
+	return defaultXRPCTimeout
+}
+
+func xrpcRetries() int {
+	if configData != nil {
+		return max(configData.XRPCRetries, 0)
+	}
+	return 2
+}
+
+// sendXRPC sends a request to bluesky.
+// GETs are retried (with backoff) if the network or the PDS has a hiccup. Anything else could've gone through, so it isn't.
+// If we know we're rate limited, this doesn't even send it, and returns a 429 like bluesky would.
+// If bluesky says the token expired and the context has a TokenRefresher, it's refreshed and the request is sent again, once.
+func sendXRPC(ctx context.Context, token *string, method string, rawURL string, payload []byte, contentType string) (*http.Response, error) {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	token = refreshedToken(ctx, token)
+
+	maxRetries := 0
+	if method == http.MethodGet || method == http.MethodHead {
+		maxRetries = xrpcRetries()
+	}
+	retries := 0
+	refreshed := false
+
+	for {
+		limitKey := rateLimitKey(parsedURL, token)
+
+		// per attempt, so a retry gets the full timeout
+		callCtx, cancel := context.WithTimeout(ctx, xrpcTimeout())
+		var body io.Reader
+		if payload != nil {
+			body = bytes.NewReader(payload)
+		}
+		req, err := http.NewRequestWithContext(callCtx, method, rawURL, body)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		if token != nil {
+			req.Header.Set("Authorization", "Bearer "+*token)
+		}
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("User-Agent", "ATwitterBridge/1.0")
+
+		if limit, limited := rateLimits.check(limitKey); limited {
+			cancel()
+			recordRateLimit(ctx, limit)
+			metrics.XRPCRequests.Inc(xrpcMethod(req.URL), "backoff", "RateLimitExceeded")
+			return rateLimitedResponse(req, limit), nil
+		}
+
+		resp, errorName, err := doXRPC(httpClient, req)
+		if err == nil {
+			if limit, ok := rateLimits.update(limitKey, resp); ok && resp.StatusCode == http.StatusTooManyRequests {
+				recordRateLimit(ctx, limit)
+			}
+		}
+
+		if errorName == "ExpiredToken" && token != nil && !refreshed {
+			// bluesky turned it down before doing anything, so it's safe to send again, whatever it is
+			if newToken, ok := refreshToken(ctx, *token); ok {
+				resp.Body.Close()
+				cancel()
+				token = &newToken
+				refreshed = true
+				continue
+			}
+		}
+
+		if retries < maxRetries && shouldRetry(ctx, resp, err) {
+			if resp != nil {
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+			cancel()
+			retries++
+			log.DebugContext(ctx, "Retrying bluesky request", "method", xrpcMethod(req.URL), "attempt", retries, "error", err)
+			if err := sleep(ctx, retryBackoff(retries)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		// the timeout has to last until the caller's done reading the body
+		resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+		return resp, nil
+	}
+}
+
+func shouldRetry(ctx context.Context, resp *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		// the request we're doing this for is over (or out of time), no point
+		return false
+	}
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryBackoff is exponential (250ms, 500ms, 1s...), with jitter so a PDS that just came back doesn't get everyone at once.
+func retryBackoff(attempt int) time.Duration {
+	backoff := 250 * time.Millisecond << (attempt - 1)
+	return backoff/2 + rand.N(backoff/2+1)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// -- Token refreshes --
+
+// TokenRefresher gets a new access token when bluesky says the one we sent expired.
+// It's given the expired token, so if someone else already refreshed it, it can hand back theirs.
+type TokenRefresher func(ctx context.Context, expiredToken string) (string, error)
+
+type tokenRefresherContextKey struct{}
+
+type tokenRefresherHolder struct {
+	refresh TokenRefresher
+	mutex   sync.Mutex
+	// expired token -> what it got refreshed to, so later requests during the same request don't send the expired one again
+	refreshed map[string]string
+}
+
+// WithTokenRefresher makes requests using this context refresh the token & try again if it has expired.
+func WithTokenRefresher(ctx context.Context, refresh TokenRefresher) context.Context {
+	return context.WithValue(ctx, tokenRefresherContextKey{}, &tokenRefresherHolder{refresh: refresh, refreshed: make(map[string]string)})
+}
+
+// refreshedToken swaps the token for the new one, if it's one we've already refreshed.
+func refreshedToken(ctx context.Context, token *string) *string {
+	holder, ok := ctx.Value(tokenRefresherContextKey{}).(*tokenRefresherHolder)
+	if !ok || holder == nil || token == nil {
+		return token
+	}
+	holder.mutex.Lock()
+	defer holder.mutex.Unlock()
+	if newToken, ok := holder.refreshed[*token]; ok {
+		return &newToken
+	}
+	return token
+}
+
+func refreshToken(ctx context.Context, expiredToken string) (string, bool) {
+	holder, ok := ctx.Value(tokenRefresherContextKey{}).(*tokenRefresherHolder)
+	if !ok || holder == nil {
+		return "", false
+	}
+
+	// Refreshing sends a request too, which can't end up back here if the refresh token is expired as well.
+	refreshCtx := context.WithValue(ctx, tokenRefresherContextKey{}, (*tokenRefresherHolder)(nil))
+	newToken, err := holder.refresh(refreshCtx, expiredToken)
+	if err != nil {
+		log.WarnContext(ctx, "Couldn't refresh expired token", "error", err)
+		return "", false
+	}
+
+	holder.mutex.Lock()
+	holder.refreshed[expiredToken] = newToken
+	holder.mutex.Unlock()
+	return newToken, true
+}
+
+// -- Rate limits --
+
+// RateLimit is what a PDS told us about its rate limit, from the RateLimit-* headers.
+type RateLimit struct {
+	Limit     int
+	Remaining int
+	Reset     time.Time
+}
+
+// RetryAfter is how many seconds until the rate limit resets, rounded up.
+func (l RateLimit) RetryAfter() int {
+	return max(int(math.Ceil(time.Until(l.Reset).Seconds())), 0)
+}
+
+// Rate limits are tracked per PDS *and* per account, since that's how bluesky counts most of them.
+// If we only went by PDS, one busy user hitting their limit would get everyone else on bsky.social locked out too.
+type rateLimitTracker struct {
+	mutex  sync.Mutex
+	limits map[string]RateLimit
+}
+
+var rateLimits = &rateLimitTracker{limits: make(map[string]RateLimit)}
+
+// Once there's this many, the ones that have already reset get cleaned out.
+const maxTrackedRateLimits = 10000
+
+func rateLimitKey(u *url.URL, token *string) string {
+	if token == nil {
+		return u.Host
+	}
+	// hashed, so there isn't a map full of tokens sitting in memory
+	sum := sha256.Sum256([]byte(*token))
+	return u.Host + "|" + hex.EncodeToString(sum[:8])
+}
+
+// check says if we've used up the rate limit, and shouldn't send anything until it resets.
+func (t *rateLimitTracker) check(key string) (RateLimit, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	limit, ok := t.limits[key]
+	if !ok {
+		return RateLimit{}, false
+	}
+	if !time.Now().Before(limit.Reset) {
+		delete(t.limits, key)
+		return RateLimit{}, false
+	}
+	return limit, limit.Remaining == 0
+}
+
+// update saves the rate limit from a response, if it had one.
+func (t *rateLimitTracker) update(key string, resp *http.Response) (RateLimit, bool) {
+	limit, ok := parseRateLimit(resp)
+	if !ok {
+		return RateLimit{}, false
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if len(t.limits) >= maxTrackedRateLimits {
+		now := time.Now()
+		for k, l := range t.limits {
+			if !now.Before(l.Reset) {
+				delete(t.limits, k)
+			}
+		}
+	}
+	t.limits[key] = limit
+	return limit, true
+}
+
+func parseRateLimit(resp *http.Response) (RateLimit, bool) {
+	limit := RateLimit{Limit: -1, Remaining: -1}
+	found := false
+
+	if value, err := strconv.Atoi(resp.Header.Get("RateLimit-Limit")); err == nil {
+		limit.Limit = value
+		found = true
+	}
+	if value, err := strconv.Atoi(resp.Header.Get("RateLimit-Remaining")); err == nil {
+		limit.Remaining = value
+		found = true
+	}
+	// bluesky sends the reset as a unix timestamp, not seconds from now
+	if value, err := strconv.ParseInt(resp.Header.Get("RateLimit-Reset"), 10, 64); err == nil {
+		limit.Reset = time.Unix(value, 0)
+		found = true
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		limit.Remaining = 0
+		if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			limit.Reset = retryAfter
+		}
+		if limit.Reset.IsZero() {
+			// no idea when it resets, give it a bit
+			limit.Reset = time.Now().Add(time.Minute)
+		}
+		found = true
+	}
+
+	if !found || limit.Reset.IsZero() {
+		return RateLimit{}, false
+	}
+	return limit, true
+}
+
+func parseRetryAfter(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Now().Add(time.Duration(seconds) * time.Second), true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return date, true
+	}
+	return time.Time{}, false
+}
+
+// rateLimitedResponse makes the 429 bluesky would've sent us, so callers handle it like any other error from bluesky.
+func rateLimitedResponse(req *http.Request, limit RateLimit) *http.Response {
+	body, _ := json.Marshal(struct {
+		Error   string `json:"error"`
+		Message string `json:"message"`
+	}{
+		Error:   "RateLimitExceeded",
+		Message: "Rate Limit Exceeded",
+	})
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("Retry-After", strconv.Itoa(limit.RetryAfter()))
+	return &http.Response{
+		Status:        "429 Too Many Requests",
+		StatusCode:    http.StatusTooManyRequests,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// Handlers want to know the rate limit we ran into, so they can pass it on to the client.
+// TrackRateLimits gives a request's context somewhere to put that.
+type rateLimitContextKey struct{}
+
+type rateLimitHolder struct {
+	mutex sync.Mutex
+	limit *RateLimit
+}
+
+// TrackRateLimits makes the context remember the last rate limit bluesky gave us, see RateLimitFromContext.
+func TrackRateLimits(ctx context.Context) context.Context {
+	return context.WithValue(ctx, rateLimitContextKey{}, &rateLimitHolder{})
+}
+
+// RateLimitFromContext gets the rate limit a request using this context ran into, if it did.
+func RateLimitFromContext(ctx context.Context) (RateLimit, bool) {
+	holder, ok := ctx.Value(rateLimitContextKey{}).(*rateLimitHolder)
+	if !ok {
+		return RateLimit{}, false
+	}
+	holder.mutex.Lock()
+	defer holder.mutex.Unlock()
+	if holder.limit == nil {
+		return RateLimit{}, false
+	}
+	return *holder.limit, true
+}
+
+func recordRateLimit(ctx context.Context, limit RateLimit) {
+	holder, ok := ctx.Value(rateLimitContextKey{}).(*rateLimitHolder)
+	if !ok {
+		return
+	}
+	holder.mutex.Lock()
+	defer holder.mutex.Unlock()
+	holder.limit = &limit
+}
+
+// getWithTimeout is for the few things that aren't XRPC (ex. DID documents), but should still use our client & time out.
+func getWithTimeout(ctx context.Context, rawURL string) (*http.Response, error) {
+	callCtx, cancel := context.WithTimeout(ctx, xrpcTimeout())
+	req, err := http.NewRequestWithContext(callCtx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "ATwitterBridge/1.0")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
@@ -0,0 +1,146 @@
+package blueskyapi
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Preloading/TwitterAPIBridge/config"
+)
+
+// fakeBluesky answers every request with respond, and counts them.
+func fakeBluesky(t *testing.T, respond func(w http.ResponseWriter, attempt int)) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		respond(w, int(requests.Add(1)))
+	}))
+	t.Cleanup(server.Close)
+
+	oldConfig := configData
+	configData = &config.Config{XRPCRetries: 2}
+	t.Cleanup(func() { configData = oldConfig })
+	return server, &requests
+}
+
+func send(t *testing.T, method string, url string, token string) *http.Response {
+	t.Helper()
+	resp, err := sendXRPC(context.Background(), &token, method, url, nil, "application/json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestGETRetriedOnServerErrors(t *testing.T) {
+	server, requests := fakeBluesky(t, func(w http.ResponseWriter, attempt int) {
+		if attempt < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{}`))
+	})
+	if resp := send(t, http.MethodGet, server.URL+"/xrpc/app.bsky.actor.getProfile", "token"); resp.StatusCode != http.StatusOK {
+		t.Errorf("got %d", resp.StatusCode)
+	}
+	if requests.Load() != 3 {
+		t.Errorf("%d requests, want 3", requests.Load())
+	}
+}
+
+func TestGETRetriesRunOut(t *testing.T) {
+	server, requests := fakeBluesky(t, func(w http.ResponseWriter, attempt int) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	if resp := send(t, http.MethodGet, server.URL+"/xrpc/app.bsky.actor.getProfile", "token"); resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("got %d", resp.StatusCode)
+	}
+	if requests.Load() != 3 { // the first try, and XRPC_RETRIES more
+		t.Errorf("%d requests, want 3", requests.Load())
+	}
+}
+
+// A POST that failed might've gone through anyway, so it's never sent again.
+func TestPOSTNotRetried(t *testing.T) {
+	server, requests := fakeBluesky(t, func(w http.ResponseWriter, attempt int) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	if resp := send(t, http.MethodPost, server.URL+"/xrpc/com.atproto.repo.createRecord", "token"); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("got %d", resp.StatusCode)
+	}
+	if requests.Load() != 1 {
+		t.Errorf("%d requests, want 1", requests.Load())
+	}
+}
+
+// Once bluesky says there's nothing left, we answer with a 429 ourselves until it resets (for that account).
+func TestRateLimitRemainingZero(t *testing.T) {
+	reset := time.Now().Add(30 * time.Second)
+	server, requests := fakeBluesky(t, func(w http.ResponseWriter, attempt int) {
+		w.Header().Set("RateLimit-Limit", "3000")
+		w.Header().Set("RateLimit-Remaining", "0")
+		w.Header().Set("RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+		w.Write([]byte(`{}`))
+	})
+	url := server.URL + "/xrpc/app.bsky.feed.getTimeline"
+	if resp := send(t, http.MethodGet, url, "limited"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("got %d", resp.StatusCode)
+	}
+
+	resp := send(t, http.MethodGet, url, "limited")
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("got %d, want 429", resp.StatusCode)
+	}
+	if requests.Load() != 1 {
+		t.Errorf("%d requests, the second shouldn't have been sent", requests.Load())
+	}
+	retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || retryAfter < 25 || retryAfter > 31 {
+		t.Errorf("Retry-After is %q, want about 30", resp.Header.Get("Retry-After"))
+	}
+	if xrpcErr := errorFromResponse(resp); xrpcErr.Name != "RateLimitExceeded" || xrpcErr.RetryAfter < 25*time.Second {
+		t.Errorf("reads as %+v", xrpcErr)
+	}
+
+	// someone else on the same PDS isn't limited
+	if resp := send(t, http.MethodGet, url, "someone else"); resp.StatusCode != http.StatusOK {
+		t.Errorf("another account got %d", resp.StatusCode)
+	}
+}
+
+// countingReader is a big body that counts how much was read.
+type countingReader struct{ read, size int }
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	if r.read >= r.size {
+		return 0, io.EOF
+	}
+	n := min(len(p), r.size-r.read)
+	for i := range n {
+		p[i] = 'x'
+	}
+	r.read += n
+	return n, nil
+}
+
+// A huge error page (ex. from a proxy in front of the PDS) isn't all read into memory.
+func TestErrorBodyLimited(t *testing.T) {
+	body := &countingReader{size: 64 << 20}
+	req := httptest.NewRequest(http.MethodGet, "https://pds.example/xrpc/app.bsky.actor.getProfile", nil)
+	resp := &http.Response{StatusCode: http.StatusBadGateway, Header: http.Header{}, Body: io.NopCloser(body), Request: req}
+
+	xrpcErr := errorFromResponse(resp)
+	if body.read > maxErrorBodyBytes+32*1024 {
+		t.Errorf("read %d bytes", body.read)
+	}
+	if xrpcErr.StatusCode != http.StatusBadGateway || len(xrpcErr.Message) != 200 || strings.Trim(xrpcErr.Message, "x") != "" {
+		t.Errorf("got %+v", xrpcErr)
+	}
+}
@@ -0,0 +1,79 @@
+package blueskyapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// XRPCError is an error from bluesky. Every function here that talks to bluesky returns one of these when bluesky says no,
+// so handlers can look at what went wrong instead of picking apart strings. (see HandleBlueskyError in twitterv1)
+// Anything else (network errors, timeouts...) is returned as-is.
+type XRPCError struct {
+	StatusCode int    // HTTP status bluesky sent
+	Name       string // ex. ExpiredToken, "" if bluesky didn't say
+	Message    string
+	Lexicon    string // the method that failed, ex. app.bsky.actor.getProfile
+	// How long until we can try again, if bluesky said (rate limits & some 503s)
+	RetryAfter time.Duration
+}
+
+func (e *XRPCError) Error() string {
+	name := e.Name
+	if name == "" {
+		name = strconv.Itoa(e.StatusCode)
+	}
+	if e.Message == "" {
+		return fmt.Sprintf("%s: %s", e.Lexicon, name)
+	}
+	return fmt.Sprintf("%s: %s: %s", e.Lexicon, name, e.Message)
+}
+
+// How much of an error response we read. XRPC errors are tiny, this is for whatever's in front of the PDS that isn't.
+const maxErrorBodyBytes = 64 << 10
+
+// errorFromResponse reads a failed response from bluesky into an XRPCError, and logs it.
+func errorFromResponse(resp *http.Response) *XRPCError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodyBytes))
+	resp.Body.Close()
+
+	xrpcErr := &XRPCError{
+		StatusCode: resp.StatusCode,
+		Lexicon:    xrpcMethod(resp.Request.URL),
+	}
+	res := struct {
+		Error   string `json:"error"`
+		Message string `json:"message"`
+	}{}
+	if err := json.Unmarshal(body, &res); err == nil {
+		xrpcErr.Name = res.Error
+		xrpcErr.Message = res.Message
+	} else {
+		// not XRPC, probably a proxy in front of the PDS. Keep a bit of it so there's something to go on
+		message := strings.TrimSpace(string(body))
+		if len(message) > 200 {
+			message = message[:200]
+		}
+		xrpcErr.Message = message
+	}
+
+	if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+		xrpcErr.RetryAfter = max(time.Until(retryAfter), 0)
+	} else if limit, ok := parseRateLimit(resp); ok && resp.StatusCode == http.StatusTooManyRequests {
+		xrpcErr.RetryAfter = max(time.Until(limit.Reset), 0)
+	}
+
+	// the request's context has the request ID
+	log.WarnContext(resp.Request.Context(), "Bluesky request failed", "method", xrpcErr.Lexicon, "status", resp.StatusCode, "error", xrpcErr.Name, "body", string(body))
+	return xrpcErr
+}
+
+// identityError is for when we can't resolve a handle or DID ourselves. They're named like the errors
+// com.atproto.identity.* would give, so they get handled the same way.
+func identityError(name string, message string, lexicon string) *XRPCError {
+	return &XRPCError{StatusCode: http.StatusBadRequest, Name: name, Message: message, Lexicon: lexicon}
+}
@@ -2,15 +2,18 @@ package bridge
 
 import (
 	"bytes"
+	"context"
 	"encoding/xml"
 	"fmt"
 	"hash/fnv"
 	"time"
 
-	"github.com/Preloading/TwitterAPIBridge/db_controller"
+	"github.com/Preloading/TwitterAPIBridge/logging"
 	"github.com/golang-jwt/jwt/v5"
 )
 
+var log = logging.For("bridge")
+
 type RelatedResultsQuery struct {
 	Annotations []Annotations `json:"annotations"` // TODO
 	ResultType  string        `json:"resultType"`
@@ -226,9 +229,9 @@ type UserMention struct {
 }
 
 type SleepTime struct {
-	EndTime   *string `json:"end_time" xml:"end_time"`
-	Enabled   bool    `json:"enabled" xml:"enabled"`
-	StartTime *string `json:"start_time" xml:"start_time"`
+	EndTime   *int `json:"end_time" xml:"end_time"` // hour of the day, 0-23
+	Enabled   bool `json:"enabled" xml:"enabled"`
+	StartTime *int `json:"start_time" xml:"start_time"` // hour of the day, 0-23
 }
 
 type PlaceType struct {
@@ -260,6 +263,7 @@ type Config struct {
 	DiscoverableByEmail bool            `json:"discoverable_by_email"`
 	TimeZone            TimeZone        `json:"time_zone" xml:"time_zone"`
 	GeoEnabled          bool            `json:"geo_enabled" xml:"geo_enabled"`
+	SplitLongTweets     bool            `json:"split_long_tweets" xml:"split_long_tweets"` // not twitter's, see SPLIT_LONG_TWEETS
 }
 
 // Used in the /friends/lookup endpoint
@@ -579,64 +583,89 @@ func encodeToUint63(input string) *int64 {
 // While twitter uses a numeric ID, meaning we
 // need to convert between the two
 
-func BlueSkyToTwitterID(letterID string) *int64 {
+func BlueSkyToTwitterID(ctx context.Context, letterID string) *int64 {
 	if letterID == "" {
 		return nil
 	}
 	twitterId := encodeToUint63(letterID)
-	if err := db_controller.StoreTwitterIdInDatabase(twitterId, letterID, nil, nil); err != nil {
-		fmt.Println("Error storing Twitter ID in database:", err)
-		panic(err)
-	}
+	ids.remember(*twitterId, idMapping{blueskyID: letterID}) // written at the end of the request, see idmapper.go
+	trackID(ctx, *twitterId)
 	return twitterId
 }
 
 // TwitterIDToBlueSky converts a numeric ID to a letter ID
 func TwitterIDToBlueSky(numericID *int64) (*string, error) {
+	if numericID != nil {
+		if mapping, ok := ids.lookup(*numericID); ok && !mapping.isPost {
+			return &mapping.blueskyID, nil
+		}
+	}
+
+	if numericID == nil {
+		return nil, fmt.Errorf("twitterID is nil")
+	}
+
 	// Get the letter ID from the database
-	letterID, _, _, err := db_controller.GetTwitterIDFromDatabase(numericID)
+	letterID, err := currentIDStore().GetUserID(*numericID)
 	if err != nil {
 		return nil, err
 	}
+	ids.loaded(*numericID, idMapping{blueskyID: letterID})
 
-	return letterID, nil
+	return &letterID, nil
 }
 
-func BskyMsgToTwitterID(uri string, creationTime *time.Time, retweetUserId *string) *int64 {
+// Post IDs are snowflakes, see snowflake.go. ctx is the request it's for (see TrackIDs). creationTime is the post's createdAt, or for retweets, when it was retweeted.
+func BskyMsgToTwitterID(ctx context.Context, uri string, creationTime *time.Time, retweetUserId *string) *int64 {
 	if uri == "" {
 		return nil
 	}
 
-	var encodedId *int64
+	key := uri
 	if retweetUserId != nil {
-		encodedId = encodeToUint63(uri + *retweetUserId + creationTime.Format("20060102150405")) // We add the date to avoid having the same ID for reposts
-		if err := db_controller.StoreTwitterIdInDatabase(encodedId, uri, creationTime, retweetUserId); err != nil {
-			fmt.Println("Error storing Twitter ID in database:", err)
-			panic(err) // TODO: handle this gracefully?
-		}
-	} else {
-		encodedId = encodeToUint63(uri)
-		if err := db_controller.StoreTwitterIdInDatabase(encodedId, uri, creationTime, nil); err != nil {
-			fmt.Println("Error storing Twitter ID in database:", err)
-			panic(err)
-		}
+		key = uri + *retweetUserId // Reposts get their own ID
 	}
-	return encodedId
+
+	createdAt := time.Time{}
+	if creationTime != nil {
+		createdAt = *creationTime
+	}
+
+	encodedId, inRange := makeSnowflake(createdAt, key)
+	var storedDate *time.Time
+	if !inRange && creationTime != nil {
+		storedDate = creationTime // clamped, so we need to remember the real date
+	}
+
+	encodedId = ids.remember(encodedId, idMapping{blueskyID: uri, reposterDid: retweetUserId, dateCreated: storedDate, isPost: true})
+	trackID(ctx, encodedId)
+	return &encodedId
 }
 
 // This is here soley because we have to use psudo ids for retweets.
+// Works for both snowflakes and the old hashed IDs.
 func TwitterMsgIdToBluesky(id *int64) (*string, *time.Time, *string, error) {
-	// Get the letter ID from the database
-	uri, createdAt, retweetUserId, err := db_controller.GetTwitterIDFromDatabase(id)
-	if err != nil {
-		return nil, nil, nil, err
+	if id == nil {
+		return nil, nil, nil, fmt.Errorf("id is nil")
+	}
+
+	mapping, ok := ids.lookup(*id)
+	if !ok || !mapping.isPost {
+		postID, err := currentIDStore().GetPostID(*id)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		mapping = postMapping(*postID)
+		ids.loaded(*id, mapping)
 	}
 
+	createdAt := mapping.dateCreated
 	if createdAt == nil {
-		return nil, nil, nil, err
+		t := SnowflakeTime(*id)
+		createdAt = &t
 	}
 
-	return uri, createdAt, retweetUserId, nil
+	return &mapping.blueskyID, createdAt, mapping.reposterDid, nil
 }
 
 // FormatTime converts Go's time.Time into the format "Wed Sep 01 00:00:00 +0000 2021"
@@ -655,7 +684,7 @@ func XMLEncoder(data interface{}, oldHeaderName string, newHeaderName string) (*
 	enc := xml.NewEncoder(&buf)
 	enc.Indent("", "  ")
 	if err := enc.Encode(data); err != nil {
-		fmt.Println("Error encoding XML:", err)
+		log.Error("Error encoding XML", "error", err)
 		return nil, err
 	}
 
@@ -0,0 +1,391 @@
+package bridge
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Preloading/TwitterAPIBridge/db_controller"
+	"github.com/Preloading/TwitterAPIBridge/metrics"
+)
+
+// Every user & post we render needs its ID written down so we can turn it back into a DID/AT-URI later.
+// Doing that one row at a time (hundreds per timeline) was slow, so IDs get queued here and written in batches.
+//
+// The rule is: an ID we hand to a client must always be resolvable.
+// - New IDs sit in pending until they're in the DB. Lookups check pending & the LRU before the DB, so IDs can be
+//   resolved before they've been written.
+// - Requests keep track of the IDs they hand out (TrackIDs), and FlushIDMappings writes those at the end of the
+//   request, before the response goes out. Only those, so an ID that won't write doesn't hold up anyone else's request.
+// - If writing fails, the response still goes out (the IDs resolve from pending, and it might have been a post that's
+//   already on bluesky, which the client would send again). StartPeriodicIDFlusher keeps trying.
+// - An ID that fails idMapperMaxFailures times is given up on. It's logged and moved to the LRU, so it resolves for as
+//   long as it's there, and it gets another try whenever it's handed out again. Same for new IDs once there are
+//   idMapperMaxPending waiting (ex. the DB has been down for a while), so pending can't grow forever.
+// - Known IDs get written again once they're idMapperRefreshAfter old, which bumps last_seen so pruning
+//   (which only goes by last_seen) doesn't delete IDs we're still handing out.
+// - A post ID never moves to another post. If the one a post should get is already another post's, it gets the next
+//   free one (see snowflake.go). remember catches the ones we know about, the DB catches the rest when we flush,
+//   and that request fails so the client never sees the ID pointing at the wrong post. Next time it's handed out,
+//   remember knows about the other post and moves along.
+
+const (
+	idMapperCacheSize    = 50000
+	idMapperMaxPending   = 100000
+	idMapperMaxFailures  = 5
+	idMapperRefreshAfter = time.Hour
+)
+
+// ErrIDTaken is when an ID we handed out for a post turned out to be another post's in the DB.
+var ErrIDTaken = errors.New("post id is already another post's")
+
+type idMapping struct {
+	blueskyID   string
+	reposterDid *string
+	dateCreated *time.Time
+	isPost      bool // posts go into post_ids, users into twitter_ids
+}
+
+func (m idMapping) equal(other idMapping) bool {
+	if !m.samePost(other) {
+		return false
+	}
+	if (m.dateCreated == nil) != (other.dateCreated == nil) || (m.dateCreated != nil && !m.dateCreated.Equal(*other.dateCreated)) {
+		return false
+	}
+	return true
+}
+
+// samePost checks if two mappings are for the same thing, even if one of them has the date and the other doesn't.
+func (m idMapping) samePost(other idMapping) bool {
+	if m.blueskyID != other.blueskyID || m.isPost != other.isPost {
+		return false
+	}
+	return (m.reposterDid == nil) == (other.reposterDid == nil) && (m.reposterDid == nil || *m.reposterDid == *other.reposterDid)
+}
+
+func postMapping(row db_controller.PostIDs) idMapping {
+	return idMapping{blueskyID: row.BlueskyID, reposterDid: row.ReposterDid, dateCreated: row.DateCreated, isPost: true}
+}
+
+type lruEntry struct {
+	id        int64
+	mapping   idMapping
+	writtenAt time.Time // when we last wrote it, zero if we only read it from the DB
+}
+
+type pendingID struct {
+	mapping  idMapping
+	failures int // how many times writing it has failed
+}
+
+type idMapper struct {
+	mutex      sync.Mutex
+	pending    map[int64]pendingID     // not in the DB yet
+	known      map[int64]*list.Element // in the DB (or given up on), least recently used at the back
+	order      *list.List
+	size       int
+	maxPending int
+}
+
+var (
+	ids        = newIDMapper(idMapperCacheSize, idMapperMaxPending)
+	idsGivenUp = metrics.NewCounterVec("twitterbridge_id_mappings_given_up_total", "IDs handed out that we stopped trying to write to the database, by why.", "reason")
+)
+
+func init() {
+	metrics.NewGaugeFunc("twitterbridge_id_mappings_pending", "IDs handed out that haven't been written to the database yet.",
+		func() float64 {
+			ids.mutex.Lock()
+			defer ids.mutex.Unlock()
+			return float64(len(ids.pending))
+		})
+}
+
+func newIDMapper(size int, maxPending int) *idMapper {
+	return &idMapper{
+		pending:    make(map[int64]pendingID),
+		known:      make(map[int64]*list.Element),
+		order:      list.New(),
+		size:       size,
+		maxPending: maxPending,
+	}
+}
+
+type handedOutKey struct{}
+
+// handedOut is the IDs a request gave to the client.
+type handedOut struct {
+	mutex sync.Mutex
+	ids   map[int64]struct{}
+}
+
+// TrackIDs makes FlushIDMappings(ctx) write the IDs handed out with ctx (or anything made from it).
+func TrackIDs(ctx context.Context) context.Context {
+	return context.WithValue(ctx, handedOutKey{}, &handedOut{ids: make(map[int64]struct{})})
+}
+
+func trackID(ctx context.Context, id int64) {
+	if tracked, ok := ctx.Value(handedOutKey{}).(*handedOut); ok {
+		tracked.mutex.Lock()
+		tracked.ids[id] = struct{}{}
+		tracked.mutex.Unlock()
+	}
+}
+
+// remember queues an ID to be written, unless we already know it's in the DB, and returns the ID to hand out.
+// For posts, that's the next free one if id is already another post's.
+func (m *idMapper) remember(id int64, mapping idMapping) int64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if mapping.isPost {
+		id = m.freePostID(id, mapping)
+	}
+
+	if current, ok := m.pending[id]; ok {
+		if !current.mapping.equal(mapping) {
+			m.pending[id] = pendingID{mapping: mapping}
+		}
+		return id
+	}
+	if element, ok := m.known[id]; ok {
+		m.order.MoveToFront(element)
+		entry := element.Value.(*lruEntry)
+		if entry.mapping.equal(mapping) && time.Since(entry.writtenAt) < idMapperRefreshAfter {
+			return id
+		}
+	}
+	if len(m.pending) >= m.maxPending {
+		idsGivenUp.Inc("pending_full")
+		m.markKnown(id, mapping, time.Time{})
+		return id
+	}
+	m.pending[id] = pendingID{mapping: mapping}
+	return id
+}
+
+// freePostID is the first ID from id on that isn't something else's, as far as we know.
+// must be called with the mutex held.
+func (m *idMapper) freePostID(id int64, mapping idMapping) int64 {
+	for range snowflakeHashMask + 1 {
+		current, ok := m.pending[id]
+		other := current.mapping
+		if element, known := m.known[id]; !ok && known {
+			other, ok = element.Value.(*lruEntry).mapping, true
+		}
+		if !ok || other.samePost(mapping) {
+			return id
+		}
+		id = nextSnowflake(id)
+	}
+	return id // there's never this many in memory
+}
+
+// markKnown puts an ID in the LRU, for things we've just written or just read from the DB.
+// must be called with the mutex held.
+func (m *idMapper) markKnown(id int64, mapping idMapping, writtenAt time.Time) {
+	if element, ok := m.known[id]; ok {
+		entry := element.Value.(*lruEntry)
+		entry.mapping = mapping
+		entry.writtenAt = writtenAt
+		m.order.MoveToFront(element)
+		return
+	}
+	m.known[id] = m.order.PushFront(&lruEntry{id: id, mapping: mapping, writtenAt: writtenAt})
+	for m.order.Len() > m.size {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.known, oldest.Value.(*lruEntry).id)
+	}
+}
+
+func (m *idMapper) lookup(id int64) (idMapping, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if current, ok := m.pending[id]; ok {
+		return current.mapping, true
+	}
+	if element, ok := m.known[id]; ok {
+		m.order.MoveToFront(element)
+		return element.Value.(*lruEntry).mapping, true
+	}
+	return idMapping{}, false
+}
+
+// loaded caches something we read from the DB. We don't know how old its last_seen is, so it still gets
+// written again next time we hand it out.
+func (m *idMapper) loaded(id int64, mapping idMapping) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.markKnown(id, mapping, time.Time{})
+}
+
+// flush writes the IDs in only that are still pending to the DB (or whatever SetIDStore set), or everything pending if only is nil.
+// Anything that fails stays pending for next time, until it's failed idMapperMaxFailures times.
+func (m *idMapper) flush(only map[int64]struct{}) error {
+	m.mutex.Lock()
+	batch := make(map[int64]idMapping)
+	if only == nil {
+		for id, current := range m.pending {
+			batch[id] = current.mapping
+		}
+	} else {
+		for id := range only {
+			if current, ok := m.pending[id]; ok {
+				batch[id] = current.mapping
+			}
+		}
+	}
+	m.mutex.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	taken, err := m.store(batch)
+	if err == nil {
+		return m.stored(batch, taken)
+	}
+	if len(batch) == 1 {
+		m.failed(batch, err)
+		return err
+	}
+
+	// Something in there won't write, or the DB is down. One at a time, so it's only the ones that won't write
+	// that get held back, unless the first few all fail too, then it's probably the DB.
+	errs := []error{err}
+	tried, worked := 0, 0
+	for id, mapping := range batch {
+		single := map[int64]idMapping{id: mapping}
+		if tried >= 3 && worked == 0 {
+			m.failed(single, err)
+			continue
+		}
+		tried++
+		taken, singleErr := m.store(single)
+		if singleErr != nil {
+			m.failed(single, singleErr)
+			continue
+		}
+		worked++
+		errs = append(errs, m.stored(single, taken))
+	}
+	return errors.Join(errs...)
+}
+
+// store writes a batch of IDs, see IDStore.StorePostIDs for taken.
+func (m *idMapper) store(batch map[int64]idMapping) (taken []db_controller.PostIDs, err error) {
+	now := time.Now()
+	userIDs := []db_controller.TwitterIDs{}
+	postIDs := []db_controller.PostIDs{}
+	for id, mapping := range batch {
+		if mapping.isPost {
+			postIDs = append(postIDs, db_controller.PostIDs{
+				TwitterID:   id,
+				BlueskyID:   mapping.blueskyID,
+				ReposterDid: mapping.reposterDid,
+				DateCreated: mapping.dateCreated,
+				LastSeen:    now,
+			})
+		} else {
+			userIDs = append(userIDs, db_controller.TwitterIDs{
+				TwitterID:   strconv.FormatInt(id, 10),
+				BlueskyID:   mapping.blueskyID,
+				ReposterDid: mapping.reposterDid,
+				DateCreated: mapping.dateCreated,
+				LastSeen:    now,
+			})
+		}
+	}
+
+	store := currentIDStore()
+	if err := store.StoreUserIDs(userIDs); err != nil {
+		return nil, fmt.Errorf("failed to store user ids: %w", err)
+	}
+	taken, err = store.StorePostIDs(postIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store post ids: %w", err)
+	}
+	return taken, nil
+}
+
+// stored moves a batch that's been written from pending to the LRU.
+func (m *idMapper) stored(batch map[int64]idMapping, taken []db_controller.PostIDs) error {
+	now := time.Now()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, row := range taken {
+		// we handed this out for a post, but the DB says it's another one's. Remember that one, so ours moves along next time.
+		if current, ok := m.pending[row.TwitterID]; ok && current.mapping.equal(batch[row.TwitterID]) {
+			delete(m.pending, row.TwitterID)
+		}
+		delete(batch, row.TwitterID)
+		m.markKnown(row.TwitterID, postMapping(row), time.Time{})
+	}
+	for id, mapping := range batch {
+		// if it was changed while we were writing, leave the new one pending
+		if current, ok := m.pending[id]; ok && current.mapping.equal(mapping) {
+			delete(m.pending, id)
+		}
+		m.markKnown(id, mapping, now)
+	}
+	if len(taken) > 0 {
+		return fmt.Errorf("%d post ids, first is %d: %w", len(taken), taken[0].TwitterID, ErrIDTaken)
+	}
+	return nil
+}
+
+// failed counts a failed write for everything in batch, and gives up on the ones that have failed too many times.
+func (m *idMapper) failed(batch map[int64]idMapping, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for id, mapping := range batch {
+		current, ok := m.pending[id]
+		if !ok || !current.mapping.equal(mapping) {
+			continue
+		}
+		current.failures++
+		if current.failures < idMapperMaxFailures {
+			m.pending[id] = current
+			continue
+		}
+		log.Error("Giving up on writing an ID to the database", "id", id, "bluesky_id", mapping.blueskyID, "post", mapping.isPost, "error", err)
+		idsGivenUp.Inc("failed")
+		delete(m.pending, id)
+		m.markKnown(id, mapping, time.Time{})
+	}
+}
+
+// FlushIDMappings writes the IDs handed out with ctx (see TrackIDs) to the DB, or everything pending if ctx isn't tracking them.
+// This should happen before a response containing new IDs is sent. If it fails, the IDs still resolve from memory,
+// and the periodic flusher tries again, but errors.Is(err, ErrIDTaken) means one of them leads to a different post.
+func FlushIDMappings(ctx context.Context) error {
+	tracked, ok := ctx.Value(handedOutKey{}).(*handedOut)
+	if !ok {
+		return ids.flush(nil)
+	}
+	tracked.mutex.Lock()
+	only := make(map[int64]struct{}, len(tracked.ids))
+	for id := range tracked.ids {
+		only[id] = struct{}{}
+	}
+	tracked.mutex.Unlock()
+	return ids.flush(only)
+}
+
+// StartPeriodicIDFlusher flushes IDs made outside of requests (ex. push notifications), and retries failed flushes.
+func StartPeriodicIDFlusher(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		for range ticker.C {
+			if err := FlushIDMappings(context.Background()); err != nil {
+				log.Error("Error flushing ID mappings", "error", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,315 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Preloading/TwitterAPIBridge/db_controller"
+)
+
+// useIDStore points the mapper at store, with nothing in memory, until the test is done.
+func useIDStore(t *testing.T, store IDStore) {
+	t.Helper()
+	SetIDStore(store)
+	t.Cleanup(func() { SetIDStore(DBIDStore{}) })
+}
+
+// collidingPosts finds two posts that get the same ID when neither has a createdAt (so they're both at millisecond 0).
+func collidingPosts() (string, string) {
+	seen := map[int64]string{}
+	for i := 0; ; i++ {
+		uri := fmt.Sprintf("at://did:plc:test/app.bsky.feed.post/%d", i)
+		id, _ := makeSnowflake(time.Time{}, uri)
+		if other, ok := seen[id]; ok {
+			return other, uri
+		}
+		seen[id] = uri
+	}
+}
+
+func postID(uri string) int64 {
+	return *BskyMsgToTwitterID(context.Background(), uri, nil, nil)
+}
+
+func resolvesTo(t *testing.T, id int64, uri string) {
+	t.Helper()
+	got, _, _, err := TwitterMsgIdToBluesky(&id)
+	if err != nil || *got != uri {
+		t.Errorf("%d resolves to %v (%v), want %s", id, got, err, uri)
+	}
+}
+
+func TestPostIDCollision(t *testing.T) {
+	first, second := collidingPosts()
+
+	t.Run("in memory", func(t *testing.T) {
+		useIDStore(t, NewMemoryIDStore())
+		a, b := postID(first), postID(second)
+		if b != nextSnowflake(a) {
+			t.Fatalf("%s got %d, %s got %d, it should be the next one", first, a, second, b)
+		}
+		if err := FlushIDMappings(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if postID(first) != a || postID(second) != b {
+			t.Errorf("got %d and %d after flushing, before it was %d and %d", postID(first), postID(second), a, b)
+		}
+		resolvesTo(t, a, first)
+		resolvesTo(t, b, second)
+	})
+
+	// ex. after a restart, when the other post is only in the database
+	t.Run("in the database", func(t *testing.T) {
+		store := NewMemoryIDStore()
+		useIDStore(t, store)
+		a := postID(first)
+		if err := FlushIDMappings(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		SetIDStore(store)
+
+		if b := postID(second); b != a {
+			t.Fatalf("got %d, nothing in memory should've had %d", b, a)
+		}
+		if err := FlushIDMappings(context.Background()); !errors.Is(err, ErrIDTaken) {
+			t.Errorf("handed out an ID that's another post's, got %v", err)
+		}
+		resolvesTo(t, a, first)
+
+		b := postID(second)
+		if b != nextSnowflake(a) {
+			t.Fatalf("got %d the second time, want %d", b, nextSnowflake(a))
+		}
+		if err := FlushIDMappings(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		resolvesTo(t, a, first)
+		resolvesTo(t, b, second)
+	})
+
+	// the repost and the post have different keys, so they only collide if someone goes looking
+	t.Run("reposts", func(t *testing.T) {
+		useIDStore(t, NewMemoryIDStore())
+		reposter := "did:plc:reposter"
+		ids.remember(1, idMapping{blueskyID: first, isPost: true})
+		if got := ids.remember(1, idMapping{blueskyID: first, reposterDid: &reposter, isPost: true}); got != 2 {
+			t.Errorf("the repost got %d", got)
+		}
+		if got := ids.remember(1, idMapping{blueskyID: first, isPost: true}); got != 1 {
+			t.Errorf("the post moved to %d", got)
+		}
+		// same post, but now we know the date it was clamped from
+		created := time.Unix(0, 0)
+		if got := ids.remember(2, idMapping{blueskyID: first, reposterDid: &reposter, dateCreated: &created, isPost: true}); got != 2 {
+			t.Errorf("the repost moved to %d once it had a date", got)
+		}
+	})
+}
+
+// Posts with their date clamped still get it back.
+func TestClampedPostDate(t *testing.T) {
+	useIDStore(t, NewMemoryIDStore())
+	created := time.Date(2005, 6, 7, 8, 9, 10, 0, time.UTC)
+	id := *BskyMsgToTwitterID(context.Background(), "at://did:plc:test/app.bsky.feed.post/old", &created, nil)
+	if err := FlushIDMappings(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	SetIDStore(currentIDStore()) // from the store, not memory
+
+	_, got, _, err := TwitterMsgIdToBluesky(&id)
+	if err != nil || !got.Equal(created) {
+		t.Errorf("got %v (%v), want %s", got, err, created)
+	}
+	if got, err := TwitterMsgIdToTime(id); err != nil || !got.Equal(created) {
+		t.Errorf("since_id time is %v (%v), want %s", got, err, created)
+	}
+}
+
+// flakyStore is a MemoryIDStore that won't write the bluesky IDs in bad, or anything while down.
+type flakyStore struct {
+	*MemoryIDStore
+	mutex  sync.Mutex
+	bad    map[string]bool
+	down   bool
+	writes int
+}
+
+func newFlakyStore(bad ...string) *flakyStore {
+	store := &flakyStore{MemoryIDStore: NewMemoryIDStore(), bad: map[string]bool{}}
+	for _, id := range bad {
+		store.bad[id] = true
+	}
+	return store
+}
+
+func (s *flakyStore) check(blueskyIDs []string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.writes++
+	if s.down {
+		return errors.New("database is down")
+	}
+	for _, id := range blueskyIDs {
+		if s.bad[id] {
+			return fmt.Errorf("%s is too long", id)
+		}
+	}
+	return nil
+}
+
+func (s *flakyStore) StoreUserIDs(ids []db_controller.TwitterIDs) error {
+	blueskyIDs := []string{}
+	for _, id := range ids {
+		blueskyIDs = append(blueskyIDs, id.BlueskyID)
+	}
+	if err := s.check(blueskyIDs); err != nil {
+		return err
+	}
+	return s.MemoryIDStore.StoreUserIDs(ids)
+}
+
+func (s *flakyStore) StorePostIDs(ids []db_controller.PostIDs) ([]db_controller.PostIDs, error) {
+	blueskyIDs := []string{}
+	for _, id := range ids {
+		blueskyIDs = append(blueskyIDs, id.BlueskyID)
+	}
+	if err := s.check(blueskyIDs); err != nil {
+		return nil, err
+	}
+	return s.MemoryIDStore.StorePostIDs(ids)
+}
+
+func (s *flakyStore) stored(id int64) bool {
+	_, err := s.MemoryIDStore.GetPostID(id)
+	return err == nil
+}
+
+// An ID that won't write only holds up the requests that handed it out, and everything still resolves.
+func TestIDsResolveWhenWritesFail(t *testing.T) {
+	const bad = "at://did:plc:test/app.bsky.feed.post/bad"
+	store := newFlakyStore(bad)
+	useIDStore(t, store)
+	created := time.Date(2024, 11, 8, 12, 0, 0, 0, time.UTC)
+
+	first, second := TrackIDs(context.Background()), TrackIDs(context.Background())
+	good := *BskyMsgToTwitterID(first, "at://did:plc:test/app.bsky.feed.post/good", &created, nil)
+	broken := *BskyMsgToTwitterID(first, bad, &created, nil)
+	other := *BskyMsgToTwitterID(second, "at://did:plc:test/app.bsky.feed.post/other", &created, nil)
+	user := *BlueSkyToTwitterID(second, "did:plc:test")
+
+	if err := FlushIDMappings(second); err != nil {
+		t.Fatalf("the second request only had good IDs: %v", err)
+	}
+	if !store.stored(other) || store.stored(good) {
+		t.Error("the second request should have written its own IDs, and only those")
+	}
+	if err := FlushIDMappings(first); err == nil || errors.Is(err, ErrIDTaken) {
+		t.Errorf("got %v", err)
+	}
+	if !store.stored(good) || store.stored(broken) {
+		t.Error("the good ID in the first request should have been written anyway")
+	}
+	resolvesTo(t, good, "at://did:plc:test/app.bsky.feed.post/good")
+	resolvesTo(t, broken, bad)
+	resolvesTo(t, other, "at://did:plc:test/app.bsky.feed.post/other")
+	if did, err := TwitterIDToBlueSky(&user); err != nil || *did != "did:plc:test" {
+		t.Errorf("user ID resolves to %v (%v)", did, err)
+	}
+
+	// it gets a few more tries, then it's given up on (but still resolves while we remember it)
+	for range idMapperMaxFailures - 1 {
+		if _, pending := ids.pending[broken]; !pending {
+			t.Fatal("gave up too soon")
+		}
+		FlushIDMappings(context.Background())
+	}
+	if _, pending := ids.pending[broken]; pending {
+		t.Errorf("still trying after %d failures", idMapperMaxFailures)
+	}
+	resolvesTo(t, broken, bad)
+
+	// and it's written next time it's handed out, if it works by then
+	delete(store.bad, bad)
+	BskyMsgToTwitterID(context.Background(), bad, &created, nil)
+	if err := FlushIDMappings(context.Background()); err != nil || !store.stored(broken) {
+		t.Errorf("not written once it worked: %v", err)
+	}
+	SetIDStore(store) // forget what's in memory
+	resolvesTo(t, broken, bad)
+}
+
+// When the database is down, a request doesn't try every ID one at a time.
+func TestIDFlushWithDatabaseDown(t *testing.T) {
+	store := newFlakyStore()
+	useIDStore(t, store)
+	store.down = true
+
+	ctx := TrackIDs(context.Background())
+	handedOut := []int64{}
+	for i := range 50 {
+		handedOut = append(handedOut, *BlueSkyToTwitterID(ctx, fmt.Sprintf("did:plc:%d", i)))
+	}
+	if err := FlushIDMappings(ctx); err == nil {
+		t.Fatal("no error with the database down")
+	}
+	if store.writes > 4 {
+		t.Errorf("%d writes for one flush", store.writes)
+	}
+	for i, id := range handedOut {
+		if did, err := TwitterIDToBlueSky(&id); err != nil || *did != fmt.Sprintf("did:plc:%d", i) {
+			t.Errorf("%d resolves to %v (%v)", id, did, err)
+		}
+	}
+
+	store.down = false
+	if err := FlushIDMappings(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(ids.pending) != 0 || len(store.users) != 50 {
+		t.Errorf("%d pending, %d written", len(ids.pending), len(store.users))
+	}
+}
+
+func TestIDMapperLRU(t *testing.T) {
+	m := newIDMapper(3, 4)
+	user := func(n int) idMapping { return idMapping{blueskyID: fmt.Sprintf("did:plc:%d", n)} }
+	for n := 1; n <= 3; n++ {
+		m.loaded(int64(n), user(n))
+	}
+	m.lookup(1) // 2 is used longest ago now
+	m.loaded(4, user(4))
+
+	if _, ok := m.lookup(2); ok {
+		t.Error("2 should have been evicted")
+	}
+	for _, n := range []int{1, 3, 4} {
+		if got, ok := m.lookup(int64(n)); !ok || got.blueskyID != user(n).blueskyID {
+			t.Errorf("%d: got %v, %v", n, got, ok)
+		}
+	}
+	if len(m.known) != 3 || m.order.Len() != 3 {
+		t.Errorf("%d in the map, %d in the list, the size is 3", len(m.known), m.order.Len())
+	}
+
+	// what's pending is never evicted, however much there is...
+	for n := 10; n < 14; n++ {
+		m.remember(int64(n), user(n))
+	}
+	for n := 10; n < 14; n++ {
+		if _, ok := m.lookup(int64(n)); !ok {
+			t.Errorf("pending %d is gone", n)
+		}
+	}
+	// ...up to maxPending, then new ones only go in the LRU
+	m.remember(14, user(14))
+	if len(m.pending) != 4 {
+		t.Errorf("%d pending, the most is 4", len(m.pending))
+	}
+	if got, ok := m.lookup(14); !ok || got.blueskyID != user(14).blueskyID {
+		t.Errorf("14 doesn't resolve with pending full: %v, %v", got, ok)
+	}
+}
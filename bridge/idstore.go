@@ -0,0 +1,149 @@
+package bridge
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Preloading/TwitterAPIBridge/db_controller"
+)
+
+// IDStore is where the ID mapper (see idmapper.go) keeps IDs once they've been handed out.
+// It's the database normally, SetIDStore swaps it for something else, ex. MemoryIDStore when there is no database
+// (rendering posts with TranslatePostToTweet & co. without one, or tools that don't need IDs to stick around).
+type IDStore interface {
+	StoreUserIDs(ids []db_controller.TwitterIDs) error
+	// StorePostIDs never moves an ID to another post, see db_controller.StorePostIdsInDatabase.
+	// taken is what's stored for the IDs that were already another post's.
+	StorePostIDs(ids []db_controller.PostIDs) (taken []db_controller.PostIDs, err error)
+	// GetUserID gets the DID for a user ID.
+	GetUserID(id int64) (string, error)
+	GetPostID(id int64) (*db_controller.PostIDs, error)
+	// GetStoredPostDate is the real date of the first of these posts that had its date clamped, or nil if none did.
+	GetStoredPostDate(ids ...int64) (*time.Time, error)
+}
+
+var (
+	idStore      IDStore = DBIDStore{}
+	idStoreMutex sync.RWMutex
+)
+
+// SetIDStore changes where IDs are stored, and forgets every ID that's been handed out so far (including ones that weren't written yet).
+// Meant to be called at startup, before anything's been rendered.
+func SetIDStore(store IDStore) {
+	idStoreMutex.Lock()
+	idStore = store
+	idStoreMutex.Unlock()
+
+	ids.mutex.Lock()
+	defer ids.mutex.Unlock()
+	fresh := newIDMapper(ids.size, ids.maxPending)
+	ids.pending, ids.known, ids.order = fresh.pending, fresh.known, fresh.order
+}
+
+func currentIDStore() IDStore {
+	idStoreMutex.RLock()
+	defer idStoreMutex.RUnlock()
+	return idStore
+}
+
+// DBIDStore is the database, through db_controller. It's the default.
+type DBIDStore struct{}
+
+func (DBIDStore) StoreUserIDs(ids []db_controller.TwitterIDs) error {
+	return db_controller.StoreTwitterIdsInDatabase(ids)
+}
+
+func (DBIDStore) StorePostIDs(ids []db_controller.PostIDs) ([]db_controller.PostIDs, error) {
+	return db_controller.StorePostIdsInDatabase(ids)
+}
+
+func (DBIDStore) GetUserID(id int64) (string, error) {
+	did, _, _, err := db_controller.GetTwitterIDFromDatabase(&id)
+	if err != nil {
+		return "", err
+	}
+	return *did, nil
+}
+
+func (DBIDStore) GetPostID(id int64) (*db_controller.PostIDs, error) {
+	return db_controller.GetPostIdFromDatabase(id)
+}
+
+func (DBIDStore) GetStoredPostDate(ids ...int64) (*time.Time, error) {
+	return db_controller.GetStoredPostDate(ids...)
+}
+
+// MemoryIDStore keeps IDs in memory. They're gone when the process exits, and it never forgets anything,
+// so it's only for things that don't run for long.
+type MemoryIDStore struct {
+	mutex sync.Mutex
+	users map[int64]db_controller.TwitterIDs
+	posts map[int64]db_controller.PostIDs
+}
+
+func NewMemoryIDStore() *MemoryIDStore {
+	return &MemoryIDStore{
+		users: make(map[int64]db_controller.TwitterIDs),
+		posts: make(map[int64]db_controller.PostIDs),
+	}
+}
+
+func (s *MemoryIDStore) StoreUserIDs(ids []db_controller.TwitterIDs) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, id := range ids {
+		numericID, err := strconv.ParseInt(id.TwitterID, 10, 64)
+		if err != nil {
+			return err
+		}
+		s.users[numericID] = id
+	}
+	return nil
+}
+
+func (s *MemoryIDStore) StorePostIDs(ids []db_controller.PostIDs) (taken []db_controller.PostIDs, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, id := range ids {
+		stored, ok := s.posts[id.TwitterID]
+		if ok && !postMapping(stored).samePost(postMapping(id)) {
+			taken = append(taken, stored)
+			continue
+		}
+		s.posts[id.TwitterID] = id
+	}
+	return taken, nil
+}
+
+func (s *MemoryIDStore) GetUserID(id int64) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	user, ok := s.users[id]
+	if !ok {
+		return "", fmt.Errorf("user id %d not found", id)
+	}
+	return user.BlueskyID, nil
+}
+
+func (s *MemoryIDStore) GetPostID(id int64) (*db_controller.PostIDs, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	post, ok := s.posts[id]
+	if !ok {
+		return nil, fmt.Errorf("post id %d not found", id)
+	}
+	return &post, nil
+}
+
+func (s *MemoryIDStore) GetStoredPostDate(ids ...int64) (*time.Time, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, id := range ids {
+		if post, ok := s.posts[id]; ok && post.DateCreated != nil {
+			return post.DateCreated, nil
+		}
+	}
+	return nil, nil
+}
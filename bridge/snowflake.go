@@ -0,0 +1,81 @@
+package bridge
+
+import (
+	"fmt"
+	"hash/fnv"
+	"time"
+)
+
+// Post IDs are built like twitter's snowflakes, so clients can sort them, and since_id/max_id are just math.
+//
+// | 1 bit unused | 41 bits milliseconds since twitterEpoch | 22 bits hash of the post (and reposter) |
+//
+// Twitter uses the low 22 bits for the worker/sequence, we don't have either, so we put a hash of the post there.
+// The time part is all we need for pagination, and the post_ids table gets us back to the AT-URI.
+//
+// The hash can collide, ex. two posts in the same millisecond, or any two posts that had their date clamped (which
+// all end up at millisecond 0). createdAt and the rkey are both up to whoever posts, so someone could even go looking
+// for a collision. An ID never moves to another post, the second one gets the next free hash instead (see nextSnowflake).
+const (
+	twitterEpoch      = int64(1288834974657) // Twitter's snowflake epoch, Nov 04 2010 01:42:54.657 UTC
+	snowflakeHashBits = 22
+	snowflakeHashMask = (int64(1) << snowflakeHashBits) - 1
+	snowflakeTimeMax  = (int64(1) << 41) - 1
+)
+
+// makeSnowflake builds a post ID from a time and the thing identifying the post.
+// inRange is false if the time doesn't fit in the ID (ex. someone set their createdAt to 1970), in which case
+// it gets clamped and the real time has to be stored.
+func makeSnowflake(t time.Time, key string) (id int64, inRange bool) {
+	ms := t.UnixMilli() - twitterEpoch
+	inRange = true
+	if ms < 0 {
+		ms = 0
+		inRange = false
+	} else if ms > snowflakeTimeMax {
+		ms = snowflakeTimeMax
+		inRange = false
+	}
+
+	hasher := fnv.New64a()
+	hasher.Write([]byte(key))
+	hash := int64(hasher.Sum64() & uint64(snowflakeHashMask))
+
+	return ms<<snowflakeHashBits | hash, inRange
+}
+
+// nextSnowflake is the ID to try when id is taken by another post. Only the hash part changes (wrapping around),
+// so it's still in the same millisecond.
+func nextSnowflake(id int64) int64 {
+	return id&^snowflakeHashMask | (id+1)&snowflakeHashMask
+}
+
+// SnowflakeTime gets the time out of a post ID.
+// This works for any ID, even ones we've never seen, but old hashed IDs will give you nonsense.
+func SnowflakeTime(id int64) time.Time {
+	return time.UnixMilli((id >> snowflakeHashBits) + twitterEpoch).UTC()
+}
+
+// TwitterMsgIdToTime gets the time a post ID points at, for since_id/max_id.
+// Old hashed IDs (and their neighbours, since clients do since_id+1 and max_id-1 like the twitter docs say)
+// get their date from the DB, everything else is read straight from the ID.
+func TwitterMsgIdToTime(id int64) (*time.Time, error) {
+	if id < 0 {
+		return nil, fmt.Errorf("invalid post id %d", id)
+	}
+
+	if mapping, ok := ids.lookup(id); ok && mapping.isPost && mapping.dateCreated != nil {
+		return mapping.dateCreated, nil
+	}
+
+	storedDate, err := currentIDStore().GetStoredPostDate(id-1, id, id+1)
+	if err != nil {
+		return nil, err
+	}
+	if storedDate != nil {
+		return storedDate, nil
+	}
+
+	t := SnowflakeTime(id)
+	return &t, nil
+}
@@ -0,0 +1,77 @@
+package bridge
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"testing"
+	"time"
+)
+
+func TestSnowflakeRoundTrip(t *testing.T) {
+	random := rand.New(rand.NewPCG(1, 2))
+	start, end := time.UnixMilli(twitterEpoch), time.UnixMilli(twitterEpoch+snowflakeTimeMax)
+	for i := range 10000 {
+		created := start.Add(time.Duration(random.Int64N(int64(end.Sub(start))))).UTC()
+		key := fmt.Sprintf("at://did:plc:test/app.bsky.feed.post/%d", i)
+
+		id, inRange := makeSnowflake(created, key)
+		if !inRange || id < 0 {
+			t.Fatalf("%s: got %d, in range %v", created, id, inRange)
+		}
+		if got := SnowflakeTime(id); !got.Equal(created.Truncate(time.Millisecond)) {
+			t.Fatalf("%s came back as %s", created, got)
+		}
+		hasher := fnv.New64a()
+		hasher.Write([]byte(key))
+		if hash := id & snowflakeHashMask; uint64(hash) != hasher.Sum64()&uint64(snowflakeHashMask) {
+			t.Fatalf("%s: hash part is %x", key, hash)
+		}
+
+		// clients sort by ID, so a post a millisecond later always has a bigger one
+		later, _ := makeSnowflake(created.Add(time.Millisecond), "anything else")
+		if later <= id {
+			t.Fatalf("%s: a millisecond later got %d, before it %d", created, later, id)
+		}
+	}
+}
+
+func TestSnowflakeClamping(t *testing.T) {
+	epoch, last := time.UnixMilli(twitterEpoch).UTC(), time.UnixMilli(twitterEpoch+snowflakeTimeMax).UTC()
+	for _, test := range []struct {
+		created time.Time
+		want    time.Time
+		inRange bool
+	}{
+		{time.Time{}, epoch, false}, // no createdAt
+		{time.Unix(0, 0), epoch, false},
+		{time.Date(2009, 1, 1, 0, 0, 0, 0, time.UTC), epoch, false},
+		{epoch.Add(-time.Millisecond), epoch, false},
+		{epoch, epoch, true},
+		{last, last, true},
+		{last.Add(time.Millisecond), last, false},
+		{time.Date(2200, 1, 1, 0, 0, 0, 0, time.UTC), last, false},
+	} {
+		id, inRange := makeSnowflake(test.created, "at://did:plc:test/app.bsky.feed.post/a")
+		if inRange != test.inRange || !SnowflakeTime(id).Equal(test.want) || id < 0 {
+			t.Errorf("%s: got %d (%s), in range %v, want %s, %v", test.created, id, SnowflakeTime(id), inRange, test.want, test.inRange)
+		}
+	}
+}
+
+func TestNextSnowflake(t *testing.T) {
+	created := time.Date(2024, 11, 8, 12, 0, 0, 0, time.UTC)
+	id, _ := makeSnowflake(created, "at://did:plc:test/app.bsky.feed.post/a")
+	ms := id &^ snowflakeHashMask
+	for _, test := range []struct{ hash, next int64 }{
+		{id & snowflakeHashMask, id&snowflakeHashMask + 1},
+		{0, 1},
+		{snowflakeHashMask - 1, snowflakeHashMask},
+		{snowflakeHashMask, 0}, // wraps around, instead of going into the next millisecond
+	} {
+		next := nextSnowflake(ms | test.hash)
+		if next != ms|test.next || !SnowflakeTime(next).Equal(created) {
+			t.Errorf("after %x got %x (%s), want %x", test.hash, next&snowflakeHashMask, SnowflakeTime(next), test.next)
+		}
+	}
+}
@@ -3,6 +3,8 @@ package bridge
 import (
 	"sync"
 	"time"
+
+	"github.com/Preloading/TwitterAPIBridge/metrics"
 )
 
 type Cache struct {
@@ -23,8 +25,10 @@ func (c *Cache) Get(key string) (TwitterUser, bool) {
 	defer c.mutex.RUnlock()
 	user, found := c.data[key]
 	if !found {
+		metrics.CacheRequests.Inc("user", "miss")
 		return TwitterUser{}, false
 	}
+	metrics.CacheRequests.Inc("user", "hit")
 	return user.copy(), true
 }
 
@@ -45,6 +49,13 @@ func (c *Cache) SetMultiple(keys []string, user TwitterUser) {
 	}
 }
 
+// Clear forgets everyone in the cache.
+func (c *Cache) Clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	clear(c.data)
+}
+
 func (c *Cache) expireKeyAfterTTL(key string) {
 	time.Sleep(c.ttl)
 	c.mutex.Lock()
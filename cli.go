@@ -0,0 +1,327 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Preloading/TwitterAPIBridge/config"
+	"github.com/Preloading/TwitterAPIBridge/db_controller"
+)
+
+// Admin commands, so operators don't have to hand edit the database.
+// These use the same config.yaml (and env vars) as the server. Run with no arguments to start the server.
+
+const adminUsage = `usage: twitterbridge <command> [arguments]
+
+commands:
+  migrate                                      run database migrations and exit
+  tokens list [--did DID]                      list sessions
+  tokens revoke --did DID [--uuid UUID]        log a user out (everywhere, or just one session)
+  push list [--did DID]                        list push notification registrations
+  push remove --did DID                        remove a user's push notification registration
+  ids prune --older-than AGE                   delete user/post ids we haven't handed out in AGE
+  shortlinks prune --older-than AGE            delete short links that haven't been used in AGE
+  maintenance run                              prune everything past its retention, like the server does
+  analytics report [--since AGE]               summarize analytics from the last AGE (default 7d)
+  export --out FILE                            export the database as JSON lines
+  import --in FILE                             import a database export
+
+AGE is a go duration (ex. 12h), or a number of days (ex. 30d).
+`
+
+// runAdminCommand runs an admin command, returning the exit code.
+func runAdminCommand(cfg config.Config, args []string) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		fmt.Print(adminUsage)
+		return 0
+	}
+
+	var err error
+	switch args[0] {
+	case "migrate":
+		err = migrateCommand(cfg)
+	case "tokens":
+		err = tokensCommand(cfg, args[1:])
+	case "push":
+		err = pushCommand(cfg, args[1:])
+	case "ids":
+		err = idsCommand(cfg, args[1:])
+	case "shortlinks":
+		err = shortlinksCommand(cfg, args[1:])
+	case "maintenance":
+		err = maintenanceCommand(cfg, args[1:])
+	case "analytics":
+		err = analyticsCommand(cfg, args[1:])
+	case "export":
+		err = exportCommand(cfg, args[1:])
+	case "import":
+		err = importCommand(cfg, args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", args[0], adminUsage)
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	return 0
+}
+
+func migrateCommand(cfg config.Config) error {
+	db_controller.OpenDB(cfg)
+	version, err := db_controller.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	fmt.Println("Database is on schema version", version)
+	return nil
+}
+
+func tokensCommand(cfg config.Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("expected list or revoke")
+	}
+	flags := flag.NewFlagSet("tokens "+args[0], flag.ExitOnError)
+	did := flags.String("did", "", "user DID")
+	tokenUUID := flags.String("uuid", "", "only this session")
+	flags.Parse(args[1:])
+
+	switch args[0] {
+	case "list":
+		db_controller.OpenDB(cfg)
+		tokens, err := db_controller.ListTokens(*did)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "DID\tUUID\tPDS\tUSERNAME\tVERSION\tREFRESH EXPIRES")
+		for _, token := range tokens {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n", token.UserDid, token.TokenUUID, token.UserPDS, token.BasicAuthUsername, token.TokenVersion,
+				time.Unix(int64(token.RefreshExpiry), 0).Format(time.RFC3339))
+		}
+		return w.Flush()
+	case "revoke":
+		if *did == "" {
+			return fmt.Errorf("--did is required")
+		}
+		db_controller.OpenDB(cfg)
+		count, err := db_controller.RevokeTokens(*did, *tokenUUID)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Revoked %d session(s) for %s\n", count, *did)
+		return nil
+	}
+	return fmt.Errorf("unknown tokens command %q", args[0])
+}
+
+func pushCommand(cfg config.Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("expected list or remove")
+	}
+	flags := flag.NewFlagSet("push "+args[0], flag.ExitOnError)
+	did := flags.String("did", "", "user DID")
+	flags.Parse(args[1:])
+
+	switch args[0] {
+	case "list":
+		db_controller.OpenDB(cfg)
+		registrations, err := db_controller.ListPushRegistrations(*did)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "DID\tSERVER\tENABLED FOR\tLANGUAGE\tLAST UPDATED")
+		for _, registration := range registrations {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", registration.UserDID, registration.ServerAddress, registration.EnabledFor, registration.Language,
+				registration.LastUpdated.Format(time.RFC3339))
+		}
+		return w.Flush()
+	case "remove":
+		if *did == "" {
+			return fmt.Errorf("--did is required")
+		}
+		db_controller.OpenDB(cfg)
+		if err := db_controller.DeleteeeeeeeeeeeeRegistrationForPushNotificationsWithDid(*did); err != nil {
+			return err
+		}
+		fmt.Println("Removed push notifications for", *did)
+		return nil
+	}
+	return fmt.Errorf("unknown push command %q", args[0])
+}
+
+func idsCommand(cfg config.Config, args []string) error {
+	if len(args) == 0 || args[0] != "prune" {
+		return fmt.Errorf("expected prune")
+	}
+	cutoff, err := parseOlderThan("ids prune", args[1:])
+	if err != nil {
+		return err
+	}
+
+	db_controller.OpenDB(cfg)
+	users, posts, err := db_controller.PruneIDs(cutoff)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Pruned %d user id(s) and %d post id(s) last seen before %s\n", users, posts, cutoff.Format(time.RFC3339))
+	return nil
+}
+
+func shortlinksCommand(cfg config.Config, args []string) error {
+	if len(args) == 0 || args[0] != "prune" {
+		return fmt.Errorf("expected prune")
+	}
+	cutoff, err := parseOlderThan("shortlinks prune", args[1:])
+	if err != nil {
+		return err
+	}
+
+	db_controller.OpenDB(cfg)
+	count, err := db_controller.PruneShortLinks(cutoff)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Pruned %d short link(s) last used before %s\n", count, cutoff.Format(time.RFC3339))
+	return nil
+}
+
+func maintenanceCommand(cfg config.Config, args []string) error {
+	if len(args) == 0 || args[0] != "run" {
+		return fmt.Errorf("expected run")
+	}
+
+	db_controller.OpenDB(cfg)
+	report := db_controller.RunMaintenance()
+	if len(report.Errors) > 0 {
+		return fmt.Errorf("maintenance had %d error(s)", len(report.Errors))
+	}
+	return nil
+}
+
+func analyticsCommand(cfg config.Config, args []string) error {
+	if len(args) == 0 || args[0] != "report" {
+		return fmt.Errorf("expected report")
+	}
+	flags := flag.NewFlagSet("analytics report", flag.ExitOnError)
+	sinceStr := flags.String("since", "7d", "how far back to report")
+	flags.Parse(args[1:])
+	age, err := parseAge(*sinceStr)
+	if err != nil {
+		return err
+	}
+	since := time.Now().Add(-age)
+
+	db_controller.OpenDB(cfg)
+	counts, err := db_controller.AnalyticsReport(since)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Analytics since", since.Format(time.RFC3339))
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TYPE\tCLIENT\tEVENTS\tUNIQUE IPS")
+	for _, count := range counts {
+		users := "-" // ANALYTICS_AGGREGATE_ONLY doesn't keep IPs
+		if count.Users >= 0 {
+			users = strconv.FormatInt(count.Users, 10)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", count.DataType, count.TwitterClient, count.Count, users)
+	}
+	return w.Flush()
+}
+
+func exportCommand(cfg config.Config, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	// not stdout, migrations and gorm's slow query warnings print there
+	outPath := flags.String("out", "", "file to write to")
+	flags.Parse(args)
+	if *outPath == "" {
+		return fmt.Errorf("--out is required")
+	}
+
+	file, err := os.Create(*outPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	db_controller.OpenDB(cfg)
+	counts, err := db_controller.ExportDatabase(file)
+	if err != nil {
+		return err
+	}
+	printTableCounts("Exported", counts)
+	return nil
+}
+
+func importCommand(cfg config.Config, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	inPath := flags.String("in", "", "export file to read")
+	flags.Parse(args)
+	if *inPath == "" {
+		return fmt.Errorf("--in is required")
+	}
+
+	file, err := os.Open(*inPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	db_controller.OpenDB(cfg)
+	counts, err := db_controller.ImportDatabase(file)
+	if err != nil {
+		return err
+	}
+	printTableCounts("Imported", counts)
+	return nil
+}
+
+func printTableCounts(verb string, counts map[string]int) {
+	tables := make([]string, 0, len(counts))
+	for table := range counts {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		fmt.Printf("%s %d row(s) from %s\n", verb, counts[table], table)
+	}
+}
+
+func parseOlderThan(name string, args []string) (time.Time, error) {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	olderThan := flags.String("older-than", "", "age, ex. 30d")
+	flags.Parse(args)
+	if *olderThan == "" {
+		return time.Time{}, fmt.Errorf("--older-than is required")
+	}
+	age, err := parseAge(*olderThan)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Now().Add(-age), nil
+}
+
+// parseAge parses a go duration, or a number of days like "30d", since time.ParseDuration doesn't do days.
+func parseAge(age string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(age, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid age %q", age)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	duration, err := time.ParseDuration(age)
+	if err != nil {
+		return 0, fmt.Errorf("invalid age %q", age)
+	}
+	return duration, nil
+}
@@ -8,7 +8,7 @@ package config
 
 import (
 	"encoding/hex"
-	"fmt"
+	"log/slog"
 
 	"github.com/spf13/viper"
 )
@@ -18,17 +18,61 @@ type Config struct {
 	Version string `mapstructure:"VERSION"`
 	// Accessible server address
 	CdnURL string `mapstructure:"CDN_URL"`
+	// Where resized images from the CDN are kept, so they're not downloaded & resized again. Empty doesn't keep them
+	CdnCacheDir string `mapstructure:"CDN_CACHE_DIR"`
+	// How big the CDN cache can get (MB), the images used longest ago are deleted past this
+	CdnCacheMaxMB int `mapstructure:"CDN_CACHE_MAX_MB"`
+	// JPEG quality for images from the CDN, unless the client's profile says otherwise
+	ImageJPEGQuality int `mapstructure:"IMAGE_JPEG_QUALITY"`
+	// Biggest image (width * height) sent to clients without a profile, 0 for no limit
+	ImageMaxPixels int `mapstructure:"IMAGE_MAX_PIXELS"`
+	// Biggest image (bytes) sent to clients without a profile, 0 for no limit
+	ImageMaxBytes int `mapstructure:"IMAGE_MAX_BYTES"`
+	// What formats & sizes of images old clients can handle, see ImageClientProfile
+	ImageClientProfiles []ImageClientProfile `mapstructure:"IMAGE_CLIENT_PROFILES"`
 	// The port to run the server on
 	ServerPort int `mapstructure:"SERVER_PORT"`
-	// This enables extra logging, INCLUDING SENSETIVE INFORMATION like BLUESKY TOKENS. Useful for debugging with tools like insomnia. DO NOT USE ON PUBLIC SERVERS
+	// This enables extra (debug) logging. Tokens & passwords are still redacted. Useful for debugging with tools like insomnia. DO NOT USE ON PUBLIC SERVERS
 	// Also requires all passwords start with "dev_" to work
 	DeveloperMode bool `mapstructure:"DEVELOPER_MODE"`
+	// Log format (text, json)
+	LogFormat string `mapstructure:"LOG_FORMAT"`
+	// Log level (debug, info, warn, error). Empty is info, or debug in developer mode
+	LogLevel string `mapstructure:"LOG_LEVEL"`
 	// Collects analytics on users.
 	TrackAnalytics bool `mapstructure:"TRACK_ANALYTICS"`
+	// What to do with IPs in analytics (raw, truncate, hash, none)
+	AnalyticsIPMode string `mapstructure:"ANALYTICS_IP_MODE"`
+	// Only keep daily counts, not individual analytics events
+	AnalyticsAggregateOnly bool `mapstructure:"ANALYTICS_AGGREGATE_ONLY"`
+	// Token for /admin endpoints, empty disables them
+	AdminToken string `mapstructure:"ADMIN_TOKEN"`
+	// Separate address to serve /metrics on without the admin token (ex. 127.0.0.1:9100), empty to only serve it behind ADMIN_TOKEN
+	MetricsListenAddress string `mapstructure:"METRICS_LISTEN_ADDRESS"`
+	// Clients that get their own label in the metrics, everyone else is "other"
+	MetricsClients []string `mapstructure:"METRICS_CLIENTS"`
+	// The AppView used for requests that aren't logged in (ex. notifications, public profiles)
+	AppViewURL string `mapstructure:"APPVIEW_URL"`
+	// Where did:plc DIDs get resolved
+	PLCDirectoryURL string `mapstructure:"PLC_DIRECTORY_URL"`
+	// Resolve handles with com.atproto.identity.resolveHandle on this server, instead of by ourselves with .well-known & DNS. Empty to do it ourselves
+	HandleResolverURL string `mapstructure:"HANDLE_RESOLVER_URL"`
+	// How long a single request to bluesky can take (seconds)
+	XRPCTimeoutSeconds int `mapstructure:"XRPC_TIMEOUT_SECONDS"`
+	// How many times to retry a GET to bluesky that failed because of the network or a 5xx
+	XRPCRetries int `mapstructure:"XRPC_RETRIES"`
+	// How long a request to the bridge can take in total, including everything it asks bluesky (seconds)
+	RequestTimeoutSeconds int `mapstructure:"REQUEST_TIMEOUT_SECONDS"`
 	// Database type (mysql, postgres, sqlite)
 	DatabaseType string `mapstructure:"DATABASE_TYPE"`
 	// Database path
 	DatabasePath string `mapstructure:"DATABASE_PATH"`
+	// How often to prune old rows (hours, 0 disables)
+	MaintenanceIntervalHours int `mapstructure:"MAINTENANCE_INTERVAL_HOURS"`
+	// How long to keep things around after they were last used (days, 0 keeps forever)
+	RetentionIDsDays        int `mapstructure:"RETENTION_IDS_DAYS"`
+	RetentionShortLinksDays int `mapstructure:"RETENTION_SHORT_LINKS_DAYS"`
+	RetentionAnalyticsDays  int `mapstructure:"RETENTION_ANALYTICS_DAYS"`
 
 	UseXForwardedFor bool `mapstructure:"USE_X_FORWARDED_FOR"`
 
@@ -40,6 +84,30 @@ type Config struct {
 	GifDisplayText string `mapstructure:"GIF_DISPLAY_TEXT"`
 	GifURLText     string `mapstructure:"GIF_URL_TEXT"`
 
+	// What links in tweets become, like t.co. {shortcode} is the short link. Empty to leave links alone
+	LinkURLText string `mapstructure:"LINK_URL_TEXT"`
+	// Count how many times each link is clicked (in short_links.clicks)
+	LinkClickCounting bool `mapstructure:"LINK_CLICK_COUNTING"`
+
+	// What to try adding to @name mentions when we can't find who they meant in who the user follows/talked to. Empty to not guess
+	ShortMentionSuffix string `mapstructure:"SHORT_MENTION_SUFFIX"`
+	// Change @name to the full handle in the post, so it reads right on bluesky
+	ShortMentionRewrite bool `mapstructure:"SHORT_MENTION_REWRITE"`
+	// Split posts that are too long for bluesky into a thread, for users that haven't picked for themselves. If not, they get an error
+	SplitLongTweets bool `mapstructure:"SPLIT_LONG_TWEETS"`
+	// How long after posting something, posting it again counts as a duplicate (seconds, 0 disables)
+	DuplicateStatusWindowSeconds int `mapstructure:"DUPLICATE_STATUS_WINDOW_SECONDS"`
+	// Answer duplicates with twitter's error 187, instead of the tweet that was already posted
+	DuplicateStatusError bool `mapstructure:"DUPLICATE_STATUS_ERROR"`
+	// Add a link card (title, description & thumbnail from the page) to posts with a link, like the bluesky app does
+	LinkCards bool `mapstructure:"LINK_CARDS"`
+	// How long fetching a page (and its image) for a link card can take (seconds)
+	LinkCardTimeoutSeconds int `mapstructure:"LINK_CARD_TIMEOUT_SECONDS"`
+	// Let link cards be made for pages on private/loopback addresses. Only for testing!
+	LinkCardAllowPrivate bool `mapstructure:"LINK_CARD_ALLOW_PRIVATE"`
+	// Clients (matched against X-Twitter-Client & the User-Agent) that count entity indices in UTF-16 instead of code points
+	UTF16IndexClients []string `mapstructure:"UTF16_INDEX_CLIENTS"`
+
 	// Secret key used for JWT. Must be at least 32 bytes long. Keep this secret!
 	SecretKey string `mapstructure:"SECRET_KEY"`
 	// The security key but in bytes.
@@ -55,6 +123,23 @@ type Config struct {
 	NotificationTrustedServer        string `mapstructure:"NOTIFICATION_TRUSTED_SERVER"`
 	NotificationFeedbackSecretString string `mapstructure:"NOTIFICATION_FEEDBACK_SECRET"`
 	NotificationFeedbackSecret       []byte
+	// Maps our push message keys (push_mention, push_favourited, push_retweeted, push_followed) to a loc-key
+	// built into the client, so the client localizes the alert itself. Leave empty to send translated text.
+	NotificationLocKeys map[string]string `mapstructure:"NOTIFICATION_LOC_KEYS"`
+}
+
+// What images a client (or a group of them) can handle. The first profile to match a client is used.
+type ImageClientProfile struct {
+	// Matched against the User-Agent & X-Twitter-Client headers (not case sensitive)
+	Match []string `mapstructure:"MATCH"`
+	// Formats it can decode (jpeg, png, gif), best first. Empty goes by the Accept header
+	Formats []string `mapstructure:"FORMATS"`
+	// Biggest image (width * height) it gets, 0 uses IMAGE_MAX_PIXELS
+	MaxPixels int `mapstructure:"MAX_PIXELS"`
+	// Biggest image (bytes) it gets, 0 uses IMAGE_MAX_BYTES
+	MaxBytes int `mapstructure:"MAX_BYTES"`
+	// 0 uses IMAGE_JPEG_QUALITY
+	JPEGQuality int `mapstructure:"JPEG_QUALITY"`
 }
 
 // Loads our config files.
@@ -71,10 +156,49 @@ func LoadConfig() (*Config, error) {
 	viper.SetDefault("VERSION", "1.0.7") // wait till i forget to update this
 	viper.SetDefault("SERVER_PORT", "3000")
 	viper.SetDefault("DEVELOPER_MODE", false)
+	viper.SetDefault("LOG_FORMAT", "text")
+	viper.SetDefault("LOG_LEVEL", "")
 	viper.SetDefault("DATABASE_TYPE", "sqlite")
 	viper.SetDefault("DATABASE_PATH", "./db/twitterbridge.db")
 	viper.SetDefault("TRACK_ANALYTICS", true)
+	viper.SetDefault("ANALYTICS_IP_MODE", "truncate")
+	viper.SetDefault("ANALYTICS_AGGREGATE_ONLY", false)
+	viper.SetDefault("ADMIN_TOKEN", "")
+	viper.SetDefault("METRICS_LISTEN_ADDRESS", "")
+	viper.SetDefault("METRICS_CLIENTS", []string{"Twitter-iPhone", "Twitter-iPad", "Twitter-Mac", "TwitterAndroid"})
+	viper.SetDefault("APPVIEW_URL", "https://public.api.bsky.app")
+	viper.SetDefault("PLC_DIRECTORY_URL", "https://plc.directory")
+	viper.SetDefault("HANDLE_RESOLVER_URL", "")
+	viper.SetDefault("XRPC_TIMEOUT_SECONDS", 20)
+	viper.SetDefault("XRPC_RETRIES", 2)
+	viper.SetDefault("REQUEST_TIMEOUT_SECONDS", 60)
+	viper.SetDefault("MAINTENANCE_INTERVAL_HOURS", 6)
+	viper.SetDefault("RETENTION_IDS_DAYS", 0) // pruning IDs breaks old tweets clients still have, so it's opt-in
+	viper.SetDefault("RETENTION_SHORT_LINKS_DAYS", 180)
+	viper.SetDefault("RETENTION_ANALYTICS_DAYS", 365)
 	viper.SetDefault("CDN_URL", "http://127.0.0.1:3000")
+	viper.SetDefault("CDN_CACHE_DIR", "")
+	viper.SetDefault("CDN_CACHE_MAX_MB", 1024)
+	viper.SetDefault("IMAGE_JPEG_QUALITY", 75)
+	viper.SetDefault("IMAGE_MAX_PIXELS", 0)
+	viper.SetDefault("IMAGE_MAX_BYTES", 0)
+	viper.SetDefault("IMAGE_CLIENT_PROFILES", []map[string]any{
+		{ // iOS 3 to 5
+			"MATCH": []string{"iPhone OS 3_", "iPhone OS 4_", "iPhone OS 5_", "CPU OS 3_", "CPU OS 4_", "CPU OS 5_",
+				"iOS/3.", "iOS/4.", "iOS/5.", "Darwin/9.", "Darwin/10.", "Darwin/11."},
+			"FORMATS":      []string{"jpeg", "png", "gif"},
+			"MAX_PIXELS":   2000000,
+			"MAX_BYTES":    1000000,
+			"JPEG_QUALITY": 75,
+		},
+		{
+			"MATCH":        []string{"PlayStation Vita"},
+			"FORMATS":      []string{"jpeg", "png", "gif"},
+			"MAX_PIXELS":   2000000,
+			"MAX_BYTES":    500000,
+			"JPEG_QUALITY": 70,
+		},
+	})
 	viper.SetDefault("USE_X_FORWARDED_FOR", false)
 	viper.SetDefault("IMG_DISPLAY_TEXT", "pic.twitter.com/{shortblob}")
 	viper.SetDefault("VID_DISPLAY_TEXT", "pic.twitter.com/{shortblob}")
@@ -82,6 +206,17 @@ func LoadConfig() (*Config, error) {
 	viper.SetDefault("IMG_URL_TEXT", "http://127.0.0.1:3000/img/{shortblob}")
 	viper.SetDefault("VID_URL_TEXT", "http://127.0.0.1:3000/img/{shortblob}")
 	viper.SetDefault("GIF_URL_TEXT", "http://127.0.0.1:3000/img/{shortblob}")
+	viper.SetDefault("LINK_URL_TEXT", "") // needs to be this server's public address, there's no default that works
+	viper.SetDefault("LINK_CLICK_COUNTING", false)
+	viper.SetDefault("UTF16_INDEX_CLIENTS", []string{})
+	viper.SetDefault("SHORT_MENTION_SUFFIX", ".bsky.social")
+	viper.SetDefault("SHORT_MENTION_REWRITE", false)
+	viper.SetDefault("SPLIT_LONG_TWEETS", false)
+	viper.SetDefault("DUPLICATE_STATUS_WINDOW_SECONDS", 300)
+	viper.SetDefault("DUPLICATE_STATUS_ERROR", false)
+	viper.SetDefault("LINK_CARDS", false) // fetches whatever url someone posts, so it's opt-in
+	viper.SetDefault("LINK_CARD_TIMEOUT_SECONDS", 5)
+	viper.SetDefault("LINK_CARD_ALLOW_PRIVATE", false)
 	viper.SetDefault("SECRET_KEY", "")
 	viper.SetDefault("MIN_TOKEN_VERSION", 1)
 	viper.SetDefault("NOTIFICATION_TRUSTED_SERVER", "")
@@ -89,7 +224,7 @@ func LoadConfig() (*Config, error) {
 
 	// Read config file
 	if err := viper.ReadInConfig(); err != nil {
-		fmt.Println("No config file found, relying on environment variables")
+		slog.Info("No config file found, relying on environment variables")
 	}
 
 	// Bind config to struct
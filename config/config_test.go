@@ -0,0 +1,22 @@
+package config
+
+import "testing"
+
+// Things that are off unless you turn them on, because they need setting up or can hurt if you don't know about them.
+func TestOptInDefaults(t *testing.T) {
+	t.Chdir(t.TempDir()) // no config.yaml, only defaults
+	config, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.LinkURLText != "" {
+		t.Errorf("LINK_URL_TEXT defaults to %q, it needs this server's address", config.LinkURLText)
+	}
+	if config.LinkCards {
+		t.Error("LINK_CARDS defaults to on, it fetches any url anyone posts")
+	}
+	if config.RetentionIDsDays != 0 {
+		t.Errorf("RETENTION_IDS_DAYS defaults to %d, pruning IDs breaks old tweets", config.RetentionIDsDays)
+	}
+}
@@ -0,0 +1,287 @@
+package db_controller
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+// Stuff for the admin commands (see cli.go in the root), so nobody has to hand edit the DB again.
+
+// ListTokens lists sessions, for everyone if did is empty.
+func ListTokens(did string) ([]Token, error) {
+	var tokens []Token
+	query := db.Order("user_did")
+	if did != "" {
+		query = query.Where("user_did = ?", did)
+	}
+	if err := query.Find(&tokens).Error; err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// RevokeTokens deletes a user's sessions, or just one if tokenUUID is set.
+// @results: how many were deleted, error
+func RevokeTokens(did string, tokenUUID string) (int64, error) {
+	query := db.Where("user_did = ?", did)
+	if tokenUUID != "" {
+		query = query.Where("token_uuid = ?", tokenUUID)
+	}
+	result := query.Delete(&Token{})
+	return result.RowsAffected, result.Error
+}
+
+// ListPushRegistrations lists push notification registrations, for everyone if did is empty.
+func ListPushRegistrations(did string) ([]NotificationTokens, error) {
+	var registrations []NotificationTokens
+	query := db.Order("user_did")
+	if did != "" {
+		query = query.Where("user_did = ?", did)
+	}
+	if err := query.Find(&registrations).Error; err != nil {
+		return nil, err
+	}
+	return registrations, nil
+}
+
+type AnalyticsCount struct {
+	DataType      string
+	TwitterClient string
+	Count         int64
+	Users         int64 // distinct IPs, close enough. -1 if we don't know (from the daily counters)
+}
+
+// AnalyticsReport counts analytics events since a time, by type and client.
+// With ANALYTICS_AGGREGATE_ONLY there's nothing in analytic_data, so it's from the daily counters instead, which only
+// go by whole days (UTC) and don't know how many users there were.
+func AnalyticsReport(since time.Time) ([]AnalyticsCount, error) {
+	var counts []AnalyticsCount
+	if cfg.AnalyticsAggregateOnly {
+		err := db.Model(&AnalyticsDaily{}).
+			Select("data_type, twitter_client, SUM(count) AS count, -1 AS users").
+			Where("day >= ?", since.UTC().Format("2006-01-02")).
+			Group("data_type, twitter_client").
+			Order("data_type, count DESC").
+			Scan(&counts).Error
+		return counts, err
+	}
+	err := db.Model(&AnalyticData{}).
+		Select("data_type, twitter_client, COUNT(*) AS count, COUNT(DISTINCT ip_address) AS users").
+		Where("timestamp >= ?", since).
+		Group("data_type, twitter_client").
+		Order("data_type, count DESC").
+		Scan(&counts).Error
+	return counts, err
+}
+
+// The export format is JSON lines, one row per line, so it works between sqlite, mysql and postgres:
+// {"table":"tokens","row":{...}}
+// The first line is {"table":"_meta","row":{"schema_version":N}}.
+
+type exportLine struct {
+	Table string          `json:"table"`
+	Row   json.RawMessage `json:"row"`
+}
+
+type exportMeta struct {
+	SchemaVersion int `json:"schema_version"`
+}
+
+// Every table that gets exported, in the order they're exported (and imported).
+// upsert is false for tables without a primary key, since there's nothing to conflict on.
+var exportTables = []struct {
+	name     string
+	upsert   bool
+	export   func(enc *json.Encoder, table string) (int, error)
+	importer func(upsert bool) tableImporter
+}{
+	{"tokens", true, exportTable[Token], newTableImporter[Token]},
+	{"message_contexts", true, exportTable[MessageContext], newTableImporter[MessageContext]},
+	{"twitter_ids", true, exportTable[TwitterIDs], newTableImporter[TwitterIDs]},
+	{"post_ids", true, exportTable[PostIDs], newTableImporter[PostIDs]},
+	{"analytic_data", false, exportTable[AnalyticData], newTableImporter[AnalyticData]},
+	{"analytics_dailies", true, exportTable[AnalyticsDaily], newTableImporter[AnalyticsDaily]},
+	{"short_links", true, exportTable[ShortLink], newTableImporter[ShortLink]},
+	{"notification_tokens", true, exportTable[NotificationTokens], newTableImporter[NotificationTokens]},
+	{"user_settings", true, exportTable[UserSettings], newTableImporter[UserSettings]},
+}
+
+// ExportDatabase writes every table to w.
+// @results: rows written per table, error
+func ExportDatabase(w io.Writer) (map[string]int, error) {
+	enc := json.NewEncoder(w)
+
+	version, err := SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	if err := writeExportLine(enc, "_meta", exportMeta{SchemaVersion: version}); err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int{}
+	for _, table := range exportTables {
+		count, err := table.export(enc, table.name)
+		if err != nil {
+			return counts, fmt.Errorf("failed to export %s: %w", table.name, err)
+		}
+		counts[table.name] = count
+	}
+	return counts, nil
+}
+
+func exportTable[T any](enc *json.Encoder, table string) (int, error) {
+	// not FindInBatches, analytic_data has no primary key to page by
+	rows, err := db.Model(new(T)).Rows()
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	count := 0
+	for rows.Next() {
+		var row T
+		if err := db.ScanRows(rows, &row); err != nil {
+			return count, err
+		}
+		if err := writeExportLine(enc, table, row); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, rows.Err()
+}
+
+func writeExportLine(enc *json.Encoder, table string, row interface{}) error {
+	rowJSON, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	return enc.Encode(exportLine{Table: table, Row: rowJSON})
+}
+
+type tableImporter interface {
+	add(row json.RawMessage) error
+	flush() error
+	count() int
+}
+
+type genericImporter[T any] struct {
+	upsert   bool
+	pending  []T
+	imported int
+	checked  bool
+}
+
+func newTableImporter[T any](upsert bool) tableImporter {
+	return &genericImporter[T]{upsert: upsert}
+}
+
+func (i *genericImporter[T]) add(rowJSON json.RawMessage) error {
+	// without a primary key nothing gets replaced, importing on top of what's there would count everything twice
+	if !i.upsert && !i.checked {
+		var existing int64
+		if err := db.Model(new(T)).Count(&existing).Error; err != nil {
+			return err
+		}
+		if existing > 0 {
+			return fmt.Errorf("table already has %d row(s), it has to be empty to import into", existing)
+		}
+		i.checked = true
+	}
+
+	var row T
+	if err := json.Unmarshal(rowJSON, &row); err != nil {
+		return err
+	}
+	i.pending = append(i.pending, row)
+	if len(i.pending) >= 500 {
+		return i.flush()
+	}
+	return nil
+}
+
+func (i *genericImporter[T]) flush() error {
+	if len(i.pending) == 0 {
+		return nil
+	}
+	query := db
+	if i.upsert {
+		query = db.Clauses(clause.OnConflict{UpdateAll: true})
+	}
+	if err := query.CreateInBatches(i.pending, 100).Error; err != nil {
+		return err
+	}
+	i.imported += len(i.pending)
+	i.pending = i.pending[:0]
+	return nil
+}
+
+func (i *genericImporter[T]) count() int {
+	return i.imported
+}
+
+// ImportDatabase reads an export made by ExportDatabase into this database. Rows that already exist are replaced.
+// Tables without a primary key (analytic_data) have to be empty, if the export has any rows for them.
+// @results: rows imported per table, error
+func ImportDatabase(r io.Reader) (map[string]int, error) {
+	importers := map[string]tableImporter{}
+	for _, table := range exportTables {
+		importers[table.name] = table.importer(table.upsert)
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var line exportLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+
+		if line.Table == "_meta" {
+			var meta exportMeta
+			if err := json.Unmarshal(line.Row, &meta); err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+			}
+			version, err := SchemaVersion()
+			if err != nil {
+				return nil, err
+			}
+			if meta.SchemaVersion != version {
+				log.Warn("Export is from a different schema version", "export_version", meta.SchemaVersion, "database_version", version)
+			}
+			continue
+		}
+
+		importer, ok := importers[line.Table]
+		if !ok {
+			return nil, fmt.Errorf("line %d: unknown table %q", lineNumber, line.Table)
+		}
+		if err := importer.add(line.Row); err != nil {
+			return nil, fmt.Errorf("line %d (%s): %w", lineNumber, line.Table, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int{}
+	for _, table := range exportTables {
+		if err := importers[table.name].flush(); err != nil {
+			return counts, fmt.Errorf("failed to import %s: %w", table.name, err)
+		}
+		counts[table.name] = importers[table.name].count()
+	}
+	return counts, nil
+}
@@ -0,0 +1,67 @@
+package db_controller
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Preloading/TwitterAPIBridge/config"
+)
+
+// With ANALYTICS_AGGREGATE_ONLY the report comes from the daily counters, since analytic_data is empty.
+func TestAnalyticsReportAggregateOnly(t *testing.T) {
+	useTestDB(t, config.Config{TrackAnalytics: true, AnalyticsAggregateOnly: true})
+	today := time.Now().UTC()
+	batch := []AnalyticData{
+		{DataType: "tweets posted", TwitterClient: "Tweetie", TwitterClientVersion: "2.1", Timestamp: today},
+		{DataType: "tweets posted", TwitterClient: "Tweetie", TwitterClientVersion: "2.2", Timestamp: today},
+		{DataType: "tweets posted", TwitterClient: "Echofon", Timestamp: today},
+		{DataType: "tweets posted", TwitterClient: "Tweetie", Timestamp: today.AddDate(0, 0, -10)},
+	}
+	if left := writeAnalyticsToDB(batch); len(left) != 0 {
+		t.Fatal("write failed")
+	}
+
+	counts, err := AnalyticsReport(today.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []AnalyticsCount{
+		{DataType: "tweets posted", TwitterClient: "Tweetie", Count: 2, Users: -1},
+		{DataType: "tweets posted", TwitterClient: "Echofon", Count: 1, Users: -1},
+	}
+	if fmt.Sprint(counts) != fmt.Sprint(want) {
+		t.Errorf("got %+v, want %+v", counts, want)
+	}
+}
+
+// analytic_data has no primary key, so importing on top of what's there would double count it.
+func TestImportIntoAnalyticData(t *testing.T) {
+	useTestDB(t, config.Config{TrackAnalytics: true})
+	now := time.Now().UTC()
+	if left := writeAnalyticsToDB([]AnalyticData{{DataType: "tweets posted", IPAddress: "1.2.3.4", Timestamp: now}}); len(left) != 0 {
+		t.Fatal("write failed")
+	}
+	var export bytes.Buffer
+	if _, err := ExportDatabase(&export); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ImportDatabase(bytes.NewReader(export.Bytes())); err == nil || !strings.Contains(err.Error(), "analytic_data") {
+		t.Errorf("imported into a table with rows in it: %v", err)
+	}
+	var rows int64
+	db.Model(&AnalyticData{}).Count(&rows)
+	if rows != 1 {
+		t.Errorf("analytic_data has %d rows", rows)
+	}
+
+	// into an empty one it's fine
+	useTestDB(t, config.Config{TrackAnalytics: true})
+	counts, err := ImportDatabase(bytes.NewReader(export.Bytes()))
+	if err != nil || counts["analytic_data"] != 1 {
+		t.Fatalf("got %v, %v", counts, err)
+	}
+}
@@ -1,64 +1,330 @@
 package db_controller
 
 import (
-	"log"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/Preloading/TwitterAPIBridge/metrics"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Analytics get sent to a collector goroutine over a channel, which writes them in batches.
+// If the channel is full (or the DB has been down long enough that we're holding too many), analytics get dropped
+// and counted, instead of slowing down requests or eating all our memory.
+
+const (
+	analyticsQueueSize = 10000 // how many can be waiting on the channel
+	analyticsMaxHeld   = 50000 // how many the collector will hold on to while the DB isn't working
+	analyticsBatchSize = 100
 )
 
 var (
-	inRamAnalytics  []AnalyticData
-	analyticsTicker *time.Ticker
+	analyticsQueue    chan AnalyticData
+	analyticsStop     chan struct{}
+	analyticsDone     chan struct{}
+	analyticsStopOnce sync.Once
+	analyticsDropped  atomic.Uint64
+	analyticsWritten  atomic.Uint64
 )
 
+func init() {
+	metrics.NewCounterFunc("twitterbridge_analytics_written_total", "Analytics events written to the database.",
+		func() float64 { return float64(analyticsWritten.Load()) })
+	metrics.NewCounterFunc("twitterbridge_analytics_dropped_total", "Analytics events dropped because the queue was full or the database was down.",
+		func() float64 { return float64(analyticsDropped.Load()) })
+}
+
 // Stores analytic data (if enabled)
 // -- TYPES --
 // 1. "login"
 // 2. "tweets viewed"
 // 3. "tweets posted"
 func StoreAnalyticData(data AnalyticData) {
-	if !cfg.TrackAnalytics {
+	if !cfg.TrackAnalytics || analyticsQueue == nil {
 		return
 	}
 
-	inRamAnalytics = append(inRamAnalytics, data)
+	data.IPAddress = anonymizeIP(data.IPAddress, data.Timestamp)
+
+	select {
+	case analyticsQueue <- data:
+	default:
+		analyticsDropped.Add(1)
+	}
 }
 
-func WriteAnalyticsToDB() {
+// AnalyticsStats returns how many analytics were written and dropped since startup.
+func AnalyticsStats() (written uint64, dropped uint64) {
+	return analyticsWritten.Load(), analyticsDropped.Load()
+}
+
+// StartPeriodicAnalyticsWriter starts the collector, which writes to the DB every interval (or when it has a full batch).
+func StartPeriodicAnalyticsWriter(interval time.Duration) {
 	if !cfg.TrackAnalytics {
 		return
 	}
 
-	if len(inRamAnalytics) == 0 {
+	analyticsQueue = make(chan AnalyticData, analyticsQueueSize)
+	analyticsStop = make(chan struct{})
+	analyticsDone = make(chan struct{})
+	analyticsStopOnce = sync.Once{}
+
+	go runAnalyticsCollector(interval)
+}
+
+// StopAnalytics writes whatever analytics are left and stops the collector. Call this on shutdown.
+func StopAnalytics(timeout time.Duration) {
+	if analyticsStop == nil {
 		return
 	}
 
+	analyticsStopOnce.Do(func() { close(analyticsStop) })
+	select {
+	case <-analyticsDone:
+	case <-time.After(timeout):
+		log.Warn("Timed out writing analytics on shutdown")
+	}
+}
+
+func runAnalyticsCollector(interval time.Duration) {
+	defer close(analyticsDone)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var held []AnalyticData
+	hold := func(data AnalyticData) {
+		if len(held) >= analyticsMaxHeld {
+			// DB's been down a while, drop the oldest
+			held = held[1:]
+			analyticsDropped.Add(1)
+		}
+		held = append(held, data)
+	}
+
+	for {
+		select {
+		case data := <-analyticsQueue:
+			hold(data)
+			if len(held) == analyticsBatchSize*10 { // not >=, if the DB is down we wait for the ticker instead of retrying every time
+				held = writeAnalyticsToDB(held)
+			}
+		case <-ticker.C:
+			held = writeAnalyticsToDB(held)
+		case <-analyticsStop:
+			// grab anything still in the channel, then one last write
+		drain:
+			for {
+				select {
+				case data := <-analyticsQueue:
+					hold(data)
+				default:
+					break drain
+				}
+			}
+			writeAnalyticsToDB(held)
+			return
+		}
+	}
+}
+
+// writeAnalyticsToDB writes analytics in one transaction, and returns what's left (nothing, unless it failed).
+func writeAnalyticsToDB(data []AnalyticData) []AnalyticData {
+	if len(data) == 0 {
+		return data
+	}
+
 	tx := db.Begin()
 
-	if err := tx.CreateInBatches(inRamAnalytics, 100).Error; err != nil {
+	if err := addToDailyAnalytics(tx, data); err != nil {
 		tx.Rollback()
-		log.Printf("Error writing analytics data: %v", err)
-		return
+		log.Error("Error writing daily analytics (will retry)", "error", err)
+		return data
+	}
+
+	if !cfg.AnalyticsAggregateOnly {
+		if err := tx.CreateInBatches(data, analyticsBatchSize).Error; err != nil {
+			tx.Rollback()
+			log.Error("Error writing analytics data (will retry)", "error", err)
+			return data
+		}
 	}
 
 	if err := tx.Commit().Error; err != nil {
-		log.Printf("Error committing analytics transaction: %v", err)
-		return
+		log.Error("Error committing analytics transaction (will retry)", "error", err)
+		return data
 	}
 
-	log.Printf("Successfully wrote %d analytics records to database", len(inRamAnalytics))
-	inRamAnalytics = inRamAnalytics[:0]
+	analyticsWritten.Add(uint64(len(data)))
+	log.Debug("Wrote analytics to database", "count", len(data))
+	return data[:0]
 }
 
-func StartPeriodicAnalyticsWriter(interval time.Duration) {
-	if !cfg.TrackAnalytics {
-		return
+// addToDailyAnalytics adds analytics to the daily counters.
+func addToDailyAnalytics(tx *gorm.DB, data []AnalyticData) error {
+	counters := map[AnalyticsDaily]int64{}
+	for _, d := range data {
+		key := AnalyticsDaily{
+			Day:                  d.Timestamp.UTC().Format("2006-01-02"),
+			DataType:             d.DataType,
+			TwitterClient:        d.TwitterClient,
+			TwitterClientVersion: d.TwitterClientVersion,
+			Language:             primaryLanguage(d.Language),
+		}
+		counters[key]++
+	}
+
+	rows := make([]AnalyticsDaily, 0, len(counters))
+	for key, count := range counters {
+		key.Count = count
+		rows = append(rows, key)
+	}
+
+	// add to the count if the row's already there
+	increment := gorm.Expr("analytics_dailies.count + excluded.count")
+	if tx.Dialector.Name() == "mysql" {
+		increment = gorm.Expr("count + VALUES(count)")
 	}
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "day"}, {Name: "data_type"}, {Name: "twitter_client"}, {Name: "twitter_client_version"}, {Name: "language"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"count": increment}),
+	}).CreateInBatches(rows, analyticsBatchSize).Error
+}
+
+// primaryLanguage turns an Accept-Language header (or a post's lang) into just the first language, ex. "en-us".
+// Whole headers are too unique to be counting by.
+func primaryLanguage(language string) string {
+	language = strings.SplitN(language, ",", 2)[0]
+	language = strings.SplitN(language, ";", 2)[0]
+	return strings.ToLower(strings.TrimSpace(language))
+}
 
-	analyticsTicker = time.NewTicker(interval)
+var (
+	ipSalt      []byte
+	ipSaltDay   string
+	ipSaltMutex sync.Mutex
+)
 
-	go func() {
-		for range analyticsTicker.C {
-			WriteAnalyticsToDB()
+// anonymizeIP does what ANALYTICS_IP_MODE says with an IP.
+func anonymizeIP(ip string, at time.Time) string {
+	if ip == "" {
+		return ""
+	}
+
+	switch cfg.AnalyticsIPMode {
+	case "raw":
+		return ip
+	case "none":
+		return ""
+	case "hash":
+		// The salt is random, only in memory, and changes daily. So we can count unique IPs in a day,
+		// but can't link them across days or reverse them (IPv4 is small enough to brute force an unsalted hash).
+		mac := hmac.New(sha256.New, dailyIPSalt(at))
+		mac.Write([]byte(ip))
+		return hex.EncodeToString(mac.Sum(nil))[:16]
+	default: // "truncate"
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			return ""
+		}
+		if v4 := parsed.To4(); v4 != nil {
+			return v4.Mask(net.CIDRMask(24, 32)).String()
 		}
-	}()
+		return parsed.Mask(net.CIDRMask(48, 128)).String()
+	}
+}
+
+func dailyIPSalt(at time.Time) []byte {
+	ipSaltMutex.Lock()
+	defer ipSaltMutex.Unlock()
+
+	day := at.UTC().Format("2006-01-02")
+	if day != ipSaltDay {
+		ipSalt = make([]byte, 32)
+		if _, err := rand.Read(ipSalt); err != nil {
+			panic(err) // crypto/rand doesn't fail
+		}
+		ipSaltDay = day
+	}
+	return ipSalt
+}
+
+type AnalyticsDay struct {
+	Day    string           `json:"day"`
+	Events map[string]int64 `json:"events"`
+}
+
+type AnalyticsBreakdown struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	Count   int64  `json:"count"`
+}
+
+// AnalyticsSummary is the /admin/analytics report.
+type AnalyticsSummary struct {
+	Since     string               `json:"since"`
+	Days      []AnalyticsDay       `json:"days"`
+	Events    map[string]int64     `json:"events"`
+	Clients   []AnalyticsBreakdown `json:"clients"`
+	Languages []AnalyticsBreakdown `json:"languages"`
+	Written   uint64               `json:"written_since_startup"`
+	Dropped   uint64               `json:"dropped_since_startup"`
+}
+
+// GetAnalyticsSummary builds a report from the daily counters since a day.
+func GetAnalyticsSummary(since time.Time) (*AnalyticsSummary, error) {
+	sinceDay := since.UTC().Format("2006-01-02")
+	summary := AnalyticsSummary{
+		Since:     sinceDay,
+		Days:      []AnalyticsDay{},
+		Events:    map[string]int64{},
+		Clients:   []AnalyticsBreakdown{},
+		Languages: []AnalyticsBreakdown{},
+	}
+	summary.Written, summary.Dropped = AnalyticsStats()
+
+	var byDay []AnalyticsDaily
+	if err := db.Model(&AnalyticsDaily{}).
+		Select("day, data_type, SUM(count) AS count").
+		Where("day >= ?", sinceDay).
+		Group("day, data_type").
+		Order("day").
+		Scan(&byDay).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range byDay {
+		if len(summary.Days) == 0 || summary.Days[len(summary.Days)-1].Day != row.Day {
+			summary.Days = append(summary.Days, AnalyticsDay{Day: row.Day, Events: map[string]int64{}})
+		}
+		summary.Days[len(summary.Days)-1].Events[row.DataType] += row.Count
+		summary.Events[row.DataType] += row.Count
+	}
+
+	if err := db.Model(&AnalyticsDaily{}).
+		Select("twitter_client AS name, twitter_client_version AS version, SUM(count) AS count").
+		Where("day >= ?", sinceDay).
+		Group("twitter_client, twitter_client_version").
+		Order("count DESC").
+		Scan(&summary.Clients).Error; err != nil {
+		return nil, err
+	}
+
+	if err := db.Model(&AnalyticsDaily{}).
+		Select("language AS name, SUM(count) AS count").
+		Where("day >= ?", sinceDay).
+		Group("language").
+		Order("count DESC").
+		Scan(&summary.Languages).Error; err != nil {
+		return nil, err
+	}
+
+	return &summary, nil
 }
@@ -0,0 +1,128 @@
+package db_controller
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Preloading/TwitterAPIBridge/config"
+)
+
+// Lots of requests storing analytics at once, while the collector writes them. Run with -race.
+func TestAnalyticsCollector(t *testing.T) {
+	useTestDB(t, config.Config{TrackAnalytics: true, AnalyticsIPMode: "truncate"})
+	writtenBefore, droppedBefore := AnalyticsStats()
+	StartPeriodicAnalyticsWriter(10 * time.Millisecond)
+
+	days := []time.Time{
+		time.Date(2024, 11, 1, 23, 59, 0, 0, time.UTC),
+		time.Date(2024, 11, 2, 0, 1, 0, 0, time.UTC),
+	}
+	const workers, perWorker = 8, 300
+	var wg sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				data := AnalyticData{
+					DataType:             "tweets viewed",
+					IPAddress:            fmt.Sprintf("203.0.113.%d", i%250),
+					Language:             "en-US,en;q=0.9",
+					TwitterClient:        "Twitter for iPhone",
+					TwitterClientVersion: "4.1.3",
+					Timestamp:            days[i%2],
+				}
+				if worker%2 == 1 {
+					data.DataType = "login"
+					data.Language = "ja"
+				}
+				StoreAnalyticData(data)
+			}
+		}()
+	}
+	wg.Wait()
+	StopAnalytics(10 * time.Second)
+
+	written, dropped := AnalyticsStats()
+	if dropped != droppedBefore {
+		t.Fatalf("%d analytics dropped", dropped-droppedBefore)
+	}
+	if written-writtenBefore != workers*perWorker {
+		t.Errorf("%d analytics written, stored %d", written-writtenBefore, workers*perWorker)
+	}
+
+	var raw []AnalyticData
+	if err := db.Find(&raw).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) != workers*perWorker {
+		t.Errorf("analytic_data has %d rows, stored %d", len(raw), workers*perWorker)
+	}
+	for _, data := range raw {
+		if data.IPAddress != "203.0.113.0" {
+			t.Fatalf("IP %q wasn't truncated", data.IPAddress)
+		}
+	}
+
+	summary, err := GetAnalyticsSummary(days[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	perDay := int64(workers / 2 * perWorker / 2)
+	if len(summary.Days) != 2 {
+		t.Fatalf("summary has days %+v", summary.Days)
+	}
+	for _, day := range summary.Days {
+		if day.Events["tweets viewed"] != perDay || day.Events["login"] != perDay {
+			t.Errorf("%s has %v, want %d of each", day.Day, day.Events, perDay)
+		}
+	}
+	if summary.Days[0].Day != "2024-11-01" || summary.Days[1].Day != "2024-11-02" {
+		t.Errorf("days are %s and %s", summary.Days[0].Day, summary.Days[1].Day)
+	}
+	languages := map[string]int64{}
+	for _, language := range summary.Languages {
+		languages[language.Name] = language.Count
+	}
+	if languages["en-us"] != perDay*2 || languages["ja"] != perDay*2 || len(languages) != 2 {
+		t.Errorf("languages are %v", languages)
+	}
+}
+
+// Writing to a day that already has counters adds to them.
+func TestDailyAnalyticsAggregation(t *testing.T) {
+	useTestDB(t, config.Config{TrackAnalytics: true, AnalyticsAggregateOnly: true})
+
+	day := time.Date(2024, 11, 1, 12, 0, 0, 0, time.UTC)
+	batch := []AnalyticData{
+		{DataType: "tweets posted", TwitterClient: "Tweetie", TwitterClientVersion: "2.1", Language: "fr-FR", Timestamp: day},
+		{DataType: "tweets posted", TwitterClient: "Tweetie", TwitterClientVersion: "2.1", Language: "fr", Timestamp: day.Add(time.Hour)},
+		{DataType: "tweets posted", TwitterClient: "Tweetie", TwitterClientVersion: "2.2", Language: "fr", Timestamp: day},
+	}
+	for i := 0; i < 3; i++ {
+		if left := writeAnalyticsToDB(append([]AnalyticData{}, batch...)); len(left) != 0 {
+			t.Fatalf("write %d failed", i)
+		}
+	}
+
+	var counters []AnalyticsDaily
+	if err := db.Order("twitter_client_version, language").Find(&counters).Error; err != nil {
+		t.Fatal(err)
+	}
+	want := []AnalyticsDaily{
+		{Day: "2024-11-01", DataType: "tweets posted", TwitterClient: "Tweetie", TwitterClientVersion: "2.1", Language: "fr", Count: 3},
+		{Day: "2024-11-01", DataType: "tweets posted", TwitterClient: "Tweetie", TwitterClientVersion: "2.1", Language: "fr-fr", Count: 3},
+		{Day: "2024-11-01", DataType: "tweets posted", TwitterClient: "Tweetie", TwitterClientVersion: "2.2", Language: "fr", Count: 3},
+	}
+	if fmt.Sprint(counters) != fmt.Sprint(want) {
+		t.Errorf("analytics_dailies has %+v, want %+v", counters, want)
+	}
+
+	var raw int64
+	db.Model(&AnalyticData{}).Count(&raw)
+	if raw != 0 {
+		t.Errorf("ANALYTICS_AGGREGATE_ONLY still wrote %d rows to analytic_data", raw)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	skyglownotificationlib "github.com/Preloading/SkyglowNotificationLibraries"
 	"github.com/Preloading/TwitterAPIBridge/config"
 	authcrypt "github.com/Preloading/TwitterAPIBridge/cryption"
+	"github.com/Preloading/TwitterAPIBridge/logging"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/driver/mysql"
@@ -52,9 +53,9 @@ import (
 type Token struct {
 	BasicAuthHash         string  `gorm:"type:string;"`      // I am not a fan that i have to store this for basic authentication
 	BasicAuthUsername     string  `gorm:"type:string;index"` // Add this field with an index
-	UserDid               string  `gorm:"type:string;index;not null"`
+	UserDid               string  `gorm:"type:string;index;uniqueIndex:idx_tokens_user_did_token_uuid,priority:1;not null"`
 	UserPDS               string  `gorm:"type:string;not null"`
-	TokenUUID             string  `gorm:"type:string;primaryKey"`
+	TokenUUID             string  `gorm:"type:string;primaryKey;uniqueIndex:idx_tokens_user_did_token_uuid,priority:2"`
 	EncryptedAccessToken  string  `gorm:"type:string;not null"`
 	EncryptedRefreshToken string  `gorm:"type:string;not null"`
 	AccessExpiry          float64 `gorm:"type:float;not null"`
@@ -64,10 +65,21 @@ type Token struct {
 }
 
 type TwitterIDs struct {
-	BlueskyID   string     `gorm:"type:string;not null"`
+	BlueskyID   string     `gorm:"type:string;not null;index"` // for DID -> ID lookups
 	TwitterID   string     `gorm:"type:string;primaryKey;not null"`
-	ReposterDid *string    `gorm:"type:string"`
-	DateCreated *time.Time `gorm:"type:timestamp"`
+	ReposterDid *string    `gorm:"type:string;index"`
+	DateCreated *time.Time `gorm:"type:timestamp;index"`
+	LastSeen    time.Time  `gorm:"index"` // last time we handed this id out (give or take an hour, see idmapper.go), used for pruning
+}
+
+// PostIDs maps our snowflake-style post IDs back to bluesky.
+// The time is already in the ID, so this only has to store what the hash part can't give back.
+type PostIDs struct {
+	TwitterID   int64      `gorm:"primaryKey;autoIncrement:false"`
+	BlueskyID   string     `gorm:"type:string;not null;index"` // for AT-URI -> ID lookups
+	ReposterDid *string    `gorm:"type:string;index"`
+	DateCreated *time.Time `gorm:"type:timestamp"` // only set when the time can't be read from the ID (old hashed IDs, or out of range dates)
+	LastSeen    time.Time  `gorm:"index"`          // last time we handed this id out (give or take an hour, see idmapper.go), used for pruning
 }
 
 type MessageContext struct {
@@ -89,10 +101,22 @@ type AnalyticData struct {
 	Timestamp            time.Time `gorm:"type:timestamp"`
 }
 
+// Daily counters, these are always kept (even with ANALYTICS_AGGREGATE_ONLY) and don't have anything personal in them.
+type AnalyticsDaily struct {
+	Day                  string `gorm:"type:string;primaryKey"` // 2006-01-02, UTC
+	DataType             string `gorm:"type:string;primaryKey"`
+	TwitterClient        string `gorm:"type:string;primaryKey"`
+	TwitterClientVersion string `gorm:"type:string;primaryKey"`
+	Language             string `gorm:"type:string;primaryKey"`
+	Count                int64
+}
+
 // ShortLink represents the schema for the short_links table
 type ShortLink struct {
-	ShortCode   string `gorm:"type:string;primaryKey;not null"`
-	OriginalURL string `gorm:"type:string;not null"`
+	ShortCode   string    `gorm:"type:string;primaryKey;not null"`
+	OriginalURL string    `gorm:"type:string;not null"`
+	LastSeen    time.Time `gorm:"index"`              // last time this was created or followed (roughly, we only bump it once a day), used for pruning
+	Clicks      int64     `gorm:"not null;default:0"` // only counted for links, with LINK_CLICK_COUNTING
 }
 
 type NotificationTokens struct {
@@ -101,15 +125,38 @@ type NotificationTokens struct {
 	ServerAddress string
 	UserDID       string `gorm:"column:user_did;primaryKey"`
 	EnabledFor    int
+	Language      string // from the lang param when registering, empty if we don't know/support it
 	LastUpdated   time.Time
 }
 
+// UserSettings stores what the client sets in account/settings, since bluesky has nowhere to put these.
+type UserSettings struct {
+	UserDID            string `gorm:"column:user_did;primaryKey"`
+	SleepTimeEnabled   bool
+	SleepTimeStart     *int   // hour of the day, 0-23, in the user's time zone
+	SleepTimeEnd       *int   // hour of the day, 0-23, in the user's time zone
+	TimeZone           string `gorm:"type:string"` // Rails time zone name, ex. "Pacific Time (US & Canada)"
+	Language           string `gorm:"type:string"`
+	TrendLocationWoeid int
+	SplitLongTweets    *bool // nil means use SPLIT_LONG_TWEETS
+	LastUpdated        time.Time
+}
+
 var (
 	db  *gorm.DB
 	cfg config.Config
+	log = logging.For("db")
 )
 
 func InitDB(_cfg config.Config) {
+	OpenDB(_cfg)
+	StartPeriodicAnalyticsWriter(time.Minute)
+	StartPeriodicMaintenance(time.Duration(cfg.MaintenanceIntervalHours) * time.Hour)
+}
+
+// OpenDB connects to the database and migrates it, without starting any of the background stuff the server needs.
+// This is what the admin commands use.
+func OpenDB(_cfg config.Config) {
 	cfg = _cfg
 	// Ensure the directory exists
 	if cfg.DatabaseType == "sqlite" {
@@ -140,17 +187,11 @@ func InitDB(_cfg config.Config) {
 		panic("failed to connect database")
 	}
 
-	// Auto-migrate the schema
-	db.AutoMigrate(&Token{})
-	db.AutoMigrate(&MessageContext{})
-	db.AutoMigrate(&TwitterIDs{})
-	db.AutoMigrate(&AnalyticData{})
-	db.AutoMigrate(&ShortLink{})
-	db.AutoMigrate(&NotificationTokens{})
-
-	db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_tokens_user_did_token_uuid ON tokens(user_did, token_uuid)`) // annoying!
-
-	StartPeriodicAnalyticsWriter(time.Minute)
+	// Bring the schema up to date, see migrations.go
+	if err := runMigrations(db); err != nil {
+		log.Error("Error migrating database", "error", err)
+		panic("failed to migrate database")
+	}
 }
 
 // StoreToken stores an encrypted access token and refresh token in the database.
@@ -263,7 +304,8 @@ func UpdateTokenBasic(did string, pds string, accessToken string, refreshToken s
 		TokenUUID:             uuid,
 	}
 
-	if err := db.Create(&token).Error; err != nil {
+	// the UUID stays the same when refreshing, so this replaces the old tokens
+	if err := db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&token).Error; err != nil {
 		return nil, err
 	}
 
@@ -394,21 +436,23 @@ func StoreTwitterIdInDatabase(twitterID *int64, blueskyId string, dateCreated *t
 		ReposterDid: reposterDid,
 	}
 
-	result := db.Clauses(clause.OnConflict{
+	return StoreTwitterIdsInDatabase([]TwitterIDs{storedData})
+}
+
+// Stores a batch of ID data in the database, in one go.
+// @params: ids
+// @results: error
+func StoreTwitterIdsInDatabase(ids []TwitterIDs) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	return db.Clauses(clause.OnConflict{
 		Columns: []clause.Column{
 			{Name: "twitter_id"},
 		},
 		UpdateAll: true,
-	}).Create(&storedData)
-
-	if result.Error != nil {
-		// If there's an error, try updating the existing record
-		fmt.Println("Error:", result.Error)
-		panic(result.Error)
-		//return db.Model(&TwitterIDs{}).Where("twitter_id = ?", strconv.FormatUint(twitterID, 10)).Updates(storedData).Error
-	}
-
-	return nil
+	}).CreateInBatches(ids, 100).Error
 }
 
 // Gets a twitter id from the database
@@ -427,56 +471,124 @@ func GetTwitterIDFromDatabase(twitterID *int64) (*string, *time.Time, *string, e
 	return &blueskyID.BlueskyID, blueskyID.DateCreated, blueskyID.ReposterDid, nil
 }
 
-// StoreShortLink stores a short link in the database with optimized collision handling
-func StoreShortLink(shortCode string, originalURL string) error {
-	shortLink := ShortLink{
-		ShortCode:   shortCode,
-		OriginalURL: originalURL,
+// Stores a batch of post IDs in the database, in one go.
+// An ID never moves to another post. If one is already taken by a different post (the hash part of snowflakes can
+// collide, see bridge/snowflake.go), it's left alone and what's stored for it is given back, so the caller can use another.
+// @params: ids (DateCreated nil if it can be read from the ID)
+// @results: taken, error
+func StorePostIdsInDatabase(ids []PostIDs) (taken []PostIDs, err error) {
+	if len(ids) == 0 {
+		return nil, nil
 	}
 
-	// Try direct insert first with DO NOTHING on conflict
-	result := db.Clauses(clause.OnConflict{
-		DoNothing: true,
-	}).Create(&shortLink)
-
-	if result.Error != nil {
-		return result.Error
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(ids, 100).Error; err != nil {
+		return nil, err
 	}
 
-	// If no rows were affected, either there was a shortCode collision or the URL exists
-	if result.RowsAffected == 0 {
-		// Check if URL already exists
-		var existing ShortLink
-		if err := db.Where("original_url = ?", originalURL).First(&existing).Error; err == nil {
-			return nil // URL exists, reuse existing shortcode
+	// see whose they are now, whatever was already there wasn't touched
+	for start := 0; start < len(ids); start += 100 {
+		batch := ids[start:min(start+100, len(ids))]
+		twitterIDs := make([]int64, len(batch))
+		for i, id := range batch {
+			twitterIDs[i] = id.TwitterID
+		}
+		var stored []PostIDs
+		if err := db.Where("twitter_id IN ?", twitterIDs).Find(&stored).Error; err != nil {
+			return nil, err
+		}
+		storedByID := make(map[int64]PostIDs, len(stored))
+		for _, row := range stored {
+			storedByID[row.TwitterID] = row
 		}
 
-		// Handle collision with simple numeric suffixes
-		for i := 0; i < 10; i++ {
-			newCode := fmt.Sprintf("%s%d", shortCode[:7], i)
-			shortLink.ShortCode = newCode
-			result = db.Clauses(clause.OnConflict{
-				DoNothing: true,
-			}).Create(&shortLink)
-
-			if result.Error == nil && result.RowsAffected > 0 {
-				return nil
+		ours := []int64{}
+		lastSeen := time.Time{}
+		for _, id := range batch {
+			row, ok := storedByID[id.TwitterID]
+			switch {
+			case !ok:
+				return nil, fmt.Errorf("post id %d wasn't stored", id.TwitterID)
+			case samePost(row, id):
+				ours = append(ours, id.TwitterID)
+				if id.LastSeen.After(lastSeen) {
+					lastSeen = id.LastSeen
+				}
+			default:
+				taken = append(taken, row)
+			}
+		}
+		// the ones that were already ours still get last_seen bumped, pruning goes by it
+		if len(ours) > 0 {
+			if err := db.Model(&PostIDs{}).Where("twitter_id IN ? AND last_seen < ?", ours, lastSeen).Update("last_seen", lastSeen).Error; err != nil {
+				return nil, err
 			}
 		}
+	}
+	return taken, nil
+}
 
-		// If simple attempts failed, try timestamp-based suffix
-		newCode := fmt.Sprintf("%s%x", shortCode[:7], time.Now().UnixNano()%16)
-		shortLink.ShortCode = newCode
-		result = db.Clauses(clause.OnConflict{
-			DoNothing: true,
-		}).Create(&shortLink)
+// samePost checks if two post_ids rows are for the same post (or the same person's repost of it).
+func samePost(a, b PostIDs) bool {
+	if a.BlueskyID != b.BlueskyID || (a.ReposterDid == nil) != (b.ReposterDid == nil) {
+		return false
+	}
+	return a.ReposterDid == nil || *a.ReposterDid == *b.ReposterDid
+}
 
-		if result.Error == nil && result.RowsAffected > 0 {
-			return nil
-		}
+// Gets a post id from the database
+// @params: twitterID
+// @results: the stored row, or gorm.ErrRecordNotFound
+func GetPostIdFromDatabase(twitterID int64) (*PostIDs, error) {
+	var postID PostIDs
+	if err := db.Where("twitter_id = ?", twitterID).First(&postID).Error; err != nil {
+		return nil, err
 	}
+	return &postID, nil
+}
 
-	return nil
+// Gets the stored date of the first of these post ids that has one.
+// Most post ids don't have one stored (the date is in the id), so nil, nil is normal.
+func GetStoredPostDate(twitterIDs ...int64) (*time.Time, error) {
+	var postID PostIDs
+	err := db.Where("twitter_id IN ? AND date_created IS NOT NULL", twitterIDs).First(&postID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return postID.DateCreated, nil
+}
+
+// StoreShortLink makes shortCode point to originalURL. stored is false if shortCode is already taken by a
+// different URL, so the caller can try another one.
+func StoreShortLink(shortCode string, originalURL string) (stored bool, err error) {
+	shortLink := ShortLink{
+		ShortCode:   shortCode,
+		OriginalURL: originalURL,
+		LastSeen:    time.Now(),
+	}
+
+	result := db.Clauses(clause.OnConflict{
+		DoNothing: true,
+	}).Create(&shortLink)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected > 0 {
+		return true, nil
+	}
+
+	// it's taken, hopefully by us
+	var existing ShortLink
+	if err := db.First(&existing, "short_code = ?", shortCode).Error; err != nil {
+		return false, err
+	}
+	if existing.OriginalURL != originalURL {
+		return false, nil
+	}
+	touchShortLink(shortCode)
+	return true, nil
 }
 
 // GetOriginalURL retrieves the original URL from the database using the short code
@@ -485,10 +597,26 @@ func GetOriginalURL(shortCode string) (string, error) {
 	if err := db.First(&shortLink, "short_code = ?", shortCode).Error; err != nil {
 		return "", err
 	}
+	touchShortLink(shortCode)
 
 	return shortLink.OriginalURL, nil
 }
 
+// CountShortLinkClick adds one to how many times a short link was clicked.
+func CountShortLinkClick(shortCode string) error {
+	return db.Model(&ShortLink{}).Where("short_code = ?", shortCode).Update("clicks", gorm.Expr("clicks + 1")).Error
+}
+
+// Bumps last_seen on a short link so it doesn't get pruned. Only writes once a day per link, these get hit a lot.
+func touchShortLink(shortCode string) {
+	now := time.Now()
+	if err := db.Model(&ShortLink{}).
+		Where("short_code = ? AND last_seen < ?", shortCode, now.Add(-24*time.Hour)).
+		Update("last_seen", now).Error; err != nil {
+		log.Warn("Error updating short link last seen", "short_code", shortCode, "error", err)
+	}
+}
+
 func GetAllActivePushNotifications() ([]NotificationTokens, error) {
 	var fullPushTokens []NotificationTokens
 	if err := db.Find(&fullPushTokens, "enabled_for > 1").Error; err != nil { // it's greater than 1 because we aren't implementing notifications for DMs, which is the first bit, aka 1
@@ -563,3 +691,25 @@ func DeleteeeeeeeeeeeeRegistrationForPushNotificationsWithRoutingInfo(routing_ke
 	return nil
 
 }
+
+// GetUserSettings gets the stored account settings for a user.
+// Returns nil (without an error) if the user has never changed their settings.
+func GetUserSettings(did string) (*UserSettings, error) {
+	var settings UserSettings
+	if err := db.First(&settings, "user_did = ?", did).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// SaveUserSettings creates or replaces a user's account settings.
+func SaveUserSettings(settings UserSettings) error {
+	settings.LastUpdated = time.Now()
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_did"}},
+		UpdateAll: true,
+	}).Create(&settings).Error
+}
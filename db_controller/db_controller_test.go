@@ -0,0 +1,51 @@
+package db_controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Preloading/TwitterAPIBridge/config"
+)
+
+// An ID that's already another post's is never moved to a different one.
+func TestStorePostIDsTaken(t *testing.T) {
+	useTestDB(t, config.Config{})
+	reposter := "did:plc:reposter"
+	clamped := time.Date(2005, 6, 7, 8, 9, 10, 0, time.UTC)
+	longAgo := time.Now().Add(-30 * 24 * time.Hour)
+	first := []PostIDs{
+		{TwitterID: 1, BlueskyID: "at://a", DateCreated: &clamped, LastSeen: longAgo},
+		{TwitterID: 3, BlueskyID: "at://a", ReposterDid: &reposter, LastSeen: longAgo},
+	}
+	if taken, err := StorePostIdsInDatabase(first); err != nil || len(taken) != 0 {
+		t.Fatalf("got %v, %v", taken, err)
+	}
+
+	taken, err := StorePostIdsInDatabase([]PostIDs{
+		{TwitterID: 1, BlueskyID: "at://b", LastSeen: time.Now()},
+		{TwitterID: 2, BlueskyID: "at://c", LastSeen: time.Now()},
+		{TwitterID: 3, BlueskyID: "at://a", LastSeen: time.Now()}, // the post, not the repost
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(taken) != 2 || taken[0].TwitterID != 1 || taken[0].BlueskyID != "at://a" || taken[1].TwitterID != 3 || taken[1].ReposterDid == nil {
+		t.Errorf("taken is %+v, want what's stored for 1 and 3", taken)
+	}
+	for id, want := range map[int64]string{1: "at://a", 2: "at://c", 3: "at://a"} {
+		if row, err := GetPostIdFromDatabase(id); err != nil || row.BlueskyID != want {
+			t.Errorf("%d is %+v (%v), want %s", id, row, err, want)
+		}
+	}
+	if row, _ := GetPostIdFromDatabase(1); row.DateCreated == nil || !row.DateCreated.Equal(clamped) || row.LastSeen.After(longAgo.Add(time.Minute)) {
+		t.Errorf("the other post's row changed: %+v", row)
+	}
+
+	// writing our own again is fine, and counts as seeing it
+	if taken, err := StorePostIdsInDatabase([]PostIDs{{TwitterID: 1, BlueskyID: "at://a", LastSeen: time.Now()}}); err != nil || len(taken) != 0 {
+		t.Fatalf("got %v, %v", taken, err)
+	}
+	if row, _ := GetPostIdFromDatabase(1); time.Since(row.LastSeen) > time.Minute {
+		t.Errorf("last_seen is still %s", row.LastSeen)
+	}
+}
@@ -0,0 +1,182 @@
+package db_controller
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Preloading/TwitterAPIBridge/metrics"
+)
+
+// Maintenance keeps the tables that grow with every request (twitter_ids, post_ids, short_links, analytic_data)
+// from growing forever. Retention is set per table in the config, and goes by when something was last used.
+// Deletes happen in small batches with a pause between, so sqlite can still serve requests while this runs.
+
+const (
+	maintenanceBatchSize  = 500
+	maintenanceBatchPause = 50 * time.Millisecond
+)
+
+// MaintenanceReport is what happened on a maintenance run.
+type MaintenanceReport struct {
+	StartedAt time.Time
+	Duration  time.Duration
+	Deleted   map[string]int64 // rows deleted, by table
+	Errors    []string
+}
+
+var (
+	maintenanceMutex  sync.Mutex // so runs don't overlap
+	maintenanceTicker *time.Ticker
+)
+
+// StartPeriodicMaintenance runs maintenance every interval (if it's not 0).
+func StartPeriodicMaintenance(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	maintenanceTicker = time.NewTicker(interval)
+
+	go func() {
+		for range maintenanceTicker.C {
+			RunMaintenance()
+		}
+	}()
+}
+
+// RunMaintenance prunes every table that has a retention set, and logs how it went (and reports it in the metrics).
+func RunMaintenance() MaintenanceReport {
+	maintenanceMutex.Lock()
+	defer maintenanceMutex.Unlock()
+
+	report := MaintenanceReport{
+		StartedAt: time.Now(),
+		Deleted:   map[string]int64{},
+	}
+	record := func(table string, deleted int64, err error) {
+		report.Deleted[table] += deleted
+		metrics.MaintenanceRowsDeleted.Add(float64(deleted), table)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", table, err))
+			metrics.MaintenanceErrors.Inc(table)
+		}
+	}
+
+	if cutoff, ok := retentionCutoff(cfg.RetentionIDsDays); ok {
+		users, posts, err := PruneIDs(cutoff)
+		record("twitter_ids", users, err)
+		record("post_ids", posts, nil)
+	}
+	if cutoff, ok := retentionCutoff(cfg.RetentionShortLinksDays); ok {
+		deleted, err := PruneShortLinks(cutoff)
+		record("short_links", deleted, err)
+	}
+	if cutoff, ok := retentionCutoff(cfg.RetentionAnalyticsDays); ok {
+		deleted, err := PruneAnalytics(cutoff)
+		record("analytic_data", deleted, err)
+		deleted, err = PruneDailyAnalytics(cutoff)
+		record("analytics_dailies", deleted, err)
+	}
+
+	report.Duration = time.Since(report.StartedAt)
+	metrics.MaintenanceDuration.Set(report.Duration.Seconds())
+	metrics.MaintenanceLastRun.Set(float64(time.Now().Unix()))
+	log.Info("Maintenance finished", "duration", report.Duration.Round(time.Millisecond), "deleted", report.Deleted)
+	for _, err := range report.Errors {
+		log.Error("Maintenance error", "error", err)
+	}
+	return report
+}
+
+func retentionCutoff(days int) (time.Time, bool) {
+	if days <= 0 {
+		return time.Time{}, false
+	}
+	return time.Now().AddDate(0, 0, -days), true
+}
+
+// PruneIDs deletes user & post ids we haven't handed out since before the cutoff.
+// IDs for people with a session or push notifications on this server are kept, along with their retweets,
+// since those are the ones they'll come back for.
+// @results: user ids deleted, post ids deleted, error
+func PruneIDs(olderThan time.Time) (int64, int64, error) {
+	users, err := pruneInBatches[string](&TwitterIDs{}, "twitter_id",
+		"last_seen < ? AND bluesky_id NOT IN (?) AND bluesky_id NOT IN (?)",
+		olderThan, db.Model(&Token{}).Select("user_did"), db.Model(&NotificationTokens{}).Select("user_did"))
+	if err != nil {
+		return users, 0, err
+	}
+
+	posts, err := pruneInBatches[int64](&PostIDs{}, "twitter_id",
+		"last_seen < ? AND (reposter_did IS NULL OR reposter_did NOT IN (?))",
+		olderThan, db.Model(&Token{}).Select("user_did"))
+	return users, posts, err
+}
+
+// PruneShortLinks deletes short links that haven't been made or followed since before the cutoff.
+func PruneShortLinks(olderThan time.Time) (int64, error) {
+	return pruneInBatches[string](&ShortLink{}, "short_code", "last_seen < ?", olderThan)
+}
+
+// PruneAnalytics deletes analytics from before the cutoff.
+// analytic_data has no primary key, so this batches by timestamp instead.
+func PruneAnalytics(olderThan time.Time) (int64, error) {
+	var total int64
+	for {
+		var batchEnd []time.Time
+		err := db.Model(&AnalyticData{}).
+			Where("timestamp < ?", olderThan).
+			Order("timestamp").
+			Offset(maintenanceBatchSize-1).
+			Limit(1).
+			Pluck("timestamp", &batchEnd).Error
+		if err != nil {
+			return total, err
+		}
+
+		// less than a batch left, finish it off
+		if len(batchEnd) == 0 {
+			result := db.Where("timestamp < ?", olderThan).Delete(&AnalyticData{})
+			return total + result.RowsAffected, result.Error
+		}
+
+		result := db.Where("timestamp <= ?", batchEnd[0]).Delete(&AnalyticData{})
+		total += result.RowsAffected
+		if result.Error != nil {
+			return total, result.Error
+		}
+		time.Sleep(maintenanceBatchPause)
+	}
+}
+
+// PruneDailyAnalytics deletes daily counters from before the cutoff. There's only a few hundred rows a day, so no batching.
+func PruneDailyAnalytics(olderThan time.Time) (int64, error) {
+	result := db.Where("day < ?", olderThan.UTC().Format("2006-01-02")).Delete(&AnalyticsDaily{})
+	return result.RowsAffected, result.Error
+}
+
+// pruneInBatches deletes rows matching a condition, a batch of primary keys at a time.
+func pruneInBatches[K any](model interface{}, primaryKey string, query string, args ...interface{}) (int64, error) {
+	var total int64
+	for {
+		var keys []K
+		if err := db.Model(model).Where(query, args...).Limit(maintenanceBatchSize).Pluck(primaryKey, &keys).Error; err != nil {
+			return total, err
+		}
+		if len(keys) == 0 {
+			return total, nil
+		}
+
+		result := db.Where(primaryKey+" IN ?", keys).Delete(model)
+		total += result.RowsAffected
+		if result.Error != nil {
+			return total, result.Error
+		}
+
+		if len(keys) < maintenanceBatchSize {
+			return total, nil
+		}
+		time.Sleep(maintenanceBatchPause)
+	}
+}
@@ -0,0 +1,95 @@
+package db_controller
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Preloading/TwitterAPIBridge/config"
+	"github.com/Preloading/TwitterAPIBridge/metrics"
+)
+
+// metricValue is what the metrics page says for a sample, ex. `twitterbridge_maintenance_rows_deleted_total{table="post_ids"}`.
+func metricValue(t *testing.T, sample string) float64 {
+	t.Helper()
+	var page bytes.Buffer
+	metrics.WritePrometheus(&page)
+	for _, line := range strings.Split(page.String(), "\n") {
+		if value, ok := strings.CutPrefix(line, sample+" "); ok {
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return f
+		}
+	}
+	return 0
+}
+
+// Old IDs go, in more than one batch, except the ones people on this server will come back for.
+func TestPruneIDs(t *testing.T) {
+	useTestDB(t, config.Config{RetentionIDsDays: 30})
+	old := time.Now().AddDate(0, 0, -60)
+	recent := time.Now().AddDate(0, 0, -1)
+	member, pushOnly, stranger := "did:plc:member", "did:plc:push", "did:plc:stranger"
+
+	if err := db.Create(&Token{UserDid: member, TokenUUID: "session"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&NotificationTokens{UserDID: pushOnly}).Error; err != nil {
+		t.Fatal(err)
+	}
+	users := []TwitterIDs{
+		{TwitterID: "1", BlueskyID: member, LastSeen: old},
+		{TwitterID: "2", BlueskyID: pushOnly, LastSeen: old},
+		{TwitterID: "3", BlueskyID: stranger, LastSeen: old},
+		{TwitterID: "4", BlueskyID: "did:plc:recent", LastSeen: recent},
+	}
+	if err := db.Create(&users).Error; err != nil {
+		t.Fatal(err)
+	}
+	// enough old posts for a few batches
+	oldPosts := maintenanceBatchSize*2 + 10
+	posts := []PostIDs{}
+	for i := range oldPosts {
+		posts = append(posts, PostIDs{TwitterID: int64(100 + i), BlueskyID: fmt.Sprintf("at://%s/app.bsky.feed.post/%d", stranger, i), LastSeen: old})
+	}
+	posts = append(posts,
+		PostIDs{TwitterID: 10, BlueskyID: "at://" + stranger + "/app.bsky.feed.post/rt", ReposterDid: &member, LastSeen: old},
+		PostIDs{TwitterID: 11, BlueskyID: "at://" + stranger + "/app.bsky.feed.post/rt", ReposterDid: &stranger, LastSeen: old},
+		PostIDs{TwitterID: 12, BlueskyID: "at://" + stranger + "/app.bsky.feed.post/new", LastSeen: recent},
+	)
+	if err := db.CreateInBatches(&posts, 100).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	deletedBefore := metricValue(t, `twitterbridge_maintenance_rows_deleted_total{table="post_ids"}`)
+	report := RunMaintenance()
+	if len(report.Errors) != 0 {
+		t.Fatal(report.Errors)
+	}
+	if report.Deleted["twitter_ids"] != 1 || report.Deleted["post_ids"] != int64(oldPosts)+1 {
+		t.Errorf("deleted %v", report.Deleted)
+	}
+
+	var userIDs []string
+	db.Model(&TwitterIDs{}).Order("twitter_id").Pluck("twitter_id", &userIDs)
+	if fmt.Sprint(userIDs) != "[1 2 4]" {
+		t.Errorf("twitter_ids left are %v, want the member, the push registration and the recent one", userIDs)
+	}
+	var postIDs []int64
+	db.Model(&PostIDs{}).Order("twitter_id").Pluck("twitter_id", &postIDs)
+	if fmt.Sprint(postIDs) != "[10 12]" {
+		t.Errorf("post_ids left are %v, want the member's retweet and the recent one", postIDs)
+	}
+
+	if got := metricValue(t, `twitterbridge_maintenance_rows_deleted_total{table="post_ids"}`) - deletedBefore; got != float64(oldPosts)+1 {
+		t.Errorf("the metric went up by %v", got)
+	}
+	if metricValue(t, "twitterbridge_maintenance_last_run_timestamp_seconds") < float64(report.StartedAt.Unix()) {
+		t.Error("the last run time wasn't set")
+	}
+}
@@ -0,0 +1,380 @@
+package db_controller
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Every change to the schema gets a migration here, with the next version number. Never edit or reorder one that
+// has already shipped, people's databases have already run it, add a new one instead.
+// Each migration runs in a transaction (mysql commits DDL on its own, so write them so they can be rerun),
+// and the version gets recorded in schema_migrations once it's done.
+//
+// Stick to gorm's Migrator where you can, it knows the differences between sqlite, mysql and postgres.
+// If you really need raw SQL, check tx.Dialector.Name().
+// Give the Migrator a copy of the table as that migration sees it (see below), never the models. The models keep
+// changing, and what a migration does can't.
+
+type SchemaMigration struct {
+	Version   int    `gorm:"primaryKey;autoIncrement:false"`
+	Name      string `gorm:"type:string;not null"`
+	AppliedAt time.Time
+}
+
+type migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+}
+
+var migrations = []migration{
+	{
+		// Before migrations existed we just ran AutoMigrate every startup, so this is the baseline for both old & new installs.
+		// It's the schema as it was then (see baseline* below), the models have moved on since.
+		Version: 1,
+		Name:    "baseline schema",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(
+				&baselineToken{},
+				&baselineMessageContext{},
+				&baselineTwitterIDs{},
+				&baselineAnalyticData{},
+				&baselineShortLink{},
+				&baselineNotificationTokens{},
+				&baselineUserSettings{},
+				&baselinePostIDs{},
+			)
+		},
+	},
+	{
+		// Used to be a raw CREATE UNIQUE INDEX IF NOT EXISTS, which mysql doesn't understand
+		Version: 2,
+		Name:    "unique index on tokens user_did/token_uuid",
+		Up: func(tx *gorm.DB) error {
+			return createIndexIfMissing(tx, &tokenV2{}, "idx_tokens_user_did_token_uuid")
+		},
+	},
+	{
+		Version: 3,
+		Name:    "move legacy post ids into post_ids",
+		Up:      migrateLegacyPostIDs,
+	},
+	{
+		Version: 4,
+		Name:    "reverse lookup indexes for twitter_ids and post_ids",
+		Up: func(tx *gorm.DB) error {
+			for _, field := range []string{"BlueskyID", "ReposterDid", "DateCreated"} {
+				if err := createIndexIfMissing(tx, &twitterIDsV4{}, field); err != nil {
+					return err
+				}
+			}
+			for _, field := range []string{"BlueskyID", "ReposterDid"} {
+				if err := createIndexIfMissing(tx, &postIDsV4{}, field); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		// Existing rows count as seen now, so the first prune doesn't delete everything
+		Version: 5,
+		Name:    "last_seen on twitter_ids, post_ids and short_links",
+		Up: func(tx *gorm.DB) error {
+			now := time.Now()
+			for _, model := range []interface{}{&twitterIDsV5{}, &postIDsV5{}, &shortLinkV5{}} {
+				if !tx.Migrator().HasColumn(model, "LastSeen") {
+					if err := tx.Migrator().AddColumn(model, "LastSeen"); err != nil {
+						return err
+					}
+				}
+				if err := tx.Model(model).Where("last_seen IS NULL OR last_seen < ?", time.Unix(0, 0)).Update("last_seen", now).Error; err != nil {
+					return err
+				}
+				if err := createIndexIfMissing(tx, model, "LastSeen"); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 6,
+		Name:    "daily analytics counters",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&analyticsDailyV6{})
+		},
+	},
+	{
+		Version: 7,
+		Name:    "split_long_tweets on user_settings",
+		Up: func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&userSettingsV7{}, "SplitLongTweets") {
+				return nil
+			}
+			return tx.Migrator().AddColumn(&userSettingsV7{}, "SplitLongTweets")
+		},
+	},
+	{
+		Version: 8,
+		Name:    "clicks on short_links",
+		Up: func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&shortLinkV8{}, "Clicks") {
+				return nil
+			}
+			return tx.Migrator().AddColumn(&shortLinkV8{}, "Clicks")
+		},
+	},
+}
+
+// The tables as migrations see them. These are copies, not the models, so changing a model can't change what an
+// old migration does. Once a migration has shipped its copies don't change either.
+// Later migrations only need the columns they touch (and the primary key).
+
+type baselineToken struct {
+	BasicAuthHash         string  `gorm:"type:string;"`
+	BasicAuthUsername     string  `gorm:"type:string;index"`
+	UserDid               string  `gorm:"type:string;index;not null"`
+	UserPDS               string  `gorm:"type:string;not null"`
+	TokenUUID             string  `gorm:"type:string;primaryKey"`
+	EncryptedAccessToken  string  `gorm:"type:string;not null"`
+	EncryptedRefreshToken string  `gorm:"type:string;not null"`
+	AccessExpiry          float64 `gorm:"type:float;not null"`
+	RefreshExpiry         float64 `gorm:"type:float;not null"`
+	BasicAuthSalt         string  `gorm:"type:string"`
+	TokenVersion          int     `gorm:"type:int;default:1"`
+}
+
+func (baselineToken) TableName() string { return "tokens" }
+
+type baselineTwitterIDs struct {
+	BlueskyID   string     `gorm:"type:string;not null"`
+	TwitterID   string     `gorm:"type:string;primaryKey;not null"`
+	ReposterDid *string    `gorm:"type:string"`
+	DateCreated *time.Time `gorm:"type:timestamp"`
+}
+
+func (baselineTwitterIDs) TableName() string { return "twitter_ids" }
+
+type baselinePostIDs struct {
+	TwitterID   int64      `gorm:"primaryKey;autoIncrement:false"`
+	BlueskyID   string     `gorm:"type:string;not null"`
+	ReposterDid *string    `gorm:"type:string"`
+	DateCreated *time.Time `gorm:"type:timestamp"`
+}
+
+func (baselinePostIDs) TableName() string { return "post_ids" }
+
+type baselineMessageContext struct {
+	UserDid         string `gorm:"type:string;primaryKey;not null"`
+	TokenUUID       string `gorm:"type:string;primaryKey;not null"`
+	LastMessageId   string `gorm:"type:string;not null"`
+	TimelineContext string `gorm:"type:string;not null"`
+}
+
+func (baselineMessageContext) TableName() string { return "message_contexts" }
+
+type baselineAnalyticData struct {
+	DataType             string    `gorm:"type:string;not null"`
+	IPAddress            string    `gorm:"type:string;"`
+	Language             string    `gorm:"type:string;"`
+	UserAgent            string    `gorm:"type:string;"`
+	TwitterClient        string    `gorm:"type:string"`
+	TwitterClientVersion string    `gorm:"type:string"`
+	Timestamp            time.Time `gorm:"type:timestamp"`
+}
+
+func (baselineAnalyticData) TableName() string { return "analytic_data" }
+
+type baselineShortLink struct {
+	ShortCode   string `gorm:"type:string;primaryKey;not null"`
+	OriginalURL string `gorm:"type:string;not null"`
+}
+
+func (baselineShortLink) TableName() string { return "short_links" }
+
+type baselineNotificationTokens struct {
+	DeviceToken   []byte
+	RoutingKey    []byte
+	ServerAddress string
+	UserDID       string `gorm:"column:user_did;primaryKey"`
+	EnabledFor    int
+	Language      string
+	LastUpdated   time.Time
+}
+
+func (baselineNotificationTokens) TableName() string { return "notification_tokens" }
+
+type baselineUserSettings struct {
+	UserDID            string `gorm:"column:user_did;primaryKey"`
+	SleepTimeEnabled   bool
+	SleepTimeStart     *int
+	SleepTimeEnd       *int
+	TimeZone           string `gorm:"type:string"`
+	Language           string `gorm:"type:string"`
+	TrendLocationWoeid int
+	LastUpdated        time.Time
+}
+
+func (baselineUserSettings) TableName() string { return "user_settings" }
+
+type analyticsDailyV6 struct {
+	Day                  string `gorm:"type:string;primaryKey"`
+	DataType             string `gorm:"type:string;primaryKey"`
+	TwitterClient        string `gorm:"type:string;primaryKey"`
+	TwitterClientVersion string `gorm:"type:string;primaryKey"`
+	Language             string `gorm:"type:string;primaryKey"`
+	Count                int64
+}
+
+func (analyticsDailyV6) TableName() string { return "analytics_dailies" }
+
+type tokenV2 struct {
+	UserDid   string `gorm:"type:string;uniqueIndex:idx_tokens_user_did_token_uuid,priority:1"`
+	TokenUUID string `gorm:"type:string;primaryKey;uniqueIndex:idx_tokens_user_did_token_uuid,priority:2"`
+}
+
+func (tokenV2) TableName() string { return "tokens" }
+
+type twitterIDsV4 struct {
+	TwitterID   string     `gorm:"type:string;primaryKey"`
+	BlueskyID   string     `gorm:"type:string;index"`
+	ReposterDid *string    `gorm:"type:string;index"`
+	DateCreated *time.Time `gorm:"type:timestamp;index"`
+}
+
+func (twitterIDsV4) TableName() string { return "twitter_ids" }
+
+type postIDsV4 struct {
+	TwitterID   int64   `gorm:"primaryKey;autoIncrement:false"`
+	BlueskyID   string  `gorm:"type:string;index"`
+	ReposterDid *string `gorm:"type:string;index"`
+}
+
+func (postIDsV4) TableName() string { return "post_ids" }
+
+type twitterIDsV5 struct {
+	TwitterID string    `gorm:"type:string;primaryKey"`
+	LastSeen  time.Time `gorm:"index"`
+}
+
+func (twitterIDsV5) TableName() string { return "twitter_ids" }
+
+type postIDsV5 struct {
+	TwitterID int64     `gorm:"primaryKey;autoIncrement:false"`
+	LastSeen  time.Time `gorm:"index"`
+}
+
+func (postIDsV5) TableName() string { return "post_ids" }
+
+type shortLinkV5 struct {
+	ShortCode string    `gorm:"type:string;primaryKey"`
+	LastSeen  time.Time `gorm:"index"`
+}
+
+func (shortLinkV5) TableName() string { return "short_links" }
+
+type userSettingsV7 struct {
+	UserDID         string `gorm:"column:user_did;primaryKey"`
+	SplitLongTweets *bool
+}
+
+func (userSettingsV7) TableName() string { return "user_settings" }
+
+type shortLinkV8 struct {
+	ShortCode string `gorm:"type:string;primaryKey"`
+	Clicks    int64  `gorm:"not null;default:0"`
+}
+
+func (shortLinkV8) TableName() string { return "short_links" }
+
+// runMigrations runs every migration that hasn't been run yet, in order.
+func runMigrations(db *gorm.DB) error {
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	var applied []SchemaMigration
+	if err := db.Find(&applied).Error; err != nil {
+		return fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	appliedVersions := make(map[int]bool, len(applied))
+	for _, m := range applied {
+		appliedVersions[m.Version] = true
+	}
+
+	for _, m := range migrations {
+		if appliedVersions[m.Version] {
+			continue
+		}
+
+		log.Info("Running database migration", "version", m.Version, "name", m.Name)
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{
+				Version:   m.Version,
+				Name:      m.Name,
+				AppliedAt: time.Now(),
+			}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// SchemaVersion returns the newest migration that has been applied.
+func SchemaVersion() (int, error) {
+	var latest SchemaMigration
+	err := db.Order("version desc").Limit(1).Find(&latest).Error
+	return latest.Version, err
+}
+
+// createIndexIfMissing creates an index, by field name or index name, unless it's already there.
+func createIndexIfMissing(tx *gorm.DB, model interface{}, name string) error {
+	if tx.Migrator().HasIndex(model, name) {
+		return nil
+	}
+	return tx.Migrator().CreateIndex(model, name)
+}
+
+// Post IDs used to be hashes stored in twitter_ids alongside users. Posts are the only rows with a date,
+// so we move those into post_ids (keeping their date, since it can't be read from the old IDs) and
+// twitter_ids goes back to only being for users. Old IDs that clients still have keep working.
+func migrateLegacyPostIDs(tx *gorm.DB) error {
+	var legacyIDs []baselineTwitterIDs
+	result := tx.Where("date_created IS NOT NULL").FindInBatches(&legacyIDs, 500, func(batch *gorm.DB, _ int) error {
+		postIDs := make([]baselinePostIDs, 0, len(legacyIDs))
+		for _, legacyID := range legacyIDs {
+			twitterID, err := strconv.ParseInt(legacyID.TwitterID, 10, 64)
+			if err != nil {
+				continue
+			}
+			postIDs = append(postIDs, baselinePostIDs{
+				TwitterID:   twitterID,
+				BlueskyID:   legacyID.BlueskyID,
+				ReposterDid: legacyID.ReposterDid,
+				DateCreated: legacyID.DateCreated,
+			})
+		}
+		if len(postIDs) == 0 {
+			return nil
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&postIDs).Error
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		log.Info("Migrated legacy post IDs", "count", result.RowsAffected)
+	}
+	return tx.Where("date_created IS NOT NULL").Delete(&baselineTwitterIDs{}).Error
+}
@@ -0,0 +1,252 @@
+package db_controller
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Preloading/TwitterAPIBridge/config"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// The models the server uses, migrations have to end up with all of their tables, columns and indexes.
+var liveModels = []interface{}{
+	&Token{},
+	&MessageContext{},
+	&TwitterIDs{},
+	&PostIDs{},
+	&AnalyticData{},
+	&AnalyticsDaily{},
+	&ShortLink{},
+	&NotificationTokens{},
+	&UserSettings{},
+}
+
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	testDB, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testDB
+}
+
+// useTestDB points the package at a new, migrated database (and cfg at c) until the test is done.
+func useTestDB(t *testing.T, c config.Config) {
+	t.Helper()
+	testDB := openTestDB(t)
+	if err := runMigrations(testDB); err != nil {
+		t.Fatal(err)
+	}
+	oldDB, oldCfg := db, cfg
+	db, cfg = testDB, c
+	t.Cleanup(func() { db, cfg = oldDB, oldCfg })
+}
+
+// checkSchema fails the test for anything in the models that isn't in testDB.
+func checkSchema(t *testing.T, testDB *gorm.DB) {
+	t.Helper()
+	for _, model := range liveModels {
+		stmt := &gorm.Statement{DB: testDB}
+		if err := stmt.Parse(model); err != nil {
+			t.Fatal(err)
+		}
+		table := stmt.Schema.Table
+		if !testDB.Migrator().HasTable(table) {
+			t.Errorf("no %s table", table)
+			continue
+		}
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName != "" && !testDB.Migrator().HasColumn(model, field.DBName) {
+				t.Errorf("no %s.%s column", table, field.DBName)
+			}
+		}
+		for _, index := range stmt.Schema.ParseIndexes() {
+			if !testDB.Migrator().HasIndex(model, index.Name) {
+				t.Errorf("no %s index on %s", index.Name, table)
+			}
+		}
+	}
+}
+
+func TestMigrateUp(t *testing.T) {
+	testDB := openTestDB(t)
+	if err := runMigrations(testDB); err != nil {
+		t.Fatal(err)
+	}
+	checkSchema(t, testDB)
+
+	var applied []SchemaMigration
+	if err := testDB.Order("version").Find(&applied).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(migrations) {
+		t.Fatalf("%d migrations recorded, there are %d", len(applied), len(migrations))
+	}
+	for i, m := range applied {
+		if m.Version != migrations[i].Version {
+			t.Errorf("migration %d recorded as version %d", migrations[i].Version, m.Version)
+		}
+	}
+
+	// the next startup has nothing to do
+	if err := runMigrations(testDB); err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	testDB.Model(&SchemaMigration{}).Count(&count)
+	if count != int64(len(migrations)) {
+		t.Errorf("%d migrations recorded after running them again, there are %d", count, len(migrations))
+	}
+}
+
+// The tables as AutoMigrate made them in the first release. Copies, for the same reason the migrations have theirs.
+
+type originalToken struct {
+	BasicAuthHash         string  `gorm:"type:string;"`
+	BasicAuthUsername     string  `gorm:"type:string;index"`
+	UserDid               string  `gorm:"type:string;index;not null"`
+	UserPDS               string  `gorm:"type:string;not null"`
+	TokenUUID             string  `gorm:"type:string;primaryKey"`
+	EncryptedAccessToken  string  `gorm:"type:string;not null"`
+	EncryptedRefreshToken string  `gorm:"type:string;not null"`
+	AccessExpiry          float64 `gorm:"type:float;not null"`
+	RefreshExpiry         float64 `gorm:"type:float;not null"`
+	BasicAuthSalt         string  `gorm:"type:string"`
+	TokenVersion          int     `gorm:"type:int;default:1"`
+}
+
+func (originalToken) TableName() string { return "tokens" }
+
+type originalTwitterIDs struct {
+	BlueskyID   string     `gorm:"type:string;not null"`
+	TwitterID   string     `gorm:"type:string;primaryKey;not null"`
+	ReposterDid *string    `gorm:"type:string"`
+	DateCreated *time.Time `gorm:"type:timestamp"`
+}
+
+func (originalTwitterIDs) TableName() string { return "twitter_ids" }
+
+type originalMessageContext struct {
+	UserDid         string `gorm:"type:string;primaryKey;not null"`
+	TokenUUID       string `gorm:"type:string;primaryKey;not null"`
+	LastMessageId   string `gorm:"type:string;not null"`
+	TimelineContext string `gorm:"type:string;not null"`
+}
+
+func (originalMessageContext) TableName() string { return "message_contexts" }
+
+type originalAnalyticData struct {
+	DataType             string    `gorm:"type:string;not null"`
+	IPAddress            string    `gorm:"type:string;"`
+	Language             string    `gorm:"type:string;"`
+	UserAgent            string    `gorm:"type:string;"`
+	TwitterClient        string    `gorm:"type:string"`
+	TwitterClientVersion string    `gorm:"type:string"`
+	Timestamp            time.Time `gorm:"type:timestamp"`
+}
+
+func (originalAnalyticData) TableName() string { return "analytic_data" }
+
+type originalShortLink struct {
+	ShortCode   string `gorm:"type:string;primaryKey;not null"`
+	OriginalURL string `gorm:"type:string;not null"`
+}
+
+func (originalShortLink) TableName() string { return "short_links" }
+
+type originalNotificationTokens struct {
+	DeviceToken   []byte
+	RoutingKey    []byte
+	ServerAddress string
+	UserDID       string `gorm:"column:user_did;primaryKey"`
+	EnabledFor    int
+	LastUpdated   time.Time
+}
+
+func (originalNotificationTokens) TableName() string { return "notification_tokens" }
+
+// A database from before migrations, made by AutoMigrate on every startup. Both the first release's, and the last one
+// before migrations (which had user_settings, post_ids and notification_tokens.language, see baseline*).
+func TestMigrateUpFromAutoMigrate(t *testing.T) {
+	schemas := map[string][]interface{}{
+		"first release": {&originalToken{}, &originalMessageContext{}, &originalTwitterIDs{}, &originalAnalyticData{},
+			&originalShortLink{}, &originalNotificationTokens{}},
+		"before migrations": {&baselineToken{}, &baselineMessageContext{}, &baselineTwitterIDs{}, &baselineAnalyticData{},
+			&baselineShortLink{}, &baselineNotificationTokens{}, &baselineUserSettings{}, &baselinePostIDs{}},
+	}
+	for name, models := range schemas {
+		t.Run(name, func(t *testing.T) {
+			testMigrateUpFrom(t, models)
+		})
+	}
+}
+
+func testMigrateUpFrom(t *testing.T, models []interface{}) {
+	testDB := openTestDB(t)
+	if err := testDB.AutoMigrate(models...); err != nil {
+		t.Fatal(err)
+	}
+	if err := testDB.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_tokens_user_did_token_uuid ON tokens(user_did, token_uuid)`).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	postDate := time.Date(2024, 11, 1, 12, 0, 0, 0, time.UTC)
+	legacyPostID := int64(1234567890123)
+	rows := []originalTwitterIDs{
+		{BlueskyID: "did:plc:alice", TwitterID: "42"},
+		{BlueskyID: "at://did:plc:alice/app.bsky.feed.post/3kalice001", TwitterID: strconv.FormatInt(legacyPostID, 10), DateCreated: &postDate},
+	}
+	if err := testDB.Create(&rows).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := testDB.Create(&originalShortLink{ShortCode: "abc", OriginalURL: "https://example.com"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := testDB.Create(&originalNotificationTokens{UserDID: "did:plc:alice", DeviceToken: []byte{1, 2, 3}, EnabledFor: 7}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := runMigrations(testDB); err != nil {
+		t.Fatal(err)
+	}
+	checkSchema(t, testDB)
+
+	// the post moves to post_ids, the user stays
+	var users []TwitterIDs
+	testDB.Find(&users)
+	if len(users) != 1 || users[0].TwitterID != "42" {
+		t.Fatalf("twitter_ids has %+v", users)
+	}
+	if users[0].LastSeen.IsZero() {
+		t.Error("existing twitter_ids rows don't have last_seen set")
+	}
+	var post PostIDs
+	if err := testDB.First(&post, legacyPostID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if post.BlueskyID != rows[1].BlueskyID || post.DateCreated == nil || !post.DateCreated.Equal(postDate) {
+		t.Errorf("post_ids has %+v", post)
+	}
+
+	var link ShortLink
+	if err := testDB.First(&link, "short_code = ?", "abc").Error; err != nil {
+		t.Fatal(err)
+	}
+	if link.OriginalURL != "https://example.com" || link.LastSeen.IsZero() || link.Clicks != 0 {
+		t.Errorf("short_links has %+v", link)
+	}
+
+	var notifications NotificationTokens
+	if err := testDB.First(&notifications, "user_did = ?", "did:plc:alice").Error; err != nil {
+		t.Fatal(err)
+	}
+	if notifications.EnabledFor != 7 || len(notifications.DeviceToken) != 3 {
+		t.Errorf("notification_tokens has %+v", notifications)
+	}
+}
@@ -0,0 +1,318 @@
+package diskcache
+
+import (
+	"bufio"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A cache of things that take a while to make (ex. resized images), kept on disk so it survives restarts.
+// Files are named by the hash of their key, and it keeps under a size limit by throwing out whatever was used
+// longest ago. If a few requests want the same thing at once, it's only made once, and they all get it.
+//
+// With no directory, nothing is kept, but requests for the same thing still get made once.
+
+// Entry is something in the cache.
+type Entry struct {
+	Data        []byte
+	ContentType string
+	ETag        string    // hash of Data, quoted, ready for the ETag header
+	ModTime     time.Time // when it was made
+}
+
+type fileInfo struct {
+	name string // hash of the key
+	size int64
+}
+
+type call struct {
+	done  chan struct{}
+	entry *Entry
+	err   error
+}
+
+type Cache struct {
+	dir      string
+	maxBytes int64
+
+	mutex    sync.Mutex
+	files    map[string]*list.Element // least recently used at the back
+	order    *list.List
+	size     int64
+	inflight map[string]*call
+}
+
+// Open opens (or makes) a cache in dir, holding up to maxBytes. dir can be "" to not keep anything.
+func Open(dir string, maxBytes int64) (*Cache, error) {
+	c := &Cache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		files:    make(map[string]*list.Element),
+		order:    list.New(),
+		inflight: make(map[string]*call),
+	}
+	if dir == "" {
+		return c, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	// what's already there, oldest first so the newest end up at the front
+	type found struct {
+		fileInfo
+		modTime time.Time
+	}
+	existing := []found{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		// only what we wrote, in the folder we wrote it in. Anything else in there isn't ours to evict (or read)
+		folder := filepath.Base(filepath.Dir(path))
+		if filepath.Dir(filepath.Dir(path)) != filepath.Clean(dir) {
+			return nil
+		}
+		name, isTmp := strings.CutSuffix(d.Name(), ".tmp")
+		if isTmp {
+			name, _, _ = strings.Cut(name, "-")
+		}
+		if !isEntryName(name) || name[:2] != folder {
+			return nil
+		}
+		if isTmp { // left over from a crash
+			os.Remove(path)
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		existing = append(existing, found{fileInfo{name: d.Name(), size: info.Size()}, info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(existing, func(i, j int) bool { return existing[i].modTime.Before(existing[j].modTime) })
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, file := range existing {
+		c.addLocked(file.fileInfo)
+	}
+	c.evictLocked()
+	return c, nil
+}
+
+func hashKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// isEntryName is whether name could be from hashKey.
+func isEntryName(name string) bool {
+	if len(name) != sha256.Size*2 {
+		return false
+	}
+	for _, char := range name {
+		if !(char >= '0' && char <= '9' || char >= 'a' && char <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// path is where a file goes, in a folder by the first two characters so no folder gets too big.
+func (c *Cache) path(name string) string {
+	return filepath.Join(c.dir, name[:2], name)
+}
+
+// Get gets key if it's in the cache, making it with fill if it's not. Only one fill runs at a time for a key,
+// everyone else asking for it waits for that one. Errors aren't cached.
+func (c *Cache) Get(key string, fill func() (data []byte, contentType string, err error)) (*Entry, error) {
+	name := hashKey(key)
+
+	if entry := c.read(name); entry != nil {
+		return entry, nil
+	}
+
+	c.mutex.Lock()
+	if inflight, ok := c.inflight[name]; ok {
+		c.mutex.Unlock()
+		<-inflight.done
+		return inflight.entry, inflight.err
+	}
+	current := &call{done: make(chan struct{})}
+	c.inflight[name] = current
+	c.mutex.Unlock()
+
+	defer func() {
+		c.mutex.Lock()
+		delete(c.inflight, name)
+		c.mutex.Unlock()
+		close(current.done)
+	}()
+
+	// it might've been written between us looking and getting here
+	if entry := c.read(name); entry != nil {
+		current.entry = entry
+		return entry, nil
+	}
+
+	data, contentType, err := fill()
+	if err != nil {
+		current.err = err
+		return nil, err
+	}
+	entry := &Entry{Data: data, ContentType: contentType, ETag: etag(data), ModTime: time.Now().Truncate(time.Second)}
+	if err := c.write(name, entry); err != nil {
+		// still worked, it just won't be cached
+		current.entry = entry
+		return entry, fmt.Errorf("%w: %w", ErrNotStored, err)
+	}
+	current.entry = entry
+	return entry, nil
+}
+
+// ErrNotStored is when the entry was made, but couldn't be written to disk. The entry is still returned with it.
+var ErrNotStored = errors.New("couldn't store in the cache")
+
+func etag(data []byte) string {
+	hash := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(hash[:16]) + `"`
+}
+
+// read gets a file from the cache, or nil if it's not there.
+// The file is the content type on the first line, then the data.
+func (c *Cache) read(name string) *Entry {
+	if c.dir == "" {
+		return nil
+	}
+	c.mutex.Lock()
+	element, ok := c.files[name]
+	if ok {
+		c.order.MoveToFront(element)
+	}
+	c.mutex.Unlock()
+	if !ok {
+		return nil
+	}
+
+	file, err := os.Open(c.path(name))
+	if err != nil {
+		c.forget(name)
+		return nil
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil
+	}
+	reader := bufio.NewReader(file)
+	contentType, err := reader.ReadString('\n')
+	if err != nil {
+		c.forget(name)
+		return nil
+	}
+	data := make([]byte, info.Size()-int64(len(contentType)))
+	if _, err := io.ReadFull(reader, data); err != nil {
+		c.forget(name)
+		return nil
+	}
+	return &Entry{Data: data, ContentType: strings.TrimSuffix(contentType, "\n"), ETag: etag(data), ModTime: info.ModTime().Truncate(time.Second)}
+}
+
+func (c *Cache) write(name string, entry *Entry) error {
+	if c.dir == "" {
+		return nil
+	}
+	path := c.path(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	// written somewhere else then moved, so nobody reads half a file
+	tmp, err := os.CreateTemp(filepath.Dir(path), name+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.WriteString(strings.ReplaceAll(entry.ContentType, "\n", "") + "\n")
+	if err == nil {
+		_, err = tmp.Write(entry.Data)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chtimes(tmp.Name(), entry.ModTime, entry.ModTime)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.addLocked(fileInfo{name: name, size: info.Size()})
+	c.evictLocked()
+	return nil
+}
+
+func (c *Cache) forget(name string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if element, ok := c.files[name]; ok {
+		c.order.Remove(element)
+		delete(c.files, name)
+		c.size -= element.Value.(*fileInfo).size
+	}
+}
+
+// addLocked puts a file at the front. c.mutex has to be held.
+func (c *Cache) addLocked(file fileInfo) {
+	if element, ok := c.files[file.name]; ok {
+		c.size -= element.Value.(*fileInfo).size
+		element.Value = &file
+		c.order.MoveToFront(element)
+	} else {
+		c.files[file.name] = c.order.PushFront(&file)
+	}
+	c.size += file.size
+}
+
+// evictLocked deletes files that were used longest ago until we're under maxBytes. c.mutex has to be held.
+func (c *Cache) evictLocked() {
+	for c.size > c.maxBytes && c.order.Len() > 0 {
+		oldest := c.order.Back()
+		file := oldest.Value.(*fileInfo)
+		c.order.Remove(oldest)
+		delete(c.files, file.name)
+		c.size -= file.size
+		os.Remove(c.path(file.name))
+	}
+}
+
+// Size is how much is in the cache (bytes), and how many files.
+func (c *Cache) Size() (int64, int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.size, c.order.Len()
+}
@@ -0,0 +1,203 @@
+package diskcache
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func fillWith(data string) func() ([]byte, string, error) {
+	return func() ([]byte, string, error) { return []byte(data), "image/jpeg", nil }
+}
+
+func mustGet(t *testing.T, c *Cache, key string, fill func() ([]byte, string, error)) *Entry {
+	t.Helper()
+	entry, err := c.Get(key, fill)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entry
+}
+
+func noFill(t *testing.T) func() ([]byte, string, error) {
+	return func() ([]byte, string, error) {
+		t.Error("filled something that should've been cached")
+		return nil, "", errors.New("shouldn't fill")
+	}
+}
+
+func TestGetAndReopen(t *testing.T) {
+	dir := t.TempDir()
+	c, err := Open(dir, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	made := mustGet(t, c, "a", fillWith("aaaa"))
+	if string(made.Data) != "aaaa" || made.ContentType != "image/jpeg" || made.ETag == "" {
+		t.Fatalf("got %+v", made)
+	}
+	if cached := mustGet(t, c, "a", noFill(t)); string(cached.Data) != "aaaa" || cached.ETag != made.ETag {
+		t.Errorf("cached copy is %+v", cached)
+	}
+
+	// it's still there after a restart
+	c, err = Open(dir, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached := mustGet(t, c, "a", noFill(t))
+	if string(cached.Data) != "aaaa" || cached.ContentType != "image/jpeg" || cached.ETag != made.ETag || !cached.ModTime.Equal(made.ModTime) {
+		t.Errorf("after reopening got %+v, made %+v", cached, made)
+	}
+	if size, files := c.Size(); files != 1 || size != int64(len("image/jpeg\naaaa")) {
+		t.Errorf("Size is %d bytes in %d files", size, files)
+	}
+}
+
+// Stuff in the cache's folder that we didn't put there gets left alone, and doesn't break anything.
+func TestOpenIgnoresOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+	c, err := Open(dir, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustGet(t, c, "ours", fillWith("data"))
+	name := hashKey("ours")
+
+	crashedTmp := filepath.Join(dir, name[:2], name+"-123.tmp")
+	others := []string{
+		"a", // shorter than the folder name, used to panic
+		"README",
+		"notes.tmp",
+		name,                                  // one of ours, but not in its folder
+		filepath.Join(name[:2], "x"),          // in a folder like ours
+		filepath.Join("zz", hashKey("other")), // ours, in the wrong folder
+		filepath.Join("sub", "dir", "deep", name),
+		filepath.Join(name[:2], strings.ToUpper(name)),
+	}
+	for _, other := range append(others, crashedTmp) {
+		path := filepath.Join(dir, other)
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, []byte("not a cache entry"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c, err = Open(dir, 1) // small enough to evict everything it knows about
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size, files := c.Size(); files != 0 || size != 0 {
+		t.Errorf("Size is %d bytes in %d files", size, files)
+	}
+	for _, other := range others {
+		if _, err := os.Stat(filepath.Join(dir, other)); err != nil {
+			t.Errorf("%s got deleted", other)
+		}
+	}
+	if _, err := os.Stat(crashedTmp); !os.IsNotExist(err) {
+		t.Error("a temp file left over from a crash wasn't cleaned up")
+	}
+	if _, err := os.Stat(filepath.Join(dir, name[:2], name)); !os.IsNotExist(err) {
+		t.Error("our own file wasn't evicted")
+	}
+}
+
+func TestEviction(t *testing.T) {
+	dir := t.TempDir()
+	entrySize := int64(len("image/jpeg\n") + 10)
+	c, err := Open(dir, 3*entrySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		mustGet(t, c, key, fillWith(strings.Repeat(key, 10)))
+	}
+	mustGet(t, c, "a", noFill(t)) // b is used longest ago now
+	mustGet(t, c, "d", fillWith(strings.Repeat("d", 10)))
+
+	if size, files := c.Size(); files != 3 || size != 3*entrySize {
+		t.Errorf("Size is %d bytes in %d files", size, files)
+	}
+	if _, err := os.Stat(c.path(hashKey("b"))); !os.IsNotExist(err) {
+		t.Error("b wasn't deleted")
+	}
+	filled := false
+	mustGet(t, c, "b", func() ([]byte, string, error) {
+		filled = true
+		return []byte("bbbbbbbbbb"), "image/jpeg", nil
+	})
+	if !filled {
+		t.Error("b is still cached")
+	}
+
+	// reopening with less room keeps the newest, going by when they were written
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(c.path(hashKey("d")), old, old); err != nil {
+		t.Fatal(err)
+	}
+	c, err = Open(dir, 2*entrySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, files := c.Size(); files != 2 {
+		t.Errorf("%d files after reopening smaller", files)
+	}
+	if _, err := os.Stat(c.path(hashKey("d"))); !os.IsNotExist(err) {
+		t.Error("d was the oldest, it should've been evicted")
+	}
+	mustGet(t, c, "a", noFill(t))
+	mustGet(t, c, "b", noFill(t))
+}
+
+// Everyone asking for the same thing at once gets one fill's result. Run with -race.
+func TestGetFillsOnce(t *testing.T) {
+	for _, dir := range []string{t.TempDir(), ""} {
+		c, err := Open(dir, 1<<20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fills atomic.Int32
+		release := make(chan struct{})
+		var wg sync.WaitGroup
+		for range 20 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				entry, err := c.Get("slow", func() ([]byte, string, error) {
+					fills.Add(1)
+					<-release
+					return []byte("slow"), "image/png", nil
+				})
+				if err != nil || string(entry.Data) != "slow" {
+					t.Errorf("got %v, %v", entry, err)
+				}
+			}()
+		}
+		time.Sleep(50 * time.Millisecond) // let them all pile up
+		close(release)
+		wg.Wait()
+		if fills.Load() != 1 {
+			t.Errorf("dir %q: filled %d times", dir, fills.Load())
+		}
+	}
+}
+
+func TestErrorsNotCached(t *testing.T) {
+	c, err := Open(t.TempDir(), 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := errors.New("bluesky is down")
+	if _, err := c.Get("a", func() ([]byte, string, error) { return nil, "", failed }); !errors.Is(err, failed) {
+		t.Fatalf("got %v", err)
+	}
+	if entry := mustGet(t, c, "a", fillWith("ok")); string(entry.Data) != "ok" {
+		t.Errorf("got %+v after an error", entry)
+	}
+}
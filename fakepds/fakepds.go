@@ -0,0 +1,435 @@
+package fakepds
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A fake PDS, AppView & PLC directory in one, so the bridge can be run (ex. in tests) without bluesky.
+// It does the app.bsky.* and com.atproto.* methods the bridge uses, starting out with the data from some Fixtures,
+// and keeps anything written to it (posts, likes, follows...) in memory.
+// It only tries to be right enough for the bridge, don't go using it for anything else.
+
+type Server struct {
+	URL        string
+	httpServer *httptest.Server
+
+	mutex    sync.Mutex
+	accounts []*Account // in the order they were in the fixtures
+	posts    map[string]*post
+	follows  map[string]*Follow      // by URI
+	likes    map[string]*Interaction // by URI, Post is the post's URI (not rkey) once loaded
+	reposts  map[string]*Interaction
+	lists    map[string]*List
+	topics   []TrendTopic
+	blobs    int
+	nextRKey int
+
+	accessTokens  map[string]string // token -> DID
+	refreshTokens map[string]string
+	expired       map[string]bool
+	requests      []Request
+	failures      map[string]int // XRPC method -> how many more calls go through before one fails, see FailCall
+}
+
+// Request is a request the fake PDS got, see Server.Requests.
+type Request struct {
+	Method string // XRPC method, ex. app.bsky.feed.getTimeline (or the path if it wasn't XRPC)
+	HTTP   string // GET, POST...
+	DID    string // who it was authenticated as, if anyone
+	Query  map[string][]string
+	Body   []byte
+}
+
+// A post, with its record as it'd be stored in the repo.
+type post struct {
+	uri       string
+	cid       string
+	author    string
+	record    map[string]any
+	createdAt time.Time
+	parent    string // URI
+	root      string // URI
+	hasMedia  bool
+}
+
+// New starts a fake PDS with some fixtures (nil for DefaultFixtures). Close it when you're done.
+func New(fixtures *Fixtures) *Server {
+	if fixtures == nil {
+		fixtures = DefaultFixtures()
+	}
+	s := &Server{
+		posts:         make(map[string]*post),
+		follows:       make(map[string]*Follow),
+		likes:         make(map[string]*Interaction),
+		reposts:       make(map[string]*Interaction),
+		lists:         make(map[string]*List),
+		accessTokens:  make(map[string]string),
+		refreshTokens: make(map[string]string),
+		expired:       make(map[string]bool),
+		failures:      make(map[string]int),
+	}
+	s.load(fixtures)
+	s.httpServer = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.httpServer.URL
+	return s
+}
+
+func (s *Server) Close() {
+	s.httpServer.Close()
+}
+
+// Requests is every request the fake PDS has gotten so far, oldest first.
+func (s *Server) Requests() []Request {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// ExpireAccessTokens makes every access token handed out so far get ExpiredToken, like bluesky does after a couple hours.
+func (s *Server) ExpireAccessTokens() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for token := range s.accessTokens {
+		s.expired[token] = true
+	}
+}
+
+// FailCall makes one call to an XRPC method fail with a 500, after letting skip more of them through.
+// ex. FailCall("com.atproto.repo.createRecord", 1) fails the second post made from now on.
+func (s *Server) FailCall(method string, skip int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.failures[method] = skip
+}
+
+func (s *Server) load(fixtures *Fixtures) {
+	for i := range fixtures.Accounts {
+		account := fixtures.Accounts[i]
+		s.accounts = append(s.accounts, &account)
+	}
+
+	postURIs := make(map[string]string) // rkey -> URI
+	for _, fixture := range fixtures.Posts {
+		postURIs[fixture.RKey] = atURI(fixture.Author, "app.bsky.feed.post", fixture.RKey)
+	}
+	for _, fixture := range fixtures.Posts {
+		record := map[string]any{
+			"$type":     "app.bsky.feed.post",
+			"text":      fixture.Text,
+			"createdAt": fixture.CreatedAt.UTC().Format(time.RFC3339Nano),
+		}
+		if len(fixture.Langs) > 0 {
+			record["langs"] = fixture.Langs
+		}
+		if len(fixture.Facets) > 0 {
+			record["facets"] = fixture.Facets
+		}
+		if len(fixture.Embed) > 0 {
+			record["embed"] = fixture.Embed
+		}
+		if fixture.ReplyTo != "" {
+			parentURI := postURIs[fixture.ReplyTo]
+			rootURI := parentURI
+			if parent, ok := s.posts[parentURI]; ok && parent.root != "" {
+				rootURI = parent.root
+			}
+			record["reply"] = map[string]any{
+				"root":   map[string]string{"uri": rootURI, "cid": cidFor(rootURI)},
+				"parent": map[string]string{"uri": parentURI, "cid": cidFor(parentURI)},
+			}
+		}
+		s.addPost(fixture.Author, fixture.RKey, record)
+	}
+
+	for i := range fixtures.Follows {
+		follow := fixtures.Follows[i]
+		s.follows[atURI(follow.From, "app.bsky.graph.follow", follow.RKey)] = &follow
+	}
+	for i := range fixtures.Likes {
+		like := fixtures.Likes[i]
+		like.Post = postURIs[like.Post]
+		s.likes[atURI(like.By, "app.bsky.feed.like", like.RKey)] = &like
+	}
+	for i := range fixtures.Reposts {
+		repost := fixtures.Reposts[i]
+		repost.Post = postURIs[repost.Post]
+		s.reposts[atURI(repost.By, "app.bsky.feed.repost", repost.RKey)] = &repost
+	}
+	for i := range fixtures.Lists {
+		list := fixtures.Lists[i]
+		s.lists[atURI(list.Owner, "app.bsky.graph.list", list.RKey)] = &list
+	}
+	s.topics = fixtures.Topics
+}
+
+// addPost adds a post from its record. The caller should hold the lock (or be loading fixtures).
+func (s *Server) addPost(author string, rkey string, record map[string]any) *post {
+	uri := atURI(author, "app.bsky.feed.post", rkey)
+	p := &post{
+		uri:    uri,
+		cid:    cidFor(uri),
+		author: author,
+		record: record,
+	}
+	if createdAt, ok := record["createdAt"].(string); ok {
+		p.createdAt, _ = time.Parse(time.RFC3339Nano, createdAt)
+	}
+	if p.createdAt.IsZero() {
+		p.createdAt = time.Now()
+	}
+	if reply, ok := record["reply"].(map[string]any); ok {
+		p.parent = refURI(reply["parent"])
+		p.root = refURI(reply["root"])
+	}
+	if embed, ok := record["embed"]; ok {
+		var embedType struct {
+			Type string `json:"$type"`
+		}
+		encoded, _ := json.Marshal(embed)
+		json.Unmarshal(encoded, &embedType)
+		p.hasMedia = embedType.Type == "app.bsky.embed.images" || embedType.Type == "app.bsky.embed.video" || embedType.Type == "app.bsky.embed.recordWithMedia"
+	}
+	s.posts[uri] = p
+	return p
+}
+
+func refURI(ref any) string {
+	if m, ok := ref.(map[string]any); ok {
+		uri, _ := m["uri"].(string)
+		return uri
+	}
+	if m, ok := ref.(map[string]string); ok {
+		return m["uri"]
+	}
+	return ""
+}
+
+// -- HTTP --
+
+// xrpcError is an error the way XRPC sends them.
+type xrpcError struct {
+	status  int
+	name    string
+	message string
+}
+
+func errorf(status int, name string, format string, args ...any) *xrpcError {
+	return &xrpcError{status: status, name: name, message: fmt.Sprintf(format, args...)}
+}
+
+// call is a request to an XRPC method.
+type call struct {
+	r      *http.Request
+	viewer string // DID, "" if not logged in
+	token  string
+	body   []byte
+}
+
+func (c *call) param(name string) string {
+	return c.r.URL.Query().Get(name)
+}
+
+func (c *call) params(name string) []string {
+	query := c.r.URL.Query()
+	if values, ok := query[name]; ok {
+		return values
+	}
+	return query[name+"[]"]
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	// DID documents, for the PLC directory
+	if strings.HasPrefix(r.URL.Path, "/did:") {
+		s.record(Request{Method: r.URL.Path, HTTP: r.Method, Query: r.URL.Query()})
+		s.serveDIDDocument(w, strings.TrimPrefix(r.URL.Path, "/"))
+		return
+	}
+
+	method, found := strings.CutPrefix(r.URL.Path, "/xrpc/")
+	if !found {
+		s.record(Request{Method: r.URL.Path, HTTP: r.Method, Query: r.URL.Query()})
+		http.NotFound(w, r)
+		return
+	}
+
+	c := &call{r: r, body: body}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		c.token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	s.record(Request{Method: method, HTTP: r.Method, Query: r.URL.Query(), Body: body})
+
+	handler, ok := methods[method]
+	if !ok {
+		writeError(w, errorf(http.StatusNotImplemented, "MethodNotImplemented", "Method Not Implemented"))
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// refreshSession is the only one that takes the refresh token
+	if c.token != "" && method != "com.atproto.server.refreshSession" {
+		did, ok := s.accessTokens[c.token]
+		if !ok {
+			writeError(w, errorf(http.StatusBadRequest, "InvalidToken", "Token could not be verified"))
+			return
+		}
+		if s.expired[c.token] {
+			writeError(w, errorf(http.StatusBadRequest, "ExpiredToken", "Token has expired"))
+			return
+		}
+		c.viewer = did
+		s.requests[len(s.requests)-1].DID = did
+	}
+
+	if skip, ok := s.failures[method]; ok {
+		if skip == 0 {
+			delete(s.failures, method)
+			writeError(w, errorf(http.StatusInternalServerError, "InternalServerError", "Internal Server Error"))
+			return
+		}
+		s.failures[method] = skip - 1
+	}
+
+	result, xrpcErr := handler(s, c)
+	if xrpcErr != nil {
+		writeError(w, xrpcErr)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func (s *Server) record(request Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.requests = append(s.requests, request)
+}
+
+func writeError(w http.ResponseWriter, xrpcErr *xrpcError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(xrpcErr.status)
+	json.NewEncoder(w).Encode(map[string]string{"error": xrpcErr.name, "message": xrpcErr.message})
+}
+
+func (s *Server) serveDIDDocument(w http.ResponseWriter, did string) {
+	s.mutex.Lock()
+	account := s.account(did)
+	s.mutex.Unlock()
+	if account == nil {
+		http.NotFound(w, nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"@context":    []string{"https://www.w3.org/ns/did/v1"},
+		"id":          account.DID,
+		"alsoKnownAs": []string{"at://" + account.Handle},
+		"service": []map[string]string{{
+			"id":              "#atproto_pds",
+			"type":            "AtprotoPersonalDataServer",
+			"serviceEndpoint": s.URL,
+		}},
+	})
+}
+
+// -- Helpers --
+
+// account finds an account by DID or handle.
+func (s *Server) account(actor string) *Account {
+	for _, account := range s.accounts {
+		if account.DID == actor || account.Handle == actor {
+			return account
+		}
+	}
+	return nil
+}
+
+// newRKey makes a record key for something new. They sort after the fixtures' (which start with 3k).
+func (s *Server) newRKey() string {
+	s.nextRKey++
+	return fmt.Sprintf("3m%08d", s.nextRKey)
+}
+
+// sortedPosts is every post matching keep, newest first.
+func (s *Server) sortedPosts(keep func(*post) bool) []*post {
+	var posts []*post
+	for _, p := range s.posts {
+		if keep(p) {
+			posts = append(posts, p)
+		}
+	}
+	sort.Slice(posts, func(i, j int) bool {
+		if posts[i].createdAt.Equal(posts[j].createdAt) {
+			return posts[i].uri > posts[j].uri
+		}
+		return posts[i].createdAt.After(posts[j].createdAt)
+	})
+	return posts
+}
+
+func atURI(did string, collection string, rkey string) string {
+	return fmt.Sprintf("at://%s/%s/%s", did, collection, rkey)
+}
+
+// parseATURI splits at://did/collection/rkey.
+func parseATURI(uri string) (did string, collection string, rkey string) {
+	parts := strings.SplitN(strings.TrimPrefix(uri, "at://"), "/", 3)
+	for len(parts) < 3 {
+		parts = append(parts, "")
+	}
+	return parts[0], parts[1], parts[2]
+}
+
+// cidFor makes up a CID. It just has to be the same every time for the same record.
+func cidFor(uri string) string {
+	sum := sha256.Sum256([]byte(uri))
+	return "bafyrei" + hex.EncodeToString(sum[:16])
+}
+
+// makeJWT makes a token that looks enough like bluesky's for the bridge to read the expiry out of.
+func makeJWT(did string, scope string, lifetime time.Duration, nonce int) string {
+	encode := func(v any) string {
+		encoded, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(encoded)
+	}
+	header := encode(map[string]string{"alg": "HS256", "typ": "at+jwt"})
+	payload := encode(map[string]any{
+		"scope": scope,
+		"sub":   did,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(lifetime).Unix(),
+		"jti":   nonce,
+	})
+	return header + "." + payload + "." + base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("fakesig%d", nonce)))
+}
+
+func limitParam(c *call, fallback int) int {
+	limit := fallback
+	fmt.Sscanf(c.param("limit"), "%d", &limit)
+	if limit <= 0 {
+		limit = fallback
+	}
+	return limit
+}
+
+func first[T any](items []T, n int) []T {
+	if len(items) > n {
+		return items[:n]
+	}
+	return items
+}
@@ -0,0 +1,80 @@
+package fakepds
+
+import (
+	_ "embed"
+	"encoding/json"
+	"time"
+)
+
+// Fixtures is what the fake PDS starts out with. See fixtures/default.json for what they look like.
+type Fixtures struct {
+	Accounts []Account     `json:"accounts"`
+	Posts    []Post        `json:"posts"`
+	Follows  []Follow      `json:"follows"`
+	Likes    []Interaction `json:"likes"`
+	Reposts  []Interaction `json:"reposts"`
+	Lists    []List        `json:"lists"`
+	Topics   []TrendTopic  `json:"topics"`
+}
+
+type Account struct {
+	DID         string    `json:"did"`
+	Handle      string    `json:"handle"`
+	Password    string    `json:"password"`
+	DisplayName string    `json:"displayName"`
+	Description string    `json:"description"`
+	Avatar      string    `json:"avatar"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+type Post struct {
+	RKey      string          `json:"rkey"`
+	Author    string          `json:"author"` // DID
+	Text      string          `json:"text"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Langs     []string        `json:"langs,omitempty"`
+	Facets    json.RawMessage `json:"facets,omitempty"`
+	Embed     json.RawMessage `json:"embed,omitempty"`
+	// rkey of the post this replies to (rkeys are unique across all the fixtures, whoever posted them)
+	ReplyTo string `json:"replyTo,omitempty"`
+}
+
+type Follow struct {
+	RKey      string    `json:"rkey"`
+	From      string    `json:"from"` // DID
+	To        string    `json:"to"`   // DID
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Interaction is a like or repost.
+type Interaction struct {
+	RKey      string    `json:"rkey"`
+	By        string    `json:"by"`   // DID
+	Post      string    `json:"post"` // rkey of the post
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type List struct {
+	RKey        string   `json:"rkey"`
+	Owner       string   `json:"owner"` // DID
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Members     []string `json:"members"` // DIDs
+}
+
+type TrendTopic struct {
+	Topic string `json:"topic"`
+	Link  string `json:"link"`
+}
+
+//go:embed fixtures/default.json
+var defaultFixtures []byte
+
+// DefaultFixtures are a few accounts with posts, follows and a list between them.
+func DefaultFixtures() *Fixtures {
+	fixtures := &Fixtures{}
+	if err := json.Unmarshal(defaultFixtures, fixtures); err != nil {
+		panic("fakepds: default fixtures are broken: " + err.Error())
+	}
+	return fixtures
+}
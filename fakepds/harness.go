@@ -0,0 +1,189 @@
+package fakepds
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	blueskyapi "github.com/Preloading/TwitterAPIBridge/bluesky"
+	"github.com/Preloading/TwitterAPIBridge/bridge"
+	"github.com/Preloading/TwitterAPIBridge/config"
+	"github.com/Preloading/TwitterAPIBridge/db_controller"
+	"github.com/Preloading/TwitterAPIBridge/twitterv1"
+	"github.com/gofiber/fiber/v2"
+)
+
+// HarnessConfig is the config the harness runs the bridge with. It's built here instead of with config.LoadConfig, so a
+// config.yaml lying around can't change what the tests see. Everything that reaches out to the internet (link cards,
+// the CDN cache...) is off.
+func HarnessConfig(pdsURL string, dbDir string) *config.Config {
+	secretKey := "fakepds-secret-key-that-is-long-enough"
+	return &config.Config{
+		Version:                      "test",
+		CdnURL:                       "http://127.0.0.1:3000",
+		CdnCacheDir:                  "",
+		ImageJPEGQuality:             75,
+		ServerPort:                   3000,
+		LogFormat:                    "text",
+		LogLevel:                     "error",
+		TrackAnalytics:               false,
+		AnalyticsIPMode:              "truncate",
+		MetricsListenAddress:         "",
+		AppViewURL:                   pdsURL,
+		PLCDirectoryURL:              pdsURL,
+		HandleResolverURL:            pdsURL,
+		XRPCTimeoutSeconds:           20,
+		XRPCRetries:                  0,
+		RequestTimeoutSeconds:        60,
+		DatabaseType:                 "sqlite",
+		DatabasePath:                 filepath.Join(dbDir, "twitterbridge.db"),
+		MaintenanceIntervalHours:     0,
+		ImgDisplayText:               "pic.twitter.com/{shortblob}",
+		ImgURLText:                   "http://127.0.0.1:3000/img/{shortblob}",
+		VidDisplayText:               "pic.twitter.com/{shortblob}",
+		VidURLText:                   "http://127.0.0.1:3000/img/{shortblob}",
+		GifDisplayText:               "pic.twitter.com/{shortblob}",
+		GifURLText:                   "http://127.0.0.1:3000/img/{shortblob}",
+		LinkURLText:                  "",
+		ShortMentionSuffix:           ".bsky.social",
+		DuplicateStatusWindowSeconds: 300,
+		LinkCards:                    false,
+		LinkCardTimeoutSeconds:       5,
+		SecretKey:                    secretKey,
+		SecretKeyBytes:               []byte(secretKey),
+		MinTokenVersion:              1,
+		ServerIdentifier:             "fakepds",
+		NotificationFeedbackSecret:   []byte{},
+	}
+}
+
+// Harness is the whole bridge (with a throwaway sqlite DB) pointed at a fake PDS, for driving the twitter API end to end.
+// The bridge keeps its config & DB in globals, so only have one of these going at a time.
+type Harness struct {
+	PDS    *Server
+	App    *fiber.App
+	Config *config.Config
+}
+
+// NewHarness starts a fake PDS with the fixtures (nil for DefaultFixtures) and sets the bridge up to use it.
+// The database goes in dbDir, ex. a t.TempDir(). Close it when you're done.
+func NewHarness(fixtures *Fixtures, dbDir string) (*Harness, error) {
+	pds := New(fixtures)
+	configData := HarnessConfig(pds.URL, dbDir)
+
+	db_controller.OpenDB(*configData)
+	bridge.SetIDStore(bridge.DBIDStore{}) // forget IDs the last harness handed out, they were in its database
+	blueskyapi.InitConfig(configData)
+	app := twitterv1.NewApp(configData)
+
+	return &Harness{PDS: pds, App: app, Config: configData}, nil
+}
+
+// NewTestHarness is NewHarness for tests: the database goes in a temp dir, and it's closed when the test is done.
+func NewTestHarness(t testing.TB, fixtures *Fixtures) *Harness {
+	t.Helper()
+	h, err := NewHarness(fixtures, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(h.Close)
+	return h
+}
+
+func (h *Harness) Close() {
+	h.App.Shutdown()
+	h.PDS.Close()
+}
+
+// Request sends a request to the bridge. form is sent as the body for anything but GET,
+// auth is the Authorization header (see Login), or "" for none.
+func (h *Harness) Request(method string, path string, form url.Values, auth string) (*http.Response, []byte, error) {
+	var body io.Reader
+	if form != nil && method != http.MethodGet {
+		body = strings.NewReader(form.Encode())
+	} else if form != nil {
+		path += "?" + form.Encode()
+	}
+
+	req := httptest.NewRequest(method, path, body)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+
+	resp, err := h.App.Test(req, -1)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	return resp, respBody, err
+}
+
+// Login logs in through xAuth like a twitter client would, and gives back the Authorization header to use for it.
+func (h *Harness) Login(handle string, password string) (string, error) {
+	resp, body, err := h.Request(http.MethodPost, "/oauth/access_token", url.Values{
+		"x_auth_mode":     {"client_auth"},
+		"x_auth_username": {handle},
+		"x_auth_password": {password},
+	}, "")
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("login failed: %d: %s", resp.StatusCode, body)
+	}
+
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return "", err
+	}
+	token := values.Get("oauth_token")
+	if token == "" {
+		return "", fmt.Errorf("login didn't give a token: %s", body)
+	}
+	return fmt.Sprintf(`OAuth oauth_token="%s"`, token), nil
+}
+
+// MustLogin is Login, failing the test if it doesn't work.
+func (h *Harness) MustLogin(t testing.TB, handle string, password string) string {
+	t.Helper()
+	auth, err := h.Login(handle, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return auth
+}
+
+// Call sends a request, fails the test if it doesn't come back 200, and decodes the response into out (if it's not nil),
+// as XML if the path ends in .xml and JSON otherwise.
+func (h *Harness) Call(t testing.TB, method string, path string, form url.Values, auth string, out any) {
+	t.Helper()
+	resp, body, err := h.Request(method, path, form, auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%s %s: got %d: %s", method, path, resp.StatusCode, body)
+	}
+	if out == nil {
+		return
+	}
+	if strings.HasSuffix(path, ".xml") {
+		err = xml.Unmarshal(body, out)
+	} else {
+		err = json.Unmarshal(body, out)
+	}
+	if err != nil {
+		t.Fatalf("%s %s: couldn't decode %s: %v", method, path, body, err)
+	}
+}
@@ -0,0 +1,269 @@
+package fakepds_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+
+	"github.com/Preloading/TwitterAPIBridge/fakepds"
+)
+
+// The basics, through the twitter API in both formats: logging in, timelines, posting, follows and lists.
+
+type user struct {
+	ID         int64  `json:"id" xml:"id"`
+	ScreenName string `json:"screen_name" xml:"screen_name"`
+	Name       string `json:"name" xml:"name"`
+}
+
+type status struct {
+	ID   int64  `json:"id" xml:"id"`
+	Text string `json:"text" xml:"text"`
+	User user   `json:"user" xml:"user"`
+}
+
+type statuses struct {
+	Statuses []status `xml:"status"`
+}
+
+func texts(statuses []status) []string {
+	texts := []string{}
+	for _, status := range statuses {
+		texts = append(texts, status.Text)
+	}
+	return texts
+}
+
+func TestLogin(t *testing.T) {
+	h := fakepds.NewTestHarness(t, nil)
+	auth := h.MustLogin(t, "alice.test", "dev_alice-pass")
+
+	var jsonUser user
+	h.Call(t, http.MethodGet, "/1/account/verify_credentials.json", nil, auth, &jsonUser)
+	if jsonUser.ScreenName != "alice.test" || jsonUser.Name != "Alice" {
+		t.Errorf("verify_credentials.json: got %+v", jsonUser)
+	}
+	var xmlUser user
+	h.Call(t, http.MethodGet, "/1/account/verify_credentials.xml", nil, auth, &xmlUser)
+	if xmlUser != jsonUser {
+		t.Errorf("verify_credentials.xml: got %+v, json gave %+v", xmlUser, jsonUser)
+	}
+
+	resp, body, err := h.Request(http.MethodPost, "/oauth/access_token", url.Values{
+		"x_auth_mode":     {"client_auth"},
+		"x_auth_username": {"alice.test"},
+		"x_auth_password": {"dev_wrong-password"},
+	}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("wrong password: got %d: %s", resp.StatusCode, body)
+	}
+
+	resp, _, err = h.Request(http.MethodGet, "/1/account/verify_credentials.json", nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode == http.StatusOK {
+		t.Errorf("verify_credentials worked without logging in")
+	}
+}
+
+func TestTimelines(t *testing.T) {
+	h := fakepds.NewTestHarness(t, nil)
+	auth := h.MustLogin(t, "alice.test", "dev_alice-pass")
+
+	var home []status
+	h.Call(t, http.MethodGet, "/1/statuses/home_timeline.json", nil, auth, &home)
+	if len(home) == 0 {
+		t.Fatal("home_timeline.json is empty")
+	}
+	for _, text := range []string{"Hello world 👋", "🐟🐟🐟 fish #魚", "مرحبا بالعالم"} {
+		if !slices.Contains(texts(home), text) {
+			t.Errorf("home_timeline.json doesn't have %q: %q", text, texts(home))
+		}
+	}
+	var homeXML statuses
+	h.Call(t, http.MethodGet, "/1/statuses/home_timeline.xml", nil, auth, &homeXML)
+	if !slices.Equal(texts(homeXML.Statuses), texts(home)) {
+		t.Errorf("home_timeline.xml: got %q, json gave %q", texts(homeXML.Statuses), texts(home))
+	}
+
+	var bobs []status
+	h.Call(t, http.MethodGet, "/1/statuses/user_timeline.json", url.Values{"screen_name": {"bob.test"}}, auth, &bobs)
+	if len(bobs) == 0 {
+		t.Fatal("user_timeline.json is empty")
+	}
+	for _, status := range bobs {
+		if status.User.ScreenName != "bob.test" {
+			t.Errorf("user_timeline.json for bob has a post by %s: %q", status.User.ScreenName, status.Text)
+		}
+	}
+	var bobsXML statuses
+	h.Call(t, http.MethodGet, "/1/statuses/user_timeline.xml", url.Values{"screen_name": {"bob.test"}}, auth, &bobsXML)
+	if !slices.Equal(texts(bobsXML.Statuses), texts(bobs)) {
+		t.Errorf("user_timeline.xml: got %q, json gave %q", texts(bobsXML.Statuses), texts(bobs))
+	}
+}
+
+func TestPosting(t *testing.T) {
+	h := fakepds.NewTestHarness(t, nil)
+	auth := h.MustLogin(t, "alice.test", "dev_alice-pass")
+
+	var posted status
+	h.Call(t, http.MethodPost, "/1/statuses/update.json", url.Values{"status": {"posting from a test"}}, auth, &posted)
+	if posted.Text != "posting from a test" || posted.User.ScreenName != "alice.test" || posted.ID == 0 {
+		t.Errorf("update.json: got %+v", posted)
+	}
+	var postedXML status
+	h.Call(t, http.MethodPost, "/1/statuses/update.xml", url.Values{"status": {"and in xml"}}, auth, &postedXML)
+	if postedXML.Text != "and in xml" || postedXML.ID == 0 || postedXML.ID == posted.ID {
+		t.Errorf("update.xml: got %+v", postedXML)
+	}
+
+	created := []string{}
+	for _, request := range h.PDS.Requests() {
+		if request.Method != "com.atproto.repo.createRecord" {
+			continue
+		}
+		var body struct {
+			Repo   string `json:"repo"`
+			Record struct {
+				Text string `json:"text"`
+			} `json:"record"`
+		}
+		if err := json.Unmarshal(request.Body, &body); err != nil {
+			t.Fatal(err)
+		}
+		if body.Repo != "did:plc:alice" {
+			t.Errorf("posted to %s", body.Repo)
+		}
+		created = append(created, body.Record.Text)
+	}
+	if !slices.Equal(created, []string{"posting from a test", "and in xml"}) {
+		t.Errorf("the PDS got posts %q", created)
+	}
+
+	var home []status
+	h.Call(t, http.MethodGet, "/1/statuses/home_timeline.json", nil, auth, &home)
+	if len(home) < 2 || home[0].ID != postedXML.ID || home[1].ID != posted.ID {
+		t.Errorf("new posts aren't at the top of the timeline: %q", texts(home))
+	}
+}
+
+func TestFollows(t *testing.T) {
+	h := fakepds.NewTestHarness(t, nil)
+	auth := h.MustLogin(t, "alice.test", "dev_alice-pass")
+
+	var carol user
+	h.Call(t, http.MethodGet, "/1/users/show.json", url.Values{"screen_name": {"carol.test"}}, auth, &carol)
+
+	following := func() []int64 {
+		var ids struct {
+			IDs []int64 `json:"ids"`
+		}
+		h.Call(t, http.MethodGet, "/1/friends/ids.json", url.Values{"screen_name": {"alice.test"}, "cursor": {"-1"}}, auth, &ids)
+		var idsXML struct {
+			IDs []int64 `xml:"Ids"`
+		}
+		h.Call(t, http.MethodGet, "/1/friends/ids.xml", url.Values{"screen_name": {"alice.test"}, "cursor": {"-1"}}, auth, &idsXML)
+		if !slices.Equal(ids.IDs, idsXML.IDs) {
+			t.Errorf("friends/ids.xml: got %v, json gave %v", idsXML.IDs, ids.IDs)
+		}
+		return ids.IDs
+	}
+	if !slices.Contains(following(), carol.ID) {
+		t.Fatal("alice should start out following carol")
+	}
+
+	var unfollowed user
+	h.Call(t, http.MethodPost, "/1/friendships/destroy.json", url.Values{"screen_name": {"carol.test"}}, auth, &unfollowed)
+	if unfollowed.ScreenName != "carol.test" {
+		t.Errorf("friendships/destroy.json: got %+v", unfollowed)
+	}
+	if slices.Contains(following(), carol.ID) {
+		t.Error("still following carol after unfollowing")
+	}
+
+	var followed user
+	h.Call(t, http.MethodPost, "/1/friendships/create.xml", url.Values{"screen_name": {"carol.test"}}, auth, &followed)
+	if followed.ScreenName != "carol.test" {
+		t.Errorf("friendships/create.xml: got %+v", followed)
+	}
+	if !slices.Contains(following(), carol.ID) {
+		t.Error("not following carol after following")
+	}
+
+	var relationship struct {
+		Relationship struct {
+			Source struct {
+				Following  bool `json:"following" xml:"following"`
+				FollowedBy bool `json:"followed_by" xml:"followed_by"`
+			} `json:"source" xml:"source"`
+		} `json:"relationship" xml:"relationship"`
+	}
+	form := url.Values{"source_screen_name": {"alice.test"}, "target_screen_name": {"bob.test"}}
+	h.Call(t, http.MethodGet, "/1/friendships/show.json", form, auth, &relationship)
+	jsonRelationship := relationship
+	h.Call(t, http.MethodGet, "/1/friendships/show.xml", form, auth, &relationship)
+	if !jsonRelationship.Relationship.Source.Following || !jsonRelationship.Relationship.Source.FollowedBy || relationship != jsonRelationship {
+		t.Errorf("friendships/show: got %+v in json, %+v in xml", jsonRelationship, relationship)
+	}
+}
+
+func TestLists(t *testing.T) {
+	h := fakepds.NewTestHarness(t, nil)
+	auth := h.MustLogin(t, "alice.test", "dev_alice-pass")
+
+	type list struct {
+		Slug        string `json:"slug" xml:"slug"`
+		Name        string `json:"name" xml:"name"`
+		MemberCount int    `json:"member_count" xml:"member_count"`
+	}
+	var lists struct {
+		Lists []list `json:"lists" xml:"list"`
+	}
+	h.Call(t, http.MethodGet, "/1/lists.json", url.Values{"screen_name": {"alice.test"}}, auth, &lists)
+	jsonLists := lists.Lists
+	lists.Lists = nil
+	h.Call(t, http.MethodGet, "/1/lists.xml", url.Values{"screen_name": {"alice.test"}}, auth, &lists)
+	if len(jsonLists) != 1 || jsonLists[0].Name != "Friends" || jsonLists[0].MemberCount != 2 || !slices.Equal(lists.Lists, jsonLists) {
+		t.Fatalf("lists: got %+v in json, %+v in xml", jsonLists, lists.Lists)
+	}
+
+	listForm := url.Values{"slug": {jsonLists[0].Slug}, "owner_screen_name": {"alice.test"}}
+	var timeline []status
+	h.Call(t, http.MethodGet, "/1/lists/statuses.json", listForm, auth, &timeline)
+	if len(timeline) == 0 {
+		t.Fatal("lists/statuses.json is empty")
+	}
+	for _, status := range timeline {
+		if status.User.ScreenName != "bob.test" && status.User.ScreenName != "carol.test" {
+			t.Errorf("lists/statuses.json has a post by %s, who isn't on the list", status.User.ScreenName)
+		}
+	}
+	var timelineXML statuses
+	h.Call(t, http.MethodGet, "/1/lists/statuses.xml", listForm, auth, &timelineXML)
+	if !slices.Equal(texts(timelineXML.Statuses), texts(timeline)) {
+		t.Errorf("lists/statuses.xml: got %q, json gave %q", texts(timelineXML.Statuses), texts(timeline))
+	}
+
+	var members struct {
+		Users []user `json:"users" xml:"user"`
+	}
+	h.Call(t, http.MethodGet, "/1/lists/members.json", listForm, auth, &members)
+	jsonMembers := members.Users
+	members.Users = nil
+	h.Call(t, http.MethodGet, "/1/lists/members.xml", listForm, auth, &members)
+	names := []string{}
+	for _, member := range jsonMembers {
+		names = append(names, member.ScreenName)
+	}
+	slices.Sort(names)
+	if !slices.Equal(names, []string{"bob.test", "carol.test"}) || !slices.Equal(members.Users, jsonMembers) {
+		t.Errorf("lists/members: got %+v in json, %+v in xml", jsonMembers, members.Users)
+	}
+}
@@ -0,0 +1,858 @@
+package fakepds
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Every XRPC method the fake PDS does. They're called with the server locked.
+var methods = map[string]func(s *Server, c *call) (any, *xrpcError){
+	// com.atproto.*
+	"com.atproto.server.createSession":   (*Server).createSession,
+	"com.atproto.server.refreshSession":  (*Server).refreshSession,
+	"com.atproto.server.getSession":      (*Server).getSession,
+	"com.atproto.identity.resolveHandle": (*Server).resolveHandle,
+	"com.atproto.repo.createRecord":      (*Server).createRecord,
+	"com.atproto.repo.putRecord":         (*Server).putRecord,
+	"com.atproto.repo.deleteRecord":      (*Server).deleteRecord,
+	"com.atproto.repo.getRecord":         (*Server).getRecord,
+	"com.atproto.repo.uploadBlob":        (*Server).uploadBlob,
+
+	// app.bsky.actor.*
+	"app.bsky.actor.getProfile":            (*Server).getProfile,
+	"app.bsky.actor.getProfiles":           (*Server).getProfiles,
+	"app.bsky.actor.searchActors":          (*Server).searchActors,
+	"app.bsky.actor.searchActorsTypeahead": (*Server).searchActors,
+	"app.bsky.actor.getSuggestions":        (*Server).getSuggestions,
+
+	// app.bsky.feed.*
+	"app.bsky.feed.getTimeline":   (*Server).getTimeline,
+	"app.bsky.feed.getAuthorFeed": (*Server).getAuthorFeed,
+	"app.bsky.feed.getFeed":       (*Server).getFeed,
+	"app.bsky.feed.getListFeed":   (*Server).getListFeed,
+	"app.bsky.feed.getPostThread": (*Server).getPostThread,
+	"app.bsky.feed.getPosts":      (*Server).getPosts,
+	"app.bsky.feed.getLikes":      (*Server).getLikes,
+	"app.bsky.feed.getRepostedBy": (*Server).getRepostedBy,
+	"app.bsky.feed.getActorLikes": (*Server).getActorLikes,
+	"app.bsky.feed.searchPosts":   (*Server).searchPosts,
+
+	// app.bsky.graph.*
+	"app.bsky.graph.getFollowers":               (*Server).getFollowers,
+	"app.bsky.graph.getFollows":                 (*Server).getFollows,
+	"app.bsky.graph.getRelationships":           (*Server).getRelationships,
+	"app.bsky.graph.getLists":                   (*Server).getLists,
+	"app.bsky.graph.getList":                    (*Server).getList,
+	"app.bsky.graph.getSuggestedFollowsByActor": (*Server).getSuggestions,
+
+	// everything else
+	"app.bsky.notification.listNotifications": (*Server).listNotifications,
+	"app.bsky.unspecced.getTrendingTopics":    (*Server).getTrendingTopics,
+	"app.bsky.unspecced.getSuggestedUsers":    (*Server).getSuggestions,
+}
+
+// -- Sessions --
+
+func (s *Server) createSession(c *call) (any, *xrpcError) {
+	req := struct {
+		Identifier string `json:"identifier"`
+		Password   string `json:"password"`
+	}{}
+	if err := json.Unmarshal(c.body, &req); err != nil {
+		return nil, errorf(http.StatusBadRequest, "InvalidRequest", "Invalid JSON")
+	}
+	account := s.account(req.Identifier)
+	if account == nil || account.Password != req.Password {
+		return nil, errorf(http.StatusUnauthorized, "AuthenticationRequired", "Invalid identifier or password")
+	}
+	return s.newSession(account), nil
+}
+
+func (s *Server) refreshSession(c *call) (any, *xrpcError) {
+	did, ok := s.refreshTokens[c.token]
+	if !ok {
+		return nil, errorf(http.StatusBadRequest, "ExpiredToken", "Token has been revoked")
+	}
+	delete(s.refreshTokens, c.token) // they're single use
+	return s.newSession(s.account(did)), nil
+}
+
+func (s *Server) newSession(account *Account) map[string]any {
+	nonce := len(s.accessTokens) + len(s.refreshTokens) + 1
+	accessJwt := makeJWT(account.DID, "com.atproto.access", 2*time.Hour, nonce)
+	refreshJwt := makeJWT(account.DID, "com.atproto.refresh", 90*24*time.Hour, nonce)
+	s.accessTokens[accessJwt] = account.DID
+	s.refreshTokens[refreshJwt] = account.DID
+	return map[string]any{
+		"accessJwt":  accessJwt,
+		"refreshJwt": refreshJwt,
+		"did":        account.DID,
+		"handle":     account.Handle,
+		"active":     true,
+	}
+}
+
+func (s *Server) getSession(c *call) (any, *xrpcError) {
+	if c.viewer == "" {
+		return nil, errorf(http.StatusUnauthorized, "AuthenticationRequired", "Authentication Required")
+	}
+	account := s.account(c.viewer)
+	return map[string]any{
+		"did":    account.DID,
+		"handle": account.Handle,
+		"email":  strings.Split(account.Handle, ".")[0] + "@example.com",
+	}, nil
+}
+
+func (s *Server) resolveHandle(c *call) (any, *xrpcError) {
+	account := s.account(c.param("handle"))
+	if account == nil {
+		return nil, errorf(http.StatusBadRequest, "InvalidRequest", "Unable to resolve handle")
+	}
+	return map[string]string{"did": account.DID}, nil
+}
+
+// -- Repo --
+
+func (s *Server) createRecord(c *call) (any, *xrpcError) {
+	req := struct {
+		Repo       string         `json:"repo"`
+		Collection string         `json:"collection"`
+		RKey       string         `json:"rkey"`
+		Record     map[string]any `json:"record"`
+	}{}
+	if err := json.Unmarshal(c.body, &req); err != nil {
+		return nil, errorf(http.StatusBadRequest, "InvalidRequest", "Invalid JSON")
+	}
+	if xrpcErr := s.checkRepo(c, req.Repo); xrpcErr != nil {
+		return nil, xrpcErr
+	}
+	if req.RKey == "" {
+		req.RKey = s.newRKey()
+	}
+	uri := atURI(c.viewer, req.Collection, req.RKey)
+	// what the record says, like a real PDS
+	createdAtStr, _ := req.Record["createdAt"].(string)
+	createdAt, err := time.Parse(time.RFC3339Nano, createdAtStr)
+	if err != nil {
+		createdAt = time.Now().UTC()
+	}
+
+	switch req.Collection {
+	case "app.bsky.feed.post":
+		if text, _ := req.Record["text"].(string); len([]rune(text)) > 300 {
+			return nil, errorf(http.StatusBadRequest, "InvalidRecord", "Record/text must not be longer than 300 graphemes")
+		}
+		s.addPost(c.viewer, req.RKey, req.Record)
+	case "app.bsky.feed.like", "app.bsky.feed.repost":
+		interaction := &Interaction{RKey: req.RKey, By: c.viewer, Post: refURI(req.Record["subject"]), CreatedAt: createdAt}
+		if _, ok := s.posts[interaction.Post]; !ok {
+			return nil, errorf(http.StatusBadRequest, "InvalidRequest", "Could not locate record: %s", interaction.Post)
+		}
+		if req.Collection == "app.bsky.feed.like" {
+			s.likes[uri] = interaction
+		} else {
+			s.reposts[uri] = interaction
+		}
+	case "app.bsky.graph.follow":
+		subject, _ := req.Record["subject"].(string)
+		if s.account(subject) == nil {
+			return nil, errorf(http.StatusBadRequest, "InvalidRequest", "Could not find actor: %s", subject)
+		}
+		s.follows[uri] = &Follow{RKey: req.RKey, From: c.viewer, To: subject, CreatedAt: createdAt}
+	default:
+		// blocks, list items & whatever else just get accepted, nothing reads them back
+	}
+
+	return map[string]any{
+		"uri":              uri,
+		"cid":              cidFor(uri),
+		"commit":           map[string]string{"cid": cidFor(uri + "#commit"), "rev": req.RKey},
+		"validationStatus": "valid",
+	}, nil
+}
+
+func (s *Server) putRecord(c *call) (any, *xrpcError) {
+	req := struct {
+		Repo       string         `json:"repo"`
+		Collection string         `json:"collection"`
+		RKey       string         `json:"rkey"`
+		Record     map[string]any `json:"record"`
+	}{}
+	if err := json.Unmarshal(c.body, &req); err != nil {
+		return nil, errorf(http.StatusBadRequest, "InvalidRequest", "Invalid JSON")
+	}
+	if xrpcErr := s.checkRepo(c, req.Repo); xrpcErr != nil {
+		return nil, xrpcErr
+	}
+	if req.Collection == "app.bsky.actor.profile" && req.RKey == "self" {
+		account := s.account(c.viewer)
+		if displayName, ok := req.Record["displayName"].(string); ok {
+			account.DisplayName = displayName
+		}
+		if description, ok := req.Record["description"].(string); ok {
+			account.Description = description
+		}
+	}
+	uri := atURI(c.viewer, req.Collection, req.RKey)
+	return map[string]any{
+		"uri":    uri,
+		"cid":    cidFor(uri),
+		"commit": map[string]string{"cid": cidFor(uri + "#commit"), "rev": req.RKey},
+	}, nil
+}
+
+func (s *Server) deleteRecord(c *call) (any, *xrpcError) {
+	req := struct {
+		Repo       string `json:"repo"`
+		Collection string `json:"collection"`
+		RKey       string `json:"rkey"`
+	}{}
+	if err := json.Unmarshal(c.body, &req); err != nil {
+		return nil, errorf(http.StatusBadRequest, "InvalidRequest", "Invalid JSON")
+	}
+	if xrpcErr := s.checkRepo(c, req.Repo); xrpcErr != nil {
+		return nil, xrpcErr
+	}
+	uri := atURI(c.viewer, req.Collection, req.RKey)
+	// deleting something that isn't there is fine, same as bluesky
+	delete(s.posts, uri)
+	delete(s.likes, uri)
+	delete(s.reposts, uri)
+	delete(s.follows, uri)
+	return map[string]any{}, nil
+}
+
+func (s *Server) getRecord(c *call) (any, *xrpcError) {
+	account := s.account(c.param("repo"))
+	if account == nil {
+		return nil, errorf(http.StatusBadRequest, "InvalidRequest", "Could not find repo: %s", c.param("repo"))
+	}
+	uri := atURI(account.DID, c.param("collection"), c.param("rkey"))
+
+	var value any
+	switch c.param("collection") {
+	case "app.bsky.feed.post":
+		if p, ok := s.posts[uri]; ok {
+			value = p.record
+		}
+	case "app.bsky.feed.like", "app.bsky.feed.repost":
+		interactions := s.likes
+		if c.param("collection") == "app.bsky.feed.repost" {
+			interactions = s.reposts
+		}
+		if interaction, ok := interactions[uri]; ok {
+			value = map[string]any{
+				"$type":     c.param("collection"),
+				"createdAt": interaction.CreatedAt.Format(time.RFC3339Nano),
+				"subject":   map[string]string{"uri": interaction.Post, "cid": cidFor(interaction.Post)},
+			}
+		}
+	case "app.bsky.graph.follow":
+		if follow, ok := s.follows[uri]; ok {
+			value = map[string]any{
+				"$type":     "app.bsky.graph.follow",
+				"createdAt": follow.CreatedAt.Format(time.RFC3339Nano),
+				"subject":   follow.To,
+			}
+		}
+	case "app.bsky.actor.profile":
+		if c.param("rkey") == "self" {
+			value = map[string]any{
+				"$type":       "app.bsky.actor.profile",
+				"displayName": account.DisplayName,
+				"description": account.Description,
+				"createdAt":   account.CreatedAt.Format(time.RFC3339Nano),
+			}
+		}
+	}
+	if value == nil {
+		return nil, errorf(http.StatusBadRequest, "RecordNotFound", "Could not locate record: %s", uri)
+	}
+	return map[string]any{"uri": uri, "cid": cidFor(uri), "value": value}, nil
+}
+
+func (s *Server) uploadBlob(c *call) (any, *xrpcError) {
+	if c.viewer == "" {
+		return nil, errorf(http.StatusUnauthorized, "AuthenticationRequired", "Authentication Required")
+	}
+	s.blobs++
+	mimeType := c.r.Header.Get("Content-Type")
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	return map[string]any{
+		"blob": map[string]any{
+			"$type":    "blob",
+			"ref":      map[string]string{"$link": cidFor("blob" + strconv.Itoa(s.blobs))},
+			"mimeType": mimeType,
+			"size":     len(c.body),
+		},
+	}, nil
+}
+
+// checkRepo makes sure someone's logged in, and is writing to their own repo.
+func (s *Server) checkRepo(c *call, repo string) *xrpcError {
+	if c.viewer == "" {
+		return errorf(http.StatusUnauthorized, "AuthenticationRequired", "Authentication Required")
+	}
+	if account := s.account(repo); account == nil || account.DID != c.viewer {
+		return errorf(http.StatusBadRequest, "InvalidRequest", "Can only write to your own repo")
+	}
+	return nil
+}
+
+// -- Actors --
+
+func (s *Server) getProfile(c *call) (any, *xrpcError) {
+	account := s.account(c.param("actor"))
+	if account == nil {
+		return nil, errorf(http.StatusBadRequest, "InvalidRequest", "Profile not found")
+	}
+	return s.profileView(account, c.viewer), nil
+}
+
+func (s *Server) getProfiles(c *call) (any, *xrpcError) {
+	profiles := []any{}
+	for _, actor := range c.params("actors") {
+		if account := s.account(actor); account != nil {
+			profiles = append(profiles, s.profileView(account, c.viewer))
+		}
+	}
+	return map[string]any{"profiles": profiles}, nil
+}
+
+func (s *Server) searchActors(c *call) (any, *xrpcError) {
+	query := strings.ToLower(c.param("q"))
+	actors := []any{}
+	for _, account := range s.accounts {
+		if query != "" && (strings.Contains(account.Handle, query) || strings.Contains(strings.ToLower(account.DisplayName), query)) {
+			actors = append(actors, s.profileView(account, c.viewer))
+		}
+	}
+	return map[string]any{"actors": first(actors, limitParam(c, 25))}, nil
+}
+
+// getSuggestions is everyone the viewer doesn't follow yet. (Also used for the other suggestion methods, they're all the same here)
+func (s *Server) getSuggestions(c *call) (any, *xrpcError) {
+	actors := []any{}
+	for _, account := range s.accounts {
+		if account.DID != c.viewer && s.followURI(c.viewer, account.DID) == "" && account.DID != c.param("actor") {
+			actors = append(actors, s.profileView(account, c.viewer))
+		}
+	}
+	actors = first(actors, limitParam(c, 50))
+	return map[string]any{"actors": actors, "suggestions": actors}, nil
+}
+
+// -- Feeds --
+
+func (s *Server) getTimeline(c *call) (any, *xrpcError) {
+	if c.viewer == "" {
+		return nil, errorf(http.StatusUnauthorized, "AuthenticationRequired", "Authentication Required")
+	}
+	following := map[string]bool{c.viewer: true}
+	for _, follow := range s.follows {
+		if follow.From == c.viewer {
+			following[follow.To] = true
+		}
+	}
+	items := s.feedItems(func(p *post) bool { return following[p.author] }, following)
+	return s.page(c, items, "feed"), nil
+}
+
+func (s *Server) getAuthorFeed(c *call) (any, *xrpcError) {
+	account := s.account(c.param("actor"))
+	if account == nil {
+		return nil, errorf(http.StatusBadRequest, "InvalidRequest", "Profile not found")
+	}
+	filter := c.param("filter")
+	items := s.feedItems(func(p *post) bool {
+		if p.author != account.DID {
+			return false
+		}
+		switch filter {
+		case "posts_with_media":
+			return p.hasMedia
+		case "posts_no_replies":
+			return p.parent == ""
+		}
+		return true
+	}, map[string]bool{account.DID: filter == "" || filter == "posts_with_replies"})
+	return s.page(c, items, "feed"), nil
+}
+
+// getFeed ignores which feed it is, it's everyone's posts.
+func (s *Server) getFeed(c *call) (any, *xrpcError) {
+	items := s.feedItems(func(p *post) bool { return true }, nil)
+	return s.page(c, items, "feed"), nil
+}
+
+func (s *Server) getListFeed(c *call) (any, *xrpcError) {
+	list, ok := s.lists[c.param("list")]
+	if !ok {
+		return nil, errorf(http.StatusBadRequest, "UnknownList", "List not found")
+	}
+	items := s.feedItems(func(p *post) bool { return slices.Contains(list.Members, p.author) }, nil)
+	return s.page(c, items, "feed"), nil
+}
+
+func (s *Server) getPostThread(c *call) (any, *xrpcError) {
+	p, ok := s.posts[c.param("uri")]
+	if !ok {
+		return nil, errorf(http.StatusBadRequest, "NotFound", "Post not found: %s", c.param("uri"))
+	}
+	depth, parentHeight := 6, 80
+	if d, err := strconv.Atoi(c.param("depth")); err == nil {
+		depth = d
+	}
+	if h, err := strconv.Atoi(c.param("parentHeight")); err == nil {
+		parentHeight = h
+	}
+
+	thread := s.threadView(p, c.viewer, depth)
+	// walk up the parents
+	node := thread
+	for height := 0; height < parentHeight && p.parent != ""; height++ {
+		parent, ok := s.posts[p.parent]
+		if !ok {
+			break
+		}
+		parentThread := map[string]any{"$type": "app.bsky.feed.defs#threadViewPost", "post": s.postView(parent, c.viewer)}
+		node["parent"] = parentThread
+		node, p = parentThread, parent
+	}
+	return map[string]any{"thread": thread}, nil
+}
+
+func (s *Server) threadView(p *post, viewer string, depth int) map[string]any {
+	thread := map[string]any{"$type": "app.bsky.feed.defs#threadViewPost", "post": s.postView(p, viewer)}
+	replies := []any{}
+	if depth > 0 {
+		for _, reply := range s.sortedPosts(func(r *post) bool { return r.parent == p.uri }) {
+			replies = append(replies, s.threadView(reply, viewer, depth-1))
+		}
+	}
+	thread["replies"] = replies
+	return thread
+}
+
+func (s *Server) getPosts(c *call) (any, *xrpcError) {
+	posts := []any{}
+	for _, uri := range c.params("uris") {
+		if p, ok := s.posts[uri]; ok {
+			posts = append(posts, s.postView(p, c.viewer))
+		}
+	}
+	return map[string]any{"posts": posts}, nil
+}
+
+func (s *Server) getLikes(c *call) (any, *xrpcError) {
+	likes := []any{}
+	for _, like := range sortedInteractions(s.likes) {
+		if like.Post == c.param("uri") {
+			likes = append(likes, map[string]any{
+				"indexedAt": like.CreatedAt,
+				"createdAt": like.CreatedAt,
+				"actor":     s.profileView(s.account(like.By), c.viewer),
+			})
+		}
+	}
+	return map[string]any{"uri": c.param("uri"), "likes": first(likes, limitParam(c, 50))}, nil
+}
+
+func (s *Server) getRepostedBy(c *call) (any, *xrpcError) {
+	users := []any{}
+	for _, repost := range sortedInteractions(s.reposts) {
+		if repost.Post == c.param("uri") {
+			users = append(users, s.profileView(s.account(repost.By), c.viewer))
+		}
+	}
+	return map[string]any{"uri": c.param("uri"), "repostedBy": first(users, limitParam(c, 50))}, nil
+}
+
+func (s *Server) getActorLikes(c *call) (any, *xrpcError) {
+	account := s.account(c.param("actor"))
+	if account == nil {
+		return nil, errorf(http.StatusBadRequest, "InvalidRequest", "Profile not found")
+	}
+	if account.DID != c.viewer {
+		return nil, errorf(http.StatusBadRequest, "InvalidRequest", "Profile not found")
+	}
+	items := []map[string]any{}
+	for _, like := range sortedInteractions(s.likes) {
+		if p, ok := s.posts[like.Post]; ok && like.By == account.DID {
+			items = append(items, map[string]any{"post": s.postView(p, c.viewer)})
+		}
+	}
+	return s.page(c, items, "feed"), nil
+}
+
+func (s *Server) searchPosts(c *call) (any, *xrpcError) {
+	query := strings.ToLower(c.param("q"))
+	posts := []any{}
+	for _, p := range s.sortedPosts(func(p *post) bool { return true }) {
+		text, _ := p.record["text"].(string)
+		if query != "" && strings.Contains(strings.ToLower(text), query) {
+			posts = append(posts, s.postView(p, c.viewer))
+		}
+	}
+	return map[string]any{"posts": first(posts, limitParam(c, 25)), "hitsTotal": len(posts)}, nil
+}
+
+// feedItems is every post matching keep, plus reposts by anyone in reposters, newest first.
+func (s *Server) feedItems(keep func(*post) bool, reposters map[string]bool) []map[string]any {
+	type item struct {
+		at   time.Time
+		view map[string]any
+	}
+	var items []item
+	for _, p := range s.sortedPosts(keep) {
+		items = append(items, item{p.createdAt, s.feedViewPost(p, "", "")})
+	}
+	for repostURI, repost := range s.reposts {
+		if p, ok := s.posts[repost.Post]; ok && reposters[repost.By] {
+			items = append(items, item{repost.CreatedAt, s.feedViewPost(p, repost.By, repostURI)})
+		}
+	}
+	slices.SortStableFunc(items, func(a, b item) int { return b.at.Compare(a.at) })
+
+	views := make([]map[string]any, len(items))
+	for i, item := range items {
+		views[i] = item.view
+	}
+	return views
+}
+
+// feedViewPost is app.bsky.feed.defs#feedViewPost, without the viewer bits (page fills them in)
+func (s *Server) feedViewPost(p *post, repostedBy string, repostURI string) map[string]any {
+	view := map[string]any{"post": p}
+	if repostedBy != "" {
+		view["reason"] = map[string]any{
+			"$type":     "app.bsky.feed.defs#reasonRepost",
+			"by":        s.account(repostedBy),
+			"uri":       repostURI,
+			"cid":       cidFor(repostURI),
+			"indexedAt": time.Now().UTC(), // when the appview saw it, not when it was made
+		}
+	}
+	return view
+}
+
+// page cuts items down to limit starting from the cursor (just an offset here), and renders them for the viewer.
+func (s *Server) page(c *call, items []map[string]any, key string) map[string]any {
+	offset, _ := strconv.Atoi(c.param("cursor"))
+	offset = min(max(offset, 0), len(items))
+	limit := limitParam(c, 50)
+	end := min(offset+limit, len(items))
+
+	rendered := []any{}
+	for _, item := range items[offset:end] {
+		view := map[string]any{}
+		for k, v := range item {
+			view[k] = v
+		}
+		if p, ok := item["post"].(*post); ok {
+			view["post"] = s.postView(p, c.viewer)
+			if p.parent != "" {
+				if parent, ok := s.posts[p.parent]; ok {
+					root := parent
+					if r, ok := s.posts[p.root]; ok {
+						root = r
+					}
+					view["reply"] = map[string]any{"parent": s.postView(parent, c.viewer), "root": s.postView(root, c.viewer)}
+				}
+			}
+		}
+		if reason, ok := item["reason"].(map[string]any); ok {
+			reason = map[string]any{"$type": reason["$type"], "uri": reason["uri"], "cid": reason["cid"], "indexedAt": reason["indexedAt"], "by": s.profileView(reason["by"].(*Account), c.viewer)}
+			view["reason"] = reason
+		}
+		rendered = append(rendered, view)
+	}
+
+	res := map[string]any{key: rendered}
+	if end < len(items) {
+		res["cursor"] = strconv.Itoa(end)
+	}
+	return res
+}
+
+// -- Graph --
+
+func (s *Server) getFollowers(c *call) (any, *xrpcError) {
+	account := s.account(c.param("actor"))
+	if account == nil {
+		return nil, errorf(http.StatusBadRequest, "InvalidRequest", "Profile not found")
+	}
+	followers := []map[string]any{}
+	for _, follow := range sortedFollows(s.follows) {
+		if follow.To == account.DID {
+			followers = append(followers, s.profileView(s.account(follow.From), c.viewer))
+		}
+	}
+	res := s.pageUsers(c, followers, "followers")
+	res["subject"] = s.profileView(account, c.viewer)
+	return res, nil
+}
+
+func (s *Server) getFollows(c *call) (any, *xrpcError) {
+	account := s.account(c.param("actor"))
+	if account == nil {
+		return nil, errorf(http.StatusBadRequest, "InvalidRequest", "Profile not found")
+	}
+	follows := []map[string]any{}
+	for _, follow := range sortedFollows(s.follows) {
+		if follow.From == account.DID {
+			if followed := s.account(follow.To); followed != nil {
+				follows = append(follows, s.profileView(followed, c.viewer))
+			}
+		}
+	}
+	res := s.pageUsers(c, follows, "follows")
+	res["subject"] = s.profileView(account, c.viewer)
+	return res, nil
+}
+
+func (s *Server) pageUsers(c *call, users []map[string]any, key string) map[string]any {
+	offset, _ := strconv.Atoi(c.param("cursor"))
+	offset = min(max(offset, 0), len(users))
+	end := min(offset+limitParam(c, 50), len(users))
+	res := map[string]any{key: users[offset:end]}
+	if end < len(users) {
+		res["cursor"] = strconv.Itoa(end)
+	}
+	return res
+}
+
+func (s *Server) getRelationships(c *call) (any, *xrpcError) {
+	account := s.account(c.param("actor"))
+	if account == nil {
+		return nil, errorf(http.StatusBadRequest, "InvalidRequest", "Profile not found")
+	}
+	relationships := []any{}
+	for _, others := range c.params("others") {
+		for _, other := range strings.Split(others, ",") {
+			otherAccount := s.account(other)
+			if otherAccount == nil {
+				relationships = append(relationships, map[string]any{"$type": "app.bsky.graph.defs#notFoundActor", "actor": other, "notFound": true})
+				continue
+			}
+			relationship := map[string]any{"$type": "app.bsky.graph.defs#relationship", "did": otherAccount.DID}
+			if uri := s.followURI(account.DID, otherAccount.DID); uri != "" {
+				relationship["following"] = uri
+			}
+			if uri := s.followURI(otherAccount.DID, account.DID); uri != "" {
+				relationship["followedBy"] = uri
+			}
+			relationships = append(relationships, relationship)
+		}
+	}
+	return map[string]any{"actor": account.DID, "relationships": relationships}, nil
+}
+
+func (s *Server) getLists(c *call) (any, *xrpcError) {
+	account := s.account(c.param("actor"))
+	if account == nil {
+		return nil, errorf(http.StatusBadRequest, "InvalidRequest", "Profile not found")
+	}
+	lists := []any{}
+	for _, uri := range sortedKeys(s.lists) {
+		if list := s.lists[uri]; list.Owner == account.DID {
+			lists = append(lists, s.listView(uri, list, c.viewer))
+		}
+	}
+	return map[string]any{"lists": first(lists, limitParam(c, 50))}, nil
+}
+
+func (s *Server) getList(c *call) (any, *xrpcError) {
+	uri := c.param("list")
+	list, ok := s.lists[uri]
+	if !ok {
+		return nil, errorf(http.StatusBadRequest, "UnknownList", "List not found")
+	}
+	items := []any{}
+	for i, member := range list.Members {
+		if account := s.account(member); account != nil {
+			items = append(items, map[string]any{
+				"uri":     atURI(list.Owner, "app.bsky.graph.listitem", list.RKey+strconv.Itoa(i)),
+				"subject": s.profileView(account, c.viewer),
+			})
+		}
+	}
+	return map[string]any{"list": s.listView(uri, list, c.viewer), "items": first(items, limitParam(c, 50))}, nil
+}
+
+// -- Everything else --
+
+// listNotifications doesn't have any notifications, nothing the bridge tests needs them (yet)
+func (s *Server) listNotifications(c *call) (any, *xrpcError) {
+	if c.viewer == "" {
+		return nil, errorf(http.StatusUnauthorized, "AuthenticationRequired", "Authentication Required")
+	}
+	return map[string]any{"notifications": []any{}, "seenAt": time.Now().UTC()}, nil
+}
+
+func (s *Server) getTrendingTopics(c *call) (any, *xrpcError) {
+	topics := s.topics
+	if topics == nil {
+		topics = []TrendTopic{}
+	}
+	return map[string]any{"topics": topics, "suggested": []any{}}, nil
+}
+
+// -- Views --
+
+// profileView is app.bsky.actor.defs#profileViewDetailed, as seen by the viewer.
+func (s *Server) profileView(account *Account, viewer string) map[string]any {
+	followers, follows, posts := 0, 0, 0
+	for _, follow := range s.follows {
+		if follow.To == account.DID {
+			followers++
+		}
+		if follow.From == account.DID {
+			follows++
+		}
+	}
+	for _, p := range s.posts {
+		if p.author == account.DID {
+			posts++
+		}
+	}
+
+	viewerState := map[string]any{"muted": false, "blockedBy": false}
+	if viewer != "" && viewer != account.DID {
+		if uri := s.followURI(viewer, account.DID); uri != "" {
+			viewerState["following"] = uri
+		}
+		if uri := s.followURI(account.DID, viewer); uri != "" {
+			viewerState["followedBy"] = uri
+		}
+	}
+
+	return map[string]any{
+		"did":            account.DID,
+		"handle":         account.Handle,
+		"displayName":    account.DisplayName,
+		"description":    account.Description,
+		"avatar":         account.Avatar,
+		"followersCount": followers,
+		"followsCount":   follows,
+		"postsCount":     posts,
+		"indexedAt":      account.CreatedAt,
+		"createdAt":      account.CreatedAt,
+		"associated":     map[string]any{"lists": s.countLists(account.DID), "chat": map[string]string{"allowIncoming": "following"}},
+		"viewer":         viewerState,
+	}
+}
+
+// postView is app.bsky.feed.defs#postView, as seen by the viewer.
+func (s *Server) postView(p *post, viewer string) map[string]any {
+	replies, reposts, likes, quotes := 0, 0, 0, 0
+	for _, other := range s.posts {
+		if other.parent == p.uri {
+			replies++
+		}
+		if embed, ok := other.record["embed"].(map[string]any); ok && refURI(embed["record"]) == p.uri {
+			quotes++
+		}
+	}
+	viewerState := map[string]any{"muted": false, "blockedBy": false, "threadMute": false, "replyDisabled": false, "embeddingDisabled": false, "pinned": false}
+	for uri, repost := range s.reposts {
+		if repost.Post == p.uri {
+			reposts++
+			if repost.By == viewer {
+				viewerState["repost"] = uri
+			}
+		}
+	}
+	for uri, like := range s.likes {
+		if like.Post == p.uri {
+			likes++
+			if like.By == viewer {
+				viewerState["like"] = uri
+			}
+		}
+	}
+
+	return map[string]any{
+		"uri":         p.uri,
+		"cid":         p.cid,
+		"author":      s.profileView(s.account(p.author), viewer),
+		"record":      p.record,
+		"replyCount":  replies,
+		"repostCount": reposts,
+		"likeCount":   likes,
+		"quoteCount":  quotes,
+		"indexedAt":   p.createdAt,
+		"viewer":      viewerState,
+	}
+}
+
+// listView is app.bsky.graph.defs#listView.
+func (s *Server) listView(uri string, list *List, viewer string) map[string]any {
+	return map[string]any{
+		"uri":           uri,
+		"cid":           cidFor(uri),
+		"creator":       s.profileView(s.account(list.Owner), viewer),
+		"name":          list.Name,
+		"purpose":       "app.bsky.graph.defs#curatelist",
+		"description":   list.Description,
+		"listItemCount": len(list.Members),
+		"indexedAt":     time.Unix(0, 0).UTC(),
+		"viewer":        map[string]any{"muted": false},
+	}
+}
+
+// followURI is the URI of from's follow of to, or "" if they don't follow them.
+func (s *Server) followURI(from string, to string) string {
+	for uri, follow := range s.follows {
+		if follow.From == from && follow.To == to {
+			return uri
+		}
+	}
+	return ""
+}
+
+func (s *Server) countLists(did string) int {
+	count := 0
+	for _, list := range s.lists {
+		if list.Owner == did {
+			count++
+		}
+	}
+	return count
+}
+
+// The maps are in random order, these keep responses the same every time.
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func sortedInteractions(m map[string]*Interaction) []*Interaction {
+	interactions := make([]*Interaction, 0, len(m))
+	for _, key := range sortedKeys(m) {
+		interactions = append(interactions, m[key])
+	}
+	slices.SortStableFunc(interactions, func(a, b *Interaction) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	return interactions
+}
+
+func sortedFollows(m map[string]*Follow) []*Follow {
+	follows := make([]*Follow, 0, len(m))
+	for _, key := range sortedKeys(m) {
+		follows = append(follows, m[key])
+	}
+	slices.SortStableFunc(follows, func(a, b *Follow) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	return follows
+}
@@ -0,0 +1,195 @@
+package linkcard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Link cards are what bluesky shows under a post with a link in it. The bluesky app makes them before it posts, out
+// of the page's OpenGraph/Twitter Card tags, so we do the same for posts from twitter clients.
+// The pages are whatever someone posted, so: only so much is read, it all has a time limit, and nothing on a private
+// address gets fetched (unless AllowPrivateAddresses).
+
+// Card is what we found out about a page.
+type Card struct {
+	URL         string
+	Title       string
+	Description string
+	ImageURL    string // absolute, "" if there isn't one
+}
+
+type Options struct {
+	Timeout       time.Duration // for the whole thing, page & image
+	MaxPageBytes  int64         // we only need the <head>, anything past this is ignored
+	MaxImageBytes int64         // images bigger than this are skipped, bluesky won't take big thumbnails anyway
+	UserAgent     string
+	// Lets pages on private/loopback addresses be fetched. Only for testing, otherwise anyone who can post can
+	// make us fetch things off our own network.
+	AllowPrivateAddresses bool
+}
+
+// Fetcher fetches pages & images for cards.
+type Fetcher struct {
+	options Options
+	client  *http.Client
+}
+
+var (
+	ErrNotHTML        = errors.New("not an html page")
+	ErrTooLarge       = errors.New("too large")
+	ErrPrivateAddress = errors.New("address is not public")
+)
+
+func New(options Options) *Fetcher {
+	dialer := &net.Dialer{
+		Timeout: options.Timeout,
+		// checked on the address we're actually connecting to (after DNS, and after every redirect), so a
+		// hostname that resolves to 127.0.0.1 doesn't get through either
+		Control: func(network string, address string, _ syscall.RawConn) error {
+			if options.AllowPrivateAddresses {
+				return nil
+			}
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !isPublic(addrPort.Addr()) {
+				return fmt.Errorf("%w: %s", ErrPrivateAddress, addrPort.Addr())
+			}
+			return nil
+		},
+	}
+	transport := &http.Transport{
+		Proxy:                 nil, // a proxy would do the connecting, and skip the check
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   options.Timeout,
+		ResponseHeaderTimeout: options.Timeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+	return &Fetcher{
+		options: options,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   options.Timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= 5 {
+					return errors.New("too many redirects")
+				}
+				if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+					return fmt.Errorf("redirected to a %s url", req.URL.Scheme)
+				}
+				return nil
+			},
+		},
+	}
+}
+
+// Ranges that aren't the public internet, but that netip's Is* functions don't cover
+var notPublic = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // "this network", 0.0.0.0 is localhost on linux
+	netip.MustParsePrefix("100.64.0.0/10"),  // carrier grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),   // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"),  // benchmarking, sometimes used for internal networks
+	netip.MustParsePrefix("240.0.0.0/4"),    // reserved, and broadcast
+	netip.MustParsePrefix("64:ff9b::/96"),   // NAT64, can reach any IPv4 address (including private ones) through the gateway
+	netip.MustParsePrefix("64:ff9b:1::/48"), // local use NAT64
+}
+
+func isPublic(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() || addr.IsLinkLocalUnicast() ||
+		addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() || addr.IsMulticast() {
+		return false
+	}
+	for _, prefix := range notPublic {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *Fetcher) get(ctx context.Context, rawURL string, accept string) (*http.Response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("can't fetch a %s url", u.Scheme)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", f.options.UserAgent)
+	req.Header.Set("Accept", accept)
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("got status %d", resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// Fetch gets the page at rawURL and makes a card out of it.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (*Card, error) {
+	ctx, cancel := context.WithTimeout(ctx, f.options.Timeout)
+	defer cancel()
+
+	resp, err := f.get(ctx, rawURL, "text/html,application/xhtml+xml")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, ErrNotHTML
+	}
+
+	card := parseCard(io.LimitReader(resp.Body, f.options.MaxPageBytes), resp.Request.URL)
+	card.URL = rawURL // the link they posted, not wherever it redirected to
+	return card, nil
+}
+
+// FetchImage gets a card's image, and its content type.
+func (f *Fetcher) FetchImage(ctx context.Context, rawURL string) ([]byte, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, f.options.Timeout)
+	defer cancel()
+
+	resp, err := f.get(ctx, rawURL, "image/*")
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	contentType := resp.Header.Get("Content-Type")
+	if !strings.HasPrefix(contentType, "image/") {
+		return nil, "", fmt.Errorf("not an image: %q", contentType)
+	}
+	if resp.ContentLength > f.options.MaxImageBytes {
+		return nil, "", ErrTooLarge
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, f.options.MaxImageBytes+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if int64(len(data)) > f.options.MaxImageBytes {
+		return nil, "", ErrTooLarge
+	}
+	return data, contentType, nil
+}
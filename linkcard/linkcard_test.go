@@ -0,0 +1,206 @@
+package linkcard
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIsPublic(t *testing.T) {
+	tests := []struct {
+		addr   string
+		public bool
+	}{
+		{"8.8.8.8", true},
+		{"1.1.1.1", true},
+		{"100.63.255.255", true},
+		{"100.128.0.0", true},
+		{"198.17.255.255", true},
+		{"198.20.0.0", true},
+		{"2606:4700:4700::1111", true},
+		{"64:ff9a::1", true},
+
+		{"127.0.0.1", false},
+		{"10.0.0.1", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false}, // cloud metadata
+		{"0.0.0.0", false},
+		{"0.1.2.3", false},
+		{"100.64.0.1", false},
+		{"100.127.255.255", false},
+		{"192.0.0.8", false},
+		{"198.18.0.1", false},
+		{"198.19.255.255", false},
+		{"240.0.0.1", false},
+		{"255.255.255.255", false},
+		{"224.0.0.1", false},
+		{"::", false},
+		{"::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"ff02::1", false},
+		{"64:ff9b::a00:1", false},   // NAT64 for 10.0.0.1
+		{"64:ff9b::808:808", false}, // and for 8.8.8.8, there's no telling where the gateway is
+		{"64:ff9b:1::1", false},
+	}
+	for _, test := range tests {
+		if got := isPublic(netip.MustParseAddr(test.addr)); got != test.public {
+			t.Errorf("isPublic(%s) = %v, want %v", test.addr, got, test.public)
+		}
+	}
+}
+
+func testFetcher(allowPrivate bool) *Fetcher {
+	return New(Options{
+		Timeout:               5 * time.Second,
+		MaxPageBytes:          4096,
+		MaxImageBytes:         1000,
+		UserAgent:             "linkcard test",
+		AllowPrivateAddresses: allowPrivate,
+	})
+}
+
+// A page on this machine can't be fetched, however its address is written.
+func TestFetchPrivate(t *testing.T) {
+	var fetched atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched.Store(true)
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<title>secret</title>`))
+	}))
+	defer server.Close()
+	port := server.Listener.Addr().(*net.TCPAddr).Port
+
+	fetcher := testFetcher(false)
+	for _, rawURL := range []string{
+		server.URL,
+		"http://localhost:" + strconv.Itoa(port),
+		"http://0.0.0.0:" + strconv.Itoa(port),
+		"http://[::ffff:127.0.0.1]:" + strconv.Itoa(port),
+	} {
+		if _, err := fetcher.Fetch(context.Background(), rawURL); !errors.Is(err, ErrPrivateAddress) {
+			t.Errorf("%s: got %v, want ErrPrivateAddress", rawURL, err)
+		}
+		if _, _, err := fetcher.FetchImage(context.Background(), rawURL); !errors.Is(err, ErrPrivateAddress) {
+			t.Errorf("%s image: got %v, want ErrPrivateAddress", rawURL, err)
+		}
+	}
+	if fetched.Load() {
+		t.Error("the server was reached")
+	}
+
+	if _, err := fetcher.Fetch(context.Background(), "file:///etc/passwd"); err == nil {
+		t.Error("fetched a file url")
+	}
+}
+
+func TestFetch(t *testing.T) {
+	image := bytes.Repeat([]byte{0xff}, 500)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/posted", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/articles/page", http.StatusFound)
+	})
+	mux.HandleFunc("/articles/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(`<html><head>
+<title>  The   title tag </title>
+<meta property="og:description" content=" An article ">
+<meta property="og:description" content="not this one">
+<meta name="twitter:image" content="../images/thumb.jpg">
+</head><body><meta property="og:title" content="too late"></body></html>`))
+	})
+	mux.HandleFunc("/long", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<head>" + strings.Repeat(" ", 5000) + `<meta property="og:title" content="past the limit"></head>`))
+	})
+	mux.HandleFunc("/data.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	})
+	mux.HandleFunc("/images/thumb.jpg", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(image)
+	})
+	mux.HandleFunc("/images/huge.jpg", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(bytes.Repeat([]byte{0xff}, 5000))
+	})
+	mux.HandleFunc("/images/chunked.jpg", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		for range 5 { // no Content-Length, so it has to be caught while reading
+			w.Write(bytes.Repeat([]byte{0xff}, 400))
+			w.(http.Flusher).Flush()
+		}
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	fetcher := testFetcher(true)
+	ctx := context.Background()
+
+	card, err := fetcher.Fetch(ctx, server.URL+"/posted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Card{
+		URL:         server.URL + "/posted",
+		Title:       "The title tag",
+		Description: "An article",
+		ImageURL:    server.URL + "/images/thumb.jpg", // relative to where it redirected to
+	}
+	if *card != want {
+		t.Errorf("got %+v, want %+v", *card, want)
+	}
+
+	data, contentType, err := fetcher.FetchImage(ctx, card.ImageURL)
+	if err != nil || contentType != "image/jpeg" || !bytes.Equal(data, image) {
+		t.Errorf("image: got %d bytes of %q, %v", len(data), contentType, err)
+	}
+
+	if card, err := fetcher.Fetch(ctx, server.URL+"/long"); err != nil || card.Title != "" {
+		t.Errorf("read past MaxPageBytes: %+v, %v", card, err)
+	}
+	if _, err := fetcher.Fetch(ctx, server.URL+"/data.json"); !errors.Is(err, ErrNotHTML) {
+		t.Errorf("json: got %v, want ErrNotHTML", err)
+	}
+	if _, err := fetcher.Fetch(ctx, server.URL+"/missing"); err == nil {
+		t.Error("404 made a card")
+	}
+	for _, path := range []string{"/images/huge.jpg", "/images/chunked.jpg"} {
+		if _, _, err := fetcher.FetchImage(ctx, server.URL+path); !errors.Is(err, ErrTooLarge) {
+			t.Errorf("%s: got %v, want ErrTooLarge", path, err)
+		}
+	}
+	if _, _, err := fetcher.FetchImage(ctx, server.URL+"/articles/page"); err == nil {
+		t.Error("fetched an html page as an image")
+	}
+}
+
+func TestCardImageURL(t *testing.T) {
+	base, _ := url.Parse("https://example.com/a/b")
+	for image, want := range map[string]string{
+		"https://cdn.example.com/x.png": "https://cdn.example.com/x.png",
+		"//cdn.example.com/x.png":       "https://cdn.example.com/x.png",
+		"/x.png":                        "https://example.com/x.png",
+		"javascript:alert(1)":           "",
+		"data:image/png;base64,AAAA":    "",
+	} {
+		card := cardFromMeta(map[string]string{"og:image": image}, "", base)
+		if card.ImageURL != want {
+			t.Errorf("og:image %q became %q, want %q", image, card.ImageURL, want)
+		}
+	}
+}
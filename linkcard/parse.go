@@ -0,0 +1,106 @@
+package linkcard
+
+import (
+	"io"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Same limits as the bluesky app
+const (
+	maxTitleLength       = 300
+	maxDescriptionLength = 1000
+)
+
+// parseCard reads the tags out of a page's <head>. base is where the page ended up, for relative image URLs.
+func parseCard(page io.Reader, base *url.URL) *Card {
+	meta := map[string]string{}
+	title := ""
+	inTitle := false
+
+	tokenizer := html.NewTokenizer(page)
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken: // the end (or as far as we read)
+			return cardFromMeta(meta, title, base)
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttributes := tokenizer.TagName()
+			switch atom.Lookup(name) {
+			case atom.Body:
+				return cardFromMeta(meta, title, base)
+			case atom.Title:
+				inTitle = title == ""
+			case atom.Meta:
+				if !hasAttributes {
+					continue
+				}
+				key, content := "", ""
+				for {
+					attribute, value, more := tokenizer.TagAttr()
+					switch string(attribute) {
+					case "property", "name":
+						key = strings.ToLower(string(value))
+					case "content":
+						content = strings.TrimSpace(string(value))
+					}
+					if !more {
+						break
+					}
+				}
+				// first one wins, that's what everyone else does
+				if _, ok := meta[key]; key != "" && content != "" && !ok {
+					meta[key] = content
+				}
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			switch atom.Lookup(name) {
+			case atom.Head:
+				return cardFromMeta(meta, title, base)
+			case atom.Title:
+				inTitle = false
+			}
+		case html.TextToken:
+			if inTitle {
+				title += string(tokenizer.Text())
+			}
+		}
+	}
+}
+
+func cardFromMeta(meta map[string]string, title string, base *url.URL) *Card {
+	first := func(keys ...string) string {
+		for _, key := range keys {
+			if value := meta[key]; value != "" {
+				return value
+			}
+		}
+		return ""
+	}
+
+	card := &Card{
+		Title:       truncate(first("og:title", "twitter:title"), maxTitleLength),
+		Description: truncate(first("og:description", "twitter:description", "description"), maxDescriptionLength),
+	}
+	if card.Title == "" {
+		card.Title = truncate(strings.Join(strings.Fields(title), " "), maxTitleLength)
+	}
+	if image := first("og:image:secure_url", "og:image", "og:image:url", "twitter:image", "twitter:image:src"); image != "" {
+		if imageURL, err := base.Parse(image); err == nil && (imageURL.Scheme == "http" || imageURL.Scheme == "https") {
+			card.ImageURL = imageURL.String()
+		}
+	}
+	return card
+}
+
+func truncate(text string, length int) string {
+	if utf8.RuneCountInString(text) <= length {
+		return text
+	}
+	runes := []rune(text)
+	return strings.TrimSpace(string(runes[:length-1])) + "…"
+}
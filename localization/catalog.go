@@ -0,0 +1,133 @@
+package localization
+
+// The catalog of every message we know how to translate.
+// Push notifications use the keys above, errors are keyed by the exact english text passed to ReturnError.
+// If you want to add a language, copy the "en" block and translate it. Anything missing falls back to english.
+var catalog = map[string]map[string]string{
+	"en": {
+		PushMention:    "Mentioned by @%s: %s",
+		PushFavourited: "@%s favourited your tweet: %s",
+		PushRetweeted:  "@%s: %s",
+		PushFollowed:   "@%s is now following you!",
+	},
+	"es": {
+		PushMention:    "Mencionado por @%s: %s",
+		PushFavourited: "@%s marcó tu tweet como favorito: %s",
+		PushRetweeted:  "@%s: %s",
+		PushFollowed:   "¡@%s ahora te sigue!",
+
+		"Missing authentication token.":     "Falta el token de autenticación.",
+		"Invalid authentication token.":     "Token de autenticación no válido.",
+		"Authentication token has expired.": "El token de autenticación ha caducado.",
+		"Wrong server for your login. Please verify your URLs match between applications.": "Servidor incorrecto para tu sesión. Comprueba que las URL coincidan entre aplicaciones.",
+		"App passwords required on this app":                                               "Esta aplicación requiere contraseñas de aplicación",
+		"Incorrect username":                                                               "Nombre de usuario incorrecto",
+		"Incorrect username/password.":                                                     "Nombre de usuario o contraseña incorrectos.",
+		"Expired token.":                                                                   "Token caducado.",
+		"Invalid token.":                                                                   "Token no válido.",
+		"Your account has been suspended. Check your email for details.":                   "Tu cuenta ha sido suspendida. Revisa tu correo electrónico para más detalles.",
+		"Two-factor authentication is required, use an app password.":                      "Se requiere autenticación en dos pasos, usa una contraseña de aplicación.",
+		"Rate limit exceeded contacting Bluesky. Please try again later.":                  "Se superó el límite de solicitudes a Bluesky. Inténtalo de nuevo más tarde.",
+		"Post was not found. (or was deleted)":                                             "No se encontró el tweet. (o fue eliminado)",
+		"User not found.":                                                                  "Usuario no encontrado.",
+		"An unknown error occured.":                                                        "Ocurrió un error desconocido.",
+		"An unknown error occured: %s":                                                     "Ocurrió un error desconocido: %s",
+		"ID not found.":                                                                    "No se encontró el ID.",
+		"ID not found. (%d)":                                                               "No se encontró el ID. (%d)",
+		"Invalid ID format":                                                                "Formato de ID no válido",
+		"Invalid ID format (%s)":                                                           "Formato de ID no válido (%s)",
+		"No user was specified":                                                            "No se especificó ningún usuario",
+		"push notifications are disabled on this server":                                   "las notificaciones push están desactivadas en este servidor",
+		"Skyglow Notifications is required for notifications":                              "Se requiere Skyglow Notifications para las notificaciones",
+	},
+	"fr": {
+		PushMention:    "Mentionné par @%s : %s",
+		PushFavourited: "@%s a ajouté votre tweet à ses favoris : %s",
+		PushRetweeted:  "@%s : %s",
+		PushFollowed:   "@%s vous suit désormais !",
+
+		"Missing authentication token.":     "Jeton d'authentification manquant.",
+		"Invalid authentication token.":     "Jeton d'authentification invalide.",
+		"Authentication token has expired.": "Le jeton d'authentification a expiré.",
+		"Wrong server for your login. Please verify your URLs match between applications.": "Mauvais serveur pour votre connexion. Vérifiez que les URL sont identiques entre les applications.",
+		"App passwords required on this app":                                               "Les mots de passe d'application sont requis sur cette application",
+		"Incorrect username":                                                               "Nom d'utilisateur incorrect",
+		"Incorrect username/password.":                                                     "Nom d'utilisateur ou mot de passe incorrect.",
+		"Expired token.":                                                                   "Jeton expiré.",
+		"Invalid token.":                                                                   "Jeton invalide.",
+		"Your account has been suspended. Check your email for details.":                   "Votre compte a été suspendu. Consultez vos e-mails pour plus de détails.",
+		"Two-factor authentication is required, use an app password.":                      "L'authentification à deux facteurs est requise, utilisez un mot de passe d'application.",
+		"Rate limit exceeded contacting Bluesky. Please try again later.":                  "Limite de requêtes vers Bluesky dépassée. Veuillez réessayer plus tard.",
+		"Post was not found. (or was deleted)":                                             "Le tweet est introuvable. (ou a été supprimé)",
+		"User not found.":                                                                  "Utilisateur introuvable.",
+		"An unknown error occured.":                                                        "Une erreur inconnue s'est produite.",
+		"An unknown error occured: %s":                                                     "Une erreur inconnue s'est produite : %s",
+		"ID not found.":                                                                    "ID introuvable.",
+		"ID not found. (%d)":                                                               "ID introuvable. (%d)",
+		"Invalid ID format":                                                                "Format d'ID invalide",
+		"Invalid ID format (%s)":                                                           "Format d'ID invalide (%s)",
+		"No user was specified":                                                            "Aucun utilisateur n'a été spécifié",
+		"push notifications are disabled on this server":                                   "les notifications push sont désactivées sur ce serveur",
+		"Skyglow Notifications is required for notifications":                              "Skyglow Notifications est requis pour les notifications",
+	},
+	"de": {
+		PushMention:    "Erwähnt von @%s: %s",
+		PushFavourited: "@%s hat deinen Tweet favorisiert: %s",
+		PushRetweeted:  "@%s: %s",
+		PushFollowed:   "@%s folgt dir jetzt!",
+
+		"Missing authentication token.":     "Authentifizierungstoken fehlt.",
+		"Invalid authentication token.":     "Ungültiges Authentifizierungstoken.",
+		"Authentication token has expired.": "Das Authentifizierungstoken ist abgelaufen.",
+		"Wrong server for your login. Please verify your URLs match between applications.": "Falscher Server für deine Anmeldung. Bitte prüfe, ob die URLs in allen Apps übereinstimmen.",
+		"App passwords required on this app":                                               "Für diese App sind App-Passwörter erforderlich",
+		"Incorrect username":                                                               "Falscher Benutzername",
+		"Incorrect username/password.":                                                     "Falscher Benutzername oder falsches Passwort.",
+		"Expired token.":                                                                   "Token abgelaufen.",
+		"Invalid token.":                                                                   "Ungültiges Token.",
+		"Your account has been suspended. Check your email for details.":                   "Dein Account wurde gesperrt. Details findest du in deinen E-Mails.",
+		"Two-factor authentication is required, use an app password.":                      "Zwei-Faktor-Authentifizierung ist erforderlich, verwende ein App-Passwort.",
+		"Rate limit exceeded contacting Bluesky. Please try again later.":                  "Anfragelimit bei Bluesky überschritten. Bitte versuche es später erneut.",
+		"Post was not found. (or was deleted)":                                             "Der Tweet wurde nicht gefunden. (oder wurde gelöscht)",
+		"User not found.":                                                                  "Benutzer nicht gefunden.",
+		"An unknown error occured.":                                                        "Ein unbekannter Fehler ist aufgetreten.",
+		"An unknown error occured: %s":                                                     "Ein unbekannter Fehler ist aufgetreten: %s",
+		"ID not found.":                                                                    "ID nicht gefunden.",
+		"ID not found. (%d)":                                                               "ID nicht gefunden. (%d)",
+		"Invalid ID format":                                                                "Ungültiges ID-Format",
+		"Invalid ID format (%s)":                                                           "Ungültiges ID-Format (%s)",
+		"No user was specified":                                                            "Es wurde kein Benutzer angegeben",
+		"push notifications are disabled on this server":                                   "Push-Benachrichtigungen sind auf diesem Server deaktiviert",
+		"Skyglow Notifications is required for notifications":                              "Für Benachrichtigungen wird Skyglow Notifications benötigt",
+	},
+	"ja": {
+		PushMention:    "@%sさんがあなたについてツイートしました: %s",
+		PushFavourited: "@%sさんがあなたのツイートをお気に入りに登録しました: %s",
+		PushRetweeted:  "@%s: %s",
+		PushFollowed:   "@%sさんにフォローされました!",
+
+		"Missing authentication token.":     "認証トークンがありません。",
+		"Invalid authentication token.":     "認証トークンが無効です。",
+		"Authentication token has expired.": "認証トークンの有効期限が切れました。",
+		"Wrong server for your login. Please verify your URLs match between applications.": "ログイン先のサーバーが違います。アプリ間でURLが一致しているか確認してください。",
+		"App passwords required on this app":                                               "このアプリではアプリパスワードが必要です",
+		"Incorrect username":                                                               "ユーザー名が正しくありません",
+		"Incorrect username/password.":                                                     "ユーザー名またはパスワードが正しくありません。",
+		"Expired token.":                                                                   "トークンの有効期限が切れました。",
+		"Invalid token.":                                                                   "トークンが無効です。",
+		"Your account has been suspended. Check your email for details.":                   "アカウントは凍結されています。詳細はメールを確認してください。",
+		"Two-factor authentication is required, use an app password.":                      "2段階認証が必要です。アプリパスワードを使用してください。",
+		"Rate limit exceeded contacting Bluesky. Please try again later.":                  "Blueskyへのリクエスト制限を超えました。しばらくしてからもう一度お試しください。",
+		"Post was not found. (or was deleted)":                                             "ツイートが見つかりません。(削除された可能性があります)",
+		"User not found.":                                                                  "ユーザーが見つかりません。",
+		"An unknown error occured.":                                                        "不明なエラーが発生しました。",
+		"An unknown error occured: %s":                                                     "不明なエラーが発生しました: %s",
+		"ID not found.":                                                                    "IDが見つかりません。",
+		"ID not found. (%d)":                                                               "IDが見つかりません。 (%d)",
+		"Invalid ID format":                                                                "IDの形式が正しくありません",
+		"Invalid ID format (%s)":                                                           "IDの形式が正しくありません (%s)",
+		"No user was specified":                                                            "ユーザーが指定されていません",
+		"push notifications are disabled on this server":                                   "このサーバーではプッシュ通知が無効になっています",
+		"Skyglow Notifications is required for notifications":                              "通知を受け取るにはSkyglow Notificationsが必要です",
+	},
+}
@@ -0,0 +1,105 @@
+package localization
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const DefaultLanguage = "en"
+
+// Message keys for push notifications. Error messages don't get keys, they are looked up by their english text
+// so that ReturnError calls stay readable.
+// These are also what NOTIFICATION_LOC_KEYS is keyed by, so no dots (viper treats them as nesting).
+const (
+	PushMention    = "push_mention"
+	PushFavourited = "push_favourited"
+	PushRetweeted  = "push_retweeted"
+	PushFollowed   = "push_followed"
+)
+
+// Translate looks up a message in the catalog for a language, and formats it with args.
+// If we don't have a translation, it falls back to english, and then to the key itself.
+func Translate(lang string, key string, args ...interface{}) string {
+	format, ok := catalog[lang][key]
+	if !ok {
+		format, ok = catalog[DefaultLanguage][key]
+	}
+	if !ok {
+		format = key
+	}
+
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// IsSupported checks if we have a catalog for a language.
+func IsSupported(lang string) bool {
+	_, ok := catalog[lang]
+	return ok
+}
+
+// NormalizeLanguage turns things like "fr-FR", "pt_BR" or "zh-Hans" into the languages our catalog uses.
+// Returns an empty string if we don't support the language.
+func NormalizeLanguage(lang string) string {
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	lang = strings.ReplaceAll(lang, "_", "-")
+	if lang == "" {
+		return ""
+	}
+	if IsSupported(lang) {
+		return lang
+	}
+	base := strings.SplitN(lang, "-", 2)[0]
+	if IsSupported(base) {
+		return base
+	}
+	return ""
+}
+
+// FromAcceptLanguage picks the best language we support from an Accept-Language header.
+// ex. "fr-FR,fr;q=0.9,en;q=0.8". Returns an empty string if we don't support any of them.
+func FromAcceptLanguage(header string) string {
+	type weighted struct {
+		lang   string
+		weight float64
+	}
+	var langs []weighted
+	for _, part := range strings.Split(header, ",") {
+		pieces := strings.Split(strings.TrimSpace(part), ";")
+		weight := 1.0
+		for _, param := range pieces[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil {
+					weight = q
+				}
+			}
+		}
+		langs = append(langs, weighted{lang: pieces[0], weight: weight})
+	}
+	sort.SliceStable(langs, func(i, j int) bool {
+		return langs[i].weight > langs[j].weight
+	})
+
+	for _, l := range langs {
+		if normalized := NormalizeLanguage(l.lang); normalized != "" {
+			return normalized
+		}
+	}
+	return ""
+}
+
+// PickLanguage returns the first supported language out of the given candidates (most preferred first),
+// or english if none of them are supported.
+func PickLanguage(candidates ...string) string {
+	for _, candidate := range candidates {
+		if normalized := NormalizeLanguage(candidate); normalized != "" {
+			return normalized
+		}
+	}
+	return DefaultLanguage
+}
@@ -0,0 +1,147 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// Structured logging for the whole bridge.
+// Every package gets its own logger from For, which tags everything it logs with the subsystem, and with the
+// request ID if it's logged with a request's context. Everything goes through redaction first (see redact.go),
+// so tokens & passwords don't end up in logs, even in developer mode.
+
+// root is where logs actually get written. It's swapped out by Init, which runs after the package level
+// loggers have been made, so loggers look it up every time they log instead of holding on to it.
+var root atomic.Pointer[slog.Handler]
+
+func init() {
+	setRoot(newHandler(os.Stdout, "text", slog.LevelInfo))
+	slog.SetDefault(slog.New(&redactingHandler{}))
+}
+
+func setRoot(handler slog.Handler) {
+	root.Store(&handler)
+}
+
+// Init sets up logging from the config.
+// format is text or json, level is debug, info, warn or error (empty is info, or debug in developer mode).
+func Init(format string, level string, developerMode bool) error {
+	var slogLevel slog.Level
+	switch strings.ToLower(level) {
+	case "":
+		slogLevel = slog.LevelInfo
+		if developerMode {
+			slogLevel = slog.LevelDebug
+		}
+	case "debug":
+		slogLevel = slog.LevelDebug
+	case "info":
+		slogLevel = slog.LevelInfo
+	case "warn", "warning":
+		slogLevel = slog.LevelWarn
+	case "error":
+		slogLevel = slog.LevelError
+	default:
+		return fmt.Errorf("unknown log level %q", level)
+	}
+
+	format = strings.ToLower(format)
+	if format != "" && format != "text" && format != "json" {
+		return fmt.Errorf("unknown log format %q", format)
+	}
+
+	setRoot(newHandler(os.Stdout, format, slogLevel))
+	return nil
+}
+
+func newHandler(w io.Writer, format string, level slog.Level) slog.Handler {
+	options := &slog.HandlerOptions{
+		Level:     level,
+		AddSource: true,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			// the full path is long and the same on every line, the file's enough
+			if a.Key == slog.SourceKey && len(groups) == 0 {
+				if source, ok := a.Value.Any().(*slog.Source); ok {
+					return slog.String(slog.SourceKey, fmt.Sprintf("%s/%s:%d", filepath.Base(filepath.Dir(source.File)), filepath.Base(source.File), source.Line))
+				}
+			}
+			return a
+		},
+	}
+	if format == "json" {
+		return slog.NewJSONHandler(w, options)
+	}
+	return slog.NewTextHandler(w, options)
+}
+
+// For gets the logger for a subsystem (ex. "bluesky", "notifications").
+func For(subsystem string) *slog.Logger {
+	return slog.New(&redactingHandler{}).With("subsystem", subsystem)
+}
+
+type requestIDKey struct{}
+
+// WithRequestID adds a request ID to a context. Anything logged with that context gets it.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID gets the request ID out of a context, or "" if there isn't one.
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// redactingHandler redacts everything, then passes it on to the root handler.
+type redactingHandler struct {
+	// With & WithGroup calls, replayed on the root handler when we log.
+	ops []func(slog.Handler) slog.Handler
+}
+
+func (h *redactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return (*root.Load()).Enabled(ctx, level)
+}
+
+func (h *redactingHandler) Handle(ctx context.Context, record slog.Record) error {
+	handler := *root.Load()
+	for _, op := range h.ops {
+		handler = op(handler)
+	}
+
+	redacted := slog.NewRecord(record.Time, record.Level, Redact(record.Message), record.PC)
+	record.Attrs(func(a slog.Attr) bool {
+		redacted.AddAttrs(redactAttr(a))
+		return true
+	})
+	if id := RequestID(ctx); id != "" {
+		redacted.AddAttrs(slog.String("request_id", id))
+	}
+	return handler.Handle(ctx, redacted)
+}
+
+func (h *redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = redactAttr(a)
+	}
+	return h.with(func(handler slog.Handler) slog.Handler { return handler.WithAttrs(redacted) })
+}
+
+func (h *redactingHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(handler slog.Handler) slog.Handler { return handler.WithGroup(name) })
+}
+
+func (h *redactingHandler) with(op func(slog.Handler) slog.Handler) *redactingHandler {
+	ops := make([]func(slog.Handler) slog.Handler, len(h.ops), len(h.ops)+1)
+	copy(ops, h.ops)
+	return &redactingHandler{ops: append(ops, op)}
+}
@@ -0,0 +1,79 @@
+package logging
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// Anything that looks like a secret gets masked before it's logged.
+// This goes by what the value looks like, since secrets end up in errors & response bodies, not just where we expect them.
+var redactions = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	// JWTs (bluesky access/refresh tokens, and our own oauth tokens)
+	{regexp.MustCompile(`eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`), "[REDACTED JWT]"},
+	// Authorization headers
+	{regexp.MustCompile(`\b(Basic|Bearer) [A-Za-z0-9+/=._~-]{8,}`), "$1 [REDACTED]"},
+	// OAuth header params, and the same in query strings
+	{regexp.MustCompile(`\b(oauth_token|oauth_signature|oauth_verifier)="[^"]*"`), `$1="[REDACTED]"`},
+	{regexp.MustCompile(`\b(oauth_token|oauth_signature|oauth_verifier)=[^&\s",]+`), "$1=[REDACTED]"},
+	// App passwords (xxxx-xxxx-xxxx-xxxx)
+	{regexp.MustCompile(`\b[a-zA-Z0-9]{4}-[a-zA-Z0-9]{4}-[a-zA-Z0-9]{4}-[a-zA-Z0-9]{4}\b`), "[REDACTED APP PASSWORD]"},
+	// Device tokens, 32 bytes as base64 or hex
+	{regexp.MustCompile(`\b[A-Za-z0-9+/]{43}=`), "[REDACTED DEVICE TOKEN]"},
+	{regexp.MustCompile(`\b[0-9a-fA-F]{64}\b`), "[REDACTED DEVICE TOKEN]"},
+}
+
+// Attributes with these keys are always masked, whatever they look like.
+var sensitiveKeys = map[string]bool{
+	"authorization":  true,
+	"password":       true,
+	"app_password":   true,
+	"access_jwt":     true,
+	"refresh_jwt":    true,
+	"token":          true,
+	"device_token":   true,
+	"secret":         true,
+	"encryption_key": true,
+}
+
+// Redact masks anything in s that looks like a token or password.
+func Redact(s string) string {
+	for _, redaction := range redactions {
+		s = redaction.pattern.ReplaceAllString(s, redaction.replacement)
+	}
+	return s
+}
+
+func redactAttr(a slog.Attr) slog.Attr {
+	if sensitiveKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, "[REDACTED]")
+	}
+
+	value := a.Value.Resolve()
+	switch value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, Redact(value.String()))
+	case slog.KindGroup:
+		group := value.Group()
+		redacted := make([]any, len(group))
+		for i, member := range group {
+			redacted[i] = redactAttr(member)
+		}
+		return slog.Group(a.Key, redacted...)
+	case slog.KindAny:
+		// errors & anything printable could have a token in them (ex. a response body)
+		switch v := value.Any().(type) {
+		case error:
+			return slog.String(a.Key, Redact(v.Error()))
+		case fmt.Stringer:
+			return slog.String(a.Key, Redact(v.String()))
+		case []byte:
+			return slog.String(a.Key, Redact(string(v)))
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: value}
+}
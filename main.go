@@ -1,17 +1,24 @@
 package main
 
 import (
-	"fmt"
+	"context"
 	_ "net/http/pprof"
+	"os"
+	"time"
+	_ "time/tzdata" // the docker image may not have zoneinfo, which is needed for sleep time
 
+	blueskyapi "github.com/Preloading/TwitterAPIBridge/bluesky"
+	"github.com/Preloading/TwitterAPIBridge/bridge"
 	"github.com/Preloading/TwitterAPIBridge/config"
 	"github.com/Preloading/TwitterAPIBridge/db_controller"
+	"github.com/Preloading/TwitterAPIBridge/logging"
 	"github.com/Preloading/TwitterAPIBridge/notifications"
 	"github.com/Preloading/TwitterAPIBridge/twitterv1"
 )
 
 var (
 	configData *config.Config
+	log        = logging.For("main")
 )
 
 func main() {
@@ -23,19 +30,36 @@ func main() {
 	var err error
 	configData, err = config.LoadConfig()
 	if err != nil {
-		fmt.Printf("Error loading config: %v\n", err)
+		log.Error("Error loading config", "error", err)
 		return
 	}
+	if err := logging.Init(configData.LogFormat, configData.LogLevel, configData.DeveloperMode); err != nil {
+		log.Error("Error setting up logging", "error", err)
+		return
+	}
+
+	// Admin commands (migrate, tokens revoke, etc), see cli.go
+	if len(os.Args) > 1 {
+		os.Exit(runAdminCommand(*configData, os.Args[1:]))
+	}
 
 	if configData.SecretKey == "" {
-		fmt.Println("The JWT Secret key must be set in config.yaml.")
+		log.Error("The JWT Secret key must be set in config.yaml.")
 		return
 	} else if len(configData.SecretKey) < 32 {
-		fmt.Println("The JWT Secret key must be 32 bytes long")
+		log.Error("The JWT Secret key must be 32 bytes long")
 		return
 	}
 
 	db_controller.InitDB(*configData)
+	blueskyapi.InitConfig(configData)
+	bridge.StartPeriodicIDFlusher(5 * time.Second)
 	go notifications.RunNotifications(*configData)
 	twitterv1.InitServer(configData)
+
+	// InitServer returns once the server has shut down, write out anything we're still holding
+	if err := bridge.FlushIDMappings(context.Background()); err != nil {
+		log.Error("Error flushing ID mappings", "error", err)
+	}
+	db_controller.StopAnalytics(10 * time.Second)
 }
@@ -0,0 +1,57 @@
+package metrics
+
+// Every metric the bridge reports. They live here (and not next to the code they measure)
+// so any package can use them without import cycles.
+
+var (
+	HTTPRequests = NewCounterVec("twitterbridge_http_requests_total",
+		"HTTP requests handled, by route, method, status code and client (from X-Twitter-Client, see METRICS_CLIENTS).",
+		"route", "method", "status", "client")
+	HTTPRequestDuration = NewHistogramVec("twitterbridge_http_request_duration_seconds",
+		"How long HTTP requests took, by route and client.",
+		nil, "route", "client")
+
+	XRPCRequests = NewCounterVec("twitterbridge_xrpc_requests_total",
+		"Requests made to bluesky, by XRPC method, status code and the XRPC error name (if there was one).",
+		"method", "status", "error")
+	XRPCRequestDuration = NewHistogramVec("twitterbridge_xrpc_request_duration_seconds",
+		"How long requests to bluesky took, by XRPC method.",
+		nil, "method")
+
+	TokenRefreshes = NewCounterVec("twitterbridge_token_refreshes_total",
+		"Bluesky session refreshes, by result (success, failure, expired if the refresh token was too old, or raced if another request already refreshed it).",
+		"result")
+
+	CacheRequests = NewCounterVec("twitterbridge_cache_requests_total",
+		"Cache lookups, by cache and result (hit or miss).",
+		"cache", "result")
+
+	ShortLinkCollisions = NewCounterVec("twitterbridge_short_link_collisions_total",
+		"Short codes that were already taken by a different URL, so the next one was tried, by prefix.",
+		"prefix")
+
+	CDNResizeDuration = NewHistogramVec("twitterbridge_cdn_resize_duration_seconds",
+		"How long resizing an image in the CDN proxy took, by resize mode.",
+		nil, "mode")
+
+	JetstreamLag = NewGauge("twitterbridge_jetstream_lag_seconds",
+		"How far behind the last jetstream event we processed was.")
+	JetstreamEvents = NewCounterVec("twitterbridge_jetstream_events_total",
+		"Jetstream events received, by collection.",
+		"collection")
+
+	MaintenanceRowsDeleted = NewCounterVec("twitterbridge_maintenance_rows_deleted_total",
+		"Rows deleted by database maintenance, by table.",
+		"table")
+	MaintenanceErrors = NewCounterVec("twitterbridge_maintenance_errors_total",
+		"Database maintenance runs that failed to prune a table, by table.",
+		"table")
+	MaintenanceDuration = NewGauge("twitterbridge_maintenance_last_duration_seconds",
+		"How long the last database maintenance run took.")
+	MaintenanceLastRun = NewGauge("twitterbridge_maintenance_last_run_timestamp_seconds",
+		"When the last database maintenance run finished, as a unix timestamp.")
+
+	PushNotifications = NewCounterVec("twitterbridge_push_notifications_total",
+		"Push notifications, by type and result (sent, failed, or quiet_hours if they were dropped for the user's sleep time).",
+		"type", "result")
+)
@@ -0,0 +1,344 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// A tiny Prometheus client. We only need counters, histograms and gauges in the text format,
+// which isn't worth pulling in the whole prometheus client (and all its dependencies) for.
+// See https://prometheus.io/docs/instrumenting/exposition_formats/
+
+// How many label combinations a metric can have. Label values shouldn't come straight from clients, but in case one
+// slips through, past this everything new gets counted under "other" instead of growing forever.
+const maxSeries = 1000
+
+// DefaultBuckets are latency buckets in seconds, same as the prometheus client's.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metric interface {
+	name() string
+	write(w io.Writer)
+}
+
+var (
+	registry      []metric
+	registryMutex sync.Mutex
+)
+
+func register(m metric) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	for _, existing := range registry {
+		if existing.name() == m.name() {
+			panic("metric registered twice: " + m.name())
+		}
+	}
+	registry = append(registry, m)
+}
+
+// WritePrometheus writes every metric in the prometheus text format.
+func WritePrometheus(w io.Writer) {
+	registryMutex.Lock()
+	metrics := make([]metric, len(registry))
+	copy(metrics, registry)
+	registryMutex.Unlock()
+
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].name() < metrics[j].name() })
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// ContentType is what /metrics should be served as.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// -- Counters --
+
+type CounterVec struct {
+	metricName string
+	help       string
+	labels     []string
+	mutex      sync.RWMutex
+	series     map[string]*counterSeries
+}
+
+type counterSeries struct {
+	labelValues []string
+	value       atomic.Uint64 // float64 bits
+}
+
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		metricName: name,
+		help:       help,
+		labels:     labels,
+		series:     make(map[string]*counterSeries),
+	}
+	register(c)
+	return c
+}
+
+// Inc adds 1 to the counter with these label values (in the same order as the labels).
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Add(value float64, labelValues ...string) {
+	s := getSeries(&c.mutex, c.series, c.labels, labelValues, func(values []string) *counterSeries {
+		return &counterSeries{labelValues: values}
+	})
+	addFloat(&s.value, value)
+}
+
+func (c *CounterVec) name() string { return c.metricName }
+
+func (c *CounterVec) write(w io.Writer) {
+	writeHeader(w, c.metricName, c.help, "counter")
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	for _, key := range sortedKeys(c.series) {
+		s := c.series[key]
+		writeSample(w, c.metricName, c.labels, s.labelValues, "", "", math.Float64frombits(s.value.Load()))
+	}
+}
+
+// -- Gauges --
+
+type Gauge struct {
+	metricName string
+	help       string
+	value      atomic.Uint64 // float64 bits
+}
+
+func NewGauge(name string, help string) *Gauge {
+	g := &Gauge{metricName: name, help: help}
+	register(g)
+	return g
+}
+
+func (g *Gauge) Set(value float64) {
+	g.value.Store(math.Float64bits(value))
+}
+
+func (g *Gauge) name() string { return g.metricName }
+
+func (g *Gauge) write(w io.Writer) {
+	writeHeader(w, g.metricName, g.help, "gauge")
+	writeSample(w, g.metricName, nil, nil, "", "", math.Float64frombits(g.value.Load()))
+}
+
+// funcMetric is a gauge or counter whose value comes from somewhere else (ex. the analytics counters).
+type funcMetric struct {
+	metricName string
+	help       string
+	metricType string
+	fn         func() float64
+}
+
+// NewGaugeFunc registers a gauge that calls fn every time /metrics is scraped.
+func NewGaugeFunc(name string, help string, fn func() float64) {
+	register(&funcMetric{metricName: name, help: help, metricType: "gauge", fn: fn})
+}
+
+// NewCounterFunc registers a counter that calls fn every time /metrics is scraped. fn should only ever go up.
+func NewCounterFunc(name string, help string, fn func() float64) {
+	register(&funcMetric{metricName: name, help: help, metricType: "counter", fn: fn})
+}
+
+func (f *funcMetric) name() string { return f.metricName }
+
+func (f *funcMetric) write(w io.Writer) {
+	writeHeader(w, f.metricName, f.help, f.metricType)
+	writeSample(w, f.metricName, nil, nil, "", "", f.fn())
+}
+
+// -- Histograms --
+
+type HistogramVec struct {
+	metricName string
+	help       string
+	labels     []string
+	buckets    []float64
+	mutex      sync.RWMutex
+	series     map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labelValues []string
+	mutex       sync.Mutex
+	counts      []uint64 // per bucket, not cumulative
+	count       uint64
+	sum         float64
+}
+
+// NewHistogramVec makes a histogram. buckets are upper bounds, in order, and nil means DefaultBuckets.
+func NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	h := &HistogramVec{
+		metricName: name,
+		help:       help,
+		labels:     labels,
+		buckets:    buckets,
+		series:     make(map[string]*histogramSeries),
+	}
+	register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	s := getSeries(&h.mutex, h.series, h.labels, labelValues, func(values []string) *histogramSeries {
+		return &histogramSeries{labelValues: values, counts: make([]uint64, len(h.buckets))}
+	})
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i, upperBound := range h.buckets {
+		if value <= upperBound {
+			s.counts[i]++
+			break
+		}
+	}
+	s.count++
+	s.sum += value
+}
+
+func (h *HistogramVec) name() string { return h.metricName }
+
+func (h *HistogramVec) write(w io.Writer) {
+	writeHeader(w, h.metricName, h.help, "histogram")
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		s.mutex.Lock()
+		var cumulative uint64
+		for i, upperBound := range h.buckets {
+			cumulative += s.counts[i]
+			writeSample(w, h.metricName+"_bucket", h.labels, s.labelValues, "le", formatFloat(upperBound), float64(cumulative))
+		}
+		writeSample(w, h.metricName+"_bucket", h.labels, s.labelValues, "le", "+Inf", float64(s.count))
+		writeSample(w, h.metricName+"_sum", h.labels, s.labelValues, "", "", s.sum)
+		writeSample(w, h.metricName+"_count", h.labels, s.labelValues, "", "", float64(s.count))
+		s.mutex.Unlock()
+	}
+}
+
+// -- Helpers --
+
+// getSeries finds (or makes) the series for some label values.
+func getSeries[S any](mutex *sync.RWMutex, series map[string]*S, labels []string, labelValues []string, create func([]string) *S) *S {
+	if len(labelValues) != len(labels) {
+		panic(fmt.Sprintf("expected %d label values, got %d", len(labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	mutex.RLock()
+	s, ok := series[key]
+	mutex.RUnlock()
+	if ok {
+		return s
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if s, ok := series[key]; ok {
+		return s
+	}
+	if len(series) >= maxSeries {
+		labelValues = make([]string, len(labels))
+		for i := range labelValues {
+			labelValues[i] = "other"
+		}
+		key = strings.Join(labelValues, "\xff")
+		if s, ok := series[key]; ok {
+			return s
+		}
+	}
+	// copy the values, fiber's strings point into buffers that get reused after the request
+	copied := make([]string, len(labelValues))
+	for i, value := range labelValues {
+		copied[i] = strings.Clone(value)
+	}
+	s = create(copied)
+	series[strings.Clone(key)] = s
+	return s
+}
+
+func addFloat(value *atomic.Uint64, delta float64) {
+	for {
+		old := value.Load()
+		updated := math.Float64bits(math.Float64frombits(old) + delta)
+		if value.CompareAndSwap(old, updated) {
+			return
+		}
+	}
+}
+
+func sortedKeys[S any](series map[string]*S) []string {
+	keys := make([]string, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func writeHeader(w io.Writer, name string, help string, metricType string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, metricType)
+}
+
+func writeSample(w io.Writer, name string, labels []string, labelValues []string, extraLabel string, extraValue string, value float64) {
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 || extraLabel != "" {
+		b.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			writeLabel(&b, label, labelValues[i])
+		}
+		if extraLabel != "" {
+			if len(labels) > 0 {
+				b.WriteByte(',')
+			}
+			writeLabel(&b, extraLabel, extraValue)
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(formatFloat(value))
+	b.WriteByte('\n')
+	io.WriteString(w, b.String())
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func writeLabel(b *strings.Builder, label string, value string) {
+	b.WriteString(label)
+	b.WriteString(`="`)
+	b.WriteString(labelEscaper.Replace(value))
+	b.WriteByte('"')
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+// Not registered, so tests can make as many as they like (and run more than once).
+
+func testCounter(labels ...string) *CounterVec {
+	return &CounterVec{metricName: "test_total", help: "Test.", labels: labels, series: map[string]*counterSeries{}}
+}
+
+func testHistogram(buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{metricName: "test_seconds", help: "Test.", labels: labels, buckets: buckets, series: map[string]*histogramSeries{}}
+}
+
+func written(m metric) string {
+	var b strings.Builder
+	m.write(&b)
+	return b.String()
+}
+
+func TestCounterEscaping(t *testing.T) {
+	c := testCounter("route", "client")
+	c.metricName, c.help = "escaped_total", "Help with a \\ and a\nnewline."
+	c.Inc("/1/statuses/show/:id.json", `say "hi"`)
+	c.Add(2.5, `back\slash`, "new\nline")
+
+	want := `# HELP escaped_total Help with a \\ and a\nnewline.
+# TYPE escaped_total counter
+escaped_total{route="/1/statuses/show/:id.json",client="say \"hi\""} 1
+escaped_total{route="back\\slash",client="new\nline"} 2.5
+`
+	if got := written(c); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := testHistogram([]float64{0.1, 1, 10}, "mode")
+	for _, value := range []float64{0.05, 0.1, 0.5, 5, 50} {
+		h.Observe(value, "fit")
+	}
+
+	want := `# HELP test_seconds Test.
+# TYPE test_seconds histogram
+test_seconds_bucket{mode="fit",le="0.1"} 2
+test_seconds_bucket{mode="fit",le="1"} 3
+test_seconds_bucket{mode="fit",le="10"} 4
+test_seconds_bucket{mode="fit",le="+Inf"} 5
+test_seconds_sum{mode="fit"} 55.65
+test_seconds_count{mode="fit"} 5
+`
+	if got := written(h); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+// Past maxSeries label combinations, new ones are all counted as "other", and the old ones keep counting.
+func TestSeriesCap(t *testing.T) {
+	c := testCounter("client", "status")
+	for i := range maxSeries + 50 {
+		c.Inc(fmt.Sprint("client", i), "200")
+	}
+	c.Inc("client0", "200")
+
+	if len(c.series) != maxSeries+1 {
+		t.Errorf("%d series, want %d and other", len(c.series), maxSeries)
+	}
+	page := written(c)
+	if !strings.Contains(page, `test_total{client="other",status="other"} 50`+"\n") {
+		t.Error(`the extra series weren't counted as "other"`)
+	}
+	if !strings.Contains(page, `test_total{client="client0",status="200"} 2`+"\n") {
+		t.Error("a series from before the cap stopped counting")
+	}
+
+	h := testHistogram(nil, "client")
+	for i := range maxSeries * 2 {
+		h.Observe(1, fmt.Sprint("client", i))
+	}
+	if len(h.series) != maxSeries+1 {
+		t.Errorf("histogram has %d series", len(h.series))
+	}
+}
+
+func TestFormatFloat(t *testing.T) {
+	for value, want := range map[float64]string{0: "0", 1: "1", 0.25: "0.25", 1e21: "1e+21", math.Inf(1): "+Inf", math.Inf(-1): "-Inf", math.NaN(): "NaN"} {
+		if got := formatFloat(value); got != want {
+			t.Errorf("%v is %q, want %q", value, got, want)
+		}
+	}
+}
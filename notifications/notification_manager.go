@@ -1,6 +1,7 @@
 package notifications
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"slices"
@@ -12,6 +13,9 @@ import (
 	blueskyapi "github.com/Preloading/TwitterAPIBridge/bluesky"
 	"github.com/Preloading/TwitterAPIBridge/config"
 	"github.com/Preloading/TwitterAPIBridge/db_controller"
+	"github.com/Preloading/TwitterAPIBridge/localization"
+	"github.com/Preloading/TwitterAPIBridge/logging"
+	"github.com/Preloading/TwitterAPIBridge/metrics"
 	"github.com/Preloading/TwitterAPIBridge/twitterv1"
 	"golang.org/x/net/websocket"
 )
@@ -53,12 +57,16 @@ var (
 	// posterDIDs                  []string
 	lastUpdatedNotificationTime            time.Time
 	lastCheckedForPushNotificationFeedback time.Time
+	locKeys                                map[string]string
+
+	log = logging.For("notifications")
 )
 
 func RunNotifications(cfg config.Config) {
 	if cfg.NotificationTrustedServer == "" {
 		return
 	}
+	locKeys = cfg.NotificationLocKeys
 	sgn.ConfigureSession(cfg.NotificationTrustedServer) // todo make this configureable
 
 	incomingMessages := make(chan JetstreamPostOutput)
@@ -155,7 +163,7 @@ func RunNotifications(cfg config.Config) {
 		for {
 			ws, err := websocket.Dial("wss://jetstream1.us-east.bsky.network/subscribe?wantedCollections=app.bsky.feed.post&wantedCollections=app.bsky.feed.like&wantedCollections=app.bsky.feed.repost&wantedCollections=app.bsky.graph.follow", "", "https://jetstream1.us-east.bsky.network/subscribe?wantedCollections=app.bsky.feed.post")
 			if err != nil {
-				fmt.Printf("Jetstream dial failed: %v\n", err)
+				log.Warn("Jetstream dial failed", "error", err)
 				time.Sleep(5 * time.Second)
 				continue
 			}
@@ -163,14 +171,19 @@ func RunNotifications(cfg config.Config) {
 			// read until error / disconnect; when readJetstreamMessages returns, close and retry
 			readJetstreamMessages(ws, incomingMessages)
 			if err := ws.Close(); err != nil {
-				fmt.Printf("Error closing Jetstream ws: %v\n", err)
+				log.Warn("Error closing Jetstream websocket", "error", err)
 			}
-			fmt.Println("Jetstream disconnected; reconnecting in 3s")
+			log.Info("Jetstream disconnected, reconnecting in 3s")
 			time.Sleep(3 * time.Second)
 		}
 	}()
 
 	for message := range incomingMessages {
+		if message.TimeUS > 0 {
+			metrics.JetstreamLag.Set(time.Since(time.UnixMicro(message.TimeUS)).Seconds())
+		}
+		metrics.JetstreamEvents.Inc(message.Commit.Collection)
+
 		if message.Commit.Operation != "create" {
 			continue
 		}
@@ -193,13 +206,13 @@ func RunNotifications(cfg config.Config) {
 
 							// check if the mention is in our list of people subscribed to mention notifications
 							if slices.Contains(mentionedDIDs, feature.Did) {
-								fmt.Printf("New Jetstream Message (mention): %+v\n", record)
+								log.Debug("New Jetstream message", "kind", "mention", "did", message.DID, "rkey", message.Commit.RKey)
 								go sendPushNotificationForPost(feature.Did, "mention", message.DID, message.Commit.RKey, nil)
 							}
 
 							// now lets do the follower check
 							if slices.Contains(mentionedFollowingOnlyDIDs, feature.Did) {
-								fmt.Printf("New Jetstream Message (mentioned following): %+v\n", record)
+								log.Debug("New Jetstream message", "kind", "mentioned_following", "did", message.DID, "rkey", message.Commit.RKey)
 								go sendPushNotificationForPost(feature.Did, "mention_following", message.DID, message.Commit.RKey, nil)
 							}
 						}
@@ -219,12 +232,12 @@ func RunNotifications(cfg config.Config) {
 				didOfPoster := splitURI[2]
 
 				if slices.Contains(favouritesDIDs, didOfPoster) {
-					fmt.Printf("New Jetstream Message (liked): %+v\n", record)
+					log.Debug("New Jetstream message", "kind", "liked", "did", message.DID, "rkey", message.Commit.RKey)
 					go sendPushNotificationForPost(didOfPoster, "liked", message.DID, splitURI[4], nil)
 				}
 
 				if slices.Contains(favouritesFollowingOnlyDIDs, didOfPoster) {
-					fmt.Printf("New Jetstream Message (liked_following): %+v\n", record)
+					log.Debug("New Jetstream message", "kind", "liked_following", "did", message.DID, "rkey", message.Commit.RKey)
 					go sendPushNotificationForPost(didOfPoster, "liked_following", message.DID, splitURI[4], nil)
 				}
 
@@ -241,12 +254,12 @@ func RunNotifications(cfg config.Config) {
 				didOfPoster := splitURI[2]
 
 				if slices.Contains(retweetDIDs, didOfPoster) {
-					fmt.Printf("New Jetstream Message (retweet): %+v\n", record)
+					log.Debug("New Jetstream message", "kind", "retweet", "did", message.DID, "rkey", message.Commit.RKey)
 					go sendPushNotificationForPost(didOfPoster, "retweet", message.DID, splitURI[4], nil)
 				}
 
 				if slices.Contains(retweetFollowingOnlyDIDs, didOfPoster) {
-					fmt.Printf("New Jetstream Message (retweet_following): %+v\n", record)
+					log.Debug("New Jetstream message", "kind", "retweet_following", "did", message.DID, "rkey", message.Commit.RKey)
 					go sendPushNotificationForPost(didOfPoster, "retweet_following", message.DID, splitURI[4], nil)
 				}
 
@@ -264,7 +277,7 @@ func RunNotifications(cfg config.Config) {
 				}
 
 				if slices.Contains(newFollowers, subject) {
-					fmt.Printf("New Jetstream Message (retweet): %+v\n", record)
+					log.Debug("New Jetstream message", "kind", "follow", "did", message.DID, "rkey", message.Commit.RKey)
 					go sendPushNotificationForPost(subject, "follow", message.DID, "", nil)
 				}
 
@@ -281,7 +294,7 @@ func readJetstreamMessages(ws *websocket.Conn, incomingMessages chan JetstreamPo
 		err := websocket.JSON.Receive(ws, &message)
 		// err := websocket.Message.Receive(ws, &message)
 		if err != nil {
-			fmt.Printf("Error with notification stream: %s\n", err.Error())
+			log.Warn("Error with notification stream", "error", err)
 			return
 		}
 		incomingMessages <- message
@@ -289,19 +302,36 @@ func readJetstreamMessages(ws *websocket.Conn, incomingMessages chan JetstreamPo
 }
 
 // This function is quite a bit slower than our inital check, and it does the following:
+// 0. Skip the notification if the user is in their quiet hours.
 // 1. ~~If it's a mention, verify that the user hasn't blocked~~ I dont think this is possible.
 // 2. Get the device tokens of the devices that would like these specific push notifications.
 // 3. Converting the text into a twitter post
 // 4. Send the twitter post's content as a push notification via SGN.
 func sendPushNotificationForPost(did string, typeOfNotification string, didOfPoster string, rkey string, indexed_at *int64) {
-	notificationBody := map[string]interface{}{}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	settings, err := db_controller.GetUserSettings(did)
+	if err != nil {
+		log.Error("Error getting settings for push notification", "did", did, "error", err)
+	}
+
+	// Respect the user's sleep time. Twitter just dropped these, so we will too.
+	if isInQuietHours(settings, time.Now()) {
+		metrics.PushNotifications.Inc(typeOfNotification, "quiet_hours")
+		return
+	}
+
+	// The alert gets built per device, since each one may want a different language.
+	var messageKey string
+	var messageArgs []interface{}
 	// GetPost
 
 	switch typeOfNotification {
 	case "mention", "mention_following":
 		{
 			if typeOfNotification == "mention_following" {
-				relationship, err := blueskyapi.GetRelationships("https://public.api.bsky.app", "", did, []string{didOfPoster})
+				relationship, err := blueskyapi.GetRelationships(ctx, blueskyapi.AppViewURL(), "", did, []string{didOfPoster})
 				if err != nil {
 					return
 				}
@@ -313,24 +343,20 @@ func sendPushNotificationForPost(did string, typeOfNotification string, didOfPos
 				}
 
 			}
-			bskyPost, err := blueskyapi.GetPost("https://public.api.bsky.app", "", fmt.Sprintf("at://%s/app.bsky.feed.post/%s", didOfPoster, rkey), 0, 0)
+			bskyPost, err := blueskyapi.GetPost(ctx, blueskyapi.AppViewURL(), "", fmt.Sprintf("at://%s/app.bsky.feed.post/%s", didOfPoster, rkey), 0, 0)
 			if err != nil {
 				return
 			}
 
-			tweet := twitterv1.TranslatePostToTweet(bskyPost.Thread.Post, "", "", "", nil, nil, "", "https://public.api.bsky.app")
+			tweet := twitterv1.TranslatePostToTweet(ctx, bskyPost.Thread.Post, "", "", "", nil, nil, "", blueskyapi.AppViewURL())
 			// our body
-			notificationBody = map[string]interface{}{
-				"aps": map[string]interface{}{
-					"alert": fmt.Sprintf("Mentioned by @%s: %s", tweet.User.ScreenName, tweet.Text),
-					"sound": "default",
-				},
-			}
+			messageKey = localization.PushMention
+			messageArgs = []interface{}{tweet.User.ScreenName, tweet.Text}
 		}
 	case "liked", "liked_following":
 		{
 			if typeOfNotification == "liked_following" {
-				relationship, err := blueskyapi.GetRelationships("https://public.api.bsky.app", "", did, []string{didOfPoster})
+				relationship, err := blueskyapi.GetRelationships(ctx, blueskyapi.AppViewURL(), "", did, []string{didOfPoster})
 				if err != nil {
 					return
 				}
@@ -342,29 +368,25 @@ func sendPushNotificationForPost(did string, typeOfNotification string, didOfPos
 				}
 
 			}
-			bskyPost, err := blueskyapi.GetPost("https://public.api.bsky.app", "", fmt.Sprintf("at://%s/app.bsky.feed.post/%s", did, rkey), 0, 0)
+			bskyPost, err := blueskyapi.GetPost(ctx, blueskyapi.AppViewURL(), "", fmt.Sprintf("at://%s/app.bsky.feed.post/%s", did, rkey), 0, 0)
 			if err != nil {
 				return
 			}
 
-			bskyUser, err := blueskyapi.GetUserInfo("https://public.api.bsky.app", "", didOfPoster, false)
+			bskyUser, err := blueskyapi.GetUserInfo(ctx, blueskyapi.AppViewURL(), "", didOfPoster, false)
 			if err != nil {
 				return
 			}
 
-			tweet := twitterv1.TranslatePostToTweet(bskyPost.Thread.Post, "", "", "", nil, nil, "", "https://public.api.bsky.app")
+			tweet := twitterv1.TranslatePostToTweet(ctx, bskyPost.Thread.Post, "", "", "", nil, nil, "", blueskyapi.AppViewURL())
 			// our body
-			notificationBody = map[string]interface{}{
-				"aps": map[string]interface{}{
-					"alert": fmt.Sprintf("@%s favourited your tweet: %s", bskyUser.ScreenName, tweet.Text), // idk what this is
-					"sound": "default",
-				},
-			}
+			messageKey = localization.PushFavourited
+			messageArgs = []interface{}{bskyUser.ScreenName, tweet.Text}
 		}
 	case "retweet", "retweet_following":
 		{
 			if typeOfNotification == "retweet_following" {
-				relationship, err := blueskyapi.GetRelationships("https://public.api.bsky.app", "", did, []string{didOfPoster})
+				relationship, err := blueskyapi.GetRelationships(ctx, blueskyapi.AppViewURL(), "", did, []string{didOfPoster})
 				if err != nil {
 					return
 				}
@@ -376,12 +398,12 @@ func sendPushNotificationForPost(did string, typeOfNotification string, didOfPos
 				}
 
 			}
-			bskyPost, err := blueskyapi.GetPost("https://public.api.bsky.app", "", fmt.Sprintf("at://%s/app.bsky.feed.post/%s", did, rkey), 0, 0)
+			bskyPost, err := blueskyapi.GetPost(ctx, blueskyapi.AppViewURL(), "", fmt.Sprintf("at://%s/app.bsky.feed.post/%s", did, rkey), 0, 0)
 			if err != nil {
 				return
 			}
 
-			bskyUser, err := blueskyapi.GetUserInfoRaw("https://public.api.bsky.app", "", didOfPoster)
+			bskyUser, err := blueskyapi.GetUserInfoRaw(ctx, blueskyapi.AppViewURL(), "", didOfPoster)
 			if err != nil {
 				return
 			}
@@ -391,41 +413,67 @@ func sendPushNotificationForPost(did string, typeOfNotification string, didOfPos
 				By:   *bskyUser,
 			}
 
-			tweet := twitterv1.TranslatePostToTweet(bskyPost.Thread.Post, "", "", "", nil, &postReason, "", "https://public.api.bsky.app")
+			tweet := twitterv1.TranslatePostToTweet(ctx, bskyPost.Thread.Post, "", "", "", nil, &postReason, "", blueskyapi.AppViewURL())
 
 			// our body
-			notificationBody = map[string]interface{}{
-				"aps": map[string]interface{}{
-					"alert": fmt.Sprintf("@%s: %s", tweet.User.ScreenName, tweet.Text),
-					"sound": "default",
-				},
-			}
+			messageKey = localization.PushRetweeted
+			messageArgs = []interface{}{tweet.User.ScreenName, tweet.Text}
 		}
 	case "follow":
 		{
-			bskyUser, err := blueskyapi.GetUserInfo("https://public.api.bsky.app", "", didOfPoster, false)
+			bskyUser, err := blueskyapi.GetUserInfo(ctx, blueskyapi.AppViewURL(), "", didOfPoster, false)
 			if err != nil {
 				return
 			}
 
 			// our body
-			notificationBody = map[string]interface{}{
-				"aps": map[string]interface{}{
-					"alert": fmt.Sprintf("@%s is now following you!", bskyUser.ScreenName),
-					"sound": "default",
-				},
-			}
+			messageKey = localization.PushFollowed
+			messageArgs = []interface{}{bskyUser.ScreenName}
 		}
 	}
 
+	if messageKey == "" {
+		return
+	}
+
+	settingsLanguage := ""
+	if settings != nil {
+		settingsLanguage = settings.Language
+	}
+
 	pushTokens, err := db_controller.GetPushTokensForDID(did)
 	if err != nil {
 		return
 	}
 	for _, token := range pushTokens {
+		notificationBody := map[string]interface{}{
+			"aps": map[string]interface{}{
+				"alert": buildAlert(localization.PickLanguage(token.Language, settingsLanguage), messageKey, messageArgs),
+				"sound": "default",
+			},
+		}
 		if err := sgn.SendNotification(token.DeviceToken, notificationBody); err != nil {
-			fmt.Println(err.Error())
+			log.Error("Error sending push notification", "did", did, "type", typeOfNotification, "error", err)
+			metrics.PushNotifications.Inc(typeOfNotification, "failed")
+			continue
+		}
+		metrics.PushNotifications.Inc(typeOfNotification, "sent")
+		log.Debug("Sent push notification", "did", did, "type", typeOfNotification)
+	}
+}
+
+// Builds the aps alert for a message. If the operator has told us the client has this message built in,
+// we send a loc-key so the client can localize it, otherwise we translate it ourselves.
+func buildAlert(lang string, messageKey string, messageArgs []interface{}) interface{} {
+	if locKey, ok := locKeys[messageKey]; ok && locKey != "" {
+		locArgs := make([]string, len(messageArgs))
+		for i, arg := range messageArgs {
+			locArgs[i] = fmt.Sprint(arg)
+		}
+		return map[string]interface{}{
+			"loc-key":  locKey,
+			"loc-args": locArgs,
 		}
-		fmt.Println("i just send a notification")
 	}
+	return localization.Translate(lang, messageKey, messageArgs...)
 }
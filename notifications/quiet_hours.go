@@ -0,0 +1,35 @@
+package notifications
+
+import (
+	"time"
+
+	"github.com/Preloading/TwitterAPIBridge/bridge"
+	"github.com/Preloading/TwitterAPIBridge/db_controller"
+)
+
+// isInQuietHours checks the user's sleep_time settings (from account/settings) to see if
+// we should hold off on pushing to them right now.
+func isInQuietHours(settings *db_controller.UserSettings, now time.Time) bool {
+	if settings == nil || !settings.SleepTimeEnabled || settings.SleepTimeStart == nil || settings.SleepTimeEnd == nil {
+		return false
+	}
+
+	_, _, loc, err := bridge.ResolveTimeZone(settings.TimeZone)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	return hourInSleepTime(now.In(loc).Hour(), *settings.SleepTimeStart, *settings.SleepTimeEnd)
+}
+
+// The end hour is exclusive, so 23 -> 7 means quiet from 23:00 until 06:59.
+func hourInSleepTime(hour int, start int, end int) bool {
+	if start == end {
+		return false
+	}
+	if start < end {
+		return hour >= start && hour < end
+	}
+	// wraps past midnight
+	return hour >= start || hour < end
+}
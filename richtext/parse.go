@@ -0,0 +1,107 @@
+package richtext
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Preloading/TwitterAPIBridge/bridge"
+)
+
+// These follow what the bluesky app does, so posts made through us get the same facets as ones made in the app.
+// Start & End in what they return are byte offsets, ready to go in a facet. Item is without the @ or #.
+
+var (
+	// something can only start a mention/link/tag if it's at the start, or after a space or (
+	mentionRegex = regexp.MustCompile(`(?:^|\s|\()(@(([a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?\.)+[a-zA-Z]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?))`)
+	// @alice, people on old clients don't type the whole handle. Has to be checked that it isn't the start of a full one
+	shortMentionRegex = regexp.MustCompile(`(?:^|\s|\()(@([a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?))`)
+	urlRegex          = regexp.MustCompile(`(?:^|\s|\()((https?:\/\/(www\.)?[-a-zA-Z0-9@:%._\+~#=]{1,256}\.[a-zA-Z0-9()]{1,6}\b([-a-zA-Z0-9()@:%_\+.~#?&//=]*[-a-zA-Z0-9@%_\+~#//=])?))`)
+	// # or a fullwidth ＃, then anything up to a space (or one of the invisible characters the app doesn't allow in tags)
+	tagRegex = regexp.MustCompile(`(?:^|\s)([#＃]([^\s\x{00AD}\x{2060}\x{200A}\x{200B}\x{200C}\x{200D}\x{20E2}]+))`)
+)
+
+// The app doesn't allow tags longer than this
+const maxTagLength = 64
+
+// FindMentions finds @handle.example mentions.
+func FindMentions(text string) []bridge.FacetParsing {
+	results := []bridge.FacetParsing{}
+	for _, match := range mentionRegex.FindAllStringSubmatchIndex(text, -1) {
+		start, end := match[2], match[3]
+		results = append(results, bridge.FacetParsing{
+			Start: start,
+			End:   end,
+			Item:  text[start+1 : end], // +1 to skip the '@' character
+		})
+	}
+	return results
+}
+
+// FindShortMentions finds @name mentions, without the rest of the handle. Item is just the name.
+// They have to be resolved to someone before they can be a facet, see ResolveShortMentions in twitterv1.
+func FindShortMentions(text string) []bridge.FacetParsing {
+	results := []bridge.FacetParsing{}
+	for _, match := range shortMentionRegex.FindAllStringSubmatchIndex(text, -1) {
+		start, end := match[2], match[3]
+		rest := text[end:]
+		// @alice.bsky.social is a full mention, and @alice-is-cool is too long to be a label, skip both
+		if strings.HasPrefix(rest, "-") || (strings.HasPrefix(rest, ".") && len(rest) > 1 && isHandleChar(rest[1])) || (len(rest) > 0 && isHandleChar(rest[0])) {
+			continue
+		}
+		results = append(results, bridge.FacetParsing{
+			Start: start,
+			End:   end,
+			Item:  text[start+1 : end],
+		})
+	}
+	return results
+}
+
+func isHandleChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// FindURLs finds http(s) links.
+func FindURLs(text string) []bridge.FacetParsing {
+	results := []bridge.FacetParsing{}
+	for _, match := range urlRegex.FindAllStringSubmatchIndex(text, -1) {
+		start, end := match[2], match[3]
+		results = append(results, bridge.FacetParsing{
+			Start: start,
+			End:   end,
+			Item:  text[start:end],
+		})
+	}
+	return results
+}
+
+// FindTags finds #hashtags, in any language.
+func FindTags(text string) []bridge.FacetParsing {
+	results := []bridge.FacetParsing{}
+	for _, match := range tagRegex.FindAllStringSubmatchIndex(text, -1) {
+		start, tagStart, tagEnd := match[2], match[4], match[5]
+		tag := strings.TrimRightFunc(text[tagStart:tagEnd], unicode.IsPunct) // #bluesky! -> bluesky
+		if !isValidTag(tag) {
+			continue
+		}
+		results = append(results, bridge.FacetParsing{
+			Start: start,
+			End:   tagStart + len(tag),
+			Item:  tag,
+		})
+	}
+	return results
+}
+
+// isValidTag is whether the app would make a tag out of this. It has to have something that isn't a number or punctuation
+// (#1 isn't a tag), and can't start with U+FE0F (that's the #️⃣ emoji).
+func isValidTag(tag string) bool {
+	if tag == "" || strings.HasPrefix(tag, "\ufe0f") || utf8.RuneCountInString(tag) > maxTagLength {
+		return false
+	}
+	return strings.ContainsFunc(tag, func(r rune) bool {
+		return !unicode.IsDigit(r) && !unicode.IsPunct(r)
+	})
+}
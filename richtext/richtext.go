@@ -0,0 +1,104 @@
+package richtext
+
+import (
+	"context"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Bluesky facets point into the text with UTF-8 byte offsets. Twitter entities point into it with indices, which
+// twitter documented as code points, but some clients count in UTF-16 code units instead (ex. anything that hands
+// them straight to NSString or a java String). Anything with an emoji or other non-BMP character in front of it
+// ends up in the wrong place if we get the unit wrong.
+//
+// Everything that turns one into the other goes through here, going both ways:
+// - viewing: facet byte offsets -> entity indices (Index)
+// - posting: text the client sent -> facets with byte offsets (FindMentions, FindURLs, FindTags)
+
+// Unit is what entity indices count.
+type Unit int
+
+const (
+	CodePoints Unit = iota // what twitter documented
+	UTF16                  // UTF-16 code units, so non-BMP characters (most emoji) count as 2
+)
+
+func (u Unit) String() string {
+	if u == UTF16 {
+		return "utf16"
+	}
+	return "codepoints"
+}
+
+type unitKey struct{}
+
+// WithUnit sets what entity indices should count in for this request.
+func WithUnit(ctx context.Context, unit Unit) context.Context {
+	return context.WithValue(ctx, unitKey{}, unit)
+}
+
+// UnitFromContext is what WithUnit set, or CodePoints.
+func UnitFromContext(ctx context.Context) Unit {
+	if unit, ok := ctx.Value(unitKey{}).(Unit); ok {
+		return unit
+	}
+	return CodePoints
+}
+
+// runeLength is how many units a rune is.
+func runeLength(r rune, unit Unit) int {
+	if unit == UTF16 && utf16.RuneLen(r) == 2 {
+		return 2
+	}
+	return 1
+}
+
+// Length is how long text is in unit.
+func Length(text string, unit Unit) int {
+	if unit == CodePoints {
+		return utf8.RuneCountInString(text)
+	}
+	length := 0
+	for _, r := range text {
+		length += runeLength(r, unit)
+	}
+	return length
+}
+
+// Index turns a UTF-8 byte offset into text into an entity index.
+// Offsets past either end are clamped, and ones in the middle of a character count as the start of it.
+func Index(text string, byteOffset int, unit Unit) int {
+	return Length(text[:floorByte(text, byteOffset)], unit)
+}
+
+// ByteOffset turns an entity index back into a UTF-8 byte offset into text.
+// Indices past the end give len(text), and ones in the middle of a surrogate pair count as the start of it.
+func ByteOffset(text string, index int, unit Unit) int {
+	position := 0
+	for offset, r := range text {
+		position += runeLength(r, unit)
+		if position > index {
+			return offset
+		}
+	}
+	return len(text)
+}
+
+// ValidRange is whether start:end is a non-empty range of whole characters in text, which is all a facet has to be
+// for us to use it. Facets are whatever the posting client sent, so they can't be trusted to be.
+func ValidRange(text string, start int, end int) bool {
+	return start >= 0 && start < end && end <= len(text) && isBoundary(text, start) && isBoundary(text, end)
+}
+
+func isBoundary(text string, offset int) bool {
+	return offset == len(text) || utf8.RuneStart(text[offset])
+}
+
+// floorByte clamps offset into text, and moves it back to the start of the character it's in.
+func floorByte(text string, offset int) int {
+	offset = min(max(offset, 0), len(text))
+	for offset > 0 && !isBoundary(text, offset) {
+		offset--
+	}
+	return offset
+}
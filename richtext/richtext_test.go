@@ -0,0 +1,309 @@
+package richtext
+
+import (
+	"math/rand/v2"
+	"strings"
+	"testing"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/Preloading/TwitterAPIBridge/bridge"
+)
+
+// Property tests: random text made of the characters that have broken entity indices before, checked against
+// counting the slow & obvious way. Seeded, so a failure happens again on the next run.
+
+// graphemePieces are one grapheme each, and none of them join on to the one before.
+var graphemePieces = []string{
+	"a", "Z", "7", ".", "!",
+	"\u00e9",                     // é precomposed
+	"e\u0301",                    // e + combining acute
+	"a\u0323\u0302",              // two combining marks
+	"\U0001F44B",                 // 👋, 2 UTF-16 units
+	"\U0001F44B\U0001F3FD",       // skin tone
+	"\U0001F469\u200D\U0001F4BB", // ZWJ sequence
+	"\U0001F468\u200D\U0001F469\u200D\U0001F467",   // longer ZWJ sequence
+	"\U0001F1EF\U0001F1F5", "\U0001F1FA\U0001F1F8", // flags, back to back they're still two
+	"\u2764\uFE0F", // variation selector
+	"日", "本", "ｶ",  // CJK & halfwidth
+	"ש", "ل", "\u200F", // RTL, and a right-to-left mark
+	"\u1100\u1161", // hangul jamo
+	"한",
+	"\U0001D54F", // 𝕏, a non-BMP letter
+}
+
+func randomText(rng *rand.Rand, graphemes int) string {
+	var text strings.Builder
+	for range graphemes {
+		if rng.IntN(6) == 0 {
+			text.WriteString(" ")
+			continue
+		}
+		text.WriteString(graphemePieces[rng.IntN(len(graphemePieces))])
+	}
+	return text.String()
+}
+
+// referenceIndex counts the slow way, by converting the text before the offset.
+func referenceIndex(text string, byteOffset int, unit Unit) int {
+	if unit == UTF16 {
+		return len(utf16.Encode([]rune(text[:byteOffset])))
+	}
+	return len([]rune(text[:byteOffset]))
+}
+
+// boundaries is the byte offset of every character in text, and len(text).
+func boundaries(text string) []int {
+	offsets := []int{}
+	for offset := range text {
+		offsets = append(offsets, offset)
+	}
+	return append(offsets, len(text))
+}
+
+var units = []Unit{CodePoints, UTF16}
+
+func TestIndexRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	for range 500 {
+		text := randomText(rng, rng.IntN(40))
+		for _, unit := range units {
+			if Length(text, unit) != referenceIndex(text, len(text), unit) {
+				t.Fatalf("%s: Length(%q) = %d", unit, text, Length(text, unit))
+			}
+
+			for _, offset := range boundaries(text) {
+				index := Index(text, offset, unit)
+				if want := referenceIndex(text, offset, unit); index != want {
+					t.Fatalf("%s: Index(%q, %d) = %d, want %d", unit, text, offset, index, want)
+				}
+				if back := ByteOffset(text, index, unit); back != offset {
+					t.Fatalf("%s: %q byte %d -> index %d -> byte %d", unit, text, offset, index, back)
+				}
+			}
+
+			// offsets in the middle of a character count as the start of it
+			for offset := range len(text) {
+				if !utf8.RuneStart(text[offset]) {
+					start := offset
+					for !utf8.RuneStart(text[start]) {
+						start--
+					}
+					if Index(text, offset, unit) != Index(text, start, unit) {
+						t.Fatalf("%s: Index(%q, %d) isn't the start of the character", unit, text, offset)
+					}
+				}
+			}
+
+			// and going the other way, every index lands on a character (the first half of a surrogate pair if it's the second)
+			for index := range Length(text, unit) + 1 {
+				offset := ByteOffset(text, index, unit)
+				if !isBoundary(text, offset) {
+					t.Fatalf("%s: ByteOffset(%q, %d) = %d, the middle of a character", unit, text, index, offset)
+				}
+				back := Index(text, offset, unit)
+				if back != index && !(unit == UTF16 && back == index-1 && utf16.IsSurrogate(rune(utf16.Encode([]rune(text))[index]))) {
+					t.Fatalf("%s: %q index %d -> byte %d -> index %d", unit, text, index, offset, back)
+				}
+			}
+		}
+	}
+}
+
+func TestIndexOutOfRange(t *testing.T) {
+	text := "\U0001F44B hi"
+	for _, unit := range units {
+		if Index(text, -5, unit) != 0 || Index(text, 100, unit) != Length(text, unit) {
+			t.Errorf("%s: offsets past the ends aren't clamped", unit)
+		}
+		if ByteOffset(text, 100, unit) != len(text) {
+			t.Errorf("%s: index past the end isn't len(text)", unit)
+		}
+	}
+}
+
+func TestValidRange(t *testing.T) {
+	text := "e\u0301 \U0001F44B"
+	tests := []struct {
+		start, end int
+		valid      bool
+	}{
+		{0, len(text), true},
+		{0, 1, true}, // the combining mark is its own code point, facets can split there
+		{4, 8, true},
+		{4, 6, false}, // middle of the emoji
+		{5, 8, false},
+		{2, 2, false},
+		{3, 1, false},
+		{-1, 3, false},
+		{0, len(text) + 1, false},
+	}
+	for _, test := range tests {
+		if ValidRange(text, test.start, test.end) != test.valid {
+			t.Errorf("ValidRange(%d, %d) should be %v", test.start, test.end, test.valid)
+		}
+	}
+}
+
+// Facets found in text a client posted, turned into entity indices like a client would get back, have to point at
+// the same text, in both units.
+func TestFacetRoundTrip(t *testing.T) {
+	mentions := []string{"@alice.test", "@bob.bsky.social", "@x-y.example.com"}
+	links := []string{"https://example.com", "http://www.example.com/path?q=1&r=2", "https://ja.wikipedia.org/wiki/%E6%97%A5"}
+	tags := []string{"#bluesky", "#日本語", "#caf\u00e9", "#e\u0301te\u0301", "#한국어", "#عربي", "#שלום", "＃全角", "#tag\U0001F44B", "#2024年"}
+	// look like they could be facets, but aren't
+	decoys := []string{"#2024", "#\uFE0F\u20E3", "email@", "#", "@", "(@nope"}
+
+	rng := rand.New(rand.NewPCG(3, 4))
+	for range 300 {
+		var text strings.Builder
+		var wantMentions, wantLinks, wantTags []bridge.FacetParsing
+		add := func(token string, want *[]bridge.FacetParsing, item string) {
+			start := text.Len()
+			text.WriteString(token)
+			*want = append(*want, bridge.FacetParsing{Start: start, End: text.Len(), Item: item})
+		}
+		for i := range rng.IntN(12) {
+			if i > 0 {
+				text.WriteString(" ")
+			}
+			switch rng.IntN(5) {
+			case 0:
+				mention := mentions[rng.IntN(len(mentions))]
+				add(mention, &wantMentions, mention[1:])
+			case 1:
+				link := links[rng.IntN(len(links))]
+				add(link, &wantLinks, link)
+			case 2:
+				tag := tags[rng.IntN(len(tags))]
+				_, size := utf8.DecodeRuneInString(tag)
+				add(tag, &wantTags, tag[size:])
+			case 3:
+				text.WriteString(decoys[rng.IntN(len(decoys))])
+			default:
+				text.WriteString(strings.TrimSpace(randomText(rng, 1+rng.IntN(5))))
+			}
+		}
+		posted := text.String()
+
+		for _, found := range []struct {
+			kind string
+			got  []bridge.FacetParsing
+			want []bridge.FacetParsing
+		}{
+			{"mentions", FindMentions(posted), wantMentions},
+			{"links", FindURLs(posted), wantLinks},
+			{"tags", FindTags(posted), wantTags},
+		} {
+			if !equalFacets(found.got, found.want) {
+				t.Fatalf("%q: %s are %+v, want %+v", posted, found.kind, found.got, found.want)
+			}
+			for _, facet := range found.got {
+				if !ValidRange(posted, facet.Start, facet.End) {
+					t.Fatalf("%q: %s %+v isn't a valid facet", posted, found.kind, facet)
+				}
+				for _, unit := range units {
+					start, end := Index(posted, facet.Start, unit), Index(posted, facet.End, unit)
+					if sliceUnits(posted, start, end, unit) != posted[facet.Start:facet.End] {
+						t.Fatalf("%s: %q entity %d-%d is %q, want %q", unit, posted, start, end, sliceUnits(posted, start, end, unit), posted[facet.Start:facet.End])
+					}
+					if ByteOffset(posted, start, unit) != facet.Start || ByteOffset(posted, end, unit) != facet.End {
+						t.Fatalf("%s: %q entity %d-%d doesn't go back to bytes %d-%d", unit, posted, start, end, facet.Start, facet.End)
+					}
+				}
+			}
+		}
+	}
+}
+
+func equalFacets(a, b []bridge.FacetParsing) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// sliceUnits cuts text by indices, the way a client does with an entity.
+func sliceUnits(text string, start, end int, unit Unit) string {
+	if unit == UTF16 {
+		return string(utf16.Decode(utf16.Encode([]rune(text))[start:end]))
+	}
+	return string([]rune(text)[start:end])
+}
+
+func TestFindTags(t *testing.T) {
+	tests := []struct {
+		text string
+		tags []string
+	}{
+		{"#bluesky! and #日本語。", []string{"bluesky", "日本語"}},
+		{"#caf\u00e9 #e\u0301te\u0301", []string{"caf\u00e9", "e\u0301te\u0301"}},
+		{"＃全角 #ﾃｽﾄ", []string{"全角", "ﾃｽﾄ"}},
+		{"#1 #2024 #\uFE0F\u20E3 not#tag", nil},
+		{"#" + strings.Repeat("a", maxTagLength) + " #" + strings.Repeat("a", maxTagLength+1), []string{strings.Repeat("a", maxTagLength)}},
+	}
+	for _, test := range tests {
+		got := []string{}
+		for _, tag := range FindTags(test.text) {
+			got = append(got, tag.Item)
+		}
+		if strings.Join(got, "|") != strings.Join(test.tags, "|") {
+			t.Errorf("FindTags(%q) = %q, want %q", test.text, got, test.tags)
+		}
+	}
+}
+
+func TestGraphemes(t *testing.T) {
+	rng := rand.New(rand.NewPCG(5, 6))
+	for range 500 {
+		count := rng.IntN(50)
+		var text strings.Builder
+		for range count {
+			text.WriteString(graphemePieces[rng.IntN(len(graphemePieces))])
+		}
+		if got := Graphemes(text.String()); got != count {
+			t.Fatalf("Graphemes(%q) = %d, want %d", text.String(), got, count)
+		}
+	}
+}
+
+// Split's chunks each fit in a post, only leave out spaces, and never cut a grapheme in half.
+func TestSplit(t *testing.T) {
+	rng := rand.New(rand.NewPCG(7, 8))
+	for range 200 {
+		text := randomText(rng, 200+rng.IntN(1000))
+		if rng.IntN(4) == 0 { // one word longer than a post
+			text += " " + strings.Repeat("\U0001F469\u200D\U0001F4BB", MaxPostGraphemes+10)
+		}
+		chunks := Split(text)
+
+		previousEnd := 0
+		for _, chunk := range chunks {
+			if chunk.Start < previousEnd || chunk.End <= chunk.Start || !ValidRange(text, chunk.Start, chunk.End) {
+				t.Fatalf("bad chunk %+v after %d in %q", chunk, previousEnd, text)
+			}
+			if strings.TrimSpace(text[previousEnd:chunk.Start]) != "" {
+				t.Fatalf("%q was left out between chunks", text[previousEnd:chunk.Start])
+			}
+			if !FitsInPost(text[chunk.Start:chunk.End]) {
+				t.Fatalf("chunk %+v is %d graphemes", chunk, Graphemes(text[chunk.Start:chunk.End]))
+			}
+			if next, _ := utf8.DecodeRuneInString(text[chunk.End:]); chunk.End < len(text) && !unicode.IsSpace(next) {
+				previous, _ := utf8.DecodeLastRuneInString(text[:chunk.End])
+				if extends(next) || previous == 0x200D {
+					t.Fatalf("chunk %+v ends in the middle of a grapheme", chunk)
+				}
+			}
+			previousEnd = chunk.End
+		}
+		if strings.TrimSpace(text[previousEnd:]) != "" {
+			t.Fatalf("%q was left out at the end", text[previousEnd:])
+		}
+	}
+}
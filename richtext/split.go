@@ -0,0 +1,143 @@
+package richtext
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// Bluesky posts can be 300 graphemes (and 3000 bytes, which only matters for some scripts).
+const (
+	MaxPostGraphemes = 300
+	maxPostBytes     = 3000
+)
+
+// We don't have a proper grapheme segmenter (no uniseg), so this is a close enough one: a grapheme is a character,
+// plus anything that gets stuck on to it (combining marks, variation selectors, skin tones, ZWJ sequences, flags).
+// It can count some rarer things (ex. some Indic clusters) as more than one, which just means we split a bit early.
+
+// extends is whether r sticks on to the character before it.
+func extends(r rune) bool {
+	switch {
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc):
+		return true
+	case r >= 0xFE00 && r <= 0xFE0F, r >= 0xE0100 && r <= 0xE01EF: // variation selectors
+		return true
+	case r >= 0x1F3FB && r <= 0x1F3FF: // skin tones
+		return true
+	case r >= 0xE0020 && r <= 0xE007F: // tags, used in some flags
+		return true
+	case r == 0x200D: // zero width joiner
+		return true
+	case r >= 0x1160 && r <= 0x11FF: // hangul vowel & final jamo
+		return true
+	}
+	return false
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
+
+// graphemeBoundaries calls f with the byte offset of the start of every grapheme in text, then len(text).
+// Stops early if f returns false.
+func graphemeBoundaries(text string, f func(offset int) bool) {
+	var previous rune = -1
+	regionalIndicators := 0
+	for offset, r := range text {
+		joined := previous == 0x200D || extends(r)
+		if isRegionalIndicator(r) {
+			// flags are pairs of these
+			joined = regionalIndicators%2 == 1
+			regionalIndicators++
+		} else {
+			regionalIndicators = 0
+		}
+		if (previous == -1 || !joined) && !f(offset) {
+			return
+		}
+		previous = r
+	}
+	f(len(text))
+}
+
+// Graphemes is about how many graphemes bluesky will count text as.
+func Graphemes(text string) int {
+	count := -1 // the len(text) at the end doesn't start one
+	graphemeBoundaries(text, func(int) bool {
+		count++
+		return true
+	})
+	return max(count, 0)
+}
+
+// FitsInPost is whether text is short enough to be one post.
+func FitsInPost(text string) bool {
+	return len(text) <= maxPostBytes && Graphemes(text) <= MaxPostGraphemes
+}
+
+// Chunk is part of a longer text, as byte offsets into it.
+type Chunk struct {
+	Start int
+	End   int
+}
+
+// Split cuts text into chunks that each fit in a post, at spaces where it can (so words, links & mentions stay
+// in one piece), or in the middle of a word if one's too long. The spaces it cuts at aren't in any chunk.
+func Split(text string) []Chunk {
+	chunks := []Chunk{}
+	start := skipSpaces(text, 0)
+	for start < len(text) {
+		rest := text[start:]
+		if FitsInPost(rest) {
+			chunks = append(chunks, Chunk{Start: start, End: start + trimSpacesEnd(rest)})
+			break
+		}
+
+		// find the furthest we can go, and the last space before that
+		cut, lastSpace, count := 0, -1, 0
+		graphemeBoundaries(rest, func(offset int) bool {
+			if count > MaxPostGraphemes || offset > maxPostBytes {
+				return false
+			}
+			cut = offset
+			if r, _ := utf8.DecodeRuneInString(rest[offset:]); offset > 0 && unicode.IsSpace(r) {
+				lastSpace = offset
+			}
+			count++
+			return true
+		})
+		if lastSpace > 0 {
+			cut = lastSpace
+		}
+		if cut == 0 { // one grapheme over the limit by itself, nothing we can do but send it
+			cut = len(rest)
+		}
+
+		chunks = append(chunks, Chunk{Start: start, End: start + trimSpacesEnd(rest[:cut])})
+		start = skipSpaces(text, start+cut)
+	}
+	return chunks
+}
+
+func skipSpaces(text string, offset int) int {
+	for offset < len(text) {
+		r, size := utf8.DecodeRuneInString(text[offset:])
+		if !unicode.IsSpace(r) {
+			break
+		}
+		offset += size
+	}
+	return offset
+}
+
+// trimSpacesEnd is the length of text without the spaces at the end.
+func trimSpacesEnd(text string) int {
+	for len(text) > 0 {
+		r, size := utf8.DecodeLastRuneInString(text)
+		if !unicode.IsSpace(r) {
+			break
+		}
+		text = text[:len(text)-size]
+	}
+	return len(text)
+}
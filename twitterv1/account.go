@@ -2,7 +2,6 @@ package twitterv1
 
 import (
 	"encoding/base64"
-	"fmt"
 	"io"
 	"strconv"
 	"strings"
@@ -89,20 +88,20 @@ func UpdatePushNotifications(c *fiber.Ctx) error {
 		return ReturnError(c, "enabled_for is missing", 0, 400)
 	}
 
-	fmt.Println(c.FormValue("token"))
+	log.DebugContext(c.UserContext(), "Registering for push notifications", "device_token", c.FormValue("token"), "enabled_for", enabledFor)
 
 	// device token
 	notificationToken := make([]byte, 32)
 	_, err = base64.StdEncoding.Decode(notificationToken, []byte(c.FormValue("token")))
 	if err != nil {
-		fmt.Println(err.Error())
+		log.WarnContext(c.UserContext(), "Invalid device token", "error", err)
 		return ReturnError(c, "device token is invalid", 0, 400)
 	}
 
 	routing_key, routing_server_address, err := skyglownotificationlib.RoutingInfoFromDeviceToken(notificationToken)
 
 	if err != nil {
-		fmt.Println(err.Error())
+		log.InfoContext(c.UserContext(), "Device token has no routing info", "error", err)
 		// user is probably not using SGN
 		return ReturnError(c, "Skyglow Notifications is required for notifications", 1000, 404)
 	}
@@ -113,6 +112,7 @@ func UpdatePushNotifications(c *fiber.Ctx) error {
 		RoutingKey:    routing_key,
 		ServerAddress: *routing_server_address,
 		EnabledFor:    enabledFor,
+		Language:      RequestedLanguage(c),
 		LastUpdated:   time.Now(),
 	})
 
@@ -148,7 +148,7 @@ func RemovePush(c *fiber.Ctx) error {
 	err = db_controller.DeleteeeeeeeeeeeeRegistrationForPushNotificationsWithDid(*my_did)
 
 	if err != nil {
-		fmt.Println(err.Error())
+		log.ErrorContext(c.UserContext(), "Error removing push notifications", "error", err)
 		return ReturnError(c, "something went wrong when deregistering you or smth idk and i dont care", 131, 500)
 	}
 
@@ -158,39 +158,151 @@ func RemovePush(c *fiber.Ctx) error {
 	})
 }
 
-// TODO
+// https://web.archive.org/web/20120707214008/https://dev.twitter.com/docs/api/1/get/account/settings
 func GetSettings(c *fiber.Ctx) error {
-	settings := bridge.Config{
+	my_did, _, _, _, err := GetAuthFromReq(c)
+	if err != nil {
+		return MissingAuth(c, err)
+	}
+
+	settings, err := db_controller.GetUserSettings(*my_did)
+	if err != nil {
+		log.ErrorContext(c.UserContext(), "GetUserSettings failed", "error", err)
+	}
+	if settings == nil {
+		settings = defaultUserSettings(*my_did)
+	}
+
+	return EncodeAndSend(c, settingsToTwitter(*settings))
+}
+
+// https://web.archive.org/web/20120707214015/https://dev.twitter.com/docs/api/1/post/account/settings
+func UpdateSettings(c *fiber.Ctx) error {
+	my_did, _, _, _, err := GetAuthFromReq(c)
+	if err != nil {
+		return MissingAuth(c, err)
+	}
+
+	// Lock the mutex for this user
+	userMutex := getUserMutex(*my_did)
+	userMutex.Lock()
+	defer userMutex.Unlock()
+
+	settings, err := db_controller.GetUserSettings(*my_did)
+	if err != nil {
+		log.ErrorContext(c.UserContext(), "GetUserSettings failed", "error", err)
+		return ReturnError(c, "Failed to get your settings.", 131, fiber.StatusInternalServerError)
+	}
+	if settings == nil {
+		settings = defaultUserSettings(*my_did)
+	}
+
+	// Every parameter is optional, only change what we were given.
+	if woeid := c.FormValue("trend_location_woeid"); woeid != "" {
+		woeidInt, err := strconv.Atoi(woeid)
+		if err != nil {
+			return ReturnError(c, "Invalid trend_location_woeid.", 195, fiber.StatusBadRequest)
+		}
+		settings.TrendLocationWoeid = woeidInt
+	}
+
+	if enabled := c.FormValue("sleep_time_enabled"); enabled != "" {
+		settings.SleepTimeEnabled = enabled == "true" || enabled == "t" || enabled == "1"
+	}
+
+	if start := c.FormValue("start_sleep_time"); start != "" {
+		hour, err := strconv.Atoi(start)
+		if err != nil || hour < 0 || hour > 23 {
+			return ReturnError(c, "Invalid start_sleep_time.", 195, fiber.StatusBadRequest)
+		}
+		settings.SleepTimeStart = &hour
+	}
+
+	if end := c.FormValue("end_sleep_time"); end != "" {
+		hour, err := strconv.Atoi(end)
+		if err != nil || hour < 0 || hour > 23 {
+			return ReturnError(c, "Invalid end_sleep_time.", 195, fiber.StatusBadRequest)
+		}
+		settings.SleepTimeEnd = &hour
+	}
+
+	if timeZone := c.FormValue("time_zone"); timeZone != "" {
+		railsName, _, _, err := bridge.ResolveTimeZone(timeZone)
+		if err != nil {
+			return ReturnError(c, "Invalid time_zone.", 195, fiber.StatusBadRequest)
+		}
+		settings.TimeZone = railsName
+	}
+
+	if lang := c.FormValue("lang"); lang != "" {
+		settings.Language = lang
+	}
+
+	// ours, not twitter's. Whether to split posts that are too long into a thread
+	if split := c.FormValue("split_long_tweets"); split != "" {
+		enabled := split == "true" || split == "t" || split == "1"
+		settings.SplitLongTweets = &enabled
+	}
+
+	if err := db_controller.SaveUserSettings(*settings); err != nil {
+		log.ErrorContext(c.UserContext(), "SaveUserSettings failed", "error", err)
+		return ReturnError(c, "Failed to save your settings.", 131, fiber.StatusInternalServerError)
+	}
+
+	return EncodeAndSend(c, settingsToTwitter(*settings))
+}
+
+func defaultUserSettings(did string) *db_controller.UserSettings {
+	return &db_controller.UserSettings{
+		UserDID:            did,
+		SleepTimeEnabled:   false,
+		TimeZone:           bridge.DefaultTimeZone,
+		Language:           "en",
+		TrendLocationWoeid: 1,
+	}
+}
+
+func settingsToTwitter(settings db_controller.UserSettings) bridge.Config {
+	return bridge.Config{
 		SleepTime: bridge.SleepTime{
-			EndTime:   nil,
-			Enabled:   true,
-			StartTime: nil,
+			EndTime:   settings.SleepTimeEnd,
+			Enabled:   settings.SleepTimeEnabled,
+			StartTime: settings.SleepTimeStart,
 		},
 		TrendLocation: []bridge.TrendLocation{
 			{
-				Name:  "Worldwide",
-				Woeid: 1,
+				Name: func() string {
+					// Bluesky trends are global, so this is the only location we really know about.
+					if settings.TrendLocationWoeid == 1 {
+						return "Worldwide"
+					}
+					return ""
+				}(),
+				Woeid: settings.TrendLocationWoeid,
 				PlaceType: bridge.PlaceType{
 					Name: "Supername",
 					Code: 19,
 				},
 				Country:     "",
-				URL:         "http://where.yahooapis.com/v1/place/1",
+				URL:         "http://where.yahooapis.com/v1/place/" + strconv.Itoa(settings.TrendLocationWoeid),
 				CountryCode: nil,
 			},
 		},
-		Language:            "en",
+		Language:            settings.Language,
 		AlwaysUseHttps:      false,
 		DiscoverableByEmail: true,
-		TimeZone: bridge.TimeZone{
-			Name:       "Pacific Time (US & Canada)",
-			TzinfoName: "America/Los_Angeles",
-			UtcOffset:  -28800,
-		},
-		GeoEnabled: true,
+		TimeZone:            bridge.TimeZoneToTwitter(settings.TimeZone),
+		GeoEnabled:          true,
+		SplitLongTweets:     shouldSplitLongTweets(&settings),
 	}
-	return EncodeAndSend(c, settings)
+}
 
+// shouldSplitLongTweets is whether the user wants posts that are too long split into a thread, or to get an error.
+func shouldSplitLongTweets(settings *db_controller.UserSettings) bool {
+	if settings == nil || settings.SplitLongTweets == nil {
+		return configData.SplitLongTweets
+	}
+	return *settings.SplitLongTweets
 }
 
 func UpdateProfile(c *fiber.Ctx) error {
@@ -214,22 +326,22 @@ func UpdateProfile(c *fiber.Ctx) error {
 	// some quality of life features
 	description = strings.ReplaceAll(description, "\\n", "\n")
 
-	oldProfile, err := blueskyapi.GetRecord(*pds, "app.bsky.actor.profile", *my_did, "self")
+	oldProfile, err := blueskyapi.GetRecord(c.UserContext(), *pds, "app.bsky.actor.profile", *my_did, "self")
 	if err != nil {
-		return HandleBlueskyError(c, err.Error(), "com.atproto.repo.getRecord", UpdateProfile)
+		return HandleBlueskyError(c, err, "com.atproto.repo.getRecord", UpdateProfile)
 	}
 
 	oldProfile.Value.DisplayName = name
 	oldProfile.Value.Description = description
 
-	if err := blueskyapi.UpdateRecord(*pds, *oauthToken, "app.bsky.actor.profile", *my_did, "self", oldProfile.CID, oldProfile.Value); err != nil {
-		return HandleBlueskyError(c, err.Error(), "com.atproto.repo.putRecord", UpdateProfile)
+	if err := blueskyapi.UpdateRecord(c.UserContext(), *pds, *oauthToken, "app.bsky.actor.profile", *my_did, "self", oldProfile.CID, oldProfile.Value); err != nil {
+		return HandleBlueskyError(c, err, "com.atproto.repo.putRecord", UpdateProfile)
 	}
 
-	user, err := blueskyapi.GetUserInfo(*pds, *oauthToken, *my_did, true)
+	user, err := blueskyapi.GetUserInfo(c.UserContext(), *pds, *oauthToken, *my_did, true)
 	if err != nil {
-		fmt.Println("Error:", err)
-		return HandleBlueskyError(c, err.Error(), "app.bsky.actor.getProfile", UpdateProfile)
+		log.ErrorContext(c.UserContext(), "GetUserInfo failed", "error", err)
+		return HandleBlueskyError(c, err, "app.bsky.actor.getProfile", UpdateProfile)
 	}
 
 	user.Description = description
@@ -251,52 +363,52 @@ func UpdateProfilePicture(c *fiber.Ctx) error {
 	defer userMutex.Unlock()
 
 	// get the old profile
-	oldProfile, err := blueskyapi.GetRecord(*pds, "app.bsky.actor.profile", *my_did, "self")
+	oldProfile, err := blueskyapi.GetRecord(c.UserContext(), *pds, "app.bsky.actor.profile", *my_did, "self")
 	if err != nil {
-		fmt.Println("Error:", err)
-		return HandleBlueskyError(c, err.Error(), "com.atproto.repo.getRecord", UpdateProfilePicture)
+		log.ErrorContext(c.UserContext(), "GetRecord failed", "error", err)
+		return HandleBlueskyError(c, err, "com.atproto.repo.getRecord", UpdateProfilePicture)
 	}
 
 	// get our new image
 	image, err := c.FormFile("image")
 	if err != nil {
-		fmt.Println("Error:", err)
+		log.ErrorContext(c.UserContext(), "Error reading uploaded image", "error", err)
 		return ReturnError(c, "Please upload an image", 195, 403) // idk about this error code, since it's for url params instead of post data.
 	}
 
 	// read the image file content
 	file, err := image.Open()
 	if err != nil {
-		fmt.Println("Error:", err)
+		log.ErrorContext(c.UserContext(), "Error reading uploaded image", "error", err)
 		return ReturnError(c, "Uploaded image is invalid.", 195, 403)
 	}
 	defer file.Close()
 
 	imageData, err := io.ReadAll(file)
 	if err != nil {
-		fmt.Println("Error:", err)
+		log.ErrorContext(c.UserContext(), "Error reading uploaded image", "error", err)
 		return ReturnError(c, "Uploaded image is invalid.", 195, 403)
 	}
 
 	// upload our new profile picture
-	profilePictureBlob, err := blueskyapi.UploadBlob(*pds, *oauthToken, imageData, c.Get("Content-Type"))
+	profilePictureBlob, err := blueskyapi.UploadBlob(c.UserContext(), *pds, *oauthToken, imageData, c.Get("Content-Type"))
 	if err != nil {
-		fmt.Println("Error:", err)
-		return HandleBlueskyError(c, err.Error(), "com.atproto.repo.uploadBlob", UpdateProfilePicture)
+		log.ErrorContext(c.UserContext(), "UploadBlob failed", "error", err)
+		return HandleBlueskyError(c, err, "com.atproto.repo.uploadBlob", UpdateProfilePicture)
 	}
 
 	// change our thing
 	oldProfile.Value.Avatar = *profilePictureBlob
 
-	if err := blueskyapi.UpdateRecord(*pds, *oauthToken, "app.bsky.actor.profile", *my_did, "self", oldProfile.CID, oldProfile.Value); err != nil {
-		fmt.Println("Error:", err)
-		return HandleBlueskyError(c, err.Error(), "com.atproto.repo.putRecord", UpdateProfilePicture)
+	if err := blueskyapi.UpdateRecord(c.UserContext(), *pds, *oauthToken, "app.bsky.actor.profile", *my_did, "self", oldProfile.CID, oldProfile.Value); err != nil {
+		log.ErrorContext(c.UserContext(), "UpdateRecord failed", "error", err)
+		return HandleBlueskyError(c, err, "com.atproto.repo.putRecord", UpdateProfilePicture)
 	}
 
-	user, err := blueskyapi.GetUserInfo(*pds, *oauthToken, *my_did, true)
+	user, err := blueskyapi.GetUserInfo(c.UserContext(), *pds, *oauthToken, *my_did, true)
 	if err != nil {
-		fmt.Println("Error:", err)
-		return HandleBlueskyError(c, err.Error(), "app.bsky.actor.getProfile", UpdateProfile)
+		log.ErrorContext(c.UserContext(), "GetUserInfo failed", "error", err)
+		return HandleBlueskyError(c, err, "app.bsky.actor.getProfile", UpdateProfile)
 	}
 
 	return EncodeAndSend(c, user)
@@ -0,0 +1,40 @@
+package twitterv1
+
+import (
+	"crypto/subtle"
+	"strings"
+	"time"
+
+	"github.com/Preloading/TwitterAPIBridge/db_controller"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Endpoints for whoever runs the server. These aren't part of the twitter API.
+
+// RequireAdmin only lets requests with the ADMIN_TOKEN through. If there's no token set, the admin endpoints don't exist.
+func RequireAdmin(c *fiber.Ctx) error {
+	if configData.AdminToken == "" {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+
+	token := strings.TrimPrefix(c.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(configData.AdminToken)) != 1 {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid admin token"})
+	}
+	return c.Next()
+}
+
+// /admin/analytics?days=30
+// Only uses the daily counters, so there's nothing personal in here.
+func AdminAnalytics(c *fiber.Ctx) error {
+	days := c.QueryInt("days", 30)
+	if days < 1 {
+		days = 1
+	}
+
+	summary, err := db_controller.GetAnalyticsSummary(time.Now().AddDate(0, 0, -(days - 1)))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(summary)
+}
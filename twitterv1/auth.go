@@ -1,6 +1,7 @@
 package twitterv1
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/url"
@@ -13,6 +14,7 @@ import (
 	"github.com/Preloading/TwitterAPIBridge/bridge"
 	"github.com/Preloading/TwitterAPIBridge/cryption"
 	"github.com/Preloading/TwitterAPIBridge/db_controller"
+	"github.com/Preloading/TwitterAPIBridge/metrics"
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
@@ -22,25 +24,25 @@ func ReturnSuccessfulAuth(c *fiber.Ctx, res blueskyapi.AuthResponse, pds string)
 	// Our bluesky authentication was sucessful! Now we should store the auth info, encryted, in the DB
 	encryptionkey, err := cryption.GenerateKey()
 	if err != nil {
-		fmt.Println("Error:", err)
+		log.ErrorContext(c.UserContext(), "GenerateKey failed", "error", err)
 		return ReturnError(c, "Failed to generate encryption key", 131, fiber.StatusInternalServerError)
 	}
 
 	access_token_expiry, err := cryption.GetJWTTokenExpirationUnix(res.AccessJwt)
 	if err != nil {
-		fmt.Println("Error:", err)
+		log.ErrorContext(c.UserContext(), "GetJWTTokenExpirationUnix failed", "error", err)
 		return ReturnError(c, "Failed to get token expiration.", 131, fiber.StatusInternalServerError)
 	}
 	refresh_token_expiry, err := cryption.GetJWTTokenExpirationUnix(res.RefreshJwt)
 	if err != nil {
-		fmt.Println("Error:", err)
+		log.ErrorContext(c.UserContext(), "GetJWTTokenExpirationUnix failed", "error", err)
 		return ReturnError(c, "Failed to get token expiration.", 131, fiber.StatusInternalServerError)
 	}
 
 	uuid, err := db_controller.StoreToken(res.DID, pds, res.AccessJwt, res.RefreshJwt, encryptionkey, *access_token_expiry, *refresh_token_expiry)
 
 	if err != nil {
-		fmt.Println("Error:", err)
+		log.ErrorContext(c.UserContext(), "StoreToken failed", "error", err)
 		return ReturnError(c, "Failed to store token, if this persists contact instance operator.", 131, fiber.StatusInternalServerError)
 	}
 	encryptionkey = strings.ReplaceAll(encryptionkey, "+", "-")
@@ -79,11 +81,11 @@ func ReturnSuccessfulAuth(c *fiber.Ctx, res blueskyapi.AuthResponse, pds string)
 		Timestamp:            time.Now(),
 	})
 
-	session, err := blueskyapi.GetSession(pds, res.AccessJwt)
+	session, err := blueskyapi.GetSession(c.UserContext(), pds, res.AccessJwt)
 	if err != nil {
 		return ReturnError(c, "Failed to get token information", 131, fiber.StatusInternalServerError)
 	}
-	return c.SendString(fmt.Sprintf("oauth_token=%s&oauth_token_secret=%s&user_id=%s&screen_name=%s&x_auth_expires=0", oauth_token, oauth_token, fmt.Sprintf("%d", bridge.BlueSkyToTwitterID(res.DID)), url.QueryEscape(session.Handle)))
+	return c.SendString(fmt.Sprintf("oauth_token=%s&oauth_token_secret=%s&user_id=%s&screen_name=%s&x_auth_expires=0", oauth_token, oauth_token, fmt.Sprintf("%d", bridge.BlueSkyToTwitterID(c.UserContext(), res.DID)), url.QueryEscape(session.Handle)))
 }
 
 // https://developer.x.com/en/docs/authentication/api-reference/access_token
@@ -97,9 +99,9 @@ func access_token(c *fiber.Ctx) error {
 	authUsername := c.FormValue("x_auth_username")
 
 	if authMode == "client_auth" {
-		res, pds, err := blueskyapi.Authenticate(authUsername, authPassword)
+		res, pds, err := blueskyapi.Authenticate(c.UserContext(), authUsername, authPassword)
 		if err != nil {
-			return HandleBlueskyError(c, err.Error(), "com.atproto.server.createSession", access_token)
+			return HandleBlueskyError(c, err, "com.atproto.server.createSession", access_token)
 		}
 
 		return ReturnSuccessfulAuth(c, *res, *pds)
@@ -113,12 +115,12 @@ func access_token(c *fiber.Ctx) error {
 		// if err != nil {
 		// 	return c.Status(fiber.StatusUnauthorized).SendString("Unauthorized")
 		// }
-		// authenticating_user, err := blueskyapi.GetUserInfo(*pds, *oauth_token, *my_did, true)
+		// authenticating_user, err := blueskyapi.GetUserInfo(c.UserContext(), *pds, *oauth_token, *my_did, true)
 		// if err != nil {
 		// 	return c.Status(fiber.StatusInternalServerError).SendString("Failed to fetch user info")
 		// }
 
-		// return c.SendString(fmt.Sprintf("oauth_token=%s&oauth_token_secret=%s&user_id=%s&screen_name=%s&x_auth_expires=0", *oauth_token, *oauth_token, fmt.Sprintf("%d", bridge.BlueSkyToTwitterID(*my_did)), url.QueryEscape(authenticating_user.ScreenName)))
+		// return c.SendString(fmt.Sprintf("oauth_token=%s&oauth_token_secret=%s&user_id=%s&screen_name=%s&x_auth_expires=0", *oauth_token, *oauth_token, fmt.Sprintf("%d", bridge.BlueSkyToTwitterID(c.UserContext(), *my_did)), url.QueryEscape(authenticating_user.ScreenName)))
 	} else if authMode == "" {
 		if c.Get("Authorization") != "" {
 			// this is likely an oauth request
@@ -137,10 +139,10 @@ func VerifyCredentials(c *fiber.Ctx) error {
 		return MissingAuth(c, err)
 	}
 
-	userinfo, err := blueskyapi.GetUserInfo(*pds, *oauthToken, *my_did, false)
+	userinfo, err := blueskyapi.GetUserInfo(c.UserContext(), *pds, *oauthToken, *my_did, false)
 
 	if err != nil {
-		fmt.Println("Error:", err)
+		log.ErrorContext(c.UserContext(), "GetUserInfo failed", "error", err)
 		return c.Status(fiber.StatusInternalServerError).SendString("Failed to fetch user info")
 	}
 
@@ -153,13 +155,12 @@ func VerifyCredentials(c *fiber.Ctx) error {
 // @return: userDID, pds, tokenUUID, accessJwt, error
 func GetAuthFromReq(c *fiber.Ctx) (*string, *string, *string, *string, error) {
 	authHeader := c.Get("Authorization")
-	fallbackRoute := "https://public.api.bsky.app"
-	if configData.DeveloperMode {
-		fmt.Println("Auth Header:", authHeader)
-	}
+	fallbackRoute := blueskyapi.AppViewURL()
+	log.DebugContext(c.UserContext(), "Auth header", "header", authHeader)
 	var accessJwt, refreshJwt, userPDS, basicHashSalt, basicAuthSalt, basicUUID *string
 	var userDID, tokenUUID, encryptionKey, basicAuthUsernamePassword, authPassword string
 	var access_expiry, refresh_expiry *float64
+	var tokenType int
 	var err error
 
 	isBasic := false
@@ -185,8 +186,8 @@ func GetAuthFromReq(c *fiber.Ctx) (*string, *string, *string, *string, error) {
 		authPassword = strings.Split(basicAuthUsernamePassword, ":")[1]
 
 		accessJwt, refreshJwt, access_expiry, refresh_expiry, userPDS, did, basicHashSalt, basicAuthSalt, basicUUID, err = db_controller.GetTokenViaBasic(username, authPassword)
-		fmt.Println(err)
 		if err != nil {
+			log.DebugContext(c.UserContext(), "No basic auth session", "username", username, "error", err)
 			// We might just not be signed in.
 			if err.Error() == "invalid credentials" {
 				// test if password is an app password thru regex
@@ -194,7 +195,7 @@ func GetAuthFromReq(c *fiber.Ctx) (*string, *string, *string, *string, error) {
 					return &nodid, &fallbackRoute, nil, &notoken, errors.New("invalid app password")
 				}
 
-				res, pds, err := blueskyapi.Authenticate(username, authPassword)
+				res, pds, err := blueskyapi.Authenticate(c.UserContext(), username, authPassword)
 				if err != nil {
 					return &nodid, &fallbackRoute, nil, &notoken, err
 				}
@@ -231,7 +232,7 @@ func GetAuthFromReq(c *fiber.Ctx) (*string, *string, *string, *string, error) {
 		oauthToken := matches[1]
 
 		tokenData := &bridge.AuthToken{}
-		tokenType := CheckTokenType(oauthToken)
+		tokenType = CheckTokenType(oauthToken)
 
 		if tokenType == 1 && configData.MinTokenVersion == 1 {
 			tokenData, err = ConvertV1TokenToV2(oauthToken)
@@ -278,20 +279,32 @@ func GetAuthFromReq(c *fiber.Ctx) (*string, *string, *string, *string, error) {
 		}
 	}
 
-	if configData.DeveloperMode {
-		fmt.Println("Access Token", *accessJwt)
+	log.DebugContext(c.UserContext(), "Using access token", "did", userDID, "access_jwt", *accessJwt, "access_expiry", time.Unix(int64(*access_expiry), 0))
+
+	session := &authSession{
+		did:           userDID,
+		tokenUUID:     tokenUUID,
+		encryptionKey: encryptionKey,
+		tokenType:     tokenType,
+		isBasic:       isBasic,
+		username:      username,
+		password:      authPassword,
+	}
+	if isBasic {
+		session.basicHashSalt, session.basicAuthSalt, session.basicUUID = *basicHashSalt, *basicAuthSalt, *basicUUID
 	}
 
 	// Check if the access token has expired
 	if time.Unix(int64(*access_expiry), 0).Before(time.Now()) {
 		// Get a lock before attempting refresh
-		userLock := GetLock(tokenUUID)
+		userLock := GetLock(session.lockKey())
 		userLock.Lock()
 		defer userLock.Unlock()
 
 		// Check if we were locked
-		if _, _, currentAccessExpiry, _, _, err := db_controller.GetToken(string(userDID), string(tokenUUID), encryptionKey, 2); err == nil && *currentAccessExpiry != *access_expiry {
+		if _, _, currentAccessExpiry, _, _, err := session.load(); err == nil && *currentAccessExpiry != *access_expiry {
 			// Token data has changed while we were waiting for the lock, let's recall this
+			metrics.TokenRefreshes.Inc("raced")
 			return GetAuthFromReq(c)
 		}
 
@@ -304,43 +317,123 @@ func GetAuthFromReq(c *fiber.Ctx) (*string, *string, *string, *string, error) {
 		// Lets check if our refresh token has expired
 		if time.Unix(int64(*refresh_expiry), 0).Before(time.Now()) {
 			// Our refresh token has expired. We need to re-authenticate.
-			// Delete this entry from the database
-			if isBasic {
-				db_controller.DeleteTokenViaBasic(username, authPassword)
-			} else {
-				db_controller.DeleteToken(string(userDID), string(tokenUUID))
-			}
+			metrics.TokenRefreshes.Inc("expired")
+			session.delete()
 			return &nodid, &fallbackRoute, nil, &notoken, errors.New("refresh token has expired")
 		}
 
 		// Our refresh token is still valid. Lets refresh our access token.
-		new_auth, err := blueskyapi.RefreshToken(*userPDS, *refreshJwt)
-
+		accessJwt, err = session.refresh(c.UserContext(), *userPDS, *refreshJwt)
 		if err != nil {
 			return &nodid, &fallbackRoute, nil, &notoken, err
 		}
+	}
 
-		accessJwt = &new_auth.AccessJwt
+	// Bluesky can still say the token's expired (ex. our clock is off, or it was revoked), so refresh it then too.
+	c.SetUserContext(blueskyapi.WithTokenRefresher(c.UserContext(), session.refreshExpired))
 
-		access_token_expiry, err := cryption.GetJWTTokenExpirationUnix(new_auth.AccessJwt)
-		if err != nil {
-			return &nodid, &fallbackRoute, nil, &notoken, errors.New("failed to get access token expiry")
-		}
-		refresh_token_expiry, err := cryption.GetJWTTokenExpirationUnix(new_auth.RefreshJwt)
-		if err != nil {
-			return &nodid, &fallbackRoute, nil, &notoken, errors.New("failed to get refresh token expiry")
-		}
+	userDIDStr := string(userDID)
+	return &userDIDStr, userPDS, &tokenUUID, accessJwt, nil
+}
 
-		// TODO: Recheck if the user id is still bound to that PDS
-		if isBasic {
-			db_controller.UpdateTokenBasic(userDID, *userPDS, new_auth.AccessJwt, new_auth.RefreshJwt, *access_token_expiry, *refresh_token_expiry, username, authPassword, *basicHashSalt, *basicAuthSalt, *basicUUID)
-		} else {
-			db_controller.UpdateToken(string(tokenUUID), string(userDID), *userPDS, new_auth.AccessJwt, new_auth.RefreshJwt, encryptionKey, *access_token_expiry, *refresh_token_expiry, 2)
-		}
+// authSession is everything needed to look up, refresh & save someone's bluesky tokens, whichever way they logged in.
+type authSession struct {
+	did           string
+	tokenUUID     string
+	encryptionKey string
+	tokenType     int
+
+	isBasic                                 bool
+	username, password                      string
+	basicHashSalt, basicAuthSalt, basicUUID string
+}
+
+// lockKey is what to lock on while refreshing, so two requests don't both refresh the same token.
+func (s *authSession) lockKey() string {
+	if s.isBasic {
+		return s.basicUUID
 	}
+	return s.tokenUUID
+}
 
-	userDIDStr := string(userDID)
-	return &userDIDStr, userPDS, &tokenUUID, accessJwt, nil
+// load gets the tokens as they are in the database right now.
+// @return: accessJwt, refreshJwt, accessExpiry, refreshExpiry, pds, error
+func (s *authSession) load() (*string, *string, *float64, *float64, *string, error) {
+	if s.isBasic {
+		accessJwt, refreshJwt, accessExpiry, refreshExpiry, pds, _, _, _, _, err := db_controller.GetTokenViaBasic(s.username, s.password)
+		return accessJwt, refreshJwt, accessExpiry, refreshExpiry, pds, err
+	}
+	return db_controller.GetToken(s.did, s.tokenUUID, s.encryptionKey, s.tokenType)
+}
+
+func (s *authSession) delete() {
+	if s.isBasic {
+		db_controller.DeleteTokenViaBasic(s.username, s.password)
+	} else {
+		db_controller.DeleteToken(s.did, s.tokenUUID)
+	}
+}
+
+// refresh gets a new access token from bluesky and saves it. The caller should be holding the lock.
+func (s *authSession) refresh(ctx context.Context, pds string, refreshJwt string) (*string, error) {
+	new_auth, err := blueskyapi.RefreshToken(ctx, pds, refreshJwt)
+	if err != nil {
+		metrics.TokenRefreshes.Inc("failure")
+		return nil, err
+	}
+
+	access_token_expiry, err := cryption.GetJWTTokenExpirationUnix(new_auth.AccessJwt)
+	if err != nil {
+		return nil, errors.New("failed to get access token expiry")
+	}
+	refresh_token_expiry, err := cryption.GetJWTTokenExpirationUnix(new_auth.RefreshJwt)
+	if err != nil {
+		return nil, errors.New("failed to get refresh token expiry")
+	}
+
+	// TODO: Recheck if the user id is still bound to that PDS
+	// refresh tokens are single use, so if this doesn't save the old one is useless, and they'll have to log in again
+	if s.isBasic {
+		_, err = db_controller.UpdateTokenBasic(s.did, pds, new_auth.AccessJwt, new_auth.RefreshJwt, *access_token_expiry, *refresh_token_expiry, s.username, s.password, s.basicHashSalt, s.basicAuthSalt, s.basicUUID)
+	} else {
+		_, err = db_controller.UpdateToken(s.tokenUUID, s.did, pds, new_auth.AccessJwt, new_auth.RefreshJwt, s.encryptionKey, *access_token_expiry, *refresh_token_expiry, s.tokenType)
+	}
+	if err != nil {
+		metrics.TokenRefreshes.Inc("failure")
+		log.ErrorContext(ctx, "Failed to save refreshed token", "did", s.did, "error", err)
+		return nil, fmt.Errorf("failed to save refreshed token: %w", err)
+	}
+
+	metrics.TokenRefreshes.Inc("success")
+	return &new_auth.AccessJwt, nil
+}
+
+// refreshExpired is the blueskyapi.TokenRefresher for a session. It's used when bluesky says a token's expired,
+// even though going by its expiry it shouldn't be.
+func (s *authSession) refreshExpired(ctx context.Context, expiredToken string) (string, error) {
+	userLock := GetLock(s.lockKey())
+	userLock.Lock()
+	defer userLock.Unlock()
+
+	accessJwt, refreshJwt, _, refreshExpiry, pds, err := s.load()
+	if err != nil {
+		return "", err
+	}
+	if *accessJwt != expiredToken {
+		// Someone else refreshed it while we were waiting
+		metrics.TokenRefreshes.Inc("raced")
+		return *accessJwt, nil
+	}
+	if time.Unix(int64(*refreshExpiry), 0).Before(time.Now()) {
+		metrics.TokenRefreshes.Inc("expired")
+		return "", errors.New("refresh token has expired")
+	}
+
+	newAccessJwt, err := s.refresh(ctx, *pds, *refreshJwt)
+	if err != nil {
+		return "", err
+	}
+	return *newAccessJwt, nil
 }
 
 func GetEncryptionKeyFromRequest(c *fiber.Ctx) (*string, error) {
@@ -0,0 +1,98 @@
+package twitterv1_test
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	blueskyapi "github.com/Preloading/TwitterAPIBridge/bluesky"
+	"github.com/Preloading/TwitterAPIBridge/cryption"
+	"github.com/Preloading/TwitterAPIBridge/db_controller"
+	"github.com/Preloading/TwitterAPIBridge/fakepds"
+	"github.com/google/uuid"
+)
+
+// refreshes is how many times the bridge has refreshed a session with the fake PDS.
+func refreshes(h *fakepds.Harness) int {
+	count := 0
+	for _, request := range h.PDS.Requests() {
+		if request.Method == "com.atproto.server.refreshSession" {
+			count++
+		}
+	}
+	return count
+}
+
+// expireAndCall expires every access token, then makes a request that has to refresh to work. Refresh tokens are
+// single use, so doing it twice only works if the first refresh got saved.
+func expireAndCall(t *testing.T, h *fakepds.Harness, auth string) {
+	t.Helper()
+	for i := 1; i <= 2; i++ {
+		before := refreshes(h)
+		h.PDS.ExpireAccessTokens()
+		h.Call(t, http.MethodGet, "/1/statuses/home_timeline.json", nil, auth, nil)
+		if refreshes(h) != before+1 {
+			t.Fatalf("refresh %d: the bridge refreshed %d times", i, refreshes(h)-before)
+		}
+	}
+	// and the new token works without refreshing again
+	before := refreshes(h)
+	h.Call(t, http.MethodGet, "/1/statuses/home_timeline.json", nil, auth, nil)
+	if refreshes(h) != before {
+		t.Errorf("refreshed a token that hadn't expired")
+	}
+}
+
+func TestRefreshOAuth(t *testing.T) {
+	h := fakepds.NewTestHarness(t, nil)
+	expireAndCall(t, h, h.MustLogin(t, "alice.test", "dev_alice-pass"))
+}
+
+// Basic auth (iOS 2) sessions are saved under the same UUID when refreshed.
+func TestRefreshBasicAuth(t *testing.T) {
+	const appPassword = "abcd-efgh-ijkl-mnop" // basic auth only takes app passwords
+	fixtures := fakepds.DefaultFixtures()
+	fixtures.Accounts[0].Password = appPassword
+	h := fakepds.NewTestHarness(t, fixtures)
+
+	auth := "Basic " + base64.StdEncoding.EncodeToString([]byte(fixtures.Accounts[0].Handle+":"+appPassword))
+	h.Call(t, http.MethodGet, "/1/account/verify_credentials.json", nil, auth, nil) // logs in
+	expireAndCall(t, h, auth)
+}
+
+// Sessions from before JWT tokens (token version 1) have to stay version 1 when refreshed, or they can't be found again.
+func TestRefreshV1Token(t *testing.T) {
+	h := fakepds.NewTestHarness(t, nil)
+	res, pds, err := blueskyapi.Authenticate(context.Background(), "alice.test", "dev_alice-pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := cryption.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	refreshExpiry, err := cryption.GetJWTTokenExpirationUnix(res.RefreshJwt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenUUID := uuid.NewString()
+	// already expired going by the database, so the bridge refreshes it before calling bluesky
+	expired := float64(time.Now().Add(-time.Minute).Unix())
+	if _, err := db_controller.UpdateToken(tokenUUID, res.DID, *pds, res.AccessJwt, res.RefreshJwt, key, expired, *refreshExpiry, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	urlKey := strings.TrimRight(strings.NewReplacer("+", "-", "/", "_").Replace(key), "=")
+	v1Token := base64.RawURLEncoding.EncodeToString([]byte(res.DID)) + "." + base64.RawURLEncoding.EncodeToString([]byte(tokenUUID)) + "." + urlKey
+	auth := fmt.Sprintf(`OAuth oauth_token="%s"`, v1Token)
+
+	h.Call(t, http.MethodGet, "/1/statuses/home_timeline.json", nil, auth, nil)
+	if refreshes(h) != 1 {
+		t.Fatalf("the bridge refreshed %d times", refreshes(h))
+	}
+	expireAndCall(t, h, auth)
+}
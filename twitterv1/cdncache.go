@@ -0,0 +1,45 @@
+package twitterv1
+
+import (
+	"sync/atomic"
+
+	"github.com/Preloading/TwitterAPIBridge/diskcache"
+	"github.com/Preloading/TwitterAPIBridge/metrics"
+)
+
+// Resized images from CDNDownscaler are kept on disk (in CDN_CACHE_DIR), so the same image isn't downloaded & resized
+// again for every client that loads it.
+
+// set up in NewApp. Without CDN_CACHE_DIR nothing is kept, but requests for the same image still only fetch it once.
+// Atomic because the metrics gauges read it from the metrics server's goroutine, whenever they're scraped.
+var cdnCache atomic.Pointer[diskcache.Cache]
+
+func init() {
+	noCache, _ := diskcache.Open("", 0)
+	cdnCache.Store(noCache)
+
+	metrics.NewGaugeFunc("twitterbridge_cdn_cache_bytes", "Size of the resized images in the CDN cache.",
+		func() float64 {
+			size, _ := cdnCache.Load().Size()
+			return float64(size)
+		})
+	metrics.NewGaugeFunc("twitterbridge_cdn_cache_files", "Number of resized images in the CDN cache.",
+		func() float64 {
+			_, files := cdnCache.Load().Size()
+			return float64(files)
+		})
+}
+
+func setupCDNCache() {
+	if configData.CdnCacheDir == "" {
+		noCache, _ := diskcache.Open("", 0)
+		cdnCache.Store(noCache)
+		return
+	}
+	cache, err := diskcache.Open(configData.CdnCacheDir, int64(configData.CdnCacheMaxMB)*1024*1024)
+	if err != nil {
+		log.Warn("Couldn't open the CDN cache, images won't be cached", "dir", configData.CdnCacheDir, "error", err)
+		cache, _ = diskcache.Open("", 0)
+	}
+	cdnCache.Store(cache)
+}
@@ -0,0 +1,42 @@
+package twitterv1
+
+import (
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/Preloading/TwitterAPIBridge/config"
+	"github.com/Preloading/TwitterAPIBridge/metrics"
+)
+
+// Setting the CDN cache up again (ex. a new app in tests) while the metrics are scraped. Run with -race.
+func TestCDNCacheSetupWhileScraped(t *testing.T) {
+	oldConfig, oldCache := configData, cdnCache.Load()
+	configData = &config.Config{CdnCacheDir: t.TempDir(), CdnCacheMaxMB: 1}
+	t.Cleanup(func() {
+		configData = oldConfig
+		cdnCache.Store(oldCache)
+	})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for range 200 {
+			metrics.WritePrometheus(io.Discard)
+		}
+	}()
+	for range 200 {
+		setupCDNCache()
+	}
+	wg.Wait()
+
+	entry, err := cdnCache.Load().Get("key", func() ([]byte, string, error) { return []byte("image"), "image/jpeg", nil })
+	if err != nil || string(entry.Data) != "image" {
+		t.Fatalf("got %v, %v", entry, err)
+	}
+	setupCDNCache()
+	if _, files := cdnCache.Load().Size(); files != 1 {
+		t.Errorf("the cache has %d files, it should've picked up the one already in the folder", files)
+	}
+}
@@ -1,6 +1,7 @@
 package twitterv1
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -10,6 +11,7 @@ import (
 	"strings"
 
 	blueskyapi "github.com/Preloading/TwitterAPIBridge/bluesky"
+	"github.com/Preloading/TwitterAPIBridge/diskcache"
 	"github.com/gofiber/fiber/v2"
 	"github.com/h2non/bimg"
 )
@@ -100,33 +102,74 @@ func CDNDownscaler(c *fiber.Ctx) error {
 		height = 0
 	}
 
+	profile := imageProfileFor(c)
+	// everything that changes what we send back, after the suffixes are worked out
+	cacheKey := fmt.Sprintf("%s|w=%d|h=%d|resize=%s|aspect=%t|%s", imageURL, width, height, resizeOption, maintainAspect, profile.cacheKey())
+	entry, err := cdnCache.Load().Get(cacheKey, func() ([]byte, string, error) {
+		return fetchAndResize(imageURL, width, height, resizeOption, maintainAspect, profile)
+	})
+	if errors.Is(err, diskcache.ErrNotStored) {
+		log.WarnContext(c.UserContext(), "Couldn't store an image in the CDN cache", "error", err)
+	} else if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	c.Response().Header.Set("Cache-Control", "public, max-age=1209600")
+	c.Set("Vary", "User-Agent, X-Twitter-Client, Accept")
+	c.Set("ETag", entry.ETag)
+	c.Set("Last-Modified", entry.ModTime.UTC().Format(http.TimeFormat))
+	if notModified(c, entry) {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+	c.Set("Content-Type", entry.ContentType)
+	return c.Send(entry.Data)
+}
+
+// notModified is whether the client already has entry, from If-None-Match, or If-Modified-Since if it didn't send that.
+func notModified(c *fiber.Ctx, entry *diskcache.Entry) bool {
+	if ifNoneMatch := c.Get("If-None-Match"); ifNoneMatch != "" {
+		for _, tag := range strings.Split(ifNoneMatch, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == entry.ETag {
+				return true
+			}
+		}
+		return false
+	}
+	if ifModifiedSince := c.Get("If-Modified-Since"); ifModifiedSince != "" {
+		since, err := http.ParseTime(ifModifiedSince)
+		return err == nil && !entry.ModTime.After(since)
+	}
+	return false
+}
+
+// fetchAndResize gets an image from bluesky's CDN, resizes it, and encodes it for the client (see encodeForClient).
+// The errors are what the client gets told.
+func fetchAndResize(imageURL string, width int, height int, resizeOption string, maintainAspect bool, profile imageProfile) ([]byte, string, error) {
 	resp, err := httpClient.Get(imageURL)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).SendString("Failed to fetch image")
+		return nil, "", errors.New("Failed to fetch image")
 	}
 	defer resp.Body.Close()
-
-	if resizeOption == "none" || (width == 0 && height == 0) {
-		c.Response().Header.Set("Cache-Control", "public, max-age=1209600")
-		c.Response().Header.Set("Content-Type", resp.Header.Get("Content-Type"))
-		imgBytes, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).SendString("Failed to recieve image")
-		}
-		return c.Send(imgBytes)
+	if resp.StatusCode != http.StatusOK {
+		// don't want to be caching bluesky's error pages
+		return nil, "", errors.New("Failed to fetch image")
 	}
 
 	imgBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).SendString("Failed to recieve image")
+		return nil, "", errors.New("Failed to recieve image")
 	}
 
-	imgMetadata, err := bimg.Metadata(imgBytes)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).SendString("bad img")
+	if resizeOption == "none" {
+		width, height = 0, 0
 	}
 
-	if maintainAspect {
+	if maintainAspect && (width > 0 || height > 0) {
+		imgMetadata, err := bimg.Metadata(imgBytes)
+		if err != nil {
+			return nil, "", errors.New("bad img")
+		}
 		w, h := imgMetadata.Size.Width, imgMetadata.Size.Height
 		if w > h {
 			w = width
@@ -139,44 +182,7 @@ func CDNDownscaler(c *fiber.Ctx) error {
 		height = h
 	}
 
-	o := bimg.Options{}
-
-	if width > 0 || height > 0 {
-		switch resizeOption {
-		case "fit":
-			o = bimg.Options{
-				Height: height,
-				Width:  width,
-			}
-		case "crop":
-			o = bimg.Options{
-				Height: height,
-				Width:  width,
-				Crop:   true,
-			}
-		case "none":
-			c.Set("Content-Type", "image/"+imgMetadata.Type)
-
-			c.Response().Header.Set("Cache-Control", "public, max-age=1209600")
-			return c.Send(imgBytes)
-			// Do nothing
-		default:
-			o = bimg.Options{
-				Height: height,
-				Width:  width,
-			}
-		}
-	}
-
-	imgScaled, err := bimg.Resize(imgBytes, o)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).SendString("Failed to encode image")
-	}
-
-	c.Set("Content-Type", "image/"+imgMetadata.Type)
-
-	c.Response().Header.Set("Cache-Control", "public, max-age=1209600")
-	return c.Send(imgScaled)
+	return encodeForClient(imgBytes, width, height, resizeOption == "crop", profile)
 }
 
 func UserProfileImage(c *fiber.Ctx) error {
@@ -192,27 +198,24 @@ func UserProfileImage(c *fiber.Ctx) error {
 	if screen_name == "" {
 		return c.Status(fiber.StatusBadRequest).SendString("screen_name is required")
 	}
-	// size := c.Query("size")
-	// if size == "" {
-	// 	size = "normal"
-	// }
-	// cdn_size := ":profile_bigger"
-
-	// switch size {
-	// case "normal":
-	// 	cdn_size = ":profile_normal"
-	// case "original":
-	// 	cdn_size = ":large"
-	// }
+	cdn_size := ":profile_normal"
+	switch c.Query("size") {
+	case "bigger":
+		cdn_size = ":profile_bigger"
+	case "mini":
+		cdn_size = ":profile_mini"
+	case "original":
+		cdn_size = ":large"
+	}
 
-	userinfo, err := blueskyapi.GetUserInfo(*pds, *oauthToken, screen_name, false)
+	userinfo, err := blueskyapi.GetUserInfo(c.UserContext(), *pds, *oauthToken, screen_name, false)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).SendString("Failed to fetch user info")
 	}
 
-	//c.Redirect("https://cdn.bsky.app/img/" + screen_name + ":profile_bigger")
+	// to our CDN proxy, which sends it in a format (and size) the client can handle
 	c.Set("Cache-Control", "public, max-age=900") // 15 minutes
-	return c.Redirect(userinfo.ProfileImageURL)
+	return c.Redirect(strings.TrimSuffix(userinfo.ProfileImageURL, ":profile_bigger") + cdn_size)
 }
 
 // This is here because it doesn't just want a direct link to the m3u8 file.
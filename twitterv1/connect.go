@@ -1,7 +1,6 @@
 package twitterv1
 
 import (
-	"fmt"
 	"net/url"
 	"strconv"
 	"strings"
@@ -21,11 +20,11 @@ func UserSearch(c *fiber.Ctx) error {
 		return MissingAuth(c, err)
 	}
 	// Search for users
-	bskyUsers, err := blueskyapi.UserSearch(*pds, *oauthToken, searchQuery)
+	bskyUsers, err := blueskyapi.UserSearch(c.UserContext(), *pds, *oauthToken, searchQuery)
 
 	if err != nil {
-		fmt.Println("Error:", err)
-		return HandleBlueskyError(c, err.Error(), "app.bsky.actor.searchActors", UserSearch)
+		log.ErrorContext(c.UserContext(), "UserSearch failed", "error", err)
+		return HandleBlueskyError(c, err, "app.bsky.actor.searchActors", UserSearch)
 	}
 	// Get complete user info.
 	// We must do this as the search API only returns a subset of the user info, and twitter wants all of it.
@@ -38,10 +37,10 @@ func UserSearch(c *fiber.Ctx) error {
 	if len(dids) == 0 {
 		return EncodeAndSend(c, []bridge.TwitterUser{})
 	}
-	users, err := blueskyapi.GetUsersInfo(*pds, *oauthToken, dids, false)
+	users, err := blueskyapi.GetUsersInfo(c.UserContext(), *pds, *oauthToken, dids, false)
 	if err != nil {
-		fmt.Println("Error:", err)
-		return HandleBlueskyError(c, err.Error(), "app.bsky.actor.getProfiles", UserSearch)
+		log.ErrorContext(c.UserContext(), "GetUsersInfo failed", "error", err)
+		return HandleBlueskyError(c, err, "app.bsky.actor.getProfiles", UserSearch)
 	}
 
 	return EncodeAndSend(c, users)
@@ -88,11 +87,11 @@ func SearchAhead(c *fiber.Ctx) error {
 	}
 
 	// Search for users
-	bskyUsers, err := blueskyapi.UserSearchAhead(*pds, *oauthToken, searchQuery, limitInt)
+	bskyUsers, err := blueskyapi.UserSearchAhead(c.UserContext(), *pds, *oauthToken, searchQuery, limitInt)
 
 	if err != nil {
-		fmt.Println("Error:", err)
-		return HandleBlueskyError(c, err.Error(), "app.bsky.actor.searchActorsTypeahead", SearchAhead)
+		log.ErrorContext(c.UserContext(), "UserSearchAhead failed", "error", err)
+		return HandleBlueskyError(c, err, "app.bsky.actor.searchActorsTypeahead", SearchAhead)
 	}
 
 	if len(bskyUsers) == 0 {
@@ -106,7 +105,7 @@ func SearchAhead(c *fiber.Ctx) error {
 	// Converting into a summarized version of the user
 	users := make([]bridge.SummarisedUser, len(bskyUsers))
 	for i, user := range bskyUsers {
-		userId := bridge.BlueSkyToTwitterID(user.DID)
+		userId := bridge.BlueSkyToTwitterID(c.UserContext(), user.DID)
 		pfp_url := configData.CdnURL + "/cdn/img/?url=" + url.QueryEscape(user.Avatar) + "@jpeg:profile_bigger"
 		users[i] = bridge.SummarisedUser{
 			ID:                   *userId,
@@ -177,9 +176,9 @@ func GetMyActivity(c *fiber.Ctx) error {
 	}
 
 	// Get notifications
-	bskyNotifications, err := blueskyapi.GetNotifications(*pds, *oauthToken, count, context)
+	bskyNotifications, err := blueskyapi.GetNotifications(c.UserContext(), *pds, *oauthToken, count, context)
 	if err != nil {
-		return HandleBlueskyError(c, err.Error(), "app.bsky.notification.listNotifications", GetMyActivity)
+		return HandleBlueskyError(c, err, "app.bsky.notification.listNotifications", GetMyActivity)
 	}
 
 	// Track unique users and posts
@@ -218,7 +217,7 @@ func GetMyActivity(c *fiber.Ctx) error {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		users, err := blueskyapi.GetUsersInfo(*pds, *oauthToken, usersToLookUp, false)
+		users, err := blueskyapi.GetUsersInfo(c.UserContext(), *pds, *oauthToken, usersToLookUp, false)
 		if err == nil {
 			for _, user := range users {
 				// Store by DID instead of screenName
@@ -234,8 +233,8 @@ func GetMyActivity(c *fiber.Ctx) error {
 		go func(posts []string) {
 			defer wg.Done()
 			for _, postID := range posts {
-				if post, err := blueskyapi.GetPost(*pds, *oauthToken, postID, 0, 1); err == nil {
-					tweet := TranslatePostToTweet(
+				if post, err := blueskyapi.GetPost(c.UserContext(), *pds, *oauthToken, postID, 0, 1); err == nil {
+					tweet := TranslatePostToTweet(c.UserContext(),
 						post.Thread.Post,
 						func() string {
 							if post.Thread.Parent != nil {
@@ -1,7 +1,6 @@
 package twitterv1
 
 import (
-	"fmt"
 	"net/url"
 	"strconv"
 	"strings"
@@ -21,7 +20,7 @@ import (
 func InternalSearch(c *fiber.Ctx) error {
 	// Thank you so much @Savefade for what this should repsond.
 	q := c.Query("q")
-	fmt.Println("Search query:", q)
+	log.DebugContext(c.UserContext(), "Searching", "query", q)
 
 	_, pds, _, oauthToken, err := GetAuthFromReq(c)
 	if err != nil {
@@ -37,7 +36,7 @@ func InternalSearch(c *fiber.Ctx) error {
 		if err != nil {
 			return ReturnError(c, "An invalid max_id has been specified", 195, fiber.StatusBadRequest)
 		}
-		_, until, _, err = bridge.TwitterMsgIdToBluesky(&maxIDInt)
+		until, err = bridge.TwitterMsgIdToTime(maxIDInt)
 		if err != nil {
 			return ReturnError(c, "An invalid max_id has been specified", 195, fiber.StatusBadRequest)
 		}
@@ -50,17 +49,17 @@ func InternalSearch(c *fiber.Ctx) error {
 		if err != nil {
 			return ReturnError(c, "An invalid since_id has been specified", 195, fiber.StatusBadRequest)
 		}
-		_, until, _, err = bridge.TwitterMsgIdToBluesky(&sinceIDInt)
+		since, err = bridge.TwitterMsgIdToTime(sinceIDInt)
 		if err != nil {
 			return ReturnError(c, "An invalid since_id has been specified", 195, fiber.StatusBadRequest)
 		}
 	}
 
-	bskySearch, err := blueskyapi.PostSearch(*pds, *oauthToken, q, since, until)
+	bskySearch, err := blueskyapi.PostSearch(c.UserContext(), *pds, *oauthToken, q, since, until)
 
 	if err != nil {
-		fmt.Println("Error:", err)
-		return HandleBlueskyError(c, err.Error(), "app.bsky.feed.searchPosts", InternalSearch)
+		log.ErrorContext(c.UserContext(), "PostSearch failed", "error", err)
+		return HandleBlueskyError(c, err, "app.bsky.feed.searchPosts", InternalSearch)
 	}
 
 	// Optimization: Get all users at once so we don't have to do it in chunks
@@ -68,7 +67,7 @@ func InternalSearch(c *fiber.Ctx) error {
 	for _, search := range bskySearch {
 		dids = append(dids, search.Author.DID)
 	}
-	blueskyapi.GetUsersInfo(*pds, *oauthToken, dids, false) // add to cache
+	blueskyapi.GetUsersInfo(c.UserContext(), *pds, *oauthToken, dids, false) // add to cache
 
 	replyUrls := []string{}
 
@@ -79,10 +78,10 @@ func InternalSearch(c *fiber.Ctx) error {
 	}
 
 	// Get all the replies
-	replyToPostData, err := blueskyapi.GetPosts(*pds, *oauthToken, replyUrls)
+	replyToPostData, err := blueskyapi.GetPosts(c.UserContext(), *pds, *oauthToken, replyUrls)
 	if err != nil {
-		fmt.Println("Error:", err)
-		return HandleBlueskyError(c, err.Error(), "app.bsky.feed.getPosts", InternalSearch)
+		log.ErrorContext(c.UserContext(), "GetPosts failed", "error", err)
+		return HandleBlueskyError(c, err, "app.bsky.feed.getPosts", InternalSearch)
 	}
 
 	// Create a map for quick lookup of reply dates and user IDs
@@ -114,9 +113,9 @@ func InternalSearch(c *fiber.Ctx) error {
 		}
 
 		if replyDate == nil {
-			tweets = append(tweets, TranslatePostToTweet(search, "", "", "", nil, nil, *oauthToken, *pds))
+			tweets = append(tweets, TranslatePostToTweet(c.UserContext(), search, "", "", "", nil, nil, *oauthToken, *pds))
 		} else {
-			tweets = append(tweets, TranslatePostToTweet(search, search.Record.Reply.Parent.URI, *replyUserId, *replyUserHandle, replyDate, nil, *oauthToken, *pds))
+			tweets = append(tweets, TranslatePostToTweet(c.UserContext(), search, search.Record.Reply.Parent.URI, *replyUserId, *replyUserHandle, replyDate, nil, *oauthToken, *pds))
 		}
 
 	}
@@ -141,10 +140,10 @@ func trends_woeid(c *fiber.Ctx) error {
 	}
 
 	// Get trends
-	bsky_trends, err := blueskyapi.GetTrends(*pds, *oauthToken)
+	bsky_trends, err := blueskyapi.GetTrends(c.UserContext(), *pds, *oauthToken)
 	if err != nil {
-		fmt.Println("Error:", err)
-		return HandleBlueskyError(c, err.Error(), "app.bsky.unspecced.getTrendingTopics", trends_woeid)
+		log.ErrorContext(c.UserContext(), "GetTrends failed", "error", err)
+		return HandleBlueskyError(c, err, "app.bsky.unspecced.getTrendingTopics", trends_woeid)
 	}
 
 	trends := []bridge.Trend{}
@@ -184,19 +183,19 @@ func discovery(c *fiber.Ctx) error {
 		oauthToken = &blankstring
 	}
 
-	thread, err := blueskyapi.GetPost(*pds, *oauthToken, "at://did:plc:khcyntihpu7snjszuojjgjc4/app.bsky.feed.post/3lfgrcq4di22c", 0, 1)
+	thread, err := blueskyapi.GetPost(c.UserContext(), *pds, *oauthToken, "at://did:plc:khcyntihpu7snjszuojjgjc4/app.bsky.feed.post/3lfgrcq4di22c", 0, 1)
 
 	if err != nil {
-		return HandleBlueskyError(c, err.Error(), "app.bsky.feed.getPostThread", discovery)
+		return HandleBlueskyError(c, err, "app.bsky.feed.getPostThread", discovery)
 	}
 
 	var displayTweet bridge.Tweet
 
 	// TODO: Some things may be needed for reposts to show up correctly. thats a later problem :)
 	if thread.Thread.Parent == nil {
-		displayTweet = TranslatePostToTweet(thread.Thread.Post, "", "", "", nil, nil, *oauthToken, *pds)
+		displayTweet = TranslatePostToTweet(c.UserContext(), thread.Thread.Post, "", "", "", nil, nil, *oauthToken, *pds)
 	} else {
-		displayTweet = TranslatePostToTweet(thread.Thread.Post, thread.Thread.Parent.Post.URI, thread.Thread.Parent.Post.Author.DID, thread.Thread.Parent.Post.Author.Handle, &thread.Thread.Parent.Post.Record.CreatedAt.Time, nil, *oauthToken, *pds)
+		displayTweet = TranslatePostToTweet(c.UserContext(), thread.Thread.Post, thread.Thread.Parent.Post.URI, thread.Thread.Parent.Post.Author.DID, thread.Thread.Parent.Post.Author.Handle, &thread.Thread.Parent.Post.Record.CreatedAt.Time, nil, *oauthToken, *pds)
 	}
 
 	return EncodeAndSend(c, bridge.Discovery{
@@ -323,11 +322,11 @@ func GetTopicSuggestedUsers(c *fiber.Ctx) error {
 		return ReturnError(c, "Invalid slug", 195, fiber.StatusBadRequest)
 	}
 
-	recommendedUsers, err := blueskyapi.GetTopicSuggestedUsers(*pds, *oauthToken, limit, slug)
+	recommendedUsers, err := blueskyapi.GetTopicSuggestedUsers(c.UserContext(), *pds, *oauthToken, limit, slug)
 
 	if err != nil {
-		fmt.Println("Error:", err)
-		return HandleBlueskyError(c, err.Error(), "app.bsky.unspecced.getSuggestedUsers", GetTopicSuggestedUsers)
+		log.ErrorContext(c.UserContext(), "GetTopicSuggestedUsers failed", "error", err)
+		return HandleBlueskyError(c, err, "app.bsky.unspecced.getSuggestedUsers", GetTopicSuggestedUsers)
 	}
 
 	usersDID := []string{}
@@ -335,10 +334,10 @@ func GetTopicSuggestedUsers(c *fiber.Ctx) error {
 		usersDID = append(usersDID, user.DID)
 	}
 
-	usersInfo, err := blueskyapi.GetUsersInfo(*pds, *oauthToken, usersDID, false)
+	usersInfo, err := blueskyapi.GetUsersInfo(c.UserContext(), *pds, *oauthToken, usersDID, false)
 	if err != nil {
-		fmt.Println("Error:", err)
-		return HandleBlueskyError(c, err.Error(), "app.bsky.actor.getProfiles", GetTopicSuggestedUsers)
+		log.ErrorContext(c.UserContext(), "GetUsersInfo failed", "error", err)
+		return HandleBlueskyError(c, err, "app.bsky.actor.getProfiles", GetTopicSuggestedUsers)
 	}
 
 	topic := bridge.TopicUserSuggestions{
@@ -0,0 +1,117 @@
+package twitterv1
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+)
+
+// On EDGE, a tweet can take long enough to post that the client gives up and sends it again, and then it's posted twice.
+// So we remember what everyone posted recently (by hash), and if the same thing comes in again we give back what we
+// already posted instead (or error 187, like twitter). If the first one is still being posted, the retry waits for it.
+
+const duplicateCacheMaxEntries = 10000
+
+type recentStatus struct {
+	done    chan struct{} // closed once it's posted (or failed)
+	uri     string        // the post it became
+	expires time.Time
+}
+
+type statusDeduper struct {
+	mutex    sync.Mutex
+	statuses map[string]*recentStatus // did + key
+}
+
+// set up in NewApp
+var recentStatuses = newStatusDeduper()
+
+func newStatusDeduper() *statusDeduper {
+	return &statusDeduper{
+		statuses: make(map[string]*recentStatus),
+	}
+}
+
+// statusKey is what makes a status the same as another one: the text (ignoring spacing), what it's replying to, and the image.
+func statusKey(status string, inReplyTo string, media []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(strings.Join(strings.Fields(status), " ")))
+	hash.Write([]byte{0})
+	hash.Write([]byte(inReplyTo))
+	hash.Write([]byte{0})
+	if media != nil {
+		mediaHash := sha256.Sum256(media)
+		hash.Write(mediaHash[:])
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// begin is called before posting. If did already posted this, it gives back the URI of that post, and nothing should be posted.
+// If not, it gives back an entry, and finish has to be called with it once posting is done (or failed).
+func (d *statusDeduper) begin(ctx context.Context, did string, key string) (*recentStatus, string, error) {
+	if configData.DuplicateStatusWindowSeconds <= 0 {
+		return &recentStatus{done: make(chan struct{})}, "", nil
+	}
+	key = did + "\x00" + key
+	for {
+		d.mutex.Lock()
+		entry, ok := d.statuses[key]
+		if !ok || (isClosed(entry.done) && time.Now().After(entry.expires)) {
+			if len(d.statuses) >= duplicateCacheMaxEntries {
+				d.pruneLocked()
+			}
+			entry = &recentStatus{done: make(chan struct{})}
+			d.statuses[key] = entry
+			d.mutex.Unlock()
+			return entry, "", nil
+		}
+		d.mutex.Unlock()
+
+		select {
+		case <-entry.done:
+		case <-ctx.Done():
+			return nil, "", ctx.Err()
+		}
+		if entry.uri != "" && time.Now().Before(entry.expires) {
+			return nil, entry.uri, nil
+		}
+		// the first try failed (or it's old now), so this one's the real one
+	}
+}
+
+// finish records what entry turned into. uri is "" if posting failed, so the next try goes through.
+func (d *statusDeduper) finish(did string, key string, entry *recentStatus, uri string) {
+	key = did + "\x00" + key
+	d.mutex.Lock()
+	if uri == "" {
+		if d.statuses[key] == entry {
+			delete(d.statuses, key)
+		}
+	} else {
+		entry.uri = uri
+		entry.expires = time.Now().Add(time.Duration(configData.DuplicateStatusWindowSeconds) * time.Second)
+	}
+	d.mutex.Unlock()
+	close(entry.done)
+}
+
+// pruneLocked forgets statuses that are too old to matter. d.mutex has to be held.
+func (d *statusDeduper) pruneLocked() {
+	for key, entry := range d.statuses {
+		if isClosed(entry.done) && time.Now().After(entry.expires) {
+			delete(d.statuses, key)
+		}
+	}
+}
+
+func isClosed(done chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
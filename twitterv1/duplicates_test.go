@@ -0,0 +1,130 @@
+package twitterv1_test
+
+import (
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Preloading/TwitterAPIBridge/fakepds"
+	"github.com/Preloading/TwitterAPIBridge/twitterv1"
+)
+
+// postTwice posts two statuses as bob, and gives back the tweets the client got.
+func postTwice(t *testing.T, h *fakepds.Harness, bob string, first string, second string) (tweet, tweet) {
+	t.Helper()
+	var a, b tweet
+	h.Call(t, http.MethodPost, "/1/statuses/update.json", url.Values{"status": {first}}, bob, &a)
+	h.Call(t, http.MethodPost, "/1/statuses/update.json", url.Values{"status": {second}}, bob, &b)
+	return a, b
+}
+
+// The client gave up waiting and sent it again, while the first one was still going.
+func TestConcurrentDuplicateStatuses(t *testing.T) {
+	h := fakepds.NewTestHarness(t, nil)
+	bob := h.MustLogin(t, "bob.test", "dev_bob-pass")
+
+	tweets := make([]tweet, 5)
+	var wg sync.WaitGroup
+	for i := range tweets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.Call(t, http.MethodPost, "/1/statuses/update.json", url.Values{"status": {"sent on EDGE"}}, bob, &tweets[i])
+		}()
+	}
+	wg.Wait()
+
+	if posts := createdPosts(t, h); len(posts) != 1 {
+		t.Errorf("posted %d times", len(posts))
+	}
+	for _, tweet := range tweets[1:] {
+		if tweet.ID != tweets[0].ID || tweet.Text != "sent on EDGE" {
+			t.Errorf("got %+v and %+v", tweets[0], tweet)
+		}
+	}
+}
+
+func TestDuplicateStatusSpacing(t *testing.T) {
+	h := fakepds.NewTestHarness(t, nil)
+	bob := h.MustLogin(t, "bob.test", "dev_bob-pass")
+
+	first, second := postTwice(t, h, bob, "hello   there\nworld", " hello there world ")
+	if first.ID != second.ID {
+		t.Errorf("only the spacing changed, but got %d and %d", first.ID, second.ID)
+	}
+	if posts := createdPosts(t, h); len(posts) != 1 {
+		t.Errorf("posted %d times", len(posts))
+	}
+
+	// different words are a different status
+	third, _ := postTwice(t, h, bob, "hello there world!", "something else")
+	if third.ID == first.ID {
+		t.Error("a different status was a duplicate")
+	}
+}
+
+// If the first try didn't make it, the retry is posted for real.
+func TestDuplicateAfterFailure(t *testing.T) {
+	h := fakepds.NewTestHarness(t, nil)
+	bob := h.MustLogin(t, "bob.test", "dev_bob-pass")
+	h.PDS.FailCall("com.atproto.repo.createRecord", 0)
+
+	resp, body, err := h.Request(http.MethodPost, "/1/statuses/update.json", url.Values{"status": {"try again"}}, bob)
+	if err != nil || resp.StatusCode == http.StatusOK {
+		t.Fatalf("the first try worked: %v %s", err, body)
+	}
+	var retry tweet
+	h.Call(t, http.MethodPost, "/1/statuses/update.json", url.Values{"status": {"try again"}}, bob, &retry)
+
+	var timeline []tweet
+	h.Call(t, http.MethodGet, "/1/statuses/user_timeline.json", url.Values{"screen_name": {"bob.test"}}, bob, &timeline)
+	if len(timeline) == 0 || timeline[0].ID != retry.ID || timeline[0].Text != "try again" {
+		t.Errorf("the retry isn't on the timeline")
+	}
+	if posts := createdPosts(t, h); len(posts) != 2 {
+		t.Errorf("%d tries, want 2", len(posts))
+	}
+}
+
+func TestDuplicateStatusError(t *testing.T) {
+	h := fakepds.NewTestHarness(t, nil)
+	bob := h.MustLogin(t, "bob.test", "dev_bob-pass")
+	h.Config.DuplicateStatusError = true
+	h.App = twitterv1.NewApp(h.Config)
+
+	h.Call(t, http.MethodPost, "/1/statuses/update.json", url.Values{"status": {"only once"}}, bob, nil)
+	resp, body, err := h.Request(http.MethodPost, "/1/statuses/update.json", url.Values{"status": {"only once"}}, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusForbidden || errorCode(t, body) != 187 {
+		t.Errorf("got %d: %s", resp.StatusCode, body)
+	}
+	if posts := createdPosts(t, h); len(posts) != 1 {
+		t.Errorf("posted %d times", len(posts))
+	}
+}
+
+// Past DUPLICATE_STATUS_WINDOW_SECONDS it's a new status.
+func TestDuplicateStatusWindow(t *testing.T) {
+	h := fakepds.NewTestHarness(t, nil)
+	bob := h.MustLogin(t, "bob.test", "dev_bob-pass")
+	h.Config.DuplicateStatusWindowSeconds = 1
+	h.App = twitterv1.NewApp(h.Config)
+
+	first, second := postTwice(t, h, bob, "good morning", "good morning")
+	if first.ID != second.ID {
+		t.Fatal("not a duplicate inside the window")
+	}
+	time.Sleep(1100 * time.Millisecond)
+	var third tweet
+	h.Call(t, http.MethodPost, "/1/statuses/update.json", url.Values{"status": {"good morning"}}, bob, &third)
+	if third.ID == first.ID {
+		t.Error("still a duplicate after the window")
+	}
+	if posts := createdPosts(t, h); len(posts) != 2 {
+		t.Errorf("posted %d times, want 2", len(posts))
+	}
+}
@@ -1,11 +1,15 @@
 package twitterv1
 
 import (
-	"encoding/json"
+	"context"
 	"encoding/xml"
+	"errors"
 	"fmt"
-	"log"
+	"math"
+	"strconv"
 
+	blueskyapi "github.com/Preloading/TwitterAPIBridge/bluesky"
+	"github.com/Preloading/TwitterAPIBridge/localization"
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -14,18 +18,14 @@ type Error struct {
 	Message string `json:"message" xml:",chardata"`
 }
 
-type BlueskyError struct {
-	Error   string
-	Message string
-}
-
 type Errors struct {
 	XMLName xml.Name `json:"-" xml:"errors"`
 	Error   []Error  `json:"errors"`
 }
 
 // WARNING! This doesn't return a non-nil value
-func ReturnError(c *fiber.Ctx, message string, error_code int, http_error int) error {
+// message is translated, then formatted with args (if there are any), so anything that changes goes in args.
+func ReturnError(c *fiber.Ctx, message string, error_code int, http_error int, args ...interface{}) error {
 	if c.Query("suppress_response_codes") != "true" {
 		c.Status(http_error)
 	}
@@ -34,71 +34,168 @@ func ReturnError(c *fiber.Ctx, message string, error_code int, http_error int) e
 		Error: []Error{
 			{
 				Code:    error_code,
-				Message: message,
+				Message: localization.Translate(localization.PickLanguage(RequestedLanguage(c)), message, args...),
 			},
 		},
 	}
 
-	log.Printf("a user encountered an error: %s", message)
+	if len(args) > 0 {
+		message = fmt.Sprintf(message, args...)
+	}
+	log.InfoContext(c.UserContext(), "A user encountered an error", "message", message, "code", error_code, "status", http_error)
 
 	return EncodeAndSend(c, err)
 }
 
-func HandleBlueskyError(c *fiber.Ctx, responseJson string, lexicon string, function func(c *fiber.Ctx) error) error {
-	// json decode responce
-	res := BlueskyError{}
-	if err := json.Unmarshal([]byte(responseJson), &res); err != nil {
-		fmt.Println(string(responseJson))
-		switch responseJson {
-		case "invalid handle", "user does not exist":
-			return ReturnError(c, "Incorrect username", 32, fiber.StatusUnauthorized)
-		}
-		return ReturnError(c, "An unknown error occured.", 0, fiber.StatusInternalServerError)
+// RequestedLanguage gets the language the client wants, from the lang param or the Accept-Language header.
+// Returns an empty string if it's not one we have translations for.
+func RequestedLanguage(c *fiber.Ctx) string {
+	if lang := localization.NormalizeLanguage(c.FormValue("lang")); lang != "" {
+		return lang
 	}
+	return localization.FromAcceptLanguage(c.Get("Accept-Language"))
+}
 
-	switch res.Error {
-	// Generic
-	case "InvalidRequest":
-		switch res.Message {
-		case "Profile not found":
-			return ReturnError(c, "User not found.", 50, fiber.StatusNotFound)
-		default:
-			return ReturnError(c, "Invalid request.", 0, fiber.StatusBadRequest) // unknown
-		}
+// What a bluesky error turns into for twitter clients.
+type twitterError struct {
+	message string
+	code    int
+	status  int
+}
 
-	case "InvalidSwap":
-		return ReturnError(c, "An error occured during record manipulation (InvalidSwap)", 131, fiber.StatusInternalServerError)
+// Every error bluesky is known to send, and the closest twitter error to it.
+// Errors are from the XRPC spec (https://atproto.com/specs/xrpc) and the lexicons of the methods we call.
+// message is only for errors that mean different things depending on the message (ex. InvalidRequest), "" matches any.
+// The first match wins, so those go before the catch-all for that error.
+var blueskyErrors = []struct {
+	name    string
+	message string
+	twitterError
+}{
+	// Generic
+	{"InvalidRequest", "Profile not found", twitterError{"User not found.", 50, fiber.StatusNotFound}},
+	{"InvalidRequest", "Actor not found", twitterError{"User not found.", 50, fiber.StatusNotFound}},
+	{"InvalidRequest", "", twitterError{"Invalid request.", 0, fiber.StatusBadRequest}}, // unknown
+	{"Forbidden", "", twitterError{"You don't have permission to do that.", 220, fiber.StatusForbidden}},
+	{"XRPCNotSupported", "", twitterError{"An unknown error occured.", 0, fiber.StatusInternalServerError}},
+	{"MethodNotImplemented", "", twitterError{"Sorry, that page does not exist", 34, fiber.StatusNotFound}},
+	{"PayloadTooLarge", "", twitterError{"One or more of the uploaded media is too large.", 193, fiber.StatusRequestEntityTooLarge}},
+	{"UpgradeRequired", "", twitterError{"An unknown error occured.", 0, fiber.StatusInternalServerError}},
+	{"InternalServerError", "", twitterError{"Internal error", 131, fiber.StatusInternalServerError}},
+	{"UpstreamFailure", "", twitterError{"Bluesky is over capacity.", 130, fiber.StatusServiceUnavailable}},
+	{"NotEnoughResources", "", twitterError{"Bluesky is over capacity.", 130, fiber.StatusServiceUnavailable}},
+	{"UpstreamTimeout", "", twitterError{"Bluesky is over capacity.", 130, fiber.StatusServiceUnavailable}},
+	{"RateLimitExceeded", "", twitterError{"Rate limit exceeded contacting Bluesky. Please try again later.", 88, fiber.StatusTooManyRequests}},
+
+	// Records
+	{"InvalidSwap", "", twitterError{"An error occured during record manipulation (InvalidSwap)", 131, fiber.StatusInternalServerError}},
+	{"RecordNotFound", "", twitterError{"Post was not found. (or was deleted)", 144, fiber.StatusNotFound}},
+	{"RepoNotFound", "", twitterError{"User not found.", 50, fiber.StatusNotFound}},
+	{"RepoTakendown", "", twitterError{"User has been suspended.", 63, fiber.StatusForbidden}},
+	{"RepoSuspended", "", twitterError{"User has been suspended.", 63, fiber.StatusForbidden}},
+	{"RepoDeactivated", "", twitterError{"User not found.", 50, fiber.StatusNotFound}},
+	{"BlobNotFound", "", twitterError{"Sorry, that page does not exist", 34, fiber.StatusNotFound}},
 
 	// Feed
-	case "InvalidFeed":
-		return ReturnError(c, "The feed specified was invalid", 0, fiber.StatusBadRequest) // unknown
-	case "NotFound": // could probably be
-		return ReturnError(c, "Post was not found. (or was deleted)", 144, fiber.StatusNotFound)
+	{"InvalidFeed", "", twitterError{"The feed specified was invalid", 0, fiber.StatusBadRequest}}, // unknown
+	{"UnknownFeed", "", twitterError{"The feed specified was invalid", 0, fiber.StatusBadRequest}}, // unknown
+	{"UnknownList", "", twitterError{"Sorry, that page does not exist", 34, fiber.StatusNotFound}},
+	{"NotFound", "", twitterError{"Post was not found. (or was deleted)", 144, fiber.StatusNotFound}}, // could probably be
+	{"BlockedActor", "", twitterError{"You have blocked this user.", 136, fiber.StatusUnauthorized}},
+	{"BlockedByActor", "", twitterError{"You have been blocked from viewing this user's tweets.", 136, fiber.StatusUnauthorized}},
+
+	// Actors
+	{"ActorNotFound", "", twitterError{"User not found.", 50, fiber.StatusNotFound}},
 
 	// Search
-	case "BadQueryString":
-		return ReturnError(c, "Invalid query.", 0, fiber.StatusBadRequest)
+	{"BadQueryString", "", twitterError{"Invalid query.", 0, fiber.StatusBadRequest}},
+
+	// Identity (handles & DIDs we couldn't resolve, see blueskyapi.ResolveDIDFromHandle)
+	{"InvalidHandle", "", twitterError{"Incorrect username", 32, fiber.StatusUnauthorized}},
+	{"HandleNotFound", "", twitterError{"Incorrect username", 32, fiber.StatusUnauthorized}},
+	{"DidNotFound", "", twitterError{"Incorrect username", 32, fiber.StatusUnauthorized}},
 
 	// Auth
-	case "ExpiredToken":
-		return ReturnError(c, "Expired token.", 89, 403) // TODO: If this occurs, refresh the token instead of failing.
-	case "InvalidToken":
-		return ReturnError(c, "Invalid token.", 89, 403)
-	case "AccountTakedown":
-		return ReturnError(c, "Your account has been suspended. Check your email for details.", 64, fiber.StatusForbidden)
-	case "AuthFactorTokenRequired":
-		return ReturnError(c, "Two-factor authentication is required, use an app password.", 32, fiber.StatusUnauthorized) // Unsure about this error code.
-	case "AuthMissing":
-		return ReturnError(c, "Incorrect username/password.", 32, fiber.StatusUnauthorized)
-	case "AuthenticationRequired":
-		return ReturnError(c, "Incorrect username/password.", 32, fiber.StatusUnauthorized)
-	case "RateLimitExceeded":
-		return ReturnError(c, "Rate limit exceeded contacting Bluesky. Please try again later.", 88, fiber.StatusTooManyRequests)
-
-	default:
-		// Handle other errors
-		fmt.Println("An unknown error occured! Error: " + res.Error + ". Message: " + res.Message)
-		return ReturnError(c, "An unknown error occured: "+res.Message, 0, fiber.StatusInternalServerError)
+	{"ExpiredToken", "", twitterError{"Expired token.", 89, fiber.StatusForbidden}}, // only if refreshing it didn't work, see authSession.refreshExpired
+	{"InvalidToken", "", twitterError{"Invalid token.", 89, fiber.StatusForbidden}},
+	{"AccountTakedown", "", twitterError{"Your account has been suspended. Check your email for details.", 64, fiber.StatusForbidden}},
+	{"AccountDeactivated", "", twitterError{"Your account has been suspended. Check your email for details.", 64, fiber.StatusForbidden}},
+	{"AuthFactorTokenRequired", "", twitterError{"Two-factor authentication is required, use an app password.", 32, fiber.StatusUnauthorized}}, // Unsure about this error code.
+	{"AuthMissing", "", twitterError{"Incorrect username/password.", 32, fiber.StatusUnauthorized}},
+	{"AuthenticationRequired", "", twitterError{"Incorrect username/password.", 32, fiber.StatusUnauthorized}},
+}
+
+// If bluesky (or a proxy in front of it) doesn't give an error name, the XRPC spec says to go by the status.
+var xrpcStatusErrors = map[int]string{
+	fiber.StatusBadRequest:            "InvalidRequest",
+	fiber.StatusUnauthorized:          "AuthenticationRequired",
+	fiber.StatusForbidden:             "Forbidden",
+	fiber.StatusNotFound:              "XRPCNotSupported",
+	fiber.StatusRequestEntityTooLarge: "PayloadTooLarge",
+	fiber.StatusUpgradeRequired:       "UpgradeRequired",
+	fiber.StatusTooManyRequests:       "RateLimitExceeded",
+	fiber.StatusInternalServerError:   "InternalServerError",
+	fiber.StatusNotImplemented:        "MethodNotImplemented",
+	fiber.StatusBadGateway:            "UpstreamFailure",
+	fiber.StatusServiceUnavailable:    "NotEnoughResources",
+	fiber.StatusGatewayTimeout:        "UpstreamTimeout",
+}
+
+// lookupBlueskyError finds the twitter error for a bluesky error, if we know it.
+func lookupBlueskyError(name string, message string) (twitterError, bool) {
+	for _, mapping := range blueskyErrors {
+		if mapping.name == name && (mapping.message == "" || mapping.message == message) {
+			return mapping.twitterError, true
+		}
+	}
+	return twitterError{}, false
+}
+
+// HandleBlueskyError sends the client the twitter version of an error from blueskyapi.
+// lexicon is what we were calling, only used if the error doesn't already say.
+func HandleBlueskyError(c *fiber.Ctx, err error, lexicon string, function func(c *fiber.Ctx) error) error {
+	var xrpcErr *blueskyapi.XRPCError
+	if !errors.As(err, &xrpcErr) {
+		// Never got an answer from bluesky
+		if errors.Is(err, context.DeadlineExceeded) {
+			return ReturnError(c, "Bluesky is over capacity.", 130, fiber.StatusServiceUnavailable)
+		}
+		log.ErrorContext(c.UserContext(), "Error contacting bluesky", "error", err, "lexicon", lexicon)
+		return ReturnError(c, "An unknown error occured.", 0, fiber.StatusInternalServerError)
+	}
+	if xrpcErr.Lexicon != "" && xrpcErr.Lexicon != "other" {
+		lexicon = xrpcErr.Lexicon
+	}
+
+	name := xrpcErr.Name
+	if name == "" {
+		name = xrpcStatusErrors[xrpcErr.StatusCode]
+	}
+	if name == "RateLimitExceeded" {
+		setRateLimitHeaders(c, xrpcErr)
+	}
+
+	mapped, ok := lookupBlueskyError(name, xrpcErr.Message)
+	if !ok {
+		log.ErrorContext(c.UserContext(), "Unknown bluesky error", "error", xrpcErr.Name, "status", xrpcErr.StatusCode, "message", xrpcErr.Message, "lexicon", lexicon)
+		return ReturnError(c, "An unknown error occured: %s", 0, fiber.StatusInternalServerError, xrpcErr.Message)
+	}
+	return ReturnError(c, mapped.message, mapped.code, mapped.status)
+}
+
+// setRateLimitHeaders sends the same headers twitter does when you're rate limited, so clients know when to try again.
+func setRateLimitHeaders(c *fiber.Ctx, xrpcErr *blueskyapi.XRPCError) {
+	if limit, ok := blueskyapi.RateLimitFromContext(c.UserContext()); ok {
+		if limit.Limit >= 0 {
+			c.Set("X-Rate-Limit-Limit", strconv.Itoa(limit.Limit))
+		}
+		c.Set("X-Rate-Limit-Remaining", strconv.Itoa(max(limit.Remaining, 0)))
+		c.Set("X-Rate-Limit-Reset", strconv.FormatInt(limit.Reset.Unix(), 10))
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(limit.RetryAfter()))
+		return
+	}
+	if xrpcErr.RetryAfter > 0 {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(xrpcErr.RetryAfter.Seconds()))))
 	}
 }
 
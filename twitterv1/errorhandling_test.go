@@ -0,0 +1,189 @@
+package twitterv1
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	blueskyapi "github.com/Preloading/TwitterAPIBridge/bluesky"
+	"github.com/gofiber/fiber/v2"
+)
+
+// What every bluesky error should turn into. Written out separately from blueskyErrors, so a change to one of them
+// has to be a change here too.
+var blueskyErrorTests = []struct {
+	name    string
+	message string
+	code    int
+	status  int
+}{
+	{"InvalidRequest", "Profile not found", 50, 404},
+	{"InvalidRequest", "Actor not found", 50, 404},
+	{"InvalidRequest", "Params must have the property \"actor\"", 0, 400},
+	{"Forbidden", "", 220, 403},
+	{"XRPCNotSupported", "", 0, 500},
+	{"MethodNotImplemented", "", 34, 404},
+	{"PayloadTooLarge", "", 193, 413},
+	{"UpgradeRequired", "", 0, 500},
+	{"InternalServerError", "", 131, 500},
+	{"UpstreamFailure", "", 130, 503},
+	{"NotEnoughResources", "", 130, 503},
+	{"UpstreamTimeout", "", 130, 503},
+	{"RateLimitExceeded", "", 88, 429},
+	{"InvalidSwap", "", 131, 500},
+	{"RecordNotFound", "", 144, 404},
+	{"RepoNotFound", "", 50, 404},
+	{"RepoTakendown", "", 63, 403},
+	{"RepoSuspended", "", 63, 403},
+	{"RepoDeactivated", "", 50, 404},
+	{"BlobNotFound", "", 34, 404},
+	{"InvalidFeed", "", 0, 400},
+	{"UnknownFeed", "", 0, 400},
+	{"UnknownList", "", 34, 404},
+	{"NotFound", "", 144, 404},
+	{"BlockedActor", "", 136, 401},
+	{"BlockedByActor", "", 136, 401},
+	{"ActorNotFound", "", 50, 404},
+	{"BadQueryString", "", 0, 400},
+	{"InvalidHandle", "", 32, 401},
+	{"HandleNotFound", "", 32, 401},
+	{"DidNotFound", "", 32, 401},
+	{"ExpiredToken", "", 89, 403},
+	{"InvalidToken", "", 89, 403},
+	{"AccountTakedown", "", 64, 403},
+	{"AccountDeactivated", "", 64, 403},
+	{"AuthFactorTokenRequired", "", 32, 401},
+	{"AuthMissing", "", 32, 401},
+	{"AuthenticationRequired", "", 32, 401},
+}
+
+// sendBlueskyError runs HandleBlueskyError for err like a route would, and gives back the response and the twitter error code.
+func sendBlueskyError(t *testing.T, err error, query string) (*http.Response, int) {
+	t.Helper()
+	app := fiber.New()
+	app.Get("/1/test.:filetype", func(c *fiber.Ctx) error {
+		return HandleBlueskyError(c, err, "app.bsky.test", nil)
+	})
+	resp, testErr := app.Test(httptest.NewRequest("GET", "/1/test.json"+query, nil))
+	if testErr != nil {
+		t.Fatal(testErr)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	var errs Errors
+	if err := json.Unmarshal(body, &errs); err != nil || len(errs.Error) != 1 {
+		t.Fatalf("bad error body %q: %v", body, err)
+	}
+	return resp, errs.Error[0].Code
+}
+
+func TestBlueskyErrorMappings(t *testing.T) {
+	for _, test := range blueskyErrorTests {
+		t.Run(test.name+"/"+test.message, func(t *testing.T) {
+			mapped, ok := lookupBlueskyError(test.name, test.message)
+			if !ok {
+				t.Fatal("not mapped")
+			}
+			if mapped.code != test.code || mapped.status != test.status {
+				t.Errorf("got code %d status %d, want code %d status %d", mapped.code, mapped.status, test.code, test.status)
+			}
+
+			resp, code := sendBlueskyError(t, &blueskyapi.XRPCError{StatusCode: 400, Name: test.name, Message: test.message}, "")
+			if resp.StatusCode != test.status || code != test.code {
+				t.Errorf("sent status %d code %d, want status %d code %d", resp.StatusCode, code, test.status, test.code)
+			}
+		})
+	}
+}
+
+// Every mapping is the first match for one of the tests, so none are untested or hidden behind an earlier one.
+func TestBlueskyErrorsAllTested(t *testing.T) {
+	covered := make([]bool, len(blueskyErrors))
+	for _, test := range blueskyErrorTests {
+		for i, mapping := range blueskyErrors {
+			if mapping.name == test.name && (mapping.message == "" || mapping.message == test.message) {
+				covered[i] = true
+				break
+			}
+		}
+	}
+	for i, mapping := range blueskyErrors {
+		if !covered[i] {
+			t.Errorf("%s %q has no test (or an earlier mapping always matches first)", mapping.name, mapping.message)
+		}
+	}
+}
+
+// Errors without a name go by their status, and every status we know has a mapping.
+func TestXRPCStatusErrors(t *testing.T) {
+	for status, name := range xrpcStatusErrors {
+		t.Run(fmt.Sprint(status), func(t *testing.T) {
+			want, ok := lookupBlueskyError(name, "")
+			if !ok {
+				t.Fatalf("%s isn't mapped", name)
+			}
+			resp, code := sendBlueskyError(t, &blueskyapi.XRPCError{StatusCode: status}, "")
+			if resp.StatusCode != want.status || code != want.code {
+				t.Errorf("sent status %d code %d, want %s (status %d code %d)", resp.StatusCode, code, name, want.status, want.code)
+			}
+		})
+	}
+}
+
+func TestUnmappedBlueskyErrors(t *testing.T) {
+	resp, code := sendBlueskyError(t, &blueskyapi.XRPCError{StatusCode: 400, Name: "SomethingNew", Message: "what"}, "")
+	if resp.StatusCode != 500 || code != 0 {
+		t.Errorf("unknown error: got status %d code %d", resp.StatusCode, code)
+	}
+
+	resp, code = sendBlueskyError(t, fmt.Errorf("getting profile: %w", context.DeadlineExceeded), "")
+	if resp.StatusCode != 503 || code != 130 {
+		t.Errorf("timeout: got status %d code %d", resp.StatusCode, code)
+	}
+
+	resp, code = sendBlueskyError(t, &blueskyapi.XRPCError{StatusCode: 404, Name: "RecordNotFound"}, "?suppress_response_codes=true")
+	if resp.StatusCode != 200 || code != 144 {
+		t.Errorf("suppress_response_codes: got status %d code %d", resp.StatusCode, code)
+	}
+}
+
+func TestRateLimitRetryAfter(t *testing.T) {
+	resp, code := sendBlueskyError(t, &blueskyapi.XRPCError{StatusCode: 429, Name: "RateLimitExceeded", RetryAfter: 1500 * time.Millisecond}, "")
+	if resp.StatusCode != 429 || code != 88 {
+		t.Errorf("got status %d code %d", resp.StatusCode, code)
+	}
+	if retryAfter := resp.Header.Get(fiber.HeaderRetryAfter); retryAfter != "2" {
+		t.Errorf("Retry-After is %q, want 2", retryAfter)
+	}
+}
+
+// Errors we don't know about still get translated, with bluesky's message after it.
+func TestUnmappedBlueskyErrorTranslated(t *testing.T) {
+	for _, test := range []struct{ language, message, want string }{
+		{"", "what", "An unknown error occured: what"},
+		{"fr-FR,fr;q=0.9", "what", "Une erreur inconnue s'est produite : what"},
+		{"ja", "100% broken", "不明なエラーが発生しました: 100% broken"},
+	} {
+		app := fiber.New()
+		app.Get("/1/test.:filetype", func(c *fiber.Ctx) error {
+			return HandleBlueskyError(c, &blueskyapi.XRPCError{StatusCode: 400, Name: "SomethingNew", Message: test.message}, "app.bsky.test", nil)
+		})
+		req := httptest.NewRequest("GET", "/1/test.json", nil)
+		req.Header.Set("Accept-Language", test.language)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var errs Errors
+		if err := json.NewDecoder(resp.Body).Decode(&errs); err != nil || len(errs.Error) != 1 {
+			t.Fatalf("bad error body: %v", err)
+		}
+		if errs.Error[0].Message != test.want {
+			t.Errorf("%q: got %q, want %q", test.language, errs.Error[0].Message, test.want)
+		}
+	}
+}
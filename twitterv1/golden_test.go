@@ -0,0 +1,170 @@
+package twitterv1
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	blueskyapi "github.com/Preloading/TwitterAPIBridge/bluesky"
+	"github.com/Preloading/TwitterAPIBridge/bridge"
+	"github.com/Preloading/TwitterAPIBridge/config"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Golden tests for rendering posts as tweets. Each testdata/golden/<name>.json is a timeline item as bluesky sends it
+// (plus any records the bridge looks up while rendering it), and <name>.golden.json & <name>.golden.xml are what
+// clients should get for it. There's no database, IDs go in a MemoryIDStore.
+//
+// After changing how tweets are rendered, check the diff of:
+//
+//	go test ./twitterv1 -run TestGoldenTweets -update
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+type goldenInput struct {
+	Item    blueskyapi.Feed            `json:"item"`
+	Records map[string]json.RawMessage `json:"records"` // AT-URI -> record value
+}
+
+// goldenPDS answers getRecord from the input's records, and everything else (ex. profiles) with an error,
+// so authors come from the post itself.
+func goldenPDS(t *testing.T, records map[string]json.RawMessage) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		uri := "at://" + query.Get("repo") + "/" + query.Get("collection") + "/" + query.Get("rkey")
+		record, ok := records[uri]
+		if r.URL.Path != "/xrpc/com.atproto.repo.getRecord" || !ok {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"RecordNotFound","message":"Could not locate record"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"uri": uri, "cid": "bafyreigolden", "value": record})
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func useGoldenConfig(t *testing.T, pds string) {
+	goldenConfig := &config.Config{
+		CdnURL:             "http://127.0.0.1:3000",
+		AppViewURL:         pds,
+		PLCDirectoryURL:    pds,
+		HandleResolverURL:  pds,
+		XRPCTimeoutSeconds: 5,
+		ImgDisplayText:     "pic.twitter.com/{shortblob}",
+		ImgURLText:         "http://127.0.0.1:3000/img/{shortblob}",
+		VidDisplayText:     "pic.twitter.com/{shortblob}",
+		VidURLText:         "http://127.0.0.1:3000/img/{shortblob}",
+		GifDisplayText:     "pic.twitter.com/{shortblob}",
+		GifURLText:         "http://127.0.0.1:3000/img/{shortblob}",
+	}
+	oldConfig := configData
+	configData = goldenConfig
+	blueskyapi.InitConfig(goldenConfig)
+	bridge.SetIDStore(bridge.NewMemoryIDStore())
+	t.Cleanup(func() {
+		configData = oldConfig
+		bridge.SetIDStore(bridge.DBIDStore{})
+	})
+}
+
+// renderGolden gives back what a client gets for the tweet, in json or xml.
+func renderGolden(t *testing.T, tweet bridge.Tweet, filetype string) []byte {
+	app := fiber.New()
+	app.Get("/tweet.:filetype", func(c *fiber.Ctx) error {
+		return EncodeAndSend(c, tweet)
+	})
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/tweet."+filetype, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filetype == "json" {
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, body, "", "  "); err != nil {
+			t.Fatalf("bad json %s: %v", body, err)
+		}
+		body = indented.Bytes()
+	}
+	return append(body, '\n')
+}
+
+func TestGoldenTweets(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "golden", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputs = filterGoldenInputs(inputs)
+	if len(inputs) == 0 {
+		t.Fatal("no golden inputs")
+	}
+
+	for _, input := range inputs {
+		name := strings.TrimSuffix(filepath.Base(input), ".json")
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var golden goldenInput
+			if err := json.Unmarshal(data, &golden); err != nil {
+				t.Fatal(err)
+			}
+			pds := goldenPDS(t, golden.Records)
+			useGoldenConfig(t, pds)
+
+			item := golden.Item
+			tweet := TranslatePostToTweet(t.Context(), item.Post, item.Reply.Parent.URI, item.Reply.Parent.Author.DID,
+				item.Reply.Parent.Author.Handle, &item.Reply.Parent.Record.CreatedAt.Time, item.Reason, "", pds)
+
+			// the IDs it handed out have to lead back to the post, without a database
+			uri, _, retweetedBy, err := bridge.TwitterMsgIdToBluesky(&tweet.ID)
+			if err != nil || *uri != item.Post.URI {
+				t.Errorf("tweet ID %d leads to %v (%v), want %s", tweet.ID, uri, err, item.Post.URI)
+			}
+			if item.Reason != nil && (retweetedBy == nil || *retweetedBy != item.Reason.By.DID) {
+				t.Errorf("retweet ID %d doesn't lead back to %s", tweet.ID, item.Reason.By.DID)
+			}
+
+			for _, filetype := range []string{"json", "xml"} {
+				got := renderGolden(t, tweet, filetype)
+				goldenPath := filepath.Join("testdata", "golden", name+".golden."+filetype)
+				if *updateGolden {
+					if err := os.WriteFile(goldenPath, got, 0o644); err != nil {
+						t.Fatal(err)
+					}
+					continue
+				}
+				want, err := os.ReadFile(goldenPath)
+				if err != nil {
+					t.Fatalf("%v (run with -update to make it)", err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Errorf("%s doesn't match, got:\n%s", goldenPath, got)
+				}
+			}
+		})
+	}
+}
+
+// filterGoldenInputs drops the golden outputs from a glob of testdata/golden/*.json.
+func filterGoldenInputs(paths []string) []string {
+	inputs := []string{}
+	for _, path := range paths {
+		if !strings.HasSuffix(path, ".golden.json") {
+			inputs = append(inputs, path)
+		}
+	}
+	return inputs
+}
@@ -0,0 +1,271 @@
+package twitterv1
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Preloading/TwitterAPIBridge/config"
+	"github.com/Preloading/TwitterAPIBridge/metrics"
+	"github.com/gofiber/fiber/v2"
+	"github.com/h2non/bimg"
+)
+
+// Bluesky's CDN gives out JPEG, WebP & AVIF, and iOS 3-5 or the PS Vita can't show WebP/AVIF, or big progressive JPEGs.
+// So images through the CDN proxy are always baseline JPEG, PNG or GIF, in a format & size the client can take.
+// What a client can take comes from its profile (IMAGE_CLIENT_PROFILES), or its Accept header if it doesn't have one.
+
+// The formats we send, best first. Everything can show a baseline JPEG, so it's always the last resort.
+var legacyImageFormats = []bimg.ImageType{bimg.JPEG, bimg.PNG, bimg.GIF}
+
+const (
+	// JPEG quality doesn't go lower than this to fit in a client's byte limit, the image gets smaller instead
+	minJPEGQuality = 40
+	// How many times we re-encode an image to get it under the byte limit, before sending what we have
+	imageBudgetAttempts = 6
+)
+
+// imageProfile is what images a client gets.
+type imageProfile struct {
+	formats     []bimg.ImageType // best first, never empty
+	maxPixels   int              // 0 is no limit
+	maxBytes    int              // 0 is no limit
+	jpegQuality int
+}
+
+// cacheKey is the part of the CDN cache key for the profile, since different profiles get different images.
+func (p imageProfile) cacheKey() string {
+	names := []string{}
+	for _, format := range p.formats {
+		names = append(names, bimg.ImageTypeName(format))
+	}
+	return fmt.Sprintf("formats=%s|px=%d|bytes=%d|q=%d", strings.Join(names, ","), p.maxPixels, p.maxBytes, p.jpegQuality)
+}
+
+// imageProfileFor works out what images the client that sent c gets.
+func imageProfileFor(c *fiber.Ctx) imageProfile {
+	profile := imageProfile{
+		maxPixels:   configData.ImageMaxPixels,
+		maxBytes:    configData.ImageMaxBytes,
+		jpegQuality: configData.ImageJPEGQuality,
+	}
+
+	var clientProfile *config.ImageClientProfile
+	client := strings.ToLower(c.Get("X-Twitter-Client") + " " + c.Get(fiber.HeaderUserAgent))
+	for i, candidate := range configData.ImageClientProfiles {
+		if slices.ContainsFunc(candidate.Match, func(match string) bool {
+			return match != "" && strings.Contains(client, strings.ToLower(match))
+		}) {
+			clientProfile = &configData.ImageClientProfiles[i]
+			break
+		}
+	}
+
+	if clientProfile != nil {
+		for _, name := range clientProfile.Formats {
+			if format, ok := imageFormatByName(name); ok && !slices.Contains(profile.formats, format) {
+				profile.formats = append(profile.formats, format)
+			}
+		}
+		if clientProfile.MaxPixels != 0 {
+			profile.maxPixels = clientProfile.MaxPixels
+		}
+		if clientProfile.MaxBytes != 0 {
+			profile.maxBytes = clientProfile.MaxBytes
+		}
+		if clientProfile.JPEGQuality != 0 {
+			profile.jpegQuality = clientProfile.JPEGQuality
+		}
+	}
+	if len(profile.formats) == 0 {
+		profile.formats = acceptedImageFormats(c.Get(fiber.HeaderAccept))
+	}
+	// can't save GIFs (or PNGs) with every libvips
+	profile.formats = slices.DeleteFunc(profile.formats, func(format bimg.ImageType) bool {
+		return format != bimg.JPEG && !bimg.IsTypeSupportedSave(format)
+	})
+	if len(profile.formats) == 0 {
+		profile.formats = []bimg.ImageType{bimg.JPEG}
+	}
+	if profile.jpegQuality <= 0 || profile.jpegQuality > 100 {
+		profile.jpegQuality = bimg.Quality
+	}
+	return profile
+}
+
+func imageFormatByName(name string) (bimg.ImageType, bool) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "jpeg", "jpg":
+		return bimg.JPEG, true
+	case "png":
+		return bimg.PNG, true
+	case "gif":
+		return bimg.GIF, true
+	}
+	return bimg.UNKNOWN, false
+}
+
+// acceptedImageFormats is the formats we send that are in an Accept header. No header (or */*, image/*) is all of them.
+// It's always a new slice, imageProfileFor changes it.
+func acceptedImageFormats(accept string) []bimg.ImageType {
+	if strings.TrimSpace(accept) == "" {
+		return slices.Clone(legacyImageFormats)
+	}
+	accepted := map[bimg.ImageType]bool{}
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, _, _ := strings.Cut(mediaRange, ";")
+		mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+		switch mediaType {
+		case "*/*", "image/*":
+			return slices.Clone(legacyImageFormats)
+		case "image/jpeg", "image/jpg", "image/pjpeg":
+			accepted[bimg.JPEG] = true
+		case "image/png":
+			accepted[bimg.PNG] = true
+		case "image/gif":
+			accepted[bimg.GIF] = true
+		}
+	}
+	formats := []bimg.ImageType{}
+	for _, format := range legacyImageFormats {
+		if accepted[format] {
+			formats = append(formats, format)
+		}
+	}
+	return formats
+}
+
+// encodeForClient resizes img to width x height (0 for either keeps the image's own), and encodes it in a format the
+// profile has, within its limits.
+func encodeForClient(img []byte, width int, height int, crop bool, profile imageProfile) ([]byte, string, error) {
+	imgMetadata, err := bimg.Metadata(img)
+	if err != nil {
+		return nil, "", errors.New("bad img")
+	}
+	sourceWidth, sourceHeight := imgMetadata.Size.Width, imgMetadata.Size.Height
+	if sourceWidth <= 0 || sourceHeight <= 0 {
+		return nil, "", errors.New("bad img")
+	}
+
+	// fill in whatever wasn't asked for, so there's something to shrink if it's over the limits
+	switch {
+	case width == 0 && height == 0:
+		width, height = sourceWidth, sourceHeight
+	case width == 0:
+		width = max(1, height*sourceWidth/sourceHeight)
+	case height == 0:
+		height = max(1, width*sourceHeight/sourceWidth)
+	}
+	resized := width != sourceWidth || height != sourceHeight
+	if profile.maxPixels > 0 && width*height > profile.maxPixels {
+		scale := math.Sqrt(float64(profile.maxPixels) / float64(width*height))
+		width, height = max(1, int(float64(width)*scale)), max(1, int(float64(height)*scale))
+		resized = true
+	}
+
+	sourceFormat, _ := imageFormatByName(imgMetadata.Type)
+	format := chooseImageFormat(sourceFormat, imgMetadata.Alpha, profile)
+
+	// nothing to do, send it as it is
+	if !resized && canSendAsIs(img, sourceFormat, format, profile) {
+		return img, "image/" + bimg.ImageTypeName(format), nil
+	}
+
+	o := bimg.Options{
+		Width:         width,
+		Height:        height,
+		Crop:          crop,
+		Type:          format,
+		Quality:       profile.jpegQuality,
+		Interlace:     false, // baseline, old decoders can't do progressive
+		StripMetadata: true,
+	}
+	encoded, o, err := fitImageBudget(o, profile, func(o bimg.Options) ([]byte, error) {
+		return resizeImage(img, o)
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return encoded, "image/" + bimg.ImageTypeName(o.Type), nil
+}
+
+// chooseImageFormat is what to send an image in: what it already is if the client can take it, PNG if it has
+// transparency to keep, otherwise the client's best.
+func chooseImageFormat(sourceFormat bimg.ImageType, alpha bool, profile imageProfile) bimg.ImageType {
+	switch {
+	case slices.Contains(profile.formats, sourceFormat):
+		return sourceFormat
+	case alpha && slices.Contains(profile.formats, bimg.PNG):
+		return bimg.PNG
+	}
+	return profile.formats[0]
+}
+
+// canSendAsIs is whether an image that doesn't need resizing can go to the client without being encoded again.
+func canSendAsIs(img []byte, sourceFormat bimg.ImageType, format bimg.ImageType, profile imageProfile) bool {
+	return format == sourceFormat && !(format == bimg.JPEG && isProgressiveJPEG(img)) &&
+		(profile.maxBytes == 0 || len(img) <= profile.maxBytes)
+}
+
+// fitImageBudget encodes with o, and if that's over the profile's byte limit, tries again lossy, then at lower quality,
+// then smaller, up to imageBudgetAttempts times. Gives back the last try (which might still be too big), and the
+// options it used.
+func fitImageBudget(o bimg.Options, profile imageProfile, encode func(bimg.Options) ([]byte, error)) ([]byte, bimg.Options, error) {
+	encoded, err := encode(o)
+	if err != nil {
+		return nil, o, err
+	}
+	for attempt := 0; profile.maxBytes > 0 && len(encoded) > profile.maxBytes && attempt < imageBudgetAttempts; attempt++ {
+		switch {
+		case o.Type != bimg.JPEG && slices.Contains(profile.formats, bimg.JPEG):
+			o.Type = bimg.JPEG
+		case o.Type == bimg.JPEG && o.Quality > minJPEGQuality:
+			o.Quality = max(minJPEGQuality, o.Quality-15)
+		default:
+			o.Width, o.Height = max(1, o.Width*3/4), max(1, o.Height*3/4)
+		}
+		encoded, err = encode(o)
+		if err != nil {
+			return nil, o, err
+		}
+	}
+	return encoded, o, nil
+}
+
+func resizeImage(img []byte, o bimg.Options) ([]byte, error) {
+	resizeMode := "fit"
+	if o.Crop {
+		resizeMode = "crop"
+	}
+	resizeStart := time.Now()
+	encoded, err := bimg.Resize(img, o)
+	metrics.CDNResizeDuration.Observe(time.Since(resizeStart).Seconds(), resizeMode)
+	if err != nil {
+		return nil, errors.New("Failed to encode image")
+	}
+	return encoded, nil
+}
+
+// isProgressiveJPEG is whether a JPEG is progressive, going by the first frame header (SOF) in it.
+func isProgressiveJPEG(data []byte) bool {
+	for i := 2; i+3 < len(data); {
+		if data[i] != 0xFF {
+			return false
+		}
+		marker := data[i+1]
+		switch marker {
+		case 0xFF: // padding
+			i++
+			continue
+		case 0xC2, 0xC6, 0xCA, 0xCE:
+			return true
+		case 0xC0, 0xC1, 0xC3, 0xC5, 0xC7, 0xC9, 0xCB, 0xCD, 0xDA, 0xD9:
+			return false
+		}
+		i += 2 + (int(data[i+2])<<8 | int(data[i+3]))
+	}
+	return false
+}
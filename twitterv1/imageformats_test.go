@@ -0,0 +1,340 @@
+package twitterv1
+
+import (
+	"errors"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/Preloading/TwitterAPIBridge/config"
+	"github.com/gofiber/fiber/v2"
+	"github.com/h2non/bimg"
+)
+
+// These test working out what a client gets, which is all Go. Actually encoding needs libvips, so it isn't tested here.
+
+// saveable is formats without the ones this libvips can't write, like imageProfileFor does.
+func saveable(formats ...bimg.ImageType) []bimg.ImageType {
+	formats = slices.DeleteFunc(slices.Clone(formats), func(format bimg.ImageType) bool {
+		return format != bimg.JPEG && !bimg.IsTypeSupportedSave(format)
+	})
+	if len(formats) == 0 {
+		return []bimg.ImageType{bimg.JPEG}
+	}
+	return formats
+}
+
+// useImageConfig sets configData until the test is done.
+func useImageConfig(t *testing.T, c *config.Config) {
+	oldConfig := configData
+	configData = c
+	t.Cleanup(func() { configData = oldConfig })
+}
+
+// profileFor is the profile a request with these headers gets.
+func profileFor(t *testing.T, headers map[string]string) imageProfile {
+	t.Helper()
+	var profile imageProfile
+	app := fiber.New()
+	app.Get("/img", func(c *fiber.Ctx) error {
+		profile = imageProfileFor(c)
+		return nil
+	})
+	req := httptest.NewRequest("GET", "/img", nil)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	if _, err := app.Test(req); err != nil {
+		t.Fatal(err)
+	}
+	return profile
+}
+
+// What old clients get, with the default config and the headers they really send.
+func TestNegotiatedImageProfiles(t *testing.T) {
+	t.Chdir(t.TempDir()) // no config.yaml, only defaults
+	defaults, err := config.LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	useImageConfig(t, defaults)
+
+	all := []bimg.ImageType{bimg.JPEG, bimg.PNG, bimg.GIF}
+	iOS := imageProfile{formats: all, maxPixels: 2000000, maxBytes: 1000000, jpegQuality: 75}
+	vita := imageProfile{formats: all, maxPixels: 2000000, maxBytes: 500000, jpegQuality: 70}
+	unlimited := func(formats ...bimg.ImageType) imageProfile {
+		return imageProfile{formats: formats, jpegQuality: 75}
+	}
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    imageProfile
+	}{
+		{"twitter for iphone on iOS 4", map[string]string{
+			"User-Agent": "Twitter-iPhone/3.3.1 iOS/4.2.1 (Apple;iPhone2,1;;;;;1)",
+			"Accept":     "*/*",
+		}, iOS},
+		{"iOS 3 app", map[string]string{
+			"User-Agent": "Twitter/3.0 CFNetwork/459 Darwin/10.0.0d3",
+		}, iOS},
+		{"iOS 5 safari", map[string]string{
+			"User-Agent": "Mozilla/5.0 (iPhone; CPU iPhone OS 5_1_1 like Mac OS X) AppleWebKit/534.46 (KHTML, like Gecko) Version/5.1 Mobile/9B206 Safari/7534.48.3",
+			"Accept":     "image/webp,*/*;q=0.8", // doesn't matter, the profile says what it can take
+		}, iOS},
+		{"iOS 4 ipad", map[string]string{
+			"User-Agent": "Mozilla/5.0 (iPad; U; CPU OS 4_3_5 like Mac OS X; en-us) AppleWebKit/533.17.9 (KHTML, like Gecko) Mobile/8L1",
+		}, iOS},
+		{"matched by X-Twitter-Client", map[string]string{
+			"X-Twitter-Client": "Twitter-iPhone",
+			"User-Agent":       "twitter/5.0 cfnetwork/548.0.4 darwin/11.0.0",
+		}, iOS},
+		{"ps vita", map[string]string{
+			"User-Agent": "Mozilla/5.0 (PlayStation Vita 3.60) AppleWebKit/537.73 (KHTML, like Gecko) Silk/3.2",
+			"Accept":     "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8",
+		}, vita},
+		{"iOS 6 goes by Accept", map[string]string{
+			"User-Agent": "Mozilla/5.0 (iPhone; CPU iPhone OS 6_1_3 like Mac OS X) AppleWebKit/536.26 (KHTML, like Gecko) Mobile/10B329",
+			"Accept":     "image/png,image/jpeg",
+		}, unlimited(bimg.JPEG, bimg.PNG)},
+		{"android 2 browser", map[string]string{
+			"User-Agent": "Mozilla/5.0 (Linux; U; Android 2.3.6; en-us; Nexus S Build/GRK39F) AppleWebKit/533.1 (KHTML, like Gecko) Version/4.0 Mobile Safari/533.1",
+			"Accept":     "application/xml,application/xhtml+xml,text/html;q=0.9,text/plain;q=0.8,image/png,*/*;q=0.5",
+		}, unlimited(all...)},
+		{"modern browser never gets webp or avif", map[string]string{
+			"Accept": "image/avif,image/webp,image/apng,image/svg+xml,image/*,*/*;q=0.8",
+		}, unlimited(all...)},
+		{"only webp", map[string]string{"Accept": "image/webp"}, unlimited(bimg.JPEG)},
+		{"only gif", map[string]string{"Accept": "image/gif"}, unlimited(bimg.GIF)},
+		{"pjpeg", map[string]string{"Accept": "IMAGE/PJPEG; q=0.9"}, unlimited(bimg.JPEG)},
+		{"no headers", map[string]string{}, unlimited(all...)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.want.formats = saveable(test.want.formats...)
+			got := profileFor(t, test.headers)
+			if got.cacheKey() != test.want.cacheKey() {
+				t.Errorf("got %s, want %s", got.cacheKey(), test.want.cacheKey())
+			}
+		})
+	}
+
+	// taking out formats this libvips can't save used to take them out of legacyImageFormats, for everyone after
+	if !slices.Equal(legacyImageFormats, all) {
+		t.Errorf("legacyImageFormats is %v now", legacyImageFormats)
+	}
+}
+
+func TestImageProfileOverrides(t *testing.T) {
+	useImageConfig(t, &config.Config{
+		ImageMaxPixels:   100,
+		ImageMaxBytes:    200,
+		ImageJPEGQuality: 0, // not set, so bimg's default
+		ImageClientProfiles: []config.ImageClientProfile{
+			{Match: []string{"", "OldClient"}, Formats: []string{" PNG", "bmp", "jpg", "png"}, MaxBytes: 50},
+			{Match: []string{"old"}, Formats: []string{"gif"}, JPEGQuality: 90}, // OldClient matched first
+			{Match: []string{"Webby"}, Formats: []string{"webp"}},
+		},
+	})
+
+	tests := []struct {
+		userAgent string
+		want      imageProfile
+	}{
+		{"oldclient/1.0", imageProfile{formats: saveable(bimg.PNG, bimg.JPEG), maxPixels: 100, maxBytes: 50, jpegQuality: bimg.Quality}},
+		{"an old one", imageProfile{formats: saveable(bimg.GIF), maxPixels: 100, maxBytes: 200, jpegQuality: 90}},
+		{"Webby", imageProfile{formats: saveable(bimg.JPEG, bimg.PNG, bimg.GIF), maxPixels: 100, maxBytes: 200, jpegQuality: bimg.Quality}},
+		{"anything else", imageProfile{formats: saveable(bimg.JPEG, bimg.PNG, bimg.GIF), maxPixels: 100, maxBytes: 200, jpegQuality: bimg.Quality}},
+	}
+	for _, test := range tests {
+		got := profileFor(t, map[string]string{"User-Agent": test.userAgent})
+		if got.cacheKey() != test.want.cacheKey() {
+			t.Errorf("%s: got %s, want %s", test.userAgent, got.cacheKey(), test.want.cacheKey())
+		}
+	}
+}
+
+func TestAcceptedImageFormats(t *testing.T) {
+	tests := map[string][]bimg.ImageType{
+		"":                            legacyImageFormats,
+		"*/*":                         legacyImageFormats,
+		"image/*;q=0.8":               legacyImageFormats,
+		"image/gif, image/jpeg":       {bimg.JPEG, bimg.GIF},
+		"image/jpg":                   {bimg.JPEG},
+		"image/webp, image/avif":      {},
+		"text/html, image/png":        {bimg.PNG},
+		" image/png ; q=1 ,IMAGE/GIF": {bimg.PNG, bimg.GIF},
+	}
+	for accept, want := range tests {
+		if got := acceptedImageFormats(accept); !slices.Equal(got, want) {
+			t.Errorf("%q: got %v, want %v", accept, got, want)
+		}
+	}
+}
+
+func TestChooseImageFormat(t *testing.T) {
+	jpegFirst := imageProfile{formats: []bimg.ImageType{bimg.JPEG, bimg.PNG}}
+	jpegOnly := imageProfile{formats: []bimg.ImageType{bimg.JPEG}}
+	tests := []struct {
+		source  bimg.ImageType
+		alpha   bool
+		profile imageProfile
+		want    bimg.ImageType
+	}{
+		{bimg.PNG, false, jpegFirst, bimg.PNG},   // already something it can take
+		{bimg.WEBP, false, jpegFirst, bimg.JPEG}, // bluesky's webp, to the client's best
+		{bimg.WEBP, true, jpegFirst, bimg.PNG},   // keeping the transparency
+		{bimg.AVIF, true, jpegOnly, bimg.JPEG},   // can't keep it
+		{bimg.UNKNOWN, false, jpegOnly, bimg.JPEG},
+	}
+	for _, test := range tests {
+		if got := chooseImageFormat(test.source, test.alpha, test.profile); got != test.want {
+			t.Errorf("%v (alpha %v) with %v: got %v, want %v", test.source, test.alpha, test.profile.formats, got, test.want)
+		}
+	}
+}
+
+// jpegWithFrame is the start of a JPEG: SOI, an APP0 segment, some padding, then the frame header.
+func jpegWithFrame(sof byte) []byte {
+	return []byte{
+		0xFF, 0xD8,
+		0xFF, 0xE0, 0x00, 0x06, 'J', 'F', 'I', 'F',
+		0xFF, 0xC4, 0x00, 0x03, 0x00, // a huffman table first, which isn't a frame
+		0xFF, 0xFF, // padding
+		0xFF, sof, 0x00, 0x0B, 0x08, 0x00, 0x10, 0x00, 0x10, 0x01, 0x01, 0x11, 0x00,
+		0xFF, 0xD9,
+	}
+}
+
+func TestIsProgressiveJPEG(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want bool
+	}{
+		{"baseline", jpegWithFrame(0xC0), false},
+		{"extended", jpegWithFrame(0xC1), false},
+		{"progressive", jpegWithFrame(0xC2), true},
+		{"progressive arithmetic", jpegWithFrame(0xCA), true},
+		{"cut off before the frame", jpegWithFrame(0xC2)[:12], false},
+		{"garbage", []byte{0xFF, 0xD8, 0x12, 0x34, 0x56, 0x78}, false},
+		{"empty", nil, false},
+	}
+	for _, test := range tests {
+		if got := isProgressiveJPEG(test.data); got != test.want {
+			t.Errorf("%s: got %v", test.name, got)
+		}
+	}
+}
+
+func TestCanSendAsIs(t *testing.T) {
+	limited := imageProfile{formats: []bimg.ImageType{bimg.JPEG, bimg.PNG}, maxBytes: 100}
+	baseline, progressive := jpegWithFrame(0xC0), jpegWithFrame(0xC2)
+	if !canSendAsIs(baseline, bimg.JPEG, bimg.JPEG, limited) {
+		t.Error("a small baseline JPEG got re-encoded")
+	}
+	if canSendAsIs(progressive, bimg.JPEG, bimg.JPEG, limited) {
+		t.Error("a progressive JPEG is sent as it is, old clients can't show those")
+	}
+	if canSendAsIs(baseline, bimg.WEBP, bimg.JPEG, limited) {
+		t.Error("a webp is sent as it is")
+	}
+	if canSendAsIs(make([]byte, 101), bimg.PNG, bimg.PNG, limited) {
+		t.Error("an image over the byte limit is sent as it is")
+	}
+	if !canSendAsIs(make([]byte, 101), bimg.PNG, bimg.PNG, imageProfile{formats: limited.formats}) {
+		t.Error("no byte limit, but it got re-encoded")
+	}
+}
+
+// fakeEncode makes "images" as big as a real one would roughly be: PNG is 3 bytes a pixel, JPEG less the lower the quality.
+// It remembers what it was asked for.
+type fakeEncode struct {
+	tries []bimg.Options
+}
+
+func (f *fakeEncode) encode(o bimg.Options) ([]byte, error) {
+	f.tries = append(f.tries, o)
+	size := o.Width * o.Height * 3
+	if o.Type == bimg.JPEG {
+		size = o.Width * o.Height * o.Quality / 100
+	}
+	return make([]byte, size), nil
+}
+
+func TestFitImageBudget(t *testing.T) {
+	start := bimg.Options{Width: 100, Height: 100, Type: bimg.PNG, Quality: 75}
+	type try struct {
+		format        bimg.ImageType
+		quality       int
+		width, height int
+	}
+	tests := []struct {
+		name    string
+		profile imageProfile
+		tries   []try
+	}{
+		{"no limit", imageProfile{formats: []bimg.ImageType{bimg.PNG, bimg.JPEG}}, []try{
+			{bimg.PNG, 75, 100, 100},
+		}},
+		{"fits", imageProfile{formats: []bimg.ImageType{bimg.PNG, bimg.JPEG}, maxBytes: 30000}, []try{
+			{bimg.PNG, 75, 100, 100},
+		}},
+		{"lossy, then lower quality", imageProfile{formats: []bimg.ImageType{bimg.PNG, bimg.JPEG}, maxBytes: 5000}, []try{
+			{bimg.PNG, 75, 100, 100},
+			{bimg.JPEG, 75, 100, 100},
+			{bimg.JPEG, 60, 100, 100},
+			{bimg.JPEG, 45, 100, 100},
+		}},
+		{"then smaller", imageProfile{formats: []bimg.ImageType{bimg.PNG, bimg.JPEG}, maxBytes: 2500}, []try{
+			{bimg.PNG, 75, 100, 100},
+			{bimg.JPEG, 75, 100, 100},
+			{bimg.JPEG, 60, 100, 100},
+			{bimg.JPEG, 45, 100, 100},
+			{bimg.JPEG, 40, 100, 100},
+			{bimg.JPEG, 40, 75, 75},
+		}},
+		{"can't go lossy", imageProfile{formats: []bimg.ImageType{bimg.PNG}, maxBytes: 10000}, []try{
+			{bimg.PNG, 75, 100, 100},
+			{bimg.PNG, 75, 75, 75},
+			{bimg.PNG, 75, 56, 56},
+		}},
+		{"gives up", imageProfile{formats: []bimg.ImageType{bimg.PNG, bimg.JPEG}, maxBytes: 1}, []try{
+			{bimg.PNG, 75, 100, 100},
+			{bimg.JPEG, 75, 100, 100},
+			{bimg.JPEG, 60, 100, 100},
+			{bimg.JPEG, 45, 100, 100},
+			{bimg.JPEG, 40, 100, 100},
+			{bimg.JPEG, 40, 75, 75},
+			{bimg.JPEG, 40, 56, 56}, // imageBudgetAttempts tries after the first
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := &fakeEncode{}
+			encoded, used, err := fitImageBudget(start, test.profile, fake.encode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tries := []try{}
+			for _, o := range fake.tries {
+				tries = append(tries, try{o.Type, o.Quality, o.Width, o.Height})
+			}
+			if !slices.Equal(tries, test.tries) {
+				t.Errorf("tried %v, want %v", tries, test.tries)
+			}
+			if last := fake.tries[len(fake.tries)-1]; used != last {
+				t.Errorf("said it used %+v, the last try was %+v", used, last)
+			}
+			if want, _ := fake.encode(used); len(encoded) != len(want) {
+				t.Errorf("gave back %d bytes, not the last try", len(encoded))
+			}
+		})
+	}
+
+	failed := errors.New("vips error")
+	if _, _, err := fitImageBudget(start, imageProfile{formats: []bimg.ImageType{bimg.JPEG}, maxBytes: 1}, func(bimg.Options) ([]byte, error) {
+		return nil, failed
+	}); !errors.Is(err, failed) {
+		t.Errorf("got %v, want the encoding error", err)
+	}
+}
@@ -1,40 +1,91 @@
 package twitterv1
 
 import (
+	"container/list"
+	"fmt"
 	"hash/fnv"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/Preloading/TwitterAPIBridge/db_controller"
+	"github.com/Preloading/TwitterAPIBridge/metrics"
 	"github.com/gofiber/fiber/v2"
 )
 
-// Simple cache for frequently accessed URLs
+// Short links are in namespaces, by the first letter of the code, so the same URL as an image and as a link gets
+// two different codes, and a code from one can never be taken by the other.
+const (
+	shortLinkImage = "i"
+	shortLinkVideo = "v"
+	shortLinkGIF   = "g"
+	shortLinkLink  = "l"
+)
+
+const (
+	urlCacheSize = 10000
+	// Cached codes get stored again after this, so their last_seen is bumped (or they come back, if they were pruned)
+	urlCacheRefreshAfter = 12 * time.Hour
+)
+
+type urlCacheEntry struct {
+	key      string // prefix + URL
+	code     string
+	storedAt time.Time
+}
+
+// Cache of the short codes for URLs we've seen recently, least recently used at the back
 type urlCache struct {
-	cache map[string]string
-	mutex sync.RWMutex
+	mutex   sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+	size    int
 }
 
 var (
 	base62Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
-	cache       = &urlCache{
-		cache: make(map[string]string),
-	}
+	cache       = newURLCache(urlCacheSize)
 )
 
-// Get returns the cached shortcode for a URL
-func (c *urlCache) Get(url string) (string, bool) {
-	c.mutex.RLock()
-	defer c.mutex.RUnlock()
-	code, ok := c.cache[url]
-	return code, ok
+func newURLCache(size int) *urlCache {
+	return &urlCache{
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+		size:    size,
+	}
+}
+
+// Get returns the cached shortcode for a URL in a namespace
+func (c *urlCache) Get(prefix string, url string) (string, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	element, ok := c.entries[prefix+url]
+	if ok && time.Since(element.Value.(*urlCacheEntry).storedAt) < urlCacheRefreshAfter {
+		c.order.MoveToFront(element)
+		metrics.CacheRequests.Inc("url", "hit")
+		return element.Value.(*urlCacheEntry).code, true
+	}
+	metrics.CacheRequests.Inc("url", "miss")
+	return "", false
 }
 
 // Set caches a URL and its shortcode
-func (c *urlCache) Set(url, code string) {
+func (c *urlCache) Set(prefix string, url string, code string) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
-	c.cache[url] = code
+	key := prefix + url
+	if element, ok := c.entries[key]; ok {
+		element.Value = &urlCacheEntry{key: key, code: code, storedAt: time.Now()}
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&urlCacheEntry{key: key, code: code, storedAt: time.Now()})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*urlCacheEntry).key)
+	}
 }
 
 func toBase62(num uint64) string {
@@ -55,36 +106,62 @@ func toBase62(num uint64) string {
 	return string(result[i+1:])
 }
 
-func CreateShortLink(originalPath string, prefix string) (string, error) {
-	// Check cache first
-	if code, ok := cache.Get(originalPath); ok {
-		return code, nil
+// How many different codes CreateShortLink tries for a URL before it gives up
+const shortLinkAttempts = 16
+
+// shortCodeCandidates is the codes a URL can get, in order. The first is the first 6 characters of its hash (what
+// every link got before we checked for collisions, so those keep working), then longer and longer bits of it, then
+// hashes of it with a number on the end.
+func shortCodeCandidates(originalPath string, prefix string) []string {
+	hashCode := func(salt string) string {
+		h := fnv.New64a()
+		h.Write([]byte(originalPath + salt))
+		code := toBase62(h.Sum64())
+		if len(code) < 6 {
+			code = string(base62Chars[0]) + code
+		}
+		return code
 	}
 
-	// Create the URL somewhat predictably
-	h := fnv.New64a()
-	h.Write([]byte(originalPath))
-	hash := h.Sum64()
-
-	// Convert hash to base62
-	shortCode := toBase62(hash)
-
-	if len(shortCode) < 6 {
-		shortCode = string(base62Chars[0]) + shortCode
-	} else if len(shortCode) > 6 {
-		shortCode = shortCode[:6]
+	candidates := []string{}
+	code := hashCode("")
+	for length := 6; length <= len(code) && len(candidates) < shortLinkAttempts; length++ {
+		candidates = append(candidates, prefix+code[:length])
+	}
+	for i := 1; len(candidates) < shortLinkAttempts; i++ {
+		salted := hashCode("#" + strconv.Itoa(i))
+		candidates = append(candidates, prefix+salted[:min(8, len(salted))])
 	}
+	return candidates
+}
 
-	shortCode = prefix + shortCode
+func CreateShortLink(originalPath string, prefix string) (string, error) {
+	switch prefix {
+	case shortLinkImage, shortLinkVideo, shortLinkGIF, shortLinkLink:
+	default:
+		return "", fmt.Errorf("unknown short link namespace %q", prefix)
+	}
 
-	err := db_controller.StoreShortLink(shortCode, originalPath)
-	if err != nil {
-		return "", err
+	// Check cache first
+	if code, ok := cache.Get(prefix, originalPath); ok {
+		return code, nil
 	}
 
-	// Cache the result
-	cache.Set(originalPath, shortCode)
-	return shortCode, nil
+	// Two URLs can have the same hash (only the first 6 characters of it are used), so if the code is taken by
+	// something else, try the next one.
+	for _, shortCode := range shortCodeCandidates(originalPath, prefix) {
+		stored, err := db_controller.StoreShortLink(shortCode, originalPath)
+		if err != nil {
+			return "", err
+		}
+		if stored {
+			// Cache the result
+			cache.Set(prefix, originalPath, shortCode)
+			return shortCode, nil
+		}
+		metrics.ShortLinkCollisions.Inc(prefix)
+	}
+	return "", fmt.Errorf("couldn't find a free short code for %s", originalPath)
 }
 
 func RedirectToLink(c *fiber.Ctx) error {
@@ -95,10 +172,11 @@ func RedirectToLink(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusNotFound).SendString("Could not find image!")
 	}
 
+	// a 302 like the link ones, a 301 gets cached forever and the code could be pruned & reused
 	if strings.HasPrefix(originalURL, "http") { // probably a standalone url
-		return c.Redirect(originalURL, fiber.StatusMovedPermanently)
+		return c.Redirect(originalURL, fiber.StatusFound)
 	} else {
-		return c.Redirect(configData.CdnURL+originalURL, fiber.StatusMovedPermanently)
+		return c.Redirect(configData.CdnURL+originalURL, fiber.StatusFound)
 	}
 
 }
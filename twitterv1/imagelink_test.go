@@ -0,0 +1,240 @@
+package twitterv1
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Preloading/TwitterAPIBridge/config"
+	"github.com/Preloading/TwitterAPIBridge/db_controller"
+	"github.com/gofiber/fiber/v2"
+)
+
+// useShortLinkDB gives CreateShortLink a new database, and an empty cache to go with it (codes cached from
+// another database wouldn't be in this one).
+func useShortLinkDB(t *testing.T) {
+	t.Helper()
+	db_controller.OpenDB(config.Config{DatabaseType: "sqlite", DatabasePath: filepath.Join(t.TempDir(), "links.db")})
+	oldCache := cache
+	cache = newURLCache(urlCacheSize)
+	t.Cleanup(func() { cache = oldCache })
+}
+
+func TestShortCodeCandidates(t *testing.T) {
+	candidates := shortCodeCandidates("https://example.com/", shortLinkLink)
+	if len(candidates) != shortLinkAttempts {
+		t.Fatalf("got %d candidates", len(candidates))
+	}
+	seen := map[string]bool{}
+	for _, code := range candidates {
+		if !strings.HasPrefix(code, shortLinkLink) || seen[code] {
+			t.Errorf("bad candidate %q in %q", code, candidates)
+		}
+		seen[code] = true
+	}
+	if len(candidates[0]) != 1+6 {
+		t.Errorf("the first code is %q, codes from before collisions were checked were 6 characters", candidates[0])
+	}
+}
+
+// Lots of requests making short links for the same URLs at once all get the same code for each, and it leads back to it.
+func TestCreateShortLinkConcurrent(t *testing.T) {
+	useShortLinkDB(t)
+
+	type link struct{ prefix, url string }
+	links := []link{}
+	for i := range 20 {
+		url := fmt.Sprintf("https://example.com/%d", i)
+		links = append(links, link{shortLinkLink, url}, link{shortLinkImage, url})
+	}
+
+	codes := make([][]string, len(links))
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i, link := range links {
+				code, err := CreateShortLink(link.url, link.prefix)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mutex.Lock()
+				codes[i] = append(codes[i], code)
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	used := map[string]bool{}
+	for i, link := range links {
+		code := codes[i][0]
+		for _, other := range codes[i] {
+			if other != code {
+				t.Errorf("%s%s got codes %q", link.prefix, link.url, codes[i])
+				break
+			}
+		}
+		if used[code] {
+			t.Errorf("%s is used for more than one link", code)
+		}
+		used[code] = true
+		if !strings.HasPrefix(code, link.prefix) {
+			t.Errorf("%s for %s isn't in the %s namespace", code, link.url, link.prefix)
+		}
+		if original, err := db_controller.GetOriginalURL(code); err != nil || original != link.url {
+			t.Errorf("%s leads to %q (%v), want %s", code, original, err, link.url)
+		}
+	}
+}
+
+func TestCreateShortLinkCollision(t *testing.T) {
+	useShortLinkDB(t)
+	const url = "https://example.com/collides"
+	candidates := shortCodeCandidates(url, shortLinkLink)
+
+	// something else already has the first two codes it could get
+	for _, code := range candidates[:2] {
+		if stored, err := db_controller.StoreShortLink(code, "https://example.com/was-here-first"); err != nil || !stored {
+			t.Fatal(stored, err)
+		}
+	}
+
+	code, err := CreateShortLink(url, shortLinkLink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != candidates[2] {
+		t.Errorf("got %s, want the first free one %s", code, candidates[2])
+	}
+	if original, _ := db_controller.GetOriginalURL(candidates[0]); original != "https://example.com/was-here-first" {
+		t.Errorf("the first link's code now leads to %q", original)
+	}
+
+	// and it's the same one next time, without the cache
+	cache = newURLCache(urlCacheSize)
+	if again, err := CreateShortLink(url, shortLinkLink); err != nil || again != code {
+		t.Errorf("got %s (%v) the second time, %s the first", again, err, code)
+	}
+
+	// if every code is taken, it gives up instead of taking one
+	const unlucky = "https://example.com/unlucky"
+	for _, code := range shortCodeCandidates(unlucky, shortLinkLink) {
+		db_controller.StoreShortLink(code, "https://example.com/was-here-first")
+	}
+	if code, err := CreateShortLink(unlucky, shortLinkLink); err == nil {
+		t.Errorf("got %s with every code taken", code)
+	}
+
+	if _, err := CreateShortLink(url, "x"); err == nil {
+		t.Error("made a short link in an unknown namespace")
+	}
+}
+
+func TestURLCacheLRU(t *testing.T) {
+	c := newURLCache(3)
+	c.Set("l", "a", "la")
+	c.Set("l", "b", "lb")
+	c.Set("l", "c", "lc")
+	c.Get("l", "a") // a's been used since b
+	c.Set("l", "d", "ld")
+
+	if _, ok := c.Get("l", "b"); ok {
+		t.Error("b should have been evicted")
+	}
+	for _, url := range []string{"a", "c", "d"} {
+		if code, ok := c.Get("l", url); !ok || code != "l"+url {
+			t.Errorf("%s: got %q, %v", url, code, ok)
+		}
+	}
+	if _, ok := c.Get("i", "a"); ok {
+		t.Error("the image namespace got the link's code")
+	}
+
+	// old entries are misses, so they get stored again
+	c.entries["la"].Value.(*urlCacheEntry).storedAt = time.Now().Add(-urlCacheRefreshAfter)
+	if _, ok := c.Get("l", "a"); ok {
+		t.Error("got a code past urlCacheRefreshAfter")
+	}
+	c.Set("l", "a", "la")
+	if _, ok := c.Get("l", "a"); !ok || c.order.Len() != 3 {
+		t.Error("setting it again didn't refresh it")
+	}
+}
+
+// Run with -race.
+func TestURLCacheConcurrent(t *testing.T) {
+	c := newURLCache(50)
+	var wg sync.WaitGroup
+	for worker := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 1000 {
+				url := fmt.Sprint((i * (worker + 1)) % 200)
+				if code, ok := c.Get("l", url); ok && code != "l"+url {
+					t.Errorf("%s has code %s", url, code)
+					return
+				}
+				c.Set("l", url, "l"+url)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(c.entries) != c.order.Len() || c.order.Len() > 50 {
+		t.Errorf("%d entries in the map, %d in the list, the size is 50", len(c.entries), c.order.Len())
+	}
+	for key, element := range c.entries {
+		if element.Value.(*urlCacheEntry).key != key {
+			t.Errorf("%s is in the map as %s", element.Value.(*urlCacheEntry).key, key)
+		}
+	}
+}
+
+// Image, video and gif codes redirect to the CDN (or the URL, if it's a whole one), with a 302 so nothing caches them forever.
+func TestRedirectToLink(t *testing.T) {
+	useShortLinkDB(t)
+	useImageConfig(t, &config.Config{CdnURL: "https://cdn.example"})
+	app := fiber.New()
+	app.Get("/img/:ref", RedirectToLink)
+
+	links := map[string]string{
+		shortLinkImage: "/img/feed_fullsize/plain/did:plc:alice/abc@jpeg",
+		shortLinkVideo: "https://video.example/watch/abc.m3u8",
+		shortLinkGIF:   "/gif/did:plc:alice/abc",
+	}
+	for prefix, link := range links {
+		code, err := CreateShortLink(link, prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/img/"+code, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := link
+		if !strings.HasPrefix(link, "http") {
+			want = "https://cdn.example" + link
+		}
+		if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != want {
+			t.Errorf("%s: got %d to %q, want 302 to %s", code, resp.StatusCode, resp.Header.Get("Location"), want)
+		}
+	}
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/img/inothing", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("got %d for a code that doesn't exist", resp.StatusCode)
+	}
+}
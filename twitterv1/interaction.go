@@ -2,12 +2,11 @@ package twitterv1
 
 import (
 	"bytes"
-	"fmt"
+	"errors"
 	"image"
 	_ "image/jpeg"
 	_ "image/png"
 	"io"
-	"regexp"
 	"strconv"
 	"time"
 
@@ -27,10 +26,17 @@ func status_update(c *fiber.Ctx) error {
 
 	status := c.FormValue("status")
 
-	// Status parsing!
-	mentions := findHandleInstances(status)
-	links := findUrlInstances(status)
-	tags := findTagInstances(status)
+	// the client might've given up on this last time, and be sending it again
+	duplicateKey := statusKey(status, c.FormValue("in_reply_to_status_id"), nil)
+	posting, originalURI, err := recentStatuses.begin(c.UserContext(), *my_did, duplicateKey)
+	if err != nil {
+		return ReturnError(c, "Bluesky is over capacity.", 130, fiber.StatusServiceUnavailable) // waited too long for the first one
+	}
+	if originalURI != "" {
+		return answerDuplicate(c, originalURI, *oauthToken, *pds)
+	}
+	postedURI := ""
+	defer func() { recentStatuses.finish(*my_did, duplicateKey, posting, postedURI) }()
 
 	//	trim_user := c.FormValue("trim_user") // Unused
 	encoded_in_reply_to_status_id_str := c.FormValue("in_reply_to_status_id")
@@ -43,12 +49,16 @@ func status_update(c *fiber.Ctx) error {
 		}
 	}
 
-	thread, err := blueskyapi.UpdateStatus(*pds, *oauthToken, *my_did, status, in_reply_to_status_id, mentions, links, tags, nil, []int{})
+	thread, err := postStatus(c.UserContext(), *pds, *oauthToken, *my_did, status, in_reply_to_status_id, nil, []int{})
 
+	if errors.Is(err, errStatusTooLong) {
+		return ReturnError(c, "Status is over 140 characters.", 186, fiber.StatusForbidden)
+	}
 	if err != nil {
-		fmt.Println("Error:", err)
-		return HandleBlueskyError(c, err.Error(), "com.atproto.repo.createRecord", status_update)
+		log.ErrorContext(c.UserContext(), "UpdateStatus failed", "error", err)
+		return HandleBlueskyError(c, err, "com.atproto.repo.createRecord", status_update)
 	}
+	postedURI = thread.Thread.Post.URI
 
 	db_controller.StoreAnalyticData(db_controller.AnalyticData{
 		DataType:             "status_update",
@@ -60,11 +70,7 @@ func status_update(c *fiber.Ctx) error {
 		Timestamp:            time.Now(),
 	})
 
-	if thread.Thread.Parent == nil {
-		return EncodeAndSend(c, TranslatePostToTweet(thread.Thread.Post, "", "", "", nil, nil, *oauthToken, *pds))
-	} else {
-		return EncodeAndSend(c, TranslatePostToTweet(thread.Thread.Post, thread.Thread.Parent.Post.URI, thread.Thread.Parent.Post.Author.DID, thread.Thread.Parent.Post.Author.Handle, &thread.Thread.Parent.Post.Record.CreatedAt.Time, nil, *oauthToken, *pds))
-	}
+	return EncodeAndSend(c, postedTweet(c.UserContext(), thread, *oauthToken, *pds))
 }
 
 // https://web.archive.org/web/20120508224719/https://dev.twitter.com/docs/api/1/post/statuses/update
@@ -82,7 +88,7 @@ func status_update_with_media(c *fiber.Ctx) error {
 	if err != nil {
 		imageData, err = c.FormFile("media[]")
 		if err != nil {
-			fmt.Println("Error:", err)
+			log.ErrorContext(c.UserContext(), "Error reading uploaded image", "error", err)
 			return ReturnError(c, "Please upload an image", 195, fiber.StatusForbidden)
 		}
 	}
@@ -90,35 +96,42 @@ func status_update_with_media(c *fiber.Ctx) error {
 	// read the image file content
 	file, err := imageData.Open()
 	if err != nil {
-		fmt.Println("Error:", err)
+		log.ErrorContext(c.UserContext(), "Error reading uploaded image", "error", err)
 		return ReturnError(c, "An invalid image was uploaded", 195, fiber.StatusForbidden)
 	}
 	defer file.Close()
 
 	imageBytes, err := io.ReadAll(file)
 	if err != nil {
-		fmt.Println("Error:", err)
+		log.ErrorContext(c.UserContext(), "Error reading uploaded image", "error", err)
 		return ReturnError(c, "Failed to process image", 131, fiber.StatusInternalServerError)
 	}
 
 	// Get image resolution
 	imageConfig, _, err := image.DecodeConfig(bytes.NewReader(imageBytes))
 	if err != nil {
-		fmt.Println("Error:", err)
+		log.ErrorContext(c.UserContext(), "Error decoding uploaded image", "error", err)
 		return ReturnError(c, "Failed to process image", 131, fiber.StatusInternalServerError)
 	}
 
-	// upload our new profile picture
-	imageBlob, err := blueskyapi.UploadBlob(*pds, *oauthToken, imageBytes, c.Get("Content-Type"))
+	// the client might've given up on this last time, and be sending it again
+	duplicateKey := statusKey(status, c.FormValue("in_reply_to_status_id"), imageBytes)
+	posting, originalURI, err := recentStatuses.begin(c.UserContext(), *my_did, duplicateKey)
 	if err != nil {
-		fmt.Println("Error:", err)
-		return HandleBlueskyError(c, err.Error(), "com.atproto.repo.uploadBlob", status_update_with_media)
+		return ReturnError(c, "Bluesky is over capacity.", 130, fiber.StatusServiceUnavailable) // waited too long for the first one
+	}
+	if originalURI != "" {
+		return answerDuplicate(c, originalURI, *oauthToken, *pds)
 	}
+	postedURI := ""
+	defer func() { recentStatuses.finish(*my_did, duplicateKey, posting, postedURI) }()
 
-	// Status parsing!
-	mentions := findHandleInstances(status)
-	links := findUrlInstances(status)
-	tags := findTagInstances(status)
+	// upload the image
+	imageBlob, err := blueskyapi.UploadBlob(c.UserContext(), *pds, *oauthToken, imageBytes, c.Get("Content-Type"))
+	if err != nil {
+		log.ErrorContext(c.UserContext(), "UploadBlob failed", "error", err)
+		return HandleBlueskyError(c, err, "com.atproto.repo.uploadBlob", status_update_with_media)
+	}
 
 	//	trim_user := c.FormValue("trim_user") // Unused
 	encoded_in_reply_to_status_id_str := c.FormValue("in_reply_to_status_id")
@@ -131,21 +144,22 @@ func status_update_with_media(c *fiber.Ctx) error {
 		}
 	}
 
-	thread, err := blueskyapi.UpdateStatus(*pds, *oauthToken,
+	thread, err := postStatus(c.UserContext(), *pds, *oauthToken,
 		*my_did,
 		status,
 		in_reply_to_status_id,
-		mentions,
-		links,
-		tags,
 		imageBlob,
 		[]int{imageConfig.Height, imageConfig.Width},
 	)
 
+	if errors.Is(err, errStatusTooLong) {
+		return ReturnError(c, "Status is over 140 characters.", 186, fiber.StatusForbidden)
+	}
 	if err != nil {
-		fmt.Println("Error:", err)
-		return HandleBlueskyError(c, err.Error(), "com.atproto.repo.createRecord", status_update_with_media)
+		log.ErrorContext(c.UserContext(), "UpdateStatus failed", "error", err)
+		return HandleBlueskyError(c, err, "com.atproto.repo.createRecord", status_update_with_media)
 	}
+	postedURI = thread.Thread.Post.URI
 
 	db_controller.StoreAnalyticData(db_controller.AnalyticData{
 		DataType:             "status_update",
@@ -157,11 +171,7 @@ func status_update_with_media(c *fiber.Ctx) error {
 		Timestamp:            time.Now(),
 	})
 
-	if thread.Thread.Parent == nil {
-		return EncodeAndSend(c, TranslatePostToTweet(thread.Thread.Post, "", "", "", nil, nil, *oauthToken, *pds))
-	} else {
-		return EncodeAndSend(c, TranslatePostToTweet(thread.Thread.Post, thread.Thread.Parent.Post.URI, thread.Thread.Parent.Post.Author.DID, thread.Thread.Parent.Post.Author.Handle, &thread.Thread.Parent.Post.Record.CreatedAt.Time, nil, *oauthToken, *pds))
-	}
+	return EncodeAndSend(c, postedTweet(c.UserContext(), thread, *oauthToken, *pds))
 }
 
 // https://web.archive.org/web/20120407091252/https://dev.twitter.com/docs/api/1/post/statuses/retweet/%3Aid
@@ -184,22 +194,22 @@ func retweet(c *fiber.Ctx) error {
 	}
 	postId = *postIdPtr
 
-	originalPost, blueskyRepostURI, err := blueskyapi.ReTweet(*pds, *oauthToken, postId, *user_did)
+	originalPost, blueskyRepostURI, repostCreatedAt, err := blueskyapi.ReTweet(c.UserContext(), *pds, *oauthToken, postId, *user_did)
 
 	if err != nil {
-		fmt.Println("Error:", err)
-		return HandleBlueskyError(c, err.Error(), "com.atproto.repo.createRecord", retweet)
+		log.ErrorContext(c.UserContext(), "ReTweet failed", "error", err)
+		return HandleBlueskyError(c, err, "com.atproto.repo.createRecord", retweet)
 	}
 
 	var retweet bridge.Tweet
 	if originalPost.Thread.Parent == nil {
-		retweet = TranslatePostToTweet(originalPost.Thread.Post, "", "", "", nil, nil, *oauthToken, *pds)
+		retweet = TranslatePostToTweet(c.UserContext(), originalPost.Thread.Post, "", "", "", nil, nil, *oauthToken, *pds)
 	} else {
-		retweet = TranslatePostToTweet(originalPost.Thread.Post, originalPost.Thread.Parent.Post.URI, originalPost.Thread.Parent.Post.Author.DID, originalPost.Thread.Parent.Post.Author.Handle, &originalPost.Thread.Parent.Post.Record.CreatedAt.Time, nil, *oauthToken, *pds)
+		retweet = TranslatePostToTweet(c.UserContext(), originalPost.Thread.Post, originalPost.Thread.Parent.Post.URI, originalPost.Thread.Parent.Post.Author.DID, originalPost.Thread.Parent.Post.Author.Handle, &originalPost.Thread.Parent.Post.Record.CreatedAt.Time, nil, *oauthToken, *pds)
 	}
 	retweet.Retweeted = true
-	now := time.Now() // pain, also fix this to use the proper timestamp according to the server.
-	retweetId := bridge.BskyMsgToTwitterID(originalPost.Thread.Post.URI, &now, user_did)
+	// same time as the timelines will use, from the repost record, so the IDs match
+	retweetId := bridge.BskyMsgToTwitterID(c.UserContext(), originalPost.Thread.Post.URI, repostCreatedAt, user_did)
 	retweet.ID = *retweetId
 	retweet.IDStr = strconv.FormatInt(retweet.ID, 10)
 	originalPost.Thread.Post.Viewer.Repost = blueskyRepostURI
@@ -218,9 +228,9 @@ func retweet(c *fiber.Ctx) error {
 		Tweet: retweet,
 		RetweetedStatus: func() bridge.Tweet { // TODO: make this respond with proper retweet data
 			if originalPost.Thread.Parent == nil {
-				return TranslatePostToTweet(originalPost.Thread.Post, "", "", "", nil, nil, *oauthToken, *pds)
+				return TranslatePostToTweet(c.UserContext(), originalPost.Thread.Post, "", "", "", nil, nil, *oauthToken, *pds)
 			} else {
-				return TranslatePostToTweet(originalPost.Thread.Post, originalPost.Thread.Parent.Post.URI, originalPost.Thread.Parent.Post.Author.DID, originalPost.Thread.Parent.Post.Author.Handle, &originalPost.Thread.Parent.Post.Record.CreatedAt.Time, nil, *oauthToken, *pds)
+				return TranslatePostToTweet(c.UserContext(), originalPost.Thread.Post, originalPost.Thread.Parent.Post.URI, originalPost.Thread.Parent.Post.Author.DID, originalPost.Thread.Parent.Post.Author.Handle, &originalPost.Thread.Parent.Post.Record.CreatedAt.Time, nil, *oauthToken, *pds)
 			}
 		}(),
 	})
@@ -246,18 +256,18 @@ func favourite(c *fiber.Ctx) error {
 	}
 	postId = *postIdPtr
 
-	post, err := blueskyapi.LikePost(*pds, *oauthToken, postId, *user_did)
+	post, err := blueskyapi.LikePost(c.UserContext(), *pds, *oauthToken, postId, *user_did)
 
 	if err != nil {
-		fmt.Println("Error:", err)
-		return HandleBlueskyError(c, err.Error(), "com.atproto.repo.createRecord", favourite)
+		log.ErrorContext(c.UserContext(), "LikePost failed", "error", err)
+		return HandleBlueskyError(c, err, "com.atproto.repo.createRecord", favourite)
 	}
 
 	var newTweet bridge.Tweet
 	if post.Thread.Parent == nil {
-		newTweet = TranslatePostToTweet(post.Thread.Post, "", "", "", nil, nil, *oauthToken, *pds)
+		newTweet = TranslatePostToTweet(c.UserContext(), post.Thread.Post, "", "", "", nil, nil, *oauthToken, *pds)
 	} else {
-		newTweet = TranslatePostToTweet(post.Thread.Post, post.Thread.Parent.Post.URI, post.Thread.Parent.Post.Author.DID, post.Thread.Parent.Post.Author.Handle, &post.Thread.Parent.Post.Record.CreatedAt.Time, nil, *oauthToken, *pds)
+		newTweet = TranslatePostToTweet(c.UserContext(), post.Thread.Post, post.Thread.Parent.Post.URI, post.Thread.Parent.Post.Author.DID, post.Thread.Parent.Post.Author.Handle, &post.Thread.Parent.Post.Record.CreatedAt.Time, nil, *oauthToken, *pds)
 	}
 
 	db_controller.StoreAnalyticData(db_controller.AnalyticData{
@@ -293,18 +303,18 @@ func Unfavourite(c *fiber.Ctx) error { // yes i am canadian
 	}
 	postId = *postIdPtr
 
-	post, err := blueskyapi.UnlikePost(*pds, *oauthToken, postId, *user_did)
+	post, err := blueskyapi.UnlikePost(c.UserContext(), *pds, *oauthToken, postId, *user_did)
 
 	if err != nil {
-		fmt.Println("Error:", err)
-		return HandleBlueskyError(c, err.Error(), "com.atproto.repo.deleteRecord", Unfavourite)
+		log.ErrorContext(c.UserContext(), "UnlikePost failed", "error", err)
+		return HandleBlueskyError(c, err, "com.atproto.repo.deleteRecord", Unfavourite)
 	}
 
 	var newTweet bridge.Tweet
 	if post.Thread.Parent == nil {
-		newTweet = TranslatePostToTweet(post.Thread.Post, "", "", "", nil, nil, *oauthToken, *pds)
+		newTweet = TranslatePostToTweet(c.UserContext(), post.Thread.Post, "", "", "", nil, nil, *oauthToken, *pds)
 	} else {
-		newTweet = TranslatePostToTweet(post.Thread.Post, post.Thread.Parent.Post.URI, post.Thread.Parent.Post.Author.DID, post.Thread.Parent.Post.Author.Handle, &post.Thread.Parent.Post.Record.CreatedAt.Time, nil, *oauthToken, *pds)
+		newTweet = TranslatePostToTweet(c.UserContext(), post.Thread.Post, post.Thread.Parent.Post.URI, post.Thread.Parent.Post.Author.DID, post.Thread.Parent.Post.Author.Handle, &post.Thread.Parent.Post.Record.CreatedAt.Time, nil, *oauthToken, *pds)
 	}
 
 	db_controller.StoreAnalyticData(db_controller.AnalyticData{
@@ -340,11 +350,11 @@ func DeleteTweet(c *fiber.Ctx) error {
 	}
 	postId = *postIdPtr
 
-	postToDelete, err := blueskyapi.GetPost(*pds, *oauthToken, postId, 0, 0)
+	postToDelete, err := blueskyapi.GetPost(c.UserContext(), *pds, *oauthToken, postId, 0, 0)
 
 	if err != nil {
-		fmt.Println("Error:", err)
-		return HandleBlueskyError(c, err.Error(), "com.atproto.repo.deleteRecord", DeleteTweet)
+		log.ErrorContext(c.UserContext(), "GetPost failed", "error", err)
+		return HandleBlueskyError(c, err, "com.atproto.repo.deleteRecord", DeleteTweet)
 	}
 
 	collection := "app.bsky.feed.post"
@@ -364,9 +374,9 @@ func DeleteTweet(c *fiber.Ctx) error {
 		}
 	}
 
-	if err := blueskyapi.DeleteRecord(*pds, *oauthToken, postId, *user_did, collection); err != nil {
-		fmt.Println("Error:", err)
-		return HandleBlueskyError(c, err.Error(), "com.atproto.repo.deleteRecord", DeleteTweet)
+	if err := blueskyapi.DeleteRecord(c.UserContext(), *pds, *oauthToken, postId, *user_did, collection); err != nil {
+		log.ErrorContext(c.UserContext(), "DeleteRecord failed", "error", err)
+		return HandleBlueskyError(c, err, "com.atproto.repo.deleteRecord", DeleteTweet)
 	}
 
 	db_controller.StoreAnalyticData(db_controller.AnalyticData{
@@ -385,52 +395,10 @@ func DeleteTweet(c *fiber.Ctx) error {
 	return EncodeAndSend(c,
 		func() bridge.Tweet { // TODO: make this respond with proper retweet data
 			if postToDelete.Thread.Parent == nil {
-				return TranslatePostToTweet(postToDelete.Thread.Post, "", "", "", nil, nil, *oauthToken, *pds)
+				return TranslatePostToTweet(c.UserContext(), postToDelete.Thread.Post, "", "", "", nil, nil, *oauthToken, *pds)
 			} else {
-				return TranslatePostToTweet(postToDelete.Thread.Post, postToDelete.Thread.Parent.Post.URI, postToDelete.Thread.Parent.Post.Author.DID, postToDelete.Thread.Parent.Post.Author.Handle, &postToDelete.Thread.Parent.Post.Record.CreatedAt.Time, nil, *oauthToken, *pds)
+				return TranslatePostToTweet(c.UserContext(), postToDelete.Thread.Post, postToDelete.Thread.Parent.Post.URI, postToDelete.Thread.Parent.Post.Author.DID, postToDelete.Thread.Parent.Post.Author.Handle, &postToDelete.Thread.Parent.Post.Record.CreatedAt.Time, nil, *oauthToken, *pds)
 			}
 		}(),
 	)
 }
-
-func findHandleInstances(input string) []bridge.FacetParsing {
-	regex := regexp.MustCompile(`@([a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?\.)+[a-zA-Z]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?`)
-	matches := regex.FindAllStringIndex(input, -1)
-	results := []bridge.FacetParsing{}
-	for _, match := range matches {
-		results = append(results, bridge.FacetParsing{
-			Start: match[0],
-			End:   match[1],
-			Item:  input[match[0]+1 : match[1]], // +1 to skip the '@' character
-		})
-	}
-	return results
-}
-
-func findUrlInstances(input string) []bridge.FacetParsing {
-	regex := regexp.MustCompile(`[$|\W](https?:\/\/(www\.)?[-a-zA-Z0-9@:%._\+~#=]{1,256}\.[a-zA-Z0-9()]{1,6}\b([-a-zA-Z0-9()@:%_\+.~#?&//=]*[-a-zA-Z0-9@%_\+~#//=])?)`)
-	matches := regex.FindAllStringIndex(input, -1)
-	results := []bridge.FacetParsing{}
-	for _, match := range matches {
-		results = append(results, bridge.FacetParsing{
-			Start: match[0] + 1,
-			End:   match[1],
-			Item:  input[match[0]+1 : match[1]],
-		})
-	}
-	return results
-}
-
-func findTagInstances(input string) []bridge.FacetParsing {
-	regex := regexp.MustCompile(`#[a-zA-Z0-9_]+`)
-	matches := regex.FindAllStringIndex(input, -1)
-	results := []bridge.FacetParsing{}
-	for _, match := range matches {
-		results = append(results, bridge.FacetParsing{
-			Start: match[0],
-			End:   match[1],
-			Item:  input[match[0]+1 : match[1]],
-		})
-	}
-	return results
-}
@@ -0,0 +1,67 @@
+package twitterv1
+
+import (
+	"context"
+	"time"
+
+	blueskyapi "github.com/Preloading/TwitterAPIBridge/bluesky"
+	"github.com/Preloading/TwitterAPIBridge/linkcard"
+)
+
+const (
+	linkCardMaxPageBytes  = 1 << 20 // 1MB of html is plenty to get through the <head>
+	linkCardMaxImageBytes = 1000000 // bluesky's limit for thumbnails
+)
+
+// set up in NewApp, nil if LINK_CARDS is off
+var linkCards *linkcard.Fetcher
+
+func setupLinkCards() {
+	if !configData.LinkCards {
+		linkCards = nil
+		return
+	}
+	linkCards = linkcard.New(linkcard.Options{
+		Timeout:               time.Duration(configData.LinkCardTimeoutSeconds) * time.Second,
+		MaxPageBytes:          linkCardMaxPageBytes,
+		MaxImageBytes:         linkCardMaxImageBytes,
+		UserAgent:             "Mozilla/5.0 (compatible; TwitterAPIBridge; +https://github.com/Preloading/TwitterAPIBridge)",
+		AllowPrivateAddresses: configData.LinkCardAllowPrivate,
+	})
+}
+
+// makeLinkCard makes a link card for link, with its image uploaded to the user's PDS. nil if there's nothing to show,
+// a post without a card is better than a post that failed because some website was down.
+func makeLinkCard(ctx context.Context, pds string, token string, link string) *blueskyapi.ExternalImage {
+	if linkCards == nil {
+		return nil
+	}
+	card, err := linkCards.Fetch(ctx, link)
+	if err != nil {
+		log.DebugContext(ctx, "Couldn't make a link card", "url", link, "error", err)
+		return nil
+	}
+	if card.Title == "" && card.Description == "" && card.ImageURL == "" {
+		return nil
+	}
+
+	external := &blueskyapi.ExternalImage{
+		Uri:         card.URL,
+		Title:       card.Title,
+		Description: card.Description,
+	}
+	if card.ImageURL != "" {
+		image, contentType, err := linkCards.FetchImage(ctx, card.ImageURL)
+		if err != nil {
+			log.DebugContext(ctx, "Couldn't get a link card's image", "url", card.ImageURL, "error", err)
+			return external
+		}
+		blob, err := blueskyapi.UploadBlob(ctx, pds, token, image, contentType)
+		if err != nil {
+			log.WarnContext(ctx, "UploadBlob failed for a link card", "error", err)
+			return external
+		}
+		external.Thumb = *blob
+	}
+	return external
+}
@@ -0,0 +1,173 @@
+package twitterv1
+
+import (
+	"encoding/xml"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	blueskyapi "github.com/Preloading/TwitterAPIBridge/bluesky"
+	"github.com/Preloading/TwitterAPIBridge/bridge"
+	"github.com/Preloading/TwitterAPIBridge/db_controller"
+	"github.com/Preloading/TwitterAPIBridge/richtext"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Twitter put every link through t.co, so clients expect short links in the text, and show display_url in their
+// place. Long bluesky links don't fit in their layouts (and break things, ex. getting parent tweets on 5.0.x),
+// so we do the same: every link becomes LINK_URL_TEXT (a short link to us that redirects to it), and display_url
+// is the start of the real one.
+
+// How long display_url gets before it's cut off, about what twitter did
+const linkDisplayLength = 26
+
+// wrapLink makes a short link to link.
+func wrapLink(link string) (string, error) {
+	shortCode, err := CreateShortLink(link, shortLinkLink)
+	if err != nil {
+		return "", err
+	}
+	return strings.ReplaceAll(configData.LinkURLText, "{shortcode}", shortCode), nil
+}
+
+// linkDisplayText is what's shown in place of the short link, ex. example.com/a/really/long…
+func linkDisplayText(link string) string {
+	display := strings.TrimPrefix(strings.TrimPrefix(link, "https://"), "http://")
+	if utf8.RuneCountInString(display) <= linkDisplayLength {
+		return display
+	}
+	return string([]rune(display)[:linkDisplayLength-1]) + "…"
+}
+
+// wrapLinks changes every link in text to a short link, and moves the facets to match. It gives back copies, and
+// which facets (by index) are links that were wrapped. Facets that don't make sense anymore (ex. one halfway into a
+// link) get an invalid range, so they're skipped like any other broken facet.
+func wrapLinks(text string, facets []blueskyapi.Facet) (string, []blueskyapi.Facet, map[int]bool) {
+	type replacement struct {
+		facet      int
+		start, end int // in the old text
+		shortURL   string
+	}
+	replacements := []replacement{}
+	for i, facet := range facets {
+		if len(facet.Features) == 0 || facet.Features[0].Type != "app.bsky.richtext.facet#link" || !richtext.ValidRange(text, facet.Index.ByteStart, facet.Index.ByteEnd) {
+			continue
+		}
+		uri := facet.Features[0].Uri
+		if !isWebLink(uri) {
+			continue
+		}
+		shortURL, err := wrapLink(uri)
+		if err != nil {
+			log.Error("Error creating short link", "error", err)
+			continue
+		}
+		replacements = append(replacements, replacement{facet: i, start: facet.Index.ByteStart, end: facet.Index.ByteEnd, shortURL: shortURL})
+	}
+	slices.SortFunc(replacements, func(a, b replacement) int { return a.start - b.start })
+	// overlapping links, keep the first
+	kept := replacements[:0]
+	for _, r := range replacements {
+		if len(kept) == 0 || r.start >= kept[len(kept)-1].end {
+			kept = append(kept, r)
+		}
+	}
+	replacements = kept
+
+	wrapped := map[int]bool{}
+	if len(replacements) == 0 {
+		return text, facets, wrapped
+	}
+
+	// moveOffset is where offset in the old text ends up in the new one, or -1 if it was in the middle of a link.
+	moveOffset := func(offset int) int {
+		shift := 0
+		for _, r := range replacements {
+			if offset <= r.start {
+				break
+			}
+			if offset < r.end {
+				return -1
+			}
+			shift += len(r.shortURL) - (r.end - r.start)
+		}
+		return offset + shift
+	}
+
+	var newText strings.Builder
+	last := 0
+	for _, r := range replacements {
+		newText.WriteString(text[last:r.start])
+		newText.WriteString(r.shortURL)
+		last = r.end
+	}
+	newText.WriteString(text[last:])
+
+	newFacets := slices.Clone(facets)
+	for i := range newFacets {
+		newStart, newEnd := moveOffset(newFacets[i].Index.ByteStart), moveOffset(newFacets[i].Index.ByteEnd)
+		if newStart < 0 || newEnd < 0 {
+			newStart, newEnd = -1, -1
+		}
+		newFacets[i].Index.ByteStart, newFacets[i].Index.ByteEnd = newStart, newEnd
+	}
+	for _, r := range replacements {
+		wrapped[r.facet] = true
+	}
+	return newText.String(), newFacets, wrapped
+}
+
+// RedirectToWrappedLink is our t.co, where the short links from wrapLink go.
+func RedirectToWrappedLink(c *fiber.Ctx) error {
+	shortCode := c.Params("ref")
+	if !strings.HasPrefix(shortCode, shortLinkLink) {
+		return c.Status(fiber.StatusNotFound).SendString("Could not find link!")
+	}
+	originalURL, err := db_controller.GetOriginalURL(shortCode)
+	if err != nil || !isWebLink(originalURL) {
+		return c.Status(fiber.StatusNotFound).SendString("Could not find link!")
+	}
+
+	if configData.LinkClickCounting {
+		if err := db_controller.CountShortLinkClick(shortCode); err != nil {
+			log.WarnContext(c.UserContext(), "CountShortLinkClick failed", "error", err)
+		}
+	}
+	// not a 301, clients & proxies would cache it forever, and the link might get deleted (or the code reused after pruning)
+	return c.Redirect(originalURL, fiber.StatusFound)
+}
+
+func isWebLink(link string) bool {
+	return strings.HasPrefix(link, "http://") || strings.HasPrefix(link, "https://")
+}
+
+// hasLinkFacet is whether there's a link to link in the text.
+func hasLinkFacet(facets []blueskyapi.Facet, link string) bool {
+	return slices.ContainsFunc(facets, func(facet blueskyapi.Facet) bool {
+		return len(facet.Features) > 0 && facet.Features[0].Type == "app.bsky.richtext.facet#link" && facet.Features[0].Uri == link
+	})
+}
+
+// linkEntity is the url entity for a link at start:end in the tweet's text. url is what's in the text, display is what
+// the client shows instead, and expanded is where it really goes.
+func linkEntity(start int, end int, url string, display string, expanded string) bridge.URL {
+	return bridge.URL{
+		ExpandedURL: expanded,
+		URL:         url,
+		DisplayURL:  display,
+		Start:       start,
+		End:         end,
+		Indices: []int{
+			start,
+			end,
+		},
+		XMLName: xml.Name{Local: "url"},
+		XMLFormat: bridge.URLXMLFormat{
+			Start:       start,
+			End:         end,
+			DisplayURL:  display,
+			URL:         url,
+			ExpandedURL: expanded,
+		},
+	}
+}
@@ -0,0 +1,74 @@
+package twitterv1_test
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/Preloading/TwitterAPIBridge/bridge"
+	"github.com/Preloading/TwitterAPIBridge/fakepds"
+	"github.com/Preloading/TwitterAPIBridge/twitterv1"
+)
+
+const wrappedLink = "https://example.com/a/really/long/article/path?id=1"
+
+// postLink posts a tweet with wrappedLink in it, and gives back the tweet.
+func postLink(t *testing.T, h *fakepds.Harness) bridge.Tweet {
+	t.Helper()
+	auth := h.MustLogin(t, "alice.test", "dev_alice-pass")
+	var tweet bridge.Tweet
+	h.Call(t, http.MethodPost, "/1/statuses/update.json", url.Values{"status": {"read this " + wrappedLink}}, auth, &tweet)
+	return tweet
+}
+
+func TestWrappedLinks(t *testing.T) {
+	h := fakepds.NewTestHarness(t, nil)
+	h.Config.LinkURLText = "http://bridge.example/l/{shortcode}"
+	h.App = twitterv1.NewApp(h.Config)
+
+	tweet := postLink(t, h)
+	if len(tweet.Entities.Urls) != 1 {
+		t.Fatalf("got url entities %+v", tweet.Entities.Urls)
+	}
+	entity := tweet.Entities.Urls[0]
+	if !strings.HasPrefix(entity.URL, "http://bridge.example/l/") || entity.ExpandedURL != wrappedLink || entity.DisplayURL != "example.com/a/really/long…" {
+		t.Errorf("got url entity %+v", entity)
+	}
+	if tweet.Text != "read this "+entity.URL {
+		t.Errorf("text is %q, the link should be %s", tweet.Text, entity.URL)
+	}
+
+	// following it goes to the link, with a redirect that doesn't get cached forever
+	path := strings.TrimPrefix(entity.URL, "http://bridge.example")
+	resp, _, err := h.Request(http.MethodGet, path, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != wrappedLink {
+		t.Errorf("%s: got %d to %q, want 302 to %s", path, resp.StatusCode, resp.Header.Get("Location"), wrappedLink)
+	}
+
+	for _, path := range []string{"/l/nothing", path + "x"} {
+		resp, _, err := h.Request(http.MethodGet, path, nil, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: got %d, want 404", path, resp.StatusCode)
+		}
+	}
+}
+
+// Without LINK_URL_TEXT (the default), links are left alone.
+func TestUnwrappedLinks(t *testing.T) {
+	h := fakepds.NewTestHarness(t, nil)
+
+	tweet := postLink(t, h)
+	if tweet.Text != "read this "+wrappedLink {
+		t.Errorf("text is %q", tweet.Text)
+	}
+	if len(tweet.Entities.Urls) != 1 || tweet.Entities.Urls[0].URL != wrappedLink || tweet.Entities.Urls[0].ExpandedURL != wrappedLink {
+		t.Errorf("got url entities %+v", tweet.Entities.Urls)
+	}
+}
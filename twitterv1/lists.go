@@ -48,21 +48,21 @@ func GetUsersLists(c *fiber.Ctx) error {
 
 	cursor := c.Query("cursor")
 
-	lists, err := blueskyapi.GetUsersLists(*pds, *oauthToken, screen_name, 20, cursor)
+	lists, err := blueskyapi.GetUsersLists(c.UserContext(), *pds, *oauthToken, screen_name, 20, cursor)
 	if err != nil {
-		return HandleBlueskyError(c, err.Error(), "app.bsky.graph.getLists", GetUsersLists)
+		return HandleBlueskyError(c, err, "app.bsky.graph.getLists", GetUsersLists)
 	}
 
-	listsOwner, err := blueskyapi.GetUserInfo(*pds, *oauthToken, screen_name, false)
+	listsOwner, err := blueskyapi.GetUserInfo(c.UserContext(), *pds, *oauthToken, screen_name, false)
 	if err != nil {
-		return HandleBlueskyError(c, err.Error(), "app.bsky.actor.getProfile", GetUsersLists)
+		return HandleBlueskyError(c, err, "app.bsky.actor.getProfile", GetUsersLists)
 	}
 
 	twitterLists := []bridge.TwitterList{}
 
 	for _, list := range lists.Lists {
 		listDID, _, listRKEY := blueskyapi.GetURIComponents(list.URI)
-		id := bridge.BlueSkyToTwitterID(list.URI)
+		id := bridge.BlueSkyToTwitterID(c.UserContext(), list.URI)
 
 		twitterLists = append(twitterLists, bridge.TwitterList{
 			Slug:            listRKEY,
@@ -134,7 +134,7 @@ func list_timeline(c *fiber.Ctx) error {
 				}
 				owner = *ownerPtr
 			} else {
-				ownerDID, err := blueskyapi.ResolveDIDFromHandle(owner)
+				ownerDID, err := blueskyapi.ResolveDIDFromHandle(c.UserContext(), owner)
 				if err != nil {
 					return ReturnError(c, "Invalid owner handle provided", 195, fiber.StatusForbidden)
 				}
@@ -194,7 +194,7 @@ func GetListMembers(c *fiber.Ctx) error {
 				}
 				owner = *ownerPtr
 			} else {
-				ownerDID, err := blueskyapi.ResolveDIDFromHandle(owner)
+				ownerDID, err := blueskyapi.ResolveDIDFromHandle(c.UserContext(), owner)
 				if err != nil {
 					return ReturnError(c, "Invalid owner handle provided", 195, fiber.StatusForbidden)
 				}
@@ -229,9 +229,9 @@ func GetListMembers(c *fiber.Ctx) error {
 	}
 
 	// Get our list
-	listInfo, err := blueskyapi.GetList(*pds, *oauthToken, list, 20, cursor) // No clue what the limit was on actual twitter.
+	listInfo, err := blueskyapi.GetList(c.UserContext(), *pds, *oauthToken, list, 20, cursor) // No clue what the limit was on actual twitter.
 	if err != nil {
-		return HandleBlueskyError(c, err.Error(), "app.bsky.graph.getList", GetListMembers)
+		return HandleBlueskyError(c, err, "app.bsky.graph.getList", GetListMembers)
 	}
 
 	// Get the full user info on the members of the list.
@@ -241,10 +241,10 @@ func GetListMembers(c *fiber.Ctx) error {
 		membersDID = append(membersDID, member.Subject.DID)
 	}
 
-	members, err := blueskyapi.GetUsersInfo(*pds, *oauthToken, membersDID, false)
+	members, err := blueskyapi.GetUsersInfo(c.UserContext(), *pds, *oauthToken, membersDID, false)
 
 	if err != nil {
-		return HandleBlueskyError(c, err.Error(), "app.bsky.actor.getProfiles", GetListMembers)
+		return HandleBlueskyError(c, err, "app.bsky.actor.getProfiles", GetListMembers)
 	}
 
 	// Next Cursor
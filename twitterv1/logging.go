@@ -0,0 +1,56 @@
+package twitterv1
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Preloading/TwitterAPIBridge/logging"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+var log = logging.For("twitterv1")
+
+// RequestID gives every request an ID (or uses the one our reverse proxy sent), which gets added to everything
+// logged with c.UserContext(), and sent back as X-Request-ID so users can give it to us when something breaks.
+func RequestID(c *fiber.Ctx) error {
+	// cloned, fiber reuses the buffer after the request, and this can end up in a goroutine
+	id := strings.Clone(c.Get(fiber.HeaderXRequestID))
+	if id == "" || len(id) > 64 {
+		id = uuid.NewString()
+	}
+	c.Set(fiber.HeaderXRequestID, id)
+	c.SetUserContext(logging.WithRequestID(c.UserContext(), id))
+	return c.Next()
+}
+
+// AccessLog logs every request once it's done.
+func AccessLog(c *fiber.Ctx) error {
+	start := time.Now()
+	log.DebugContext(c.UserContext(), "Request", "url", c.OriginalURL())
+
+	err := c.Next()
+
+	log.InfoContext(c.UserContext(), "Handled request",
+		"method", c.Method(),
+		"path", c.Path(),
+		"status", responseStatus(c, err),
+		"latency", time.Since(start).Round(time.Microsecond),
+		"ip", c.IP(),
+		"client", c.Get("X-Twitter-Client"),
+	)
+	return err
+}
+
+// responseStatus is the status a request will get. Errors get turned into a response after the middleware is done, so guess what it'll be.
+func responseStatus(c *fiber.Ctx, err error) int {
+	if err == nil {
+		return c.Response().StatusCode()
+	}
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return fiberErr.Code
+	}
+	return fiber.StatusInternalServerError
+}
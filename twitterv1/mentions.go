@@ -0,0 +1,199 @@
+package twitterv1
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	blueskyapi "github.com/Preloading/TwitterAPIBridge/bluesky"
+	"github.com/Preloading/TwitterAPIBridge/bridge"
+	"github.com/Preloading/TwitterAPIBridge/metrics"
+	"github.com/Preloading/TwitterAPIBridge/richtext"
+)
+
+// Old clients autocomplete (and people type) @alice, not @alice.bsky.social. Bluesky has no idea who @alice is,
+// so when posting we figure out who they meant:
+// 1. someone they've talked to recently (replied to, mentioned)
+// 2. someone they follow
+// 3. name + SHORT_MENTION_SUFFIX
+// whose handle starts with the name. Every guess gets resolved before it's used, so we never mention someone who doesn't exist.
+
+const (
+	mentionFollowsTTL      = 10 * time.Minute
+	mentionFollowsMaxPages = 10 // 500 follows, past that we just guess
+	recentMentionsPerUser  = 100
+	mentionCacheMaxUsers   = 10000
+	maxShortMentions       = 10 // per post, each one can take a few requests
+)
+
+// Handles someone follows, so we don't page through all their follows for every post
+type followsCacheEntry struct {
+	handles []string
+	expires time.Time
+}
+
+type mentionCache struct {
+	mutex   sync.Mutex
+	follows map[string]followsCacheEntry
+	recent  map[string][]string // most recent first
+}
+
+var mentionLookups = &mentionCache{
+	follows: make(map[string]followsCacheEntry),
+	recent:  make(map[string][]string),
+}
+
+// rememberInteraction notes that did talked to handle, so @name can find them next time.
+func (m *mentionCache) rememberInteraction(did string, handle string) {
+	if handle == "" || handle == "handle.invalid" {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.recent[did]; !ok && len(m.recent) >= mentionCacheMaxUsers {
+		// not worth an LRU, whoever's posting again will fill it back up
+		clear(m.recent)
+	}
+	recent := slices.DeleteFunc(m.recent[did], func(h string) bool { return strings.EqualFold(h, handle) })
+	recent = append([]string{handle}, recent...)
+	if len(recent) > recentMentionsPerUser {
+		recent = recent[:recentMentionsPerUser]
+	}
+	m.recent[did] = recent
+}
+
+func (m *mentionCache) recentHandles(did string) []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return slices.Clone(m.recent[did])
+}
+
+// followedHandles is everyone did follows (well, the first mentionFollowsMaxPages pages of it)
+func (m *mentionCache) followedHandles(ctx context.Context, pds string, token string, did string) []string {
+	m.mutex.Lock()
+	entry, ok := m.follows[did]
+	m.mutex.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		metrics.CacheRequests.Inc("follows", "hit")
+		return entry.handles
+	}
+	metrics.CacheRequests.Inc("follows", "miss")
+
+	handles := []string{}
+	cursor := ""
+	for range mentionFollowsMaxPages {
+		follows, err := blueskyapi.GetFollows(ctx, pds, token, cursor, did)
+		if err != nil {
+			log.WarnContext(ctx, "Couldn't get follows for mentions", "error", err)
+			break
+		}
+		for _, user := range follows.Followers {
+			handles = append(handles, user.Handle)
+		}
+		if follows.Cursor == "" || follows.Cursor == cursor {
+			break
+		}
+		cursor = follows.Cursor
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if len(m.follows) >= mentionCacheMaxUsers {
+		for key, entry := range m.follows {
+			if time.Now().After(entry.expires) {
+				delete(m.follows, key)
+			}
+		}
+	}
+	m.follows[did] = followsCacheEntry{handles: handles, expires: time.Now().Add(mentionFollowsTTL)}
+	return handles
+}
+
+// matchingHandles is the handles that @name could mean, the ones starting with name.
+func matchingHandles(name string, handles []string) []string {
+	matches := []string{}
+	for _, handle := range handles {
+		first, _, _ := strings.Cut(handle, ".")
+		if strings.EqualFold(first, name) && !slices.Contains(matches, handle) {
+			matches = append(matches, handle)
+		}
+	}
+	return matches
+}
+
+// firstResolvable is the first of the handles that actually exists, or "".
+func firstResolvable(ctx context.Context, handles []string) string {
+	for _, handle := range handles {
+		if _, err := blueskyapi.ResolveDIDFromHandle(ctx, handle); err == nil {
+			return handle
+		}
+	}
+	return ""
+}
+
+// ResolveShortMentions finds @name mentions in status, and works out who they are.
+// It gives back the status (with @name changed to the full handle if SHORT_MENTION_REWRITE is on), and mentions for
+// the ones it figured out, which go with the mentions from richtext.FindMentions. If the status was rewritten,
+// they're already in it, and this returns no mentions (find them in the new status).
+func ResolveShortMentions(ctx context.Context, pds string, token string, did string, status string) (string, []bridge.FacetParsing) {
+	shortMentions := richtext.FindShortMentions(status)
+	if len(shortMentions) == 0 {
+		return status, nil
+	}
+	if len(shortMentions) > maxShortMentions {
+		shortMentions = shortMentions[:maxShortMentions]
+	}
+
+	recent := mentionLookups.recentHandles(did)
+	var follows []string // only looked up if we need it
+
+	resolved := map[string]string{} // name -> handle, "" if nobody
+	results := []bridge.FacetParsing{}
+	for _, mention := range shortMentions {
+		name := strings.ToLower(mention.Item)
+		handle, ok := resolved[name]
+		if !ok {
+			handle = firstResolvable(ctx, matchingHandles(name, recent))
+			if handle == "" {
+				if follows == nil {
+					follows = mentionLookups.followedHandles(ctx, pds, token, did)
+				}
+				guesses := matchingHandles(name, follows)
+				if configData.ShortMentionSuffix != "" {
+					guesses = append(guesses, name+"."+strings.TrimPrefix(configData.ShortMentionSuffix, "."))
+				}
+				handle = firstResolvable(ctx, guesses)
+			}
+			resolved[name] = handle
+		}
+		if handle == "" {
+			continue
+		}
+		results = append(results, bridge.FacetParsing{Start: mention.Start, End: mention.End, Item: handle})
+	}
+
+	if !configData.ShortMentionRewrite || len(results) == 0 {
+		return status, results
+	}
+
+	// going backwards so the offsets before each one stay right
+	rewritten := status
+	for i := len(results) - 1; i >= 0; i-- {
+		mention := results[i]
+		rewritten = rewritten[:mention.Start] + "@" + mention.Item + rewritten[mention.End:]
+	}
+	return rewritten, nil
+}
+
+// rememberPostInteractions remembers who someone talked to in a post they just made.
+func rememberPostInteractions(did string, thread *blueskyapi.ThreadRoot, mentions []bridge.FacetParsing) {
+	if thread.Thread.Parent != nil {
+		mentionLookups.rememberInteraction(did, thread.Thread.Parent.Post.Author.Handle)
+	}
+	for _, mention := range mentions {
+		mentionLookups.rememberInteraction(did, mention.Item)
+	}
+}
@@ -0,0 +1,90 @@
+package twitterv1_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Preloading/TwitterAPIBridge/fakepds"
+	"github.com/Preloading/TwitterAPIBridge/twitterv1"
+)
+
+// mentionHarness is the default fixtures plus mia, who follows dave, and erin, who's only findable by the suffix.
+// Who mia talked to recently is remembered for as long as the process runs, so she gets a new DID every time.
+func mentionHarness(t *testing.T) (*fakepds.Harness, string) {
+	t.Helper()
+	mia := fmt.Sprintf("did:plc:mia%d", time.Now().UnixNano())
+	fixtures := fakepds.DefaultFixtures()
+	fixtures.Accounts = append(fixtures.Accounts,
+		fakepds.Account{DID: mia, Handle: "mia.test", Password: "dev_mia-pass", DisplayName: "Mia"},
+		fakepds.Account{DID: "did:plc:dave", Handle: "dave.test", DisplayName: "Dave"},
+		fakepds.Account{DID: "did:plc:erin", Handle: "erin.bsky.social", DisplayName: "Erin"},
+	)
+	fixtures.Follows = append(fixtures.Follows, fakepds.Follow{RKey: "3kfollowmia", From: mia, To: "did:plc:dave", CreatedAt: time.Now()})
+	h := fakepds.NewTestHarness(t, fixtures)
+	return h, h.MustLogin(t, "mia.test", "dev_mia-pass")
+}
+
+// post posts status as auth, and gives back what the PDS got.
+func post(t *testing.T, h *fakepds.Harness, auth string, form url.Values) createdPost {
+	t.Helper()
+	before := len(createdPosts(t, h))
+	h.Call(t, http.MethodPost, "/1/statuses/update.json", form, auth, nil)
+	posts := createdPosts(t, h)
+	if len(posts) != before+1 {
+		t.Fatalf("%d posts made, want 1", len(posts)-before)
+	}
+	return posts[before]
+}
+
+func TestShortMentions(t *testing.T) {
+	h, mia := mentionHarness(t)
+
+	// someone she follows, and someone who's only there with the suffix
+	got := post(t, h, mia, url.Values{"status": {"hi @dave and @erin"}})
+	if got.Record.Text != "hi @dave and @erin" {
+		t.Errorf("text is %q", got.Record.Text)
+	}
+	if mentions := got.mentions(); len(mentions) != 2 || mentions["@dave"] != "did:plc:dave" || mentions["@erin"] != "did:plc:erin" {
+		t.Errorf("mentions are %v", mentions)
+	}
+
+	// nobody she knows, and carol.bsky.social doesn't exist
+	got = post(t, h, mia, url.Values{"status": {"@carol @nobody are you there"}})
+	if mentions := got.mentions(); len(mentions) != 0 {
+		t.Errorf("mentioned %v", mentions)
+	}
+
+	// once she's replied to carol, @carol is her
+	var carols []struct {
+		ID int64 `json:"id"`
+	}
+	h.Call(t, http.MethodGet, "/1/statuses/user_timeline.json", url.Values{"screen_name": {"carol.test"}}, mia, &carols)
+	if len(carols) == 0 {
+		t.Fatal("carol has no posts")
+	}
+	post(t, h, mia, url.Values{"status": {"nice"}, "in_reply_to_status_id": {strconv.FormatInt(carols[0].ID, 10)}})
+	got = post(t, h, mia, url.Values{"status": {"@carol again"}})
+	if mentions := got.mentions(); len(mentions) != 1 || mentions["@carol"] != "did:plc:carol" {
+		t.Errorf("mentions after replying to carol are %v", mentions)
+	}
+}
+
+// With SHORT_MENTION_REWRITE the full handle goes in the post, and the facets point at it (in bytes, after text that
+// isn't ASCII).
+func TestShortMentionRewrite(t *testing.T) {
+	h, mia := mentionHarness(t)
+	h.Config.ShortMentionRewrite = true
+	h.App = twitterv1.NewApp(h.Config)
+
+	got := post(t, h, mia, url.Values{"status": {"今日は 🐟 @dave, @nobody & @erin!"}})
+	if want := "今日は 🐟 @dave.test, @nobody & @erin.bsky.social!"; got.Record.Text != want {
+		t.Errorf("text is %q, want %q", got.Record.Text, want)
+	}
+	if mentions := got.mentions(); len(mentions) != 2 || mentions["@dave.test"] != "did:plc:dave" || mentions["@erin.bsky.social"] != "did:plc:erin" {
+		t.Errorf("mentions are %v", mentions)
+	}
+}
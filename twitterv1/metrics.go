@@ -0,0 +1,63 @@
+package twitterv1
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Preloading/TwitterAPIBridge/metrics"
+	"github.com/gofiber/fiber/v2"
+)
+
+// MetricsMiddleware counts requests, and how long they took, by route & client.
+func MetricsMiddleware(c *fiber.Ctx) error {
+	start := time.Now()
+	err := c.Next()
+
+	// The route pattern, not the path, or every tweet id would be its own route.
+	// If nothing matched, the last route we went through is a middleware.
+	route := c.Route().Path
+	if c.Route().Method == "USE" {
+		route = "unmatched"
+	}
+
+	client := metricsClient(c.Get("X-Twitter-Client"))
+	metrics.HTTPRequests.Inc(route, c.Method(), strconv.Itoa(responseStatus(c, err)), client)
+	metrics.HTTPRequestDuration.Observe(time.Since(start).Seconds(), route, client)
+	return err
+}
+
+// metricsClient is the client label for an X-Twitter-Client header. It's only ever one of METRICS_CLIENTS, so
+// nobody can make up new series by sending whatever they want.
+func metricsClient(header string) string {
+	if header == "" {
+		return "unknown"
+	}
+	header = strings.ToLower(header)
+	for _, client := range configData.MetricsClients {
+		if client != "" && strings.Contains(header, strings.ToLower(client)) {
+			return client
+		}
+	}
+	return "other"
+}
+
+// /metrics, for prometheus
+func Metrics(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, metrics.ContentType)
+	metrics.WritePrometheus(c)
+	return nil
+}
+
+// serveMetrics serves /metrics on its own address (METRICS_LISTEN_ADDRESS), without the admin token.
+func serveMetrics(address string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", metrics.ContentType)
+		metrics.WritePrometheus(w)
+	})
+	if err := http.ListenAndServe(address, mux); err != nil {
+		log.Error("Error serving metrics", "error", err)
+	}
+}
@@ -0,0 +1,54 @@
+package twitterv1
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Preloading/TwitterAPIBridge/config"
+	"github.com/Preloading/TwitterAPIBridge/metrics"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestMetricsClient(t *testing.T) {
+	useImageConfig(t, &config.Config{MetricsClients: []string{"Twitter-iPhone", "TwitterAndroid"}})
+	for header, want := range map[string]string{
+		"":                 "unknown",
+		"Twitter-iPhone":   "Twitter-iPhone",
+		"twitter-iphone":   "Twitter-iPhone",
+		"TwitterAndroid/3": "TwitterAndroid",
+		"Twitter-Mac":      "other",
+		"made up \"\n":     "other",
+	} {
+		if got := metricsClient(header); got != want {
+			t.Errorf("%q is labeled %q, want %q", header, got, want)
+		}
+	}
+}
+
+// Made up clients don't get their own series.
+func TestMetricsMiddlewareClientLabel(t *testing.T) {
+	useImageConfig(t, &config.Config{MetricsClients: []string{"Twitter-iPhone"}})
+	app := fiber.New()
+	app.Use(MetricsMiddleware)
+	app.Get("/metrics-client-test", func(c *fiber.Ctx) error { return c.SendString("ok") })
+
+	for _, client := range []string{"Twitter-iPhone", "spam-1", "spam-2"} {
+		req := httptest.NewRequest("GET", "/metrics-client-test", nil)
+		req.Header.Set("X-Twitter-Client", client)
+		if _, err := app.Test(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var page strings.Builder
+	metrics.WritePrometheus(&page)
+	if strings.Contains(page.String(), "spam-") {
+		t.Error("a made up client got its own series")
+	}
+	for _, client := range []string{"Twitter-iPhone", "other"} {
+		if !strings.Contains(page.String(), `route="/metrics-client-test",method="GET",status="200",client="`+client+`"`) {
+			t.Errorf("no series for %s", client)
+		}
+	}
+}
@@ -258,10 +258,10 @@ func AttemptToAuthenticateWithOauth(c *fiber.Ctx) error {
 		username := c.FormValue("username")
 		password := c.FormValue("password")
 
-		res, pds, err := blueskyapi.Authenticate(username, password)
+		res, pds, err := blueskyapi.Authenticate(c.UserContext(), username, password)
 		if err != nil {
 			// failed auth
-			return HandleBlueskyError(c, err.Error(), "com.atproto.server.createSession", access_token)
+			return HandleBlueskyError(c, err, "com.atproto.server.createSession", access_token)
 		}
 
 		// successful auth
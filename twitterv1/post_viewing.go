@@ -1,6 +1,7 @@
 package twitterv1
 
 import (
+	"context"
 	"encoding/xml"
 	"fmt"
 	"net/url"
@@ -10,11 +11,11 @@ import (
 	"strings"
 	"sync"
 	"time"
-	"unicode/utf8"
 
 	blueskyapi "github.com/Preloading/TwitterAPIBridge/bluesky"
 	"github.com/Preloading/TwitterAPIBridge/bridge"
 	"github.com/Preloading/TwitterAPIBridge/db_controller"
+	"github.com/Preloading/TwitterAPIBridge/richtext"
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -79,7 +80,7 @@ func likes_timeline(c *fiber.Ctx) error {
 }
 
 // https://web.archive.org/web/20120508224719/https://dev.twitter.com/docs/api/1/get/statuses/home_timeline
-func convert_timeline(c *fiber.Ctx, param string, requireAuth bool, fetcher func(string, string, string, string, int) (*blueskyapi.Timeline, error)) error {
+func convert_timeline(c *fiber.Ctx, param string, requireAuth bool, fetcher func(context.Context, string, string, string, string, int) (*blueskyapi.Timeline, error)) error {
 	// Get all of our keys, beeps, and bops
 	_, pds, _, oauthToken, err := GetAuthFromReq(c)
 
@@ -108,22 +109,12 @@ func convert_timeline(c *fiber.Ctx, param string, requireAuth bool, fetcher func
 	if max_id != "" {
 		// Get the timeline context from the DB
 		maxIDInt, err := strconv.ParseInt(max_id, 10, 64)
-		fmt.Println("Max ID:", maxIDInt)
 		if err != nil {
 			return ReturnError(c, "Invalid max_id format", 195, fiber.StatusForbidden)
 		}
-		_, date, _, err := bridge.TwitterMsgIdToBluesky(&maxIDInt)
+		date, err := bridge.TwitterMsgIdToTime(maxIDInt)
 		if err != nil {
-			tempId := maxIDInt - 1
-			_, date, _, err = bridge.TwitterMsgIdToBluesky(&tempId)
-			if err != nil {
-				tempId = maxIDInt + 1
-				_, date, _, err = bridge.TwitterMsgIdToBluesky(&tempId)
-				if err != nil {
-					return ReturnError(c, "max_id was not found", 144, fiber.StatusForbidden)
-
-				}
-			}
+			return ReturnError(c, "max_id was not found", 144, fiber.StatusForbidden)
 		}
 		context = date.Format(time.RFC3339)
 	}
@@ -139,28 +130,18 @@ func convert_timeline(c *fiber.Ctx, param string, requireAuth bool, fetcher func
 		if err != nil {
 			return ReturnError(c, "Invalid since_id format", 195, fiber.StatusForbidden)
 		}
-		_, tempDate, _, err := bridge.TwitterMsgIdToBluesky(&sinceIdInt)
+		tempDate, err := bridge.TwitterMsgIdToTime(sinceIdInt)
 		if err != nil {
-			// the offical twitter docs recommended you add one to the id. Because of... technical debt, our IDs do not support this, and thus this must be done
-			tempId := sinceIdInt - 1
-			_, tempDate, _, err = bridge.TwitterMsgIdToBluesky(&tempId)
-			if err != nil {
-				tempId = sinceIdInt + 1
-				_, tempDate, _, err = bridge.TwitterMsgIdToBluesky(&tempId)
-				if err != nil {
-					return ReturnError(c, "since_id was not found", 144, fiber.StatusForbidden)
-				}
-			}
-
+			return ReturnError(c, "since_id was not found", 144, fiber.StatusForbidden)
 		}
 		since_date = *tempDate
 		hasSinceDate = true
 	}
 
-	fmt.Println("Context:", context)
-	res, err := fetcher(*pds, *oauthToken, context, param, limit)
+	log.DebugContext(c.UserContext(), "Getting timeline", "context", context)
+	res, err := fetcher(c.UserContext(), *pds, *oauthToken, context, param, limit)
 	if err != nil {
-		return HandleBlueskyError(c, err.Error(), "app.bsky.feed.defs#timeline", func(c *fiber.Ctx) error { // dislike the "lexicon", but its fine.
+		return HandleBlueskyError(c, err, "app.bsky.feed.defs#timeline", func(c *fiber.Ctx) error { // dislike the "lexicon", but its fine.
 			return convert_timeline(c, param, requireAuth, fetcher)
 		})
 	}
@@ -176,7 +157,7 @@ func convert_timeline(c *fiber.Ctx, param string, requireAuth bool, fetcher func
 		res.Feed = filteredFeed
 	}
 
-	fmt.Println(len(res.Feed))
+	log.DebugContext(c.UserContext(), "Got timeline", "posts", len(res.Feed))
 
 	// Caching the user DIDs efficiently
 	userDIDs := []string{}
@@ -187,13 +168,13 @@ func convert_timeline(c *fiber.Ctx, param string, requireAuth bool, fetcher func
 		}
 	}
 
-	blueskyapi.GetUsersInfo(*pds, *oauthToken, userDIDs, false) // fill cache
+	blueskyapi.GetUsersInfo(c.UserContext(), *pds, *oauthToken, userDIDs, false) // fill cache
 
 	// Translate the posts to tweets
 	tweets := []bridge.Tweet{}
 
 	for _, item := range res.Feed {
-		tweets = append(tweets, TranslatePostToTweet(item.Post, item.Reply.Parent.URI, item.Reply.Parent.Author.DID, item.Reply.Parent.Author.Handle, &item.Reply.Parent.Record.CreatedAt.Time, item.Reason, *oauthToken, *pds))
+		tweets = append(tweets, TranslatePostToTweet(c.UserContext(), item.Post, item.Reply.Parent.URI, item.Reply.Parent.Author.DID, item.Reply.Parent.Author.Handle, &item.Reply.Parent.Record.CreatedAt.Time, item.Reason, *oauthToken, *pds))
 	}
 
 	if c.Params("filetype") == "xml" { // i wonder why twitter ditched xml
@@ -229,10 +210,10 @@ func RelatedResults(c *fiber.Ctx) error {
 		oauthToken = &blankstring
 	}
 
-	thread, err := blueskyapi.GetPost(*pds, *oauthToken, uri, 1, 0)
+	thread, err := blueskyapi.GetPost(c.UserContext(), *pds, *oauthToken, uri, 1, 0)
 
 	if err != nil {
-		return HandleBlueskyError(c, err.Error(), "app.bsky.feed.getPostThread", RelatedResults)
+		return HandleBlueskyError(c, err, "app.bsky.feed.getPostThread", RelatedResults)
 	}
 
 	if thread.Thread.Replies == nil {
@@ -248,9 +229,9 @@ func RelatedResults(c *fiber.Ctx) error {
 		}
 	}
 
-	blueskyapi.GetUsersInfo(*pds, *oauthToken, userDIDs, false)
+	blueskyapi.GetUsersInfo(c.UserContext(), *pds, *oauthToken, userDIDs, false)
 
-	postAuthor := bridge.BlueSkyToTwitterID(thread.Thread.Post.Author.DID)
+	postAuthor := bridge.BlueSkyToTwitterID(c.UserContext(), thread.Thread.Post.Author.DID)
 
 	twitterReplies := bridge.RelatedResultsQuery{
 		Annotations: []bridge.Annotations{},
@@ -264,7 +245,7 @@ func RelatedResults(c *fiber.Ctx) error {
 		twitterReplies.Results = append(twitterReplies.Results, bridge.Results{
 			Kind:  "Tweet",
 			Score: 1.0,
-			Value: TranslatePostToTweet(reply.Post, uri, strconv.FormatInt(*postAuthor, 10), reply.Post.Author.Handle, &thread.Thread.Post.Record.CreatedAt.Time, nil, *oauthToken, *pds),
+			Value: TranslatePostToTweet(c.UserContext(), reply.Post, uri, strconv.FormatInt(*postAuthor, 10), reply.Post.Author.Handle, &thread.Thread.Post.Record.CreatedAt.Time, nil, *oauthToken, *pds),
 			Annotations: []bridge.Annotations{
 				{
 					ConversationRole: "Descendant",
@@ -286,12 +267,12 @@ func GetStatusFromId(c *fiber.Ctx) error {
 	// Fetch ID
 	uriPtr, _, _, err := bridge.TwitterMsgIdToBluesky(&idInt)
 	if err != nil {
-		fmt.Println("Error:", err)
+		log.ErrorContext(c.UserContext(), "TwitterMsgIdToBluesky failed", "error", err)
 		return ReturnError(c, "ID not found.", 144, fiber.StatusNotFound)
 	}
 	uri := *uriPtr
 
-	fmt.Println("URI:", uri)
+	log.DebugContext(c.UserContext(), "Getting status", "uri", uri)
 	_, pds, _, oauthToken, err := GetAuthFromReq(c)
 
 	if err != nil {
@@ -299,25 +280,26 @@ func GetStatusFromId(c *fiber.Ctx) error {
 		oauthToken = &emptyString
 	}
 
-	thread, err := blueskyapi.GetPost(*pds, *oauthToken, uri, 0, 1)
+	thread, err := blueskyapi.GetPost(c.UserContext(), *pds, *oauthToken, uri, 0, 1)
 
 	if err != nil {
-		return HandleBlueskyError(c, err.Error(), "app.bsky.feed.getPostThread", GetStatusFromId)
+		return HandleBlueskyError(c, err, "app.bsky.feed.getPostThread", GetStatusFromId)
 	}
 
 	// TODO: Some things may be needed for reposts to show up correctly. thats a later problem :)
 	if thread.Thread.Parent == nil {
-		return EncodeAndSend(c, TranslatePostToTweet(thread.Thread.Post, "", "", "", nil, nil, *oauthToken, *pds))
+		return EncodeAndSend(c, TranslatePostToTweet(c.UserContext(), thread.Thread.Post, "", "", "", nil, nil, *oauthToken, *pds))
 	} else {
-		return EncodeAndSend(c, TranslatePostToTweet(thread.Thread.Post, thread.Thread.Parent.Post.URI, thread.Thread.Parent.Post.Author.DID, thread.Thread.Parent.Post.Author.Handle, &thread.Thread.Parent.Post.Record.CreatedAt.Time, nil, *oauthToken, *pds))
+		return EncodeAndSend(c, TranslatePostToTweet(c.UserContext(), thread.Thread.Post, thread.Thread.Parent.Post.URI, thread.Thread.Parent.Post.Author.DID, thread.Thread.Parent.Post.Author.Handle, &thread.Thread.Parent.Post.Record.CreatedAt.Time, nil, *oauthToken, *pds))
 	}
 }
 
 // This gigantic function is used to convert the bluesky post format, into a format that is compatible with the twitter API.
 // https://web.archive.org/web/20120506182126/https://dev.twitter.com/docs/platform-objects/tweets
-func TranslatePostToTweet(tweet blueskyapi.Post, replyMsgBskyURI string, replyUserBskyId string, replyUserHandle string, replyTimeStamp *time.Time, postReason *blueskyapi.PostReason, token string, pds string) bridge.Tweet {
+func TranslatePostToTweet(ctx context.Context, tweet blueskyapi.Post, replyMsgBskyURI string, replyUserBskyId string, replyUserHandle string, replyTimeStamp *time.Time, postReason *blueskyapi.PostReason, token string, pds string) bridge.Tweet {
 	var err error
 	textOffset := 0
+	unit := richtext.UnitFromContext(ctx) // what the client counts entity indices in
 
 	isRetweet := false
 	bsky_retweet_og_author := tweet.Author
@@ -345,12 +327,18 @@ func TranslatePostToTweet(tweet blueskyapi.Post, replyMsgBskyURI string, replyUs
 		})
 	}
 
+	// links become short links to us, like t.co
+	wrappedLinks := map[int]bool{}
+	if configData.LinkURLText != "" {
+		tweet.Record.Text, tweet.Record.Facets, wrappedLinks = wrapLinks(tweet.Record.Text, tweet.Record.Facets)
+	}
+
 	processedText := func() string {
 		// This fucks up all the entities :crying:
 
 		if isRetweet {
 			retweetedText := "RT @" + bsky_retweet_og_author.Handle + ": "
-			textOffset += utf8.RuneCountInString(retweetedText)
+			textOffset += richtext.Length(retweetedText, unit)
 			return retweetedText + tweet.Record.Text
 		}
 		return tweet.Record.Text
@@ -392,9 +380,9 @@ func TranslatePostToTweet(tweet blueskyapi.Post, replyMsgBskyURI string, replyUs
 			displayURL = strings.ReplaceAll(displayURL, "{fullblob}", image.Image.Ref.Link)
 			displayURL = strings.ReplaceAll(displayURL, "{user_did}", tweet.Author.DID)
 			if strings.Contains(displayURL, "{shortcode}") {
-				shortCode, err = CreateShortLink("/cdn/img/bsky/"+tweet.Author.DID+"/"+image.Image.Ref.Link+".jpg", "i")
+				shortCode, err = CreateShortLink("/cdn/img/bsky/"+tweet.Author.DID+"/"+image.Image.Ref.Link+".jpg", shortLinkImage)
 				if err != nil {
-					fmt.Println("Error creating short link:", err)
+					log.Error("Error creating short link", "error", err)
 					displayURL = strings.ReplaceAll(displayURL, "{shortcode}", "")
 				} else {
 					displayURL = strings.ReplaceAll(displayURL, "{shortcode}", shortCode)
@@ -402,12 +390,12 @@ func TranslatePostToTweet(tweet blueskyapi.Post, replyMsgBskyURI string, replyUs
 			}
 
 			if len(processedText) == 0 {
-				endLen = utf8.RuneCountInString(displayURL)
+				endLen = richtext.Length(displayURL, unit)
 
 				processedText = displayURL
 			} else {
-				startLen = utf8.RuneCountInString(processedText) + 1
-				endLen = (utf8.RuneCountInString(processedText) + 1) + utf8.RuneCountInString(displayURL)
+				startLen = richtext.Length(processedText, unit) + 1
+				endLen = startLen + richtext.Length(displayURL, unit)
 
 				processedText = processedText + "\n" + displayURL
 			}
@@ -418,9 +406,9 @@ func TranslatePostToTweet(tweet blueskyapi.Post, replyMsgBskyURI string, replyUs
 			formattedImageURL = strings.ReplaceAll(formattedImageURL, "{user_did}", tweet.Author.DID)
 			if strings.Contains(formattedImageURL, "{shortcode}") {
 				if shortCode == "" {
-					shortCode, err = CreateShortLink("/cdn/img/bsky/"+tweet.Author.DID+"/"+image.Image.Ref.Link+".jpg", "i")
+					shortCode, err = CreateShortLink("/cdn/img/bsky/"+tweet.Author.DID+"/"+image.Image.Ref.Link+".jpg", shortLinkImage)
 					if err != nil {
-						fmt.Println("Error creating short link:", err)
+						log.Error("Error creating short link", "error", err)
 						formattedImageURL = strings.ReplaceAll(formattedImageURL, "{shortcode}", "")
 					} else {
 						formattedImageURL = strings.ReplaceAll(formattedImageURL, "{shortcode}", shortCode)
@@ -580,9 +568,9 @@ func TranslatePostToTweet(tweet blueskyapi.Post, replyMsgBskyURI string, replyUs
 		shortCode := ""
 		if displayURL != "" {
 			if strings.Contains(displayURL, "{shortcode}") {
-				shortCode, err = CreateShortLink(tweet.Record.Embed.External.Uri, "g")
+				shortCode, err = CreateShortLink(tweet.Record.Embed.External.Uri, shortLinkGIF)
 				if err != nil {
-					fmt.Println("Error creating short link:", err)
+					log.Error("Error creating short link", "error", err)
 					displayURL = strings.ReplaceAll(displayURL, "{shortcode}", "")
 				} else {
 					displayURL = strings.ReplaceAll(displayURL, "{shortcode}", shortCode)
@@ -590,12 +578,12 @@ func TranslatePostToTweet(tweet blueskyapi.Post, replyMsgBskyURI string, replyUs
 			}
 
 			if len(processedText) == 0 {
-				endLen = utf8.RuneCountInString(displayURL)
+				endLen = richtext.Length(displayURL, unit)
 
 				processedText = displayURL
 			} else {
-				startLen = utf8.RuneCountInString(processedText) + 1
-				endLen = (utf8.RuneCountInString(processedText) + 1) + utf8.RuneCountInString(displayURL)
+				startLen = richtext.Length(processedText, unit) + 1
+				endLen = startLen + richtext.Length(displayURL, unit)
 
 				processedText = processedText + "\n" + displayURL
 			}
@@ -603,9 +591,9 @@ func TranslatePostToTweet(tweet blueskyapi.Post, replyMsgBskyURI string, replyUs
 		if formattedImageURL != "" {
 			if strings.Contains(formattedImageURL, "{shortcode}") {
 				if shortCode == "" {
-					shortCode, err = CreateShortLink(tweet.Record.Embed.External.Uri, "g")
+					shortCode, err = CreateShortLink(tweet.Record.Embed.External.Uri, shortLinkGIF)
 					if err != nil {
-						fmt.Println("Error creating short link:", err)
+						log.Error("Error creating short link", "error", err)
 						formattedImageURL = strings.ReplaceAll(formattedImageURL, "{shortcode}", "")
 					} else {
 						formattedImageURL = strings.ReplaceAll(formattedImageURL, "{shortcode}", shortCode)
@@ -765,7 +753,7 @@ func TranslatePostToTweet(tweet blueskyapi.Post, replyMsgBskyURI string, replyUs
 	}
 
 	// Faucets, essentially links, mentions, and hashtags
-	for _, faucet := range tweet.Record.Facets {
+	for i, faucet := range tweet.Record.Facets {
 		// I haven't seen this exceed 1 element yet
 		// if len(faucet.Features) > 1 {
 		// fmt.Println("Faucet with more than 1 feature found!")
@@ -779,19 +767,16 @@ func TranslatePostToTweet(tweet blueskyapi.Post, replyMsgBskyURI string, replyUs
 		// fmt.Println(faucet.Features[0].Type)
 		switch faucet.Features[0].Type {
 		case "app.bsky.richtext.facet#mention":
-			if faucet.Index.ByteEnd > len(tweet.Record.Text) { // yup! this is in fact necessary.
-				break
-			}
-			if faucet.Index.ByteStart < 0 {
+			if !richtext.ValidRange(tweet.Record.Text, faucet.Index.ByteStart, faucet.Index.ByteEnd) { // yup! this is in fact necessary.
 				break
 			}
-			startIndex := utf8.RuneCountInString(tweet.Record.Text[:faucet.Index.ByteStart]) + textOffset
-			endIndex := utf8.RuneCountInString(tweet.Record.Text[:faucet.Index.ByteEnd]) + textOffset
+			startIndex := richtext.Index(tweet.Record.Text, faucet.Index.ByteStart, unit) + textOffset
+			endIndex := richtext.Index(tweet.Record.Text, faucet.Index.ByteEnd, unit) + textOffset
 			tweetEntities.UserMentions = append(tweetEntities.UserMentions, bridge.UserMention{
 				Name:       tweet.Record.Text[faucet.Index.ByteStart+1 : faucet.Index.ByteEnd],
 				ScreenName: tweet.Record.Text[faucet.Index.ByteStart+1 : faucet.Index.ByteEnd],
-				ID:         bridge.BlueSkyToTwitterID(faucet.Features[0].Did),
-				IDStr:      strconv.FormatInt(*bridge.BlueSkyToTwitterID(faucet.Features[0].Did), 10),
+				ID:         bridge.BlueSkyToTwitterID(ctx, faucet.Features[0].Did),
+				IDStr:      strconv.FormatInt(*bridge.BlueSkyToTwitterID(ctx, faucet.Features[0].Did), 10),
 				Indices: []int{
 					startIndex,
 					endIndex,
@@ -800,42 +785,22 @@ func TranslatePostToTweet(tweet blueskyapi.Post, replyMsgBskyURI string, replyUs
 				End:   endIndex,
 			})
 		case "app.bsky.richtext.facet#link":
-			if faucet.Index.ByteEnd > len(tweet.Record.Text) { // yup! this is in fact necessary.
+			if !richtext.ValidRange(tweet.Record.Text, faucet.Index.ByteStart, faucet.Index.ByteEnd) { // yup! this is in fact necessary.
 				break
 			}
-			if faucet.Index.ByteStart < 0 {
-				break
+			startIndex := richtext.Index(tweet.Record.Text, faucet.Index.ByteStart, unit) + textOffset
+			endIndex := richtext.Index(tweet.Record.Text, faucet.Index.ByteEnd, unit) + textOffset
+			linkURL, displayURL := faucet.Features[0].Uri, tweet.Record.Text[faucet.Index.ByteStart:faucet.Index.ByteEnd]
+			if wrappedLinks[i] {
+				linkURL, displayURL = tweet.Record.Text[faucet.Index.ByteStart:faucet.Index.ByteEnd], linkDisplayText(faucet.Features[0].Uri)
 			}
-			startIndex := utf8.RuneCountInString(tweet.Record.Text[:faucet.Index.ByteStart]) + textOffset
-			endIndex := utf8.RuneCountInString(tweet.Record.Text[:faucet.Index.ByteEnd]) + textOffset
-			tweetEntities.Urls = append(tweetEntities.Urls, bridge.URL{
-				ExpandedURL: faucet.Features[0].Uri,
-				URL:         faucet.Features[0].Uri,
-				DisplayURL:  tweet.Record.Text[faucet.Index.ByteStart:faucet.Index.ByteEnd],
-				Start:       startIndex,
-				End:         endIndex,
-				Indices: []int{
-					startIndex,
-					endIndex,
-				},
-				XMLName: xml.Name{Local: "url"},
-				XMLFormat: bridge.URLXMLFormat{
-					Start:       startIndex,
-					End:         endIndex,
-					DisplayURL:  tweet.Record.Text[faucet.Index.ByteStart:faucet.Index.ByteEnd],
-					URL:         faucet.Features[0].Uri,
-					ExpandedURL: faucet.Features[0].Uri,
-				},
-			})
+			tweetEntities.Urls = append(tweetEntities.Urls, linkEntity(startIndex, endIndex, linkURL, displayURL, faucet.Features[0].Uri))
 		case "app.bsky.richtext.facet#tag":
-			if faucet.Index.ByteEnd > len(tweet.Record.Text) { // yup! this is in fact necessary.
-				break
-			}
-			if faucet.Index.ByteStart < 0 {
+			if !richtext.ValidRange(tweet.Record.Text, faucet.Index.ByteStart, faucet.Index.ByteEnd) { // yup! this is in fact necessary.
 				break
 			}
-			startIndex := utf8.RuneCountInString(tweet.Record.Text[:faucet.Index.ByteStart]) + textOffset
-			endIndex := utf8.RuneCountInString(tweet.Record.Text[:faucet.Index.ByteEnd]) + textOffset
+			startIndex := richtext.Index(tweet.Record.Text, faucet.Index.ByteStart, unit) + textOffset
+			endIndex := richtext.Index(tweet.Record.Text, faucet.Index.ByteEnd, unit) + textOffset
 			tweetEntities.Hashtags = append(tweetEntities.Hashtags, bridge.Hashtag{
 				Text: faucet.Features[0].Tag, // Shortcut url
 				Indices: []int{
@@ -848,6 +813,27 @@ func TranslatePostToTweet(tweet blueskyapi.Post, replyMsgBskyURI string, replyUs
 		}
 	}
 
+	// Link cards. The app lets you take the link out of the text once the card's there, so if it's not in the text, add it
+	if external := tweet.Record.Embed.External; external != nil && tweet.Record.Embed.Type == "app.bsky.embed.external" &&
+		!strings.HasPrefix(external.Uri, "https://media.tenor.com/") && isWebLink(external.Uri) && !hasLinkFacet(tweet.Record.Facets, external.Uri) {
+		linkURL, displayURL := external.Uri, linkDisplayText(external.Uri)
+		if configData.LinkURLText != "" {
+			if shortURL, err := wrapLink(external.Uri); err != nil {
+				log.Error("Error creating short link", "error", err)
+			} else {
+				linkURL = shortURL
+			}
+		}
+
+		if len(processedText) > 0 {
+			processedText += "\n"
+		}
+		startIndex := richtext.Length(processedText, unit)
+		processedText += linkURL
+		endIndex := startIndex + richtext.Length(linkURL, unit)
+		tweetEntities.Urls = append(tweetEntities.Urls, linkEntity(startIndex, endIndex, linkURL, displayURL, external.Uri))
+	}
+
 	// Videos.
 	// I am 99% sure twitter API 1.0 did not have proper video uploads, so we embed it as a link.
 
@@ -864,9 +850,9 @@ func TranslatePostToTweet(tweet blueskyapi.Post, replyMsgBskyURI string, replyUs
 			displayURL = strings.ReplaceAll(displayURL, "{fullblob}", video.Video.Ref.Link)
 			displayURL = strings.ReplaceAll(displayURL, "{user_did}", tweet.Author.DID)
 			if strings.Contains(displayURL, "{shortcode}") {
-				shortCode, err = CreateShortLink("/cdn/vid/bsky/"+tweet.Author.DID+"/"+video.Video.Ref.Link+"/", "v")
+				shortCode, err = CreateShortLink("/cdn/vid/bsky/"+tweet.Author.DID+"/"+video.Video.Ref.Link+"/", shortLinkVideo)
 				if err != nil {
-					fmt.Println("Error creating short link:", err)
+					log.Error("Error creating short link", "error", err)
 					displayURL = strings.ReplaceAll(displayURL, "{shortcode}", "")
 				} else {
 					displayURL = strings.ReplaceAll(displayURL, "{shortcode}", shortCode)
@@ -874,12 +860,12 @@ func TranslatePostToTweet(tweet blueskyapi.Post, replyMsgBskyURI string, replyUs
 			}
 
 			if len(processedText) == 0 {
-				endLen = utf8.RuneCountInString(displayURL)
+				endLen = richtext.Length(displayURL, unit)
 
 				processedText = displayURL
 			} else {
-				startLen = utf8.RuneCountInString(processedText) + 1
-				endLen = (utf8.RuneCountInString(processedText) + 1) + utf8.RuneCountInString(displayURL)
+				startLen = richtext.Length(processedText, unit) + 1
+				endLen = startLen + richtext.Length(displayURL, unit)
 
 				processedText = processedText + "\n" + displayURL
 			}
@@ -890,9 +876,9 @@ func TranslatePostToTweet(tweet blueskyapi.Post, replyMsgBskyURI string, replyUs
 			formattedVideoURL = strings.ReplaceAll(formattedVideoURL, "{user_did}", tweet.Author.DID)
 			if strings.Contains(formattedVideoURL, "{shortcode}") {
 				if shortCode == "" {
-					shortCode, err = CreateShortLink("/cdn/vid/bsky/"+tweet.Author.DID+"/"+video.Video.Ref.Link+"/", "v")
+					shortCode, err = CreateShortLink("/cdn/vid/bsky/"+tweet.Author.DID+"/"+video.Video.Ref.Link+"/", shortLinkVideo)
 					if err != nil {
-						fmt.Println("Error creating short link:", err)
+						log.Error("Error creating short link", "error", err)
 						formattedVideoURL = strings.ReplaceAll(formattedVideoURL, "{shortcode}", "")
 					} else {
 						formattedVideoURL = strings.ReplaceAll(formattedVideoURL, "{shortcode}", shortCode)
@@ -932,14 +918,32 @@ func TranslatePostToTweet(tweet blueskyapi.Post, replyMsgBskyURI string, replyUs
 
 	// Get the user info
 	var author *bridge.TwitterUser
-	author, err = blueskyapi.GetUserInfo(pds, token, tweet.Author.DID, false)
+	author, err = blueskyapi.GetUserInfo(ctx, pds, token, tweet.Author.DID, false)
 	if err != nil {
-		fmt.Println("Error:", err)
+		log.Error("GetUserInfo failed", "error", err)
 		// fallback
-		authorPtr := GetUserInfoFromTweetData(tweet)
+		authorPtr := GetUserInfoFromTweetData(ctx, tweet)
 		author = &authorPtr
 	}
 
+	// we have to use psudo ids because of https://github.com/bluesky-social/atproto/issues/1811
+	var tweetID int64
+	if isRetweet {
+		tweetID = *bridge.BskyMsgToTwitterID(ctx, tweet.URI, repostTime(ctx, pds, postReason), &postReason.By.DID)
+	} else {
+		tweetID = *bridge.BskyMsgToTwitterID(ctx, tweet.URI, &tweet.Record.CreatedAt.Time, nil)
+	}
+	var inReplyToID *int64
+	if replyMsgBskyURI != "" && replyUserBskyId != "" {
+		if replyTimeStamp == nil {
+			replyTimeStamp = recordCreatedAt(ctx, pds, replyMsgBskyURI)
+		}
+		// without the date, we'd make up an ID that doesn't point at anything
+		if replyTimeStamp != nil {
+			inReplyToID = bridge.BskyMsgToTwitterID(ctx, replyMsgBskyURI, replyTimeStamp, nil)
+		}
+	}
+
 	// final object conversion.
 	convertedTweet := bridge.Tweet{
 		Coordinates: nil,
@@ -950,26 +954,13 @@ func TranslatePostToTweet(tweet blueskyapi.Post, replyMsgBskyURI string, replyUs
 			}
 			return bridge.TwitterTimeConverter(tweet.Record.CreatedAt.Time)
 		}(),
-		Truncated:    false,
-		Text:         processedText,
-		Entities:     tweetEntities,
-		Annotations:  nil, // I am curious what annotations are
-		Contributors: nil,
-		ID: func() int64 {
-			// we have to use psudo ids because of https://github.com/bluesky-social/atproto/issues/1811
-			if isRetweet {
-				return *bridge.BskyMsgToTwitterID(tweet.URI, &postReason.IndexedAt, &postReason.By.DID)
-			}
-			return *bridge.BskyMsgToTwitterID(tweet.URI, &tweet.Record.CreatedAt.Time, nil)
-		}(),
-		IDStr: func() string {
-			if isRetweet {
-				id := bridge.BskyMsgToTwitterID(tweet.URI, &postReason.IndexedAt, &postReason.By.DID)
-				return strconv.FormatInt(*id, 10)
-			}
-			id := bridge.BskyMsgToTwitterID(tweet.URI, &tweet.Record.CreatedAt.Time, nil)
-			return strconv.FormatInt(*id, 10)
-		}(),
+		Truncated:         false,
+		Text:              processedText,
+		Entities:          tweetEntities,
+		Annotations:       nil, // I am curious what annotations are
+		Contributors:      nil,
+		ID:                tweetID,
+		IDStr:             strconv.FormatInt(tweetID, 10),
 		Geo:               nil,
 		Place:             nil,
 		PossiblySensitive: false,
@@ -978,14 +969,14 @@ func TranslatePostToTweet(tweet blueskyapi.Post, replyMsgBskyURI string, replyUs
 				return nil
 			}
 
-			id := bridge.BlueSkyToTwitterID(replyUserBskyId)
+			id := bridge.BlueSkyToTwitterID(ctx, replyUserBskyId)
 			return id
 		}(),
 		InReplyToUserIDStr: func() *string {
 			if replyMsgBskyURI == "" || replyUserBskyId == "" {
 				return nil
 			}
-			id := bridge.BlueSkyToTwitterID(replyUserBskyId)
+			id := bridge.BlueSkyToTwitterID(ctx, replyUserBskyId)
 			idStr := strconv.FormatInt(*id, 10)
 			return &idStr
 		}(),
@@ -999,19 +990,12 @@ func TranslatePostToTweet(tweet blueskyapi.Post, replyMsgBskyURI string, replyUs
 				return "Bluesky"
 			}
 		}(),
-		InReplyToStatusID: func() *int64 {
-			if replyMsgBskyURI == "" || replyUserBskyId == "" {
-				return nil
-			}
-			id := bridge.BskyMsgToTwitterID(replyMsgBskyURI, replyTimeStamp, nil)
-			return id
-		}(),
+		InReplyToStatusID: inReplyToID,
 		InReplyToStatusIDStr: func() *string {
-			if replyMsgBskyURI == "" || replyUserBskyId == "" {
+			if inReplyToID == nil {
 				return nil
 			}
-			id := bridge.BskyMsgToTwitterID(replyMsgBskyURI, replyTimeStamp, nil)
-			idStr := strconv.FormatInt(*id, 10)
+			idStr := strconv.FormatInt(*inReplyToID, 10)
 			return &idStr
 		}(),
 		Retweeted:    tweet.Viewer.Repost != nil && !isRetweet,
@@ -1021,7 +1005,7 @@ func TranslatePostToTweet(tweet blueskyapi.Post, replyMsgBskyURI string, replyUs
 				retweet_bsky := tweet
 				retweet_bsky.Author = bsky_retweet_og_author
 				//retweet_bsky.Viewer.Repost = nil
-				translatedTweet := TranslatePostToTweet(retweet_bsky, replyMsgBskyURI, replyUserBskyId, replyUserHandle, replyTimeStamp, nil, token, pds)
+				translatedTweet := TranslatePostToTweet(ctx, retweet_bsky, replyMsgBskyURI, replyUserBskyId, replyUserHandle, replyTimeStamp, nil, token, pds)
 				translatedTweet.CurrentUserRetweet = nil
 				return &bridge.RetweetedTweet{
 					Tweet: translatedTweet, // Oh how i love XML
@@ -1032,14 +1016,14 @@ func TranslatePostToTweet(tweet blueskyapi.Post, replyMsgBskyURI string, replyUs
 		//TODO: If the user has retweeted, and this tweet itself is a retweet, we can't have current_user_retweet at the same time as retweeted_status
 		CurrentUserRetweet: func() *bridge.CurrentUserRetweet {
 			if tweet.Viewer.Repost != nil && !isRetweet {
-				RepostRecord, err := blueskyapi.GetRecordWithUri(pds, *tweet.Viewer.Repost)
+				RepostRecord, err := blueskyapi.GetRecordWithUri(ctx, pds, *tweet.Viewer.Repost)
 				if err != nil {
-					fmt.Println("Error:", err)
+					log.Error("GetRecordWithUri failed", "error", err)
 					return nil
 				}
 
 				_, my_did, _ := blueskyapi.GetURIComponents(*tweet.Viewer.Repost)
-				retweetId := bridge.BskyMsgToTwitterID(tweet.URI, &RepostRecord.Value.CreatedAt.Time, &my_did)
+				retweetId := bridge.BskyMsgToTwitterID(ctx, tweet.URI, &RepostRecord.Value.CreatedAt.Time, &my_did)
 				return &bridge.CurrentUserRetweet{
 					ID:    *retweetId,
 					IDStr: strconv.FormatInt(*retweetId, 10),
@@ -1051,9 +1035,34 @@ func TranslatePostToTweet(tweet blueskyapi.Post, replyMsgBskyURI string, replyUs
 	return convertedTweet
 }
 
+// repostTime is when a repost was made, from its record. Retweet IDs are made from it, and everywhere that makes one
+// (the retweet response, timelines, current_user_retweet) has to use the same time, or they get different IDs.
+func repostTime(ctx context.Context, pds string, reason *blueskyapi.PostReason) *time.Time {
+	if reason.URI != "" {
+		if createdAt := recordCreatedAt(ctx, pds, reason.URI); createdAt != nil {
+			return createdAt
+		}
+	}
+	// best we can do, this won't match the ID from anywhere else
+	return &reason.IndexedAt
+}
+
+// recordCreatedAt gets the createdAt out of a record, nil if we can't.
+func recordCreatedAt(ctx context.Context, pds string, uri string) *time.Time {
+	record, err := blueskyapi.GetRecordWithUri(ctx, pds, uri)
+	if err != nil {
+		log.WarnContext(ctx, "GetRecordWithUri failed", "uri", uri, "error", err)
+		return nil
+	}
+	if record.Value.CreatedAt.Time.IsZero() {
+		return nil
+	}
+	return &record.Value.CreatedAt.Time
+}
+
 // This is "depercated"/a togglable option in the config (eventually)
 // Primarly used as a fallback if we cannot lookup user info
-func GetUserInfoFromTweetData(tweet blueskyapi.Post) bridge.TwitterUser {
+func GetUserInfoFromTweetData(ctx context.Context, tweet blueskyapi.Post) bridge.TwitterUser {
 	return bridge.TwitterUser{
 		Name: func() string {
 			if tweet.Author.DisplayName == "" {
@@ -1075,8 +1084,8 @@ func GetUserInfoFromTweetData(tweet blueskyapi.Post) bridge.TwitterUser {
 		ContributorsEnabled:       false,
 		UtcOffset:                 nil,
 		IsTranslator:              false,
-		ID:                        *bridge.BlueSkyToTwitterID(tweet.URI),
-		IDStr:                     strconv.FormatInt(*bridge.BlueSkyToTwitterID(tweet.URI), 10),
+		ID:                        *bridge.BlueSkyToTwitterID(ctx, tweet.URI),
+		IDStr:                     strconv.FormatInt(*bridge.BlueSkyToTwitterID(ctx, tweet.URI), 10),
 		ProfileUseBackgroundImage: false,
 		ProfileTextColor:          "333333",
 		Protected:                 false,
@@ -1125,22 +1134,22 @@ func TweetInfo(c *fiber.Ctx) error {
 	}
 	id := *idPtr
 
-	thread, err := blueskyapi.GetPost(*pds, *oauthToken, id, 1, 0)
+	thread, err := blueskyapi.GetPost(c.UserContext(), *pds, *oauthToken, id, 1, 0)
 
 	if err != nil {
-		return HandleBlueskyError(c, err.Error(), "app.bsky.feed.getPostThread", TweetInfo)
+		return HandleBlueskyError(c, err, "app.bsky.feed.getPostThread", TweetInfo)
 	}
 
-	likes, err := blueskyapi.GetPostLikes(*pds, *oauthToken, id, 100)
+	likes, err := blueskyapi.GetPostLikes(c.UserContext(), *pds, *oauthToken, id, 100)
 
 	if err != nil {
-		return HandleBlueskyError(c, err.Error(), "app.bsky.feed.getLikes", TweetInfo)
+		return HandleBlueskyError(c, err, "app.bsky.feed.getLikes", TweetInfo)
 	}
 
-	reposters, err := blueskyapi.GetRetweetAuthors(*pds, *oauthToken, id, 100)
+	reposters, err := blueskyapi.GetRetweetAuthors(c.UserContext(), *pds, *oauthToken, id, 100)
 
 	if err != nil {
-		return HandleBlueskyError(c, err.Error(), "app.bsky.feed.getRepostedBy", TweetInfo)
+		return HandleBlueskyError(c, err, "app.bsky.feed.getRepostedBy", TweetInfo)
 	}
 
 	repliers := []int64{}
@@ -1148,13 +1157,13 @@ func TweetInfo(c *fiber.Ctx) error {
 	retweeters := []int64{}
 
 	for _, reply := range *thread.Thread.Replies {
-		repliers = append(repliers, *bridge.BlueSkyToTwitterID(reply.Post.Author.DID))
+		repliers = append(repliers, *bridge.BlueSkyToTwitterID(c.UserContext(), reply.Post.Author.DID))
 	}
 	for _, like := range likes.Likes {
-		favourites = append(favourites, *bridge.BlueSkyToTwitterID(like.Actor.DID))
+		favourites = append(favourites, *bridge.BlueSkyToTwitterID(c.UserContext(), like.Actor.DID))
 	}
 	for _, reposter := range reposters.RepostedBy {
-		retweeters = append(retweeters, *bridge.BlueSkyToTwitterID(reposter.DID))
+		retweeters = append(retweeters, *bridge.BlueSkyToTwitterID(c.UserContext(), reposter.DID))
 	}
 
 	return EncodeAndSend(c, bridge.TwitterActivitiySummary{
@@ -1182,22 +1191,12 @@ func mentions_timeline(c *fiber.Ctx) error {
 	if max_id != "" {
 		// Get the timeline context from the DB
 		maxIDInt, err := strconv.ParseInt(max_id, 10, 64)
-		fmt.Println("Max ID:", maxIDInt)
 		if err != nil {
 			return ReturnError(c, "Invalid max_id format", 195, fiber.StatusForbidden)
 		}
-		_, date, _, err := bridge.TwitterMsgIdToBluesky(&maxIDInt)
+		date, err := bridge.TwitterMsgIdToTime(maxIDInt)
 		if err != nil {
-			tempId := maxIDInt - 1
-			_, date, _, err = bridge.TwitterMsgIdToBluesky(&tempId)
-			if err != nil {
-				tempId = maxIDInt + 1
-				_, date, _, err = bridge.TwitterMsgIdToBluesky(&tempId)
-				if err != nil {
-					return ReturnError(c, "max_id was not found", 144, fiber.StatusForbidden)
-
-				}
-			}
+			return ReturnError(c, "max_id was not found", 144, fiber.StatusForbidden)
 		}
 		context = date.Format(time.RFC3339)
 	}
@@ -1213,19 +1212,9 @@ func mentions_timeline(c *fiber.Ctx) error {
 		if err != nil {
 			return ReturnError(c, "Invalid since_id format", 195, fiber.StatusForbidden)
 		}
-		_, tempDate, _, err := bridge.TwitterMsgIdToBluesky(&sinceIdInt)
+		tempDate, err := bridge.TwitterMsgIdToTime(sinceIdInt)
 		if err != nil {
-			// the offical twitter docs recommended you add one to the id. Because of... technical debt, our IDs do not support this, and thus this must be done
-			tempId := sinceIdInt - 1
-			_, tempDate, _, err = bridge.TwitterMsgIdToBluesky(&tempId)
-			if err != nil {
-				tempId = sinceIdInt + 1
-				_, tempDate, _, err = bridge.TwitterMsgIdToBluesky(&tempId)
-				if err != nil {
-					return ReturnError(c, "since_id was not found", 144, fiber.StatusForbidden)
-				}
-			}
-
+			return ReturnError(c, "since_id was not found", 144, fiber.StatusForbidden)
 		}
 		since_date = *tempDate
 		hasSinceDate = true
@@ -1243,9 +1232,9 @@ func mentions_timeline(c *fiber.Ctx) error {
 	}
 
 	// Get notifications
-	bskyNotifications, err := blueskyapi.GetMentions(*pds, *oauthToken, count, context)
+	bskyNotifications, err := blueskyapi.GetMentions(c.UserContext(), *pds, *oauthToken, count, context)
 	if err != nil {
-		return HandleBlueskyError(c, err.Error(), "app.bsky.notification.listNotifications", mentions_timeline)
+		return HandleBlueskyError(c, err, "app.bsky.notification.listNotifications", mentions_timeline)
 	}
 
 	// Track unique users and posts
@@ -1281,7 +1270,7 @@ func mentions_timeline(c *fiber.Ctx) error {
 	for _, chunk := range userChunks {
 		go func() {
 			defer wg.Done()
-			blueskyapi.GetUsersInfo(*pds, *oauthToken, chunk, false)
+			blueskyapi.GetUsersInfo(c.UserContext(), *pds, *oauthToken, chunk, false)
 		}()
 	}
 
@@ -1295,13 +1284,13 @@ func mentions_timeline(c *fiber.Ctx) error {
 			go func(postID string) {
 				defer wgPosts.Done()
 
-				if post, err := blueskyapi.GetPost(*pds, *oauthToken, postID, 0, 1); err == nil {
+				if post, err := blueskyapi.GetPost(c.UserContext(), *pds, *oauthToken, postID, 0, 1); err == nil {
 					if hasSinceDate {
 						if !post.Thread.Post.IndexedAt.After(since_date) {
 							return
 						}
 					}
-					tweet := TranslatePostToTweet(
+					tweet := TranslatePostToTweet(c.UserContext(),
 						post.Thread.Post,
 						func() string {
 							if post.Thread.Parent != nil {
@@ -0,0 +1,104 @@
+package twitterv1
+
+import (
+	"context"
+	"errors"
+
+	blueskyapi "github.com/Preloading/TwitterAPIBridge/bluesky"
+	"github.com/Preloading/TwitterAPIBridge/bridge"
+	"github.com/Preloading/TwitterAPIBridge/db_controller"
+	"github.com/Preloading/TwitterAPIBridge/richtext"
+	"github.com/gofiber/fiber/v2"
+)
+
+// errStatusTooLong is when a status doesn't fit in a post, and the user doesn't want it split up.
+var errStatusTooLong = errors.New("status is too long for one post")
+
+// postStatus posts status, working out the mentions, links & tags in it. If it's too long for one post, it's split
+// into a thread (each post replying to the last) if the user wants that, or errStatusTooLong if they don't.
+// The image goes on the first post, and the first post is what it gives back, that's the tweet the client made.
+func postStatus(ctx context.Context, pds string, token string, did string, status string, inReplyTo *string, imageBlob *blueskyapi.Blob, imageRes []int) (*blueskyapi.ThreadRoot, error) {
+	status, shortMentions := ResolveShortMentions(ctx, pds, token, did, status)
+
+	chunks := []richtext.Chunk{{Start: 0, End: len(status)}}
+	if !richtext.FitsInPost(status) {
+		settings, err := db_controller.GetUserSettings(did)
+		if err != nil {
+			log.WarnContext(ctx, "GetUserSettings failed, using the default for splitting", "error", err)
+		}
+		if !shouldSplitLongTweets(settings) {
+			return nil, errStatusTooLong
+		}
+		chunks = richtext.Split(status)
+	}
+
+	var first *blueskyapi.ThreadRoot
+	triedLinkCard := false
+	for i, chunk := range chunks {
+		text := status[chunk.Start:chunk.End]
+
+		// everything's found again in each part, so the offsets are right for it. Short mentions were already
+		// worked out for the whole thing, so they just get moved over (splitting never cuts one in half)
+		mentions := richtext.FindMentions(text)
+		for _, mention := range shortMentions {
+			if mention.Start >= chunk.Start && mention.End <= chunk.End {
+				mentions = append(mentions, bridge.FacetParsing{Start: mention.Start - chunk.Start, End: mention.End - chunk.Start, Item: mention.Item})
+			}
+		}
+
+		blob, res := imageBlob, imageRes
+		if i > 0 {
+			blob, res = nil, []int{}
+		}
+
+		// the first link gets a card, on whichever post it ends up in (if that post doesn't have the image)
+		links := richtext.FindURLs(text)
+		var card *blueskyapi.ExternalImage
+		if len(links) > 0 && !triedLinkCard {
+			triedLinkCard = true
+			if blob == nil {
+				card = makeLinkCard(ctx, pds, token, links[0].Item)
+			}
+		}
+
+		thread, err := blueskyapi.UpdateStatus(ctx, pds, token, did, text, inReplyTo, mentions, links, richtext.FindTags(text), blob, res, card)
+		if err != nil {
+			if first == nil {
+				return nil, err
+			}
+			// the first post is already up, if we failed the whole thing the client would just post it again
+			log.ErrorContext(ctx, "Posting the rest of a split status failed", "error", err, "part", i+1, "parts", len(chunks))
+			break
+		}
+		rememberPostInteractions(did, thread, mentions)
+
+		if first == nil {
+			first = thread
+		}
+		inReplyTo = &thread.Thread.Post.URI
+	}
+	return first, nil
+}
+
+// postedTweet is the tweet to give back after posting, the first post of what postStatus posted.
+func postedTweet(ctx context.Context, thread *blueskyapi.ThreadRoot, token string, pds string) bridge.Tweet {
+	if thread.Thread.Parent == nil {
+		return TranslatePostToTweet(ctx, thread.Thread.Post, "", "", "", nil, nil, token, pds)
+	}
+	parent := thread.Thread.Parent.Post
+	return TranslatePostToTweet(ctx, thread.Thread.Post, parent.URI, parent.Author.DID, parent.Author.Handle, &parent.Record.CreatedAt.Time, nil, token, pds)
+}
+
+// answerDuplicate is the response to a status that was already posted as uri. The client probably gave up waiting the first time,
+// so it gets the tweet it already made, unless DUPLICATE_STATUS_ERROR wants twitter's error.
+func answerDuplicate(c *fiber.Ctx, uri string, token string, pds string) error {
+	if configData.DuplicateStatusError {
+		return ReturnError(c, "Status is a duplicate.", 187, fiber.StatusForbidden)
+	}
+	thread, err := blueskyapi.GetPost(c.UserContext(), pds, token, uri, 0, 1)
+	if err != nil {
+		log.ErrorContext(c.UserContext(), "GetPost failed", "error", err)
+		return ReturnError(c, "Status is a duplicate.", 187, fiber.StatusForbidden)
+	}
+	return EncodeAndSend(c, postedTweet(c.UserContext(), thread, token, pds))
+}
@@ -0,0 +1,278 @@
+package twitterv1_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Preloading/TwitterAPIBridge/fakepds"
+	"github.com/Preloading/TwitterAPIBridge/twitterv1"
+)
+
+// createdPost is a post the bridge made on the fake PDS.
+type createdPost struct {
+	Repo   string `json:"repo"`
+	Record struct {
+		Text   string `json:"text"`
+		Facets []struct {
+			Index struct {
+				ByteStart int `json:"byteStart"`
+				ByteEnd   int `json:"byteEnd"`
+			} `json:"index"`
+			Features []struct {
+				Type string `json:"$type"`
+				Did  string `json:"did"`
+			} `json:"features"`
+		} `json:"facets"`
+		Reply *struct {
+			Root   struct{ URI string } `json:"root"`
+			Parent struct{ URI string } `json:"parent"`
+		} `json:"reply"`
+		Embed json.RawMessage `json:"embed"`
+	} `json:"record"`
+}
+
+// mentions is what the post's mention facets cover, and who they're for.
+func (p createdPost) mentions() map[string]string {
+	mentions := map[string]string{}
+	for _, facet := range p.Record.Facets {
+		for _, feature := range facet.Features {
+			if feature.Type == "app.bsky.richtext.facet#mention" {
+				mentions[p.Record.Text[facet.Index.ByteStart:facet.Index.ByteEnd]] = feature.Did
+			}
+		}
+	}
+	return mentions
+}
+
+// createdPosts is every post the bridge has made so far, oldest first.
+func createdPosts(t *testing.T, h *fakepds.Harness) []createdPost {
+	t.Helper()
+	posts := []createdPost{}
+	for _, request := range h.PDS.Requests() {
+		if request.Method != "com.atproto.repo.createRecord" {
+			continue
+		}
+		var post createdPost
+		if err := json.Unmarshal(request.Body, &post); err != nil {
+			t.Fatal(err)
+		}
+		var collection struct {
+			Collection string `json:"collection"`
+		}
+		json.Unmarshal(request.Body, &collection)
+		if collection.Collection == "app.bsky.feed.post" {
+			posts = append(posts, post)
+		}
+	}
+	return posts
+}
+
+// tweet is the bits of a tweet the posting tests look at.
+type tweet struct {
+	ID                int64  `json:"id"`
+	Text              string `json:"text"`
+	InReplyToStatusID *int64 `json:"in_reply_to_status_id"`
+}
+
+// errorCode is the twitter error code in a response.
+func errorCode(t *testing.T, body []byte) int {
+	t.Helper()
+	var errors struct {
+		Errors []struct {
+			Code int `json:"code"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &errors); err != nil || len(errors.Errors) == 0 {
+		t.Fatalf("not an error: %s", body)
+	}
+	return errors.Errors[0].Code
+}
+
+// longStatus is a status that takes about three posts. It's different every time, so it's never a duplicate.
+func longStatus() string {
+	words := []string{fmt.Sprint(time.Now().UnixNano())}
+	for i := 0; len(strings.Join(words, " ")) < 700; i++ {
+		words = append(words, fmt.Sprintf("word%d", i))
+	}
+	return strings.Join(words, " ")
+}
+
+func useSplitting(h *fakepds.Harness, split bool) {
+	h.Config.SplitLongTweets = split
+	h.App = twitterv1.NewApp(h.Config)
+}
+
+// checkThread checks that posts are status split up, and that the newest tweets on the timeline are them, each replying to
+// the one before. first is the tweet the client got back.
+func checkThread(t *testing.T, h *fakepds.Harness, auth string, status string, first tweet, posts []createdPost) {
+	t.Helper()
+	if len(posts) < 2 {
+		t.Fatalf("posted %d posts", len(posts))
+	}
+	texts := []string{}
+	for i, post := range posts {
+		if utf8.RuneCountInString(post.Record.Text) > 300 {
+			t.Errorf("post %d is %d characters", i, utf8.RuneCountInString(post.Record.Text))
+		}
+		texts = append(texts, post.Record.Text)
+	}
+	if strings.Join(strings.Fields(strings.Join(texts, " ")), " ") != status {
+		t.Errorf("the posts don't add up to the status: %q", texts)
+	}
+
+	var timeline []tweet
+	h.Call(t, http.MethodGet, "/1/statuses/user_timeline.json", url.Values{"screen_name": {"bob.test"}, "count": {"20"}}, auth, &timeline)
+	if len(timeline) < len(posts) {
+		t.Fatalf("%d tweets on the timeline", len(timeline))
+	}
+	thread := timeline[:len(posts)] // newest first
+	for i := 0; i < len(thread)-1; i++ {
+		if thread[i].InReplyToStatusID == nil || *thread[i].InReplyToStatusID != thread[i+1].ID {
+			t.Errorf("%q doesn't reply to %q", thread[i].Text, thread[i+1].Text)
+		}
+	}
+	// (the text has the image link on the end, if there's an image)
+	if oldest := thread[len(thread)-1]; oldest.ID != first.ID || !strings.HasPrefix(oldest.Text, posts[0].Record.Text) {
+		t.Errorf("got back %d %q, the first post is %d %q", first.ID, first.Text, oldest.ID, oldest.Text)
+	}
+}
+
+// Too long, and nobody asked for it to be split up.
+func TestLongStatusNotSplit(t *testing.T) {
+	h := fakepds.NewTestHarness(t, nil)
+	bob := h.MustLogin(t, "bob.test", "dev_bob-pass")
+
+	resp, body, err := h.Request(http.MethodPost, "/1/statuses/update.json", url.Values{"status": {longStatus()}}, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusForbidden || errorCode(t, body) != 186 {
+		t.Errorf("got %d: %s", resp.StatusCode, body)
+	}
+	if posts := createdPosts(t, h); len(posts) != 0 {
+		t.Errorf("posted %d posts", len(posts))
+	}
+}
+
+func TestLongStatusSplit(t *testing.T) {
+	h := fakepds.NewTestHarness(t, nil)
+	bob := h.MustLogin(t, "bob.test", "dev_bob-pass")
+	useSplitting(h, true)
+
+	status := longStatus()
+	var first tweet
+	h.Call(t, http.MethodPost, "/1/statuses/update.json", url.Values{"status": {status}}, bob, &first)
+	posts := createdPosts(t, h)
+	if len(posts) != 3 {
+		t.Errorf("split into %d posts, want 3", len(posts))
+	}
+	if posts[0].Record.Reply != nil {
+		t.Error("the first post is a reply")
+	}
+	checkThread(t, h, bob, status, first, posts)
+}
+
+// pngUpload is a multipart update_with_media request.
+func pngUpload(t *testing.T, status string, auth string) *http.Request {
+	t.Helper()
+	var picture bytes.Buffer
+	if err := png.Encode(&picture, image.NewRGBA(image.Rect(0, 0, 4, 3))); err != nil {
+		t.Fatal(err)
+	}
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("status", status)
+	media, _ := form.CreateFormFile("media[]", "image.png")
+	media.Write(picture.Bytes())
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/1/statuses/update_with_media.json", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("Authorization", auth)
+	return req
+}
+
+func TestLongStatusWithMediaSplit(t *testing.T) {
+	h := fakepds.NewTestHarness(t, nil)
+	bob := h.MustLogin(t, "bob.test", "dev_bob-pass")
+	useSplitting(h, true)
+
+	status := longStatus()
+	resp, err := h.App.Test(pngUpload(t, status, bob), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var first tweet
+	if err := json.NewDecoder(resp.Body).Decode(&first); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("got %d (%v)", resp.StatusCode, err)
+	}
+
+	posts := createdPosts(t, h)
+	checkThread(t, h, bob, status, first, posts)
+	for i, post := range posts {
+		hasImage := strings.Contains(string(post.Record.Embed), "app.bsky.embed.images")
+		if hasImage != (i == 0) {
+			t.Errorf("post %d has the image: %v", i, hasImage)
+		}
+	}
+}
+
+// split_long_tweets in someone's settings wins over SPLIT_LONG_TWEETS, either way.
+func TestSplitLongTweetsSetting(t *testing.T) {
+	for _, serverDefault := range []bool{false, true} {
+		t.Run(fmt.Sprint("SPLIT_LONG_TWEETS=", serverDefault), func(t *testing.T) {
+			h := fakepds.NewTestHarness(t, nil)
+			bob := h.MustLogin(t, "bob.test", "dev_bob-pass")
+			useSplitting(h, serverDefault)
+			h.Call(t, http.MethodPost, "/1/account/settings.json", url.Values{"split_long_tweets": {fmt.Sprint(!serverDefault)}}, bob, nil)
+
+			resp, body, err := h.Request(http.MethodPost, "/1/statuses/update.json", url.Values{"status": {longStatus()}}, bob)
+			if err != nil {
+				t.Fatal(err)
+			}
+			posts := createdPosts(t, h)
+			if serverDefault {
+				if resp.StatusCode != http.StatusForbidden || errorCode(t, body) != 186 || len(posts) != 0 {
+					t.Errorf("bob doesn't want it split, got %d and %d posts: %s", resp.StatusCode, len(posts), body)
+				}
+			} else if resp.StatusCode != http.StatusOK || len(posts) != 3 {
+				t.Errorf("bob wants it split, got %d and %d posts: %s", resp.StatusCode, len(posts), body)
+			}
+		})
+	}
+}
+
+// The first post is up, so failing would just make the client post it again.
+func TestLongStatusLaterPartFails(t *testing.T) {
+	h := fakepds.NewTestHarness(t, nil)
+	bob := h.MustLogin(t, "bob.test", "dev_bob-pass")
+	useSplitting(h, true)
+	h.PDS.FailCall("com.atproto.repo.createRecord", 1)
+
+	var first tweet
+	h.Call(t, http.MethodPost, "/1/statuses/update.json", url.Values{"status": {longStatus()}}, bob, &first)
+	posts := createdPosts(t, h)
+	if len(posts) != 2 {
+		t.Fatalf("tried %d posts, want it to stop after the one that failed", len(posts))
+	}
+	if first.Text != posts[0].Record.Text {
+		t.Errorf("got back %q, the first post is %q", first.Text, posts[0].Record.Text)
+	}
+	var timeline []tweet
+	h.Call(t, http.MethodGet, "/1/statuses/user_timeline.json", url.Values{"screen_name": {"bob.test"}}, bob, &timeline)
+	if len(timeline) == 0 || timeline[0].ID != first.ID {
+		t.Errorf("the first post isn't the newest on the timeline")
+	}
+}
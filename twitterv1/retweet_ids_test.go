@@ -0,0 +1,113 @@
+package twitterv1_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	blueskyapi "github.com/Preloading/TwitterAPIBridge/bluesky"
+	"github.com/Preloading/TwitterAPIBridge/bridge"
+	"github.com/Preloading/TwitterAPIBridge/fakepds"
+	"github.com/Preloading/TwitterAPIBridge/twitterv1"
+)
+
+type retweetStatus struct {
+	ID                int64  `json:"id"`
+	Text              string `json:"text"`
+	InReplyToStatusID *int64 `json:"in_reply_to_status_id"`
+	RetweetedStatus   *struct {
+		ID int64 `json:"id"`
+	} `json:"retweeted_status"`
+	CurrentUserRetweet *struct {
+		ID int64 `json:"id"`
+	} `json:"current_user_retweet"`
+}
+
+// retweets is the IDs of the retweets in a timeline.
+func retweets(timeline []retweetStatus) []int64 {
+	ids := []int64{}
+	for _, status := range timeline {
+		if status.RetweetedStatus != nil {
+			ids = append(ids, status.ID)
+		}
+	}
+	return ids
+}
+
+// A retweet has to get the same ID everywhere it shows up, or clients can't match them up (ex. to undo one).
+func TestRetweetIDsMatch(t *testing.T) {
+	h := fakepds.NewTestHarness(t, nil)
+	alice := h.MustLogin(t, "alice.test", "dev_alice-pass")
+	bob := h.MustLogin(t, "bob.test", "dev_bob-pass")
+
+	// alice's repost of carol's post, from the fixtures. The fake appview gives it a new indexedAt every time.
+	var first, second []retweetStatus
+	h.Call(t, http.MethodGet, "/1/statuses/home_timeline.json", nil, alice, &first)
+	h.Call(t, http.MethodGet, "/1/statuses/home_timeline.json", nil, alice, &second)
+	if len(retweets(first)) != 1 {
+		t.Fatalf("expected 1 retweet in alice's timeline, got %v", retweets(first))
+	}
+	if retweets(first)[0] != retweets(second)[0] {
+		t.Errorf("the same retweet got IDs %d and %d", retweets(first)[0], retweets(second)[0])
+	}
+	repostCreatedAt := time.Date(2024, 11, 8, 12, 0, 0, 0, time.UTC)
+	if got := bridge.SnowflakeTime(retweets(first)[0]); !got.Equal(repostCreatedAt) {
+		t.Errorf("retweet ID has time %s, the repost was made %s", got, repostCreatedAt)
+	}
+
+	// bob retweets alice's post, then sees it in his timeline and on the post
+	var alicePosts []retweetStatus
+	h.Call(t, http.MethodGet, "/1/statuses/user_timeline.json", url.Values{"screen_name": {"alice.test"}}, bob, &alicePosts)
+	var helloID int64
+	for _, status := range alicePosts {
+		if status.Text == "Hello world 👋" {
+			helloID = status.ID
+		}
+	}
+	if helloID == 0 {
+		t.Fatal("couldn't find alice's post")
+	}
+
+	var retweet retweetStatus
+	h.Call(t, http.MethodPost, "/1/statuses/retweet/"+strconv.FormatInt(helloID, 10)+".json", nil, bob, &retweet)
+	if retweet.ID == 0 || retweet.ID == helloID {
+		t.Fatalf("retweet got ID %d", retweet.ID)
+	}
+
+	var bobPosts []retweetStatus
+	h.Call(t, http.MethodGet, "/1/statuses/user_timeline.json", url.Values{"screen_name": {"bob.test"}}, bob, &bobPosts)
+	if ids := retweets(bobPosts); len(ids) != 1 || ids[0] != retweet.ID {
+		t.Errorf("bob's timeline has retweets %v, the retweet response said %d", ids, retweet.ID)
+	}
+
+	var hello retweetStatus
+	h.Call(t, http.MethodGet, "/1/statuses/show/"+strconv.FormatInt(helloID, 10)+".json", nil, bob, &hello)
+	if hello.CurrentUserRetweet == nil || hello.CurrentUserRetweet.ID != retweet.ID {
+		t.Errorf("current_user_retweet is %+v, the retweet response said %d", hello.CurrentUserRetweet, retweet.ID)
+	}
+}
+
+// Without the parent's date, in_reply_to_status_id has to come from its record, not be made up from the epoch.
+func TestReplyIDWithoutParentDate(t *testing.T) {
+	h := fakepds.NewTestHarness(t, nil)
+	ctx := context.Background()
+
+	parentURI := "at://did:plc:alice/app.bsky.feed.post/3kalice002"
+	thread, err := blueskyapi.GetPost(ctx, h.PDS.URL, "", "at://did:plc:bob/app.bsky.feed.post/3kbob00002", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tweet := twitterv1.TranslatePostToTweet(ctx, thread.Thread.Post, parentURI, "did:plc:alice", "alice.test", nil, nil, "", h.PDS.URL)
+
+	parentCreatedAt := time.Date(2024, 11, 3, 12, 0, 0, 0, time.UTC)
+	want := bridge.BskyMsgToTwitterID(context.Background(), parentURI, &parentCreatedAt, nil)
+	if tweet.InReplyToStatusID == nil || *tweet.InReplyToStatusID != *want {
+		t.Errorf("in_reply_to_status_id is %v, want %d", tweet.InReplyToStatusID, *want)
+	}
+	if tweet.InReplyToStatusIDStr == nil || *tweet.InReplyToStatusIDStr != strconv.FormatInt(*want, 10) {
+		t.Errorf("in_reply_to_status_id_str is %v, want %d", tweet.InReplyToStatusIDStr, *want)
+	}
+}
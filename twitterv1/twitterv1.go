@@ -2,18 +2,23 @@ package twitterv1
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"encoding/xml"
 	"errors"
 	"fmt"
+	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	blueskyapi "github.com/Preloading/TwitterAPIBridge/bluesky"
 	"github.com/Preloading/TwitterAPIBridge/bridge"
 	"github.com/Preloading/TwitterAPIBridge/config"
+	"github.com/Preloading/TwitterAPIBridge/richtext"
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/template/html/v2"
 )
 
@@ -22,8 +27,38 @@ var (
 )
 
 func InitServer(config *config.Config) {
+	app := NewApp(config)
+
+	if config.MetricsListenAddress != "" {
+		go serveMetrics(config.MetricsListenAddress)
+	}
+
+	go cleanupTempTokens()
+
+	// Stop taking requests on ctrl+c/docker stop, so main can write out what it's holding before we exit.
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		log.Info("Shutting down...")
+		if err := app.ShutdownWithTimeout(10 * time.Second); err != nil {
+			log.Error("Error shutting down", "error", err)
+		}
+	}()
+
+	if err := app.Listen(fmt.Sprintf(":%d", config.ServerPort)); err != nil {
+		log.Error("Error running server", "error", err)
+	}
+}
+
+// NewApp sets up the app with every route, without starting anything. (InitServer does that)
+// blueskyapi.InitConfig should've been called first.
+func NewApp(config *config.Config) *fiber.App {
 	configData = config
-	blueskyapi.InitConfig(configData)
+	setupLinkCards()
+	setupCDNCache()
+	recentStatuses = newStatusDeduper() // a new app doesn't remember what the last one posted (ex. in tests)
+	cache = newURLCache(urlCacheSize)   // or the short codes it made, they might be in a different database
 	engine := html.New("./static", ".html")
 	app := fiber.New(fiber.Config{
 		//DisablePreParseMultipartForm: true,
@@ -36,20 +71,38 @@ func InitServer(config *config.Config) {
 		Views: engine,
 	})
 
-	// Initialize default config
-	app.Use(logger.New())
-
-	// Custom middleware to log request details
-	if config.DeveloperMode {
-		app.Use(func(c *fiber.Ctx) error {
-			// fmt.Println("Request Method:", c.Method())
-			fmt.Println("Request URL:", c.OriginalURL())
-			// fmt.Println("Post Body:", string(c.Body()))
-			// fmt.Println("Headers:", string(c.Request().Header.Header()))
-			// fmt.Println()
-			return c.Next()
-		})
-	}
+	app.Use(RequestID)
+	app.Use(AccessLog)
+	app.Use(MetricsMiddleware)
+
+	// Everything we ask bluesky for during a request shares the request's deadline,
+	// and remembers if bluesky rate limited us, so we can tell the client when to come back.
+	app.Use(func(c *fiber.Ctx) error {
+		ctx := blueskyapi.TrackRateLimits(c.UserContext())
+		ctx = richtext.WithUnit(ctx, indexUnit(c))
+		if configData.RequestTimeoutSeconds > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, time.Duration(configData.RequestTimeoutSeconds)*time.Second)
+			defer cancel()
+		}
+		c.SetUserContext(ctx)
+		return c.Next()
+	})
+
+	// IDs this request hands out get written to the DB in one batch at the end of it, before the response is sent.
+	// If that fails they still resolve from memory and get written later (see idmapper.go), so the response still goes
+	// out, unless one of them turned out to be another post's.
+	app.Use(func(c *fiber.Ctx) error {
+		c.SetUserContext(bridge.TrackIDs(c.UserContext()))
+		err := c.Next()
+		if flushErr := bridge.FlushIDMappings(c.UserContext()); flushErr != nil {
+			log.ErrorContext(c.UserContext(), "Error flushing ID mappings", "error", flushErr)
+			if errors.Is(flushErr, bridge.ErrIDTaken) {
+				return ReturnError(c, "An unknown error occured.", 131, fiber.StatusInternalServerError)
+			}
+		}
+		return err
+	})
 
 	// app.Get("/", func(c *fiber.Ctx) error {
 	// 	return c.SendString("Hello, World!")
@@ -178,6 +231,7 @@ func InitServer(config *config.Config) {
 	AddV1Path(app.Post, "/account/update_profile.:filetype", UpdateProfile)
 	AddV1Path(app.Post, "/account/update_profile_image.:filetype", UpdateProfilePicture)
 	AddV1Path(app.Get, "/account/settings.:filetype", GetSettings)
+	AddV1Path(app.Post, "/account/settings.:filetype", UpdateSettings)
 
 	// Push Notifications
 	AddV1Path(app.Get, "/account/push_destinations/device.:filetype", DevicePushDestinations)
@@ -197,6 +251,11 @@ func InitServer(config *config.Config) {
 
 	// Shortcut
 	app.Get("/img/:ref", RedirectToLink)
+	app.Get("/l/:ref", RedirectToWrappedLink)
+
+	// Admin, see admin.go
+	app.Get("/admin/analytics", RequireAdmin, AdminAnalytics)
+	app.Get("/metrics", RequireAdmin, Metrics)
 
 	// misc
 	app.Get("/mobile_client_api/decider/:path", MobileClientApiDecider)
@@ -204,8 +263,7 @@ func InitServer(config *config.Config) {
 		return c.SendString("ok")
 	})
 
-	go cleanupTempTokens()
-	app.Listen(fmt.Sprintf(":%d", config.ServerPort))
+	return app
 }
 
 func HandleFiletypeSplitter(handler fiber.Handler) fiber.Handler {
@@ -226,6 +284,17 @@ func HandleFiletypeSplitter(handler fiber.Handler) fiber.Handler {
 	}
 }
 
+// indexUnit is what the client counts entity indices in, see UTF16_INDEX_CLIENTS.
+func indexUnit(c *fiber.Ctx) richtext.Unit {
+	client := strings.ToLower(c.Get("X-Twitter-Client") + " " + c.Get(fiber.HeaderUserAgent))
+	for _, match := range configData.UTF16IndexClients {
+		if match != "" && strings.Contains(client, strings.ToLower(match)) {
+			return richtext.UTF16
+		}
+	}
+	return richtext.CodePoints
+}
+
 func AddV1Path(function func(string, ...fiber.Handler) fiber.Router, url string, handler fiber.Handler) {
 	function(url, handler)
 	function(fmt.Sprintf("/1%s", url), handler)
@@ -293,7 +362,7 @@ func EncodeAndSend(c *fiber.Ctx, data interface{}) error {
 		enc := xml.NewEncoder(&buf)
 		enc.Indent("", "  ")
 		if err := enc.Encode(data); err != nil {
-			fmt.Println("Error encoding XML:", err)
+			log.ErrorContext(c.UserContext(), "Error encoding XML", "error", err)
 			return c.Status(fiber.StatusInternalServerError).SendString("Failed to encode into XML!")
 		}
 
@@ -307,7 +376,7 @@ func EncodeAndSend(c *fiber.Ctx, data interface{}) error {
 	case "json", "":
 		encoded, err := json.Marshal(data)
 		if err != nil {
-			fmt.Println("Error:", err)
+			log.ErrorContext(c.UserContext(), "Error encoding JSON", "error", err)
 			return c.Status(fiber.StatusInternalServerError).SendString("Failed to encode into json!")
 		}
 		c.Set("Content-Type", "application/json")
@@ -0,0 +1,64 @@
+package twitterv1_test
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/Preloading/TwitterAPIBridge/bridge"
+	"github.com/Preloading/TwitterAPIBridge/db_controller"
+	"github.com/Preloading/TwitterAPIBridge/fakepds"
+)
+
+// downStore is a database that won't take any IDs.
+type downStore struct{ bridge.DBIDStore }
+
+func (downStore) StoreUserIDs(ids []db_controller.TwitterIDs) error {
+	return errors.New("database is down")
+}
+
+func (downStore) StorePostIDs(ids []db_controller.PostIDs) ([]db_controller.PostIDs, error) {
+	return nil, errors.New("database is down")
+}
+
+// While IDs can't be written, responses still go out (including ones for things that already happened on bluesky),
+// and the IDs in them still work.
+func TestIDsWhileDatabaseDown(t *testing.T) {
+	h := fakepds.NewTestHarness(t, nil)
+	bob := h.MustLogin(t, "bob.test", "dev_bob-pass")
+	bridge.SetIDStore(downStore{})
+	t.Cleanup(func() { bridge.SetIDStore(bridge.DBIDStore{}) })
+
+	var timeline []struct {
+		ID              int64  `json:"id"`
+		Text            string `json:"text"`
+		RetweetedStatus *struct {
+			Text string `json:"text"`
+		} `json:"retweeted_status"`
+	}
+	h.Call(t, http.MethodGet, "/1/statuses/user_timeline.json", url.Values{"screen_name": {"alice.test"}}, bob, &timeline)
+	if len(timeline) == 0 {
+		t.Fatal("alice has no posts")
+	}
+	for _, status := range timeline {
+		var shown struct {
+			Text string `json:"text"`
+		}
+		h.Call(t, http.MethodGet, "/1/statuses/show/"+strconv.FormatInt(status.ID, 10)+".json", nil, bob, &shown)
+		want := status.Text
+		if status.RetweetedStatus != nil {
+			want = status.RetweetedStatus.Text // showing a retweet shows what was retweeted
+		}
+		if shown.Text != want {
+			t.Errorf("%d is %q, in the timeline it was %q", status.ID, shown.Text, want)
+		}
+	}
+
+	var posted struct {
+		ID int64 `json:"id"`
+	}
+	h.Call(t, http.MethodPost, "/1/statuses/update.json", url.Values{"status": {"the database is down"}}, bob, &posted)
+	h.Call(t, http.MethodPost, "/1/favorites/create/"+strconv.FormatInt(posted.ID, 10)+".json", nil, bob, nil)
+}
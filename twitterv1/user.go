@@ -1,7 +1,6 @@
 package twitterv1
 
 import (
-	"fmt"
 	"strconv"
 	"strings"
 
@@ -45,11 +44,11 @@ func user_info(c *fiber.Ctx) error {
 		oauthToken = &blankstring
 	}
 
-	userinfo, err := blueskyapi.GetUserInfo(*pds, *oauthToken, actor, false)
+	userinfo, err := blueskyapi.GetUserInfo(c.UserContext(), *pds, *oauthToken, actor, false)
 
 	if err != nil {
-		fmt.Println("Error:", err)
-		return HandleBlueskyError(c, err.Error(), "app.bsky.actor.getProfile", user_info)
+		log.ErrorContext(c.UserContext(), "GetUserInfo failed", "error", err)
+		return HandleBlueskyError(c, err, "app.bsky.actor.getProfile", user_info)
 	}
 
 	return EncodeAndSend(c, userinfo)
@@ -73,11 +72,11 @@ func UsersLookup(c *fiber.Ctx) error {
 		for _, idStr := range userIDs {
 			userID, err := strconv.ParseInt(idStr, 10, 64)
 			if err != nil {
-				return ReturnError(c, "Invalid ID format ("+idStr+")", 195, 403)
+				return ReturnError(c, "Invalid ID format (%s)", 195, 403, idStr)
 			}
 			actor, err := bridge.TwitterIDToBlueSky(&userID)
 			if err != nil {
-				return ReturnError(c, "ID not found. ("+strconv.FormatInt(userID, 10)+")", 144, fiber.StatusNotFound)
+				return ReturnError(c, "ID not found. (%d)", 144, fiber.StatusNotFound, userID)
 			}
 			if *actor != "" {
 				usersToLookUp = append(usersToLookUp, *actor)
@@ -101,10 +100,10 @@ func UsersLookup(c *fiber.Ctx) error {
 	// here's some fun problems!
 	// twitter api's max is 100 users per call. bluesky's is 25. so we get to lookup in multiple requests
 
-	users, err := blueskyapi.GetUsersInfo(*pds, *oauthToken, usersToLookUp, false)
+	users, err := blueskyapi.GetUsersInfo(c.UserContext(), *pds, *oauthToken, usersToLookUp, false)
 	if err != nil {
-		fmt.Println("Error:", err)
-		return HandleBlueskyError(c, err.Error(), "app.bsky.actor.getProfiles", UsersLookup)
+		log.ErrorContext(c.UserContext(), "GetUsersInfo failed", "error", err)
+		return HandleBlueskyError(c, err, "app.bsky.actor.getProfiles", UsersLookup)
 	}
 	return EncodeAndSend(c, users)
 }
@@ -139,11 +138,11 @@ func UserRelationships(c *fiber.Ctx) error {
 		for i, actor := range actorsArray {
 			actorID, err := strconv.ParseInt(actor, 10, 64)
 			if err != nil {
-				return ReturnError(c, "Invalid ID format ("+actor+")", 195, 403)
+				return ReturnError(c, "Invalid ID format (%s)", 195, 403, actor)
 			}
 			actorPtr, err := bridge.TwitterIDToBlueSky(&actorID)
 			if err != nil {
-				return ReturnError(c, "ID not found. ("+strconv.FormatInt(actorID, 10)+")", 144, fiber.StatusNotFound)
+				return ReturnError(c, "ID not found. (%d)", 144, fiber.StatusNotFound, actorID)
 			}
 			if actorPtr != nil {
 				actorsArray[i] = *actorPtr
@@ -152,13 +151,13 @@ func UserRelationships(c *fiber.Ctx) error {
 	}
 
 	relationships := []bridge.UsersRelationship{}
-	users, err := blueskyapi.GetUsersInfoRaw(*pds, *oauthToken, actorsArray, false)
+	users, err := blueskyapi.GetUsersInfoRaw(c.UserContext(), *pds, *oauthToken, actorsArray, false)
 	if err != nil {
-		fmt.Println("Error:", err)
-		return HandleBlueskyError(c, err.Error(), "app.bsky.actor.getProfiles", UserRelationships)
+		log.ErrorContext(c.UserContext(), "GetUsersInfoRaw failed", "error", err)
+		return HandleBlueskyError(c, err, "app.bsky.actor.getProfiles", UserRelationships)
 	}
 	for _, user := range users {
-		encodedUserId := bridge.BlueSkyToTwitterID(user.DID)
+		encodedUserId := bridge.BlueSkyToTwitterID(c.UserContext(), user.DID)
 
 		connections := bridge.Connections{}
 		connectionJSON := []string{}
@@ -261,14 +260,14 @@ func GetUsersRelationship(c *fiber.Ctx) error {
 
 	// It looks like there's a bug where I can't pass handles into GetRelationships, but we need to get the handle anyways, so this shouldn't impact that much
 
-	targetUser, err := blueskyapi.GetUserInfo(*pds, *oauthToken, targetActor, false)
+	targetUser, err := blueskyapi.GetUserInfo(c.UserContext(), *pds, *oauthToken, targetActor, false)
 	if err != nil {
-		return HandleBlueskyError(c, err.Error(), "app.bsky.actor.getProfile", GetUsersRelationship)
+		return HandleBlueskyError(c, err, "app.bsky.actor.getProfile", GetUsersRelationship)
 	}
 	// Possible optimization: if the source user is us, we can skip the api call, and just use viewer info
-	sourceUser, err := blueskyapi.GetUserInfo(*pds, *oauthToken, sourceActor, false)
+	sourceUser, err := blueskyapi.GetUserInfo(c.UserContext(), *pds, *oauthToken, sourceActor, false)
 	if err != nil {
-		return HandleBlueskyError(c, err.Error(), "app.bsky.actor.getProfile", GetUsersRelationship)
+		return HandleBlueskyError(c, err, "app.bsky.actor.getProfile", GetUsersRelationship)
 	}
 
 	targetDID, err := bridge.TwitterIDToBlueSky(&targetUser.ID) // not the most efficient way to do this, but it works
@@ -280,10 +279,10 @@ func GetUsersRelationship(c *fiber.Ctx) error {
 		return ReturnError(c, "Some wonky stuff happened. (Failed to convert target user ID to BlueSky ID)", 131, 500)
 	}
 
-	relationship, err := blueskyapi.GetRelationships(*pds, *oauthToken, *sourceDID, []string{*targetDID})
+	relationship, err := blueskyapi.GetRelationships(c.UserContext(), *pds, *oauthToken, *sourceDID, []string{*targetDID})
 	if err != nil {
-		fmt.Println("Error:", err)
-		return HandleBlueskyError(c, err.Error(), "app.bsky.graph.getRelationships", GetUsersRelationship)
+		log.ErrorContext(c.UserContext(), "GetRelationships failed", "error", err)
+		return HandleBlueskyError(c, err, "app.bsky.graph.getRelationships", GetUsersRelationship)
 	}
 	defaultTrue := true // holy fuck i hate this
 
@@ -343,14 +342,14 @@ func FollowUser(c *fiber.Ctx) error {
 	}
 
 	// follow
-	user, err := blueskyapi.FollowUser(*pds, *oauthToken, actor, *my_did)
+	user, err := blueskyapi.FollowUser(c.UserContext(), *pds, *oauthToken, actor, *my_did)
 
 	if err != nil {
-		return HandleBlueskyError(c, err.Error(), "app.bsky.graph.follow", FollowUser) // lexicon isnt tecnically right, but its fine idc
+		return HandleBlueskyError(c, err, "app.bsky.graph.follow", FollowUser) // lexicon isnt tecnically right, but its fine idc
 	}
 
 	// convert user into twitter format
-	twitterUser := blueskyapi.AuthorTTB(*user)
+	twitterUser := blueskyapi.AuthorTTB(c.UserContext(), *user)
 
 	return EncodeAndSend(c, twitterUser)
 }
@@ -364,17 +363,17 @@ func UnfollowUser(c *fiber.Ctx, actor string) error {
 	}
 
 	// follow
-	user, err := blueskyapi.UnfollowUser(*pds, *oauthToken, actor, *my_did)
+	user, err := blueskyapi.UnfollowUser(c.UserContext(), *pds, *oauthToken, actor, *my_did)
 
 	if err != nil {
-		fmt.Println("Error:", err)
-		return HandleBlueskyError(c, err.Error(), "app.bsky.graph.unfollow", func(c *fiber.Ctx) error {
+		log.ErrorContext(c.UserContext(), "UnfollowUser failed", "error", err)
+		return HandleBlueskyError(c, err, "app.bsky.graph.unfollow", func(c *fiber.Ctx) error {
 			return UnfollowUser(c, actor)
 		})
 	}
 
 	// convert user into twitter format
-	twitterUser := blueskyapi.AuthorTTB(*user)
+	twitterUser := blueskyapi.AuthorTTB(c.UserContext(), *user)
 
 	return EncodeAndSend(c, twitterUser)
 }
@@ -440,10 +439,10 @@ func GetStatusesFollowers(c *fiber.Ctx) error {
 	actor := *actorPtr
 
 	// fetch followers
-	followers, err := blueskyapi.GetFollowers(*pds, *oauthToken, "", actor)
+	followers, err := blueskyapi.GetFollowers(c.UserContext(), *pds, *oauthToken, "", actor)
 	if err != nil {
-		fmt.Println("Error:", err)
-		return HandleBlueskyError(c, err.Error(), "app.bsky.graph.getFollowers", GetStatusesFollowers)
+		log.ErrorContext(c.UserContext(), "GetFollowers failed", "error", err)
+		return HandleBlueskyError(c, err, "app.bsky.graph.getFollowers", GetStatusesFollowers)
 	}
 
 	// convert users into twitter format
@@ -453,10 +452,10 @@ func GetStatusesFollowers(c *fiber.Ctx) error {
 		actorsToLookUp = append(actorsToLookUp, user.DID)
 	}
 
-	twitterUsers, err := blueskyapi.GetUsersInfo(*pds, *oauthToken, actorsToLookUp, false)
+	twitterUsers, err := blueskyapi.GetUsersInfo(c.UserContext(), *pds, *oauthToken, actorsToLookUp, false)
 	if err != nil {
-		fmt.Println("Error:", err)
-		return HandleBlueskyError(c, err.Error(), "app.bsky.actor.getProfiles", GetStatusesFollowers)
+		log.ErrorContext(c.UserContext(), "GetUsersInfo failed", "error", err)
+		return HandleBlueskyError(c, err, "app.bsky.actor.getProfiles", GetStatusesFollowers)
 	}
 
 	// Convert []*bridge.TwitterUser to []bridge.TwitterUser
@@ -493,7 +492,7 @@ func GetFollowers(c *fiber.Ctx) error {
 		if err != nil || cursorInt > 1 {
 			cursor, err = bridge.NumToTid(uint64(cursorInt))
 			if err != nil {
-				fmt.Println("Error when converting Followers Cursor:", err)
+				log.WarnContext(c.UserContext(), "Error converting followers cursor", "error", err)
 				cursor = ""
 			}
 		} else {
@@ -520,10 +519,10 @@ func GetFollowers(c *fiber.Ctx) error {
 	}
 
 	// fetch followers
-	followers, err := blueskyapi.GetFollowers(*pds, *oauthToken, cursor, actor)
+	followers, err := blueskyapi.GetFollowers(c.UserContext(), *pds, *oauthToken, cursor, actor)
 	if err != nil {
-		fmt.Println("Error:", err)
-		return HandleBlueskyError(c, err.Error(), "app.bsky.graph.getFollowers", GetFollowers)
+		log.ErrorContext(c.UserContext(), "GetFollowers failed", "error", err)
+		return HandleBlueskyError(c, err, "app.bsky.graph.getFollowers", GetFollowers)
 	}
 
 	// convert users into twitter format
@@ -533,10 +532,10 @@ func GetFollowers(c *fiber.Ctx) error {
 		actorsToLookUp = append(actorsToLookUp, user.DID)
 	}
 
-	twitterUsers, err := blueskyapi.GetUsersInfo(*pds, *oauthToken, actorsToLookUp, false)
+	twitterUsers, err := blueskyapi.GetUsersInfo(c.UserContext(), *pds, *oauthToken, actorsToLookUp, false)
 	if err != nil {
-		fmt.Println("Error:", err)
-		return HandleBlueskyError(c, err.Error(), "app.bsky.actor.getProfiles", GetFollowers)
+		log.ErrorContext(c.UserContext(), "GetUsersInfo failed", "error", err)
+		return HandleBlueskyError(c, err, "app.bsky.actor.getProfiles", GetFollowers)
 	}
 
 	// Convert []*bridge.TwitterUser to []bridge.TwitterUser
@@ -581,10 +580,10 @@ func GetStatusesFollows(c *fiber.Ctx) error {
 	actor := *actorPtr
 
 	// fetch follows
-	followers, err := blueskyapi.GetFollows(*pds, *oauthToken, "", actor)
+	followers, err := blueskyapi.GetFollows(c.UserContext(), *pds, *oauthToken, "", actor)
 	if err != nil {
-		fmt.Println("Error:", err)
-		return HandleBlueskyError(c, err.Error(), "app.bsky.graph.getFollows", GetStatusesFollows)
+		log.ErrorContext(c.UserContext(), "GetFollows failed", "error", err)
+		return HandleBlueskyError(c, err, "app.bsky.graph.getFollows", GetStatusesFollows)
 	}
 
 	// convert users into twitter format
@@ -594,10 +593,10 @@ func GetStatusesFollows(c *fiber.Ctx) error {
 		actorsToLookUp = append(actorsToLookUp, user.DID)
 	}
 
-	twitterUsers, err := blueskyapi.GetUsersInfo(*pds, *oauthToken, actorsToLookUp, false)
+	twitterUsers, err := blueskyapi.GetUsersInfo(c.UserContext(), *pds, *oauthToken, actorsToLookUp, false)
 	if err != nil {
-		fmt.Println("Error:", err)
-		return HandleBlueskyError(c, err.Error(), "app.bsky.actor.getProfiles", GetStatusesFollows)
+		log.ErrorContext(c.UserContext(), "GetUsersInfo failed", "error", err)
+		return HandleBlueskyError(c, err, "app.bsky.actor.getProfiles", GetStatusesFollows)
 	}
 
 	// Convert []*bridge.TwitterUser to []bridge.TwitterUser
@@ -653,7 +652,7 @@ func GetFollows(c *fiber.Ctx) error {
 		if err != nil || cursorInt > 1 {
 			cursor, err = bridge.NumToTid(uint64(cursorInt))
 			if err != nil {
-				fmt.Println("Error when converting Followers Cursor:", err)
+				log.WarnContext(c.UserContext(), "Error converting followers cursor", "error", err)
 				cursor = ""
 			}
 		} else {
@@ -679,10 +678,10 @@ func GetFollows(c *fiber.Ctx) error {
 	}
 
 	// fetch follows
-	followers, err := blueskyapi.GetFollows(*pds, *oauthToken, cursor, actor)
+	followers, err := blueskyapi.GetFollows(c.UserContext(), *pds, *oauthToken, cursor, actor)
 	if err != nil {
-		fmt.Println("Error:", err)
-		return HandleBlueskyError(c, err.Error(), "app.bsky.graph.getFollows", GetFollows)
+		log.ErrorContext(c.UserContext(), "GetFollows failed", "error", err)
+		return HandleBlueskyError(c, err, "app.bsky.graph.getFollows", GetFollows)
 	}
 
 	// convert users into twitter format
@@ -692,10 +691,10 @@ func GetFollows(c *fiber.Ctx) error {
 		actorsToLookUp = append(actorsToLookUp, user.DID)
 	}
 
-	twitterUsers, err := blueskyapi.GetUsersInfo(*pds, *oauthToken, actorsToLookUp, false)
+	twitterUsers, err := blueskyapi.GetUsersInfo(c.UserContext(), *pds, *oauthToken, actorsToLookUp, false)
 	if err != nil {
-		fmt.Println("Error:", err)
-		return HandleBlueskyError(c, err.Error(), "app.bsky.actor.getProfiles", GetFollows)
+		log.ErrorContext(c.UserContext(), "GetUsersInfo failed", "error", err)
+		return HandleBlueskyError(c, err, "app.bsky.actor.getProfiles", GetFollows)
 	}
 
 	// Convert []*bridge.TwitterUser to []bridge.TwitterUser
@@ -746,7 +745,7 @@ func GetFollowingIds(c *fiber.Ctx) error {
 		if err != nil || cursorInt > 1 {
 			cursor, err = bridge.NumToTid(uint64(cursorInt))
 			if err != nil {
-				fmt.Println("Error when converting Followers Cursor:", err)
+				log.WarnContext(c.UserContext(), "Error converting followers cursor", "error", err)
 				cursor = ""
 			}
 		} else {
@@ -772,15 +771,15 @@ func GetFollowingIds(c *fiber.Ctx) error {
 	}
 
 	// fetch follows
-	followers, err := blueskyapi.GetFollows(*pds, *oauthToken, cursor, actor)
+	followers, err := blueskyapi.GetFollows(c.UserContext(), *pds, *oauthToken, cursor, actor)
 	if err != nil {
-		fmt.Println("Error:", err)
-		return HandleBlueskyError(c, err.Error(), "app.bsky.graph.getFollows", GetFollowingIds)
+		log.ErrorContext(c.UserContext(), "GetFollows failed", "error", err)
+		return HandleBlueskyError(c, err, "app.bsky.graph.getFollows", GetFollowingIds)
 	}
 
 	var userIDs []int64
 	for _, user := range followers.Followers {
-		userIDs = append(userIDs, *bridge.BlueSkyToTwitterID(user.DID))
+		userIDs = append(userIDs, *bridge.BlueSkyToTwitterID(c.UserContext(), user.DID))
 	}
 
 	next_cursor, err := bridge.TidToNum(followers.Cursor)
@@ -822,7 +821,7 @@ func GetFollowersIds(c *fiber.Ctx) error {
 		if err != nil || cursorInt > 1 {
 			cursor, err = bridge.NumToTid(uint64(cursorInt))
 			if err != nil {
-				fmt.Println("Error when converting Followers Cursor:", err)
+				log.WarnContext(c.UserContext(), "Error converting followers cursor", "error", err)
 				cursor = ""
 			}
 		} else {
@@ -848,15 +847,15 @@ func GetFollowersIds(c *fiber.Ctx) error {
 	}
 
 	// fetch follows
-	followers, err := blueskyapi.GetFollowers(*pds, *oauthToken, cursor, actor)
+	followers, err := blueskyapi.GetFollowers(c.UserContext(), *pds, *oauthToken, cursor, actor)
 	if err != nil {
-		fmt.Println("Error:", err)
-		return HandleBlueskyError(c, err.Error(), "app.bsky.graph.getFollowers", GetFollowersIds)
+		log.ErrorContext(c.UserContext(), "GetFollowers failed", "error", err)
+		return HandleBlueskyError(c, err, "app.bsky.graph.getFollowers", GetFollowersIds)
 	}
 
 	var userIDs []int64
 	for _, user := range followers.Followers {
-		userIDs = append(userIDs, *bridge.BlueSkyToTwitterID(user.DID))
+		userIDs = append(userIDs, *bridge.BlueSkyToTwitterID(c.UserContext(), user.DID))
 	}
 
 	next_cursor, err := bridge.TidToNum(followers.Cursor)
@@ -910,15 +909,15 @@ func GetSuggestedUsers(c *fiber.Ctx) error {
 		}
 		userID = *userIDPtr
 
-		recommendedUsers, err = blueskyapi.GetOthersSuggestedUsers(*pds, *oauthToken, limit, userID)
+		recommendedUsers, err = blueskyapi.GetOthersSuggestedUsers(c.UserContext(), *pds, *oauthToken, limit, userID)
 		if err != nil {
-			return HandleBlueskyError(c, err.Error(), "app.bsky.graph.getSuggestedFollowsByActor", GetSuggestedUsers)
+			return HandleBlueskyError(c, err, "app.bsky.graph.getSuggestedFollowsByActor", GetSuggestedUsers)
 		}
 	} else {
-		recommendedUsers, err = blueskyapi.GetMySuggestedUsers(*pds, *oauthToken, limit)
+		recommendedUsers, err = blueskyapi.GetMySuggestedUsers(c.UserContext(), *pds, *oauthToken, limit)
 		if err != nil {
-			fmt.Println("Error:", err)
-			return HandleBlueskyError(c, err.Error(), "app.bsky.actor.getSuggestions", GetSuggestedUsers)
+			log.ErrorContext(c.UserContext(), "GetMySuggestedUsers failed", "error", err)
+			return HandleBlueskyError(c, err, "app.bsky.actor.getSuggestions", GetSuggestedUsers)
 		}
 	}
 
@@ -927,10 +926,10 @@ func GetSuggestedUsers(c *fiber.Ctx) error {
 		usersDID = append(usersDID, user.DID)
 	}
 
-	usersInfo, err := blueskyapi.GetUsersInfo(*pds, *oauthToken, usersDID, false)
+	usersInfo, err := blueskyapi.GetUsersInfo(c.UserContext(), *pds, *oauthToken, usersDID, false)
 	if err != nil {
-		fmt.Println("Error:", err)
-		return HandleBlueskyError(c, err.Error(), "app.bsky.actor.getProfiles", GetSuggestedUsers)
+		log.ErrorContext(c.UserContext(), "GetUsersInfo failed", "error", err)
+		return HandleBlueskyError(c, err, "app.bsky.actor.getProfiles", GetSuggestedUsers)
 	}
 
 	recommended := []bridge.TwitterRecommendation{}